@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/backup"
+	"github.com/wrytehq/wryte/internal/config"
+	"github.com/wrytehq/wryte/internal/database"
+	"github.com/wrytehq/wryte/internal/storage"
+)
+
+// openInstance connects to the configured database and file storage,
+// migrating the database so that restores also work on a fresh instance.
+func openInstance() (database.Service, storage.Backend, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading configuration: %w", err)
+	}
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := database.New(cfg)
+	if err := db.RunMigrations(); err != nil {
+		db.Close()
+		return nil, nil, err
+	}
+	return db, store, nil
+}
+
+// runBackup implements `wryte backup`, writing a workspace to an archive.
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	workspaceID := fs.String("workspace", "", "ID of the workspace to back up")
+	output := fs.String("o", "", "file to write the archive to (default wryte-backup-<workspace>-<date>.zip, - for stdout)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: wryte backup -workspace <id> [-o file]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *workspaceID == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	db, store, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	name := *output
+	if name == "" {
+		name = fmt.Sprintf("wryte-backup-%s-%s.zip", *workspaceID, time.Now().Format("2006-01-02"))
+	}
+
+	var w io.Writer = os.Stdout
+	if name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := backup.Write(ctx, db.GetDB(), store, w, *workspaceID); err != nil {
+		if name != "-" {
+			os.Remove(name)
+		}
+		return err
+	}
+	if name != "-" {
+		fmt.Fprintf(os.Stderr, "Workspace %s backed up to %s\n", *workspaceID, name)
+	}
+	return nil
+}
+
+// runRestore implements `wryte restore`, recreating a workspace from an
+// archive.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	owner := fs.String("owner", "", "username or email of the user owning the restored workspace")
+	name := fs.String("name", "", "name of the restored workspace (default the name in the backup)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: wryte restore -owner <user> [-name name] <archive.zip>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *owner == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	zr, err := zip.OpenReader(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	db, store, err := openInstance()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := backup.Restore(ctx, db.GetDB(), store, &zr.Reader, backup.Options{Owner: *owner, Name: *name})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Restored workspace %s: %d documents, %d revisions, %d attachments, %d members\n",
+		result.WorkspaceID, result.Documents, result.Revisions, result.Attachments, result.Members)
+	if len(result.Unmatched) > 0 {
+		fmt.Fprintf(os.Stderr, "Users without an account on this instance, attributed to %s: %s\n",
+			*owner, strings.Join(result.Unmatched, ", "))
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"os/signal"
 	"syscall"
 	"time"
@@ -12,7 +13,7 @@ import (
 	"github.com/wrytehq/wryte/internal/server"
 )
 
-func gracefulShutdown(srv *http.Server, done chan bool) {
+func gracefulShutdown(srv *server.Server, done chan bool) {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
@@ -32,7 +33,27 @@ func gracefulShutdown(srv *http.Server, done chan bool) {
 	done <- true
 }
 
+// commands are the maintenance commands the binary runs instead of the server
+// when named as its first argument.
+var commands = map[string]func(args []string) error{
+	"backup":  runBackup,
+	"restore": runRestore,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		command, ok := commands[os.Args[1]]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command %q, available commands are backup and restore\n", os.Args[1])
+			os.Exit(2)
+		}
+		if err := command(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	server := server.New()
 
 	done := make(chan bool, 1)
@@ -0,0 +1,59 @@
+// Package auth checks the passwords of users signing in, against the hashes
+// kept in the database or by binding to an LDAP or Active Directory server.
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/wrytehq/wryte/internal/config"
+)
+
+// ErrInvalidCredentials is returned when the login or the password is wrong,
+// without telling which.
+var ErrInvalidCredentials = errors.New("auth: invalid credentials")
+
+// Identity is the account a provider checked the password of.
+type Identity struct {
+	// UserID is the user of Wryte, set by providers keeping their accounts
+	// in the database. The user of other providers is found or created
+	// from the rest of the identity.
+	UserID string
+	// Issuer and Subject name the account at the provider, such as the
+	// address of a directory server and the DN of the entry.
+	Issuer  string
+	Subject string
+	Email   string
+	// EmailVerified is whether the provider vouches for the email address.
+	EmailVerified bool
+	Name          string
+	// Groups are the groups the account belongs to, for providers that
+	// share them.
+	Groups []string
+}
+
+// Provider checks passwords.
+type Provider interface {
+	// Authenticate checks the password of someone signing in as login, an
+	// email address or a name depending on the provider.
+	Authenticate(ctx context.Context, login, password string) (*Identity, error)
+	// Verify checks the password of the account named subject, such as
+	// before a change to the account of a signed in user.
+	Verify(ctx context.Context, subject, password string) error
+	// Issuer is the Issuer of the identities of the provider, empty when
+	// their Subject is the ID of the user.
+	Issuer() string
+}
+
+// New returns the provider selected by cfg.
+func New(cfg config.AuthConfig, db *sql.DB) (Provider, error) {
+	switch cfg.Provider {
+	case "database":
+		return NewDatabase(db), nil
+	case "ldap":
+		return NewLDAP(cfg.LDAP), nil
+	}
+	return nil, fmt.Errorf("unknown auth provider %q", cfg.Provider)
+}
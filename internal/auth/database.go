@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Database checks passwords against the bcrypt hashes of the users table,
+// where users sign in with their email address.
+type Database struct {
+	db *sql.DB
+}
+
+// NewDatabase returns a provider checking the passwords kept in db.
+func NewDatabase(db *sql.DB) *Database {
+	return &Database{db: db}
+}
+
+func (d *Database) Authenticate(ctx context.Context, email, password string) (*Identity, error) {
+	var userID, hash string
+	err := d.db.QueryRowContext(ctx, `SELECT id, password_hash FROM users WHERE email = $1`, email).Scan(&userID, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := compare(hash, password); err != nil {
+		return nil, err
+	}
+	return &Identity{UserID: userID, Subject: userID, Email: email}, nil
+}
+
+func (d *Database) Verify(ctx context.Context, userID, password string) error {
+	var hash string
+	err := d.db.QueryRowContext(ctx, `SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return err
+	}
+	return compare(hash, password)
+}
+
+func (d *Database) Issuer() string { return "" }
+
+// compare checks password against a bcrypt hash. Users created by single
+// sign-on have no hash, and no password matches it.
+func compare(hash, password string) error {
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
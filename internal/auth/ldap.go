@@ -0,0 +1,293 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/wrytehq/wryte/internal/config"
+)
+
+// ldapTimeout bounds connecting to the directory server and each request to
+// it, so that an unreachable server does not hold up signing in.
+const ldapTimeout = 10 * time.Second
+
+// ldapMaxGroups is how many groups of a user are looked up at most.
+const ldapMaxGroups = 500
+
+// LDAP checks passwords by binding to a directory server, such as OpenLDAP
+// or Active Directory, as the entry of the user. The entry is found with the
+// user filter, searched as the bind account or anonymously.
+type LDAP struct {
+	cfg config.LDAPConfig
+}
+
+// NewLDAP returns a provider checking passwords against the directory of
+// cfg.
+func NewLDAP(cfg config.LDAPConfig) *LDAP {
+	return &LDAP{cfg: cfg}
+}
+
+func (l *LDAP) Authenticate(ctx context.Context, login, password string) (*Identity, error) {
+	// Binding with an empty password is an unauthenticated bind, which
+	// directory servers accept for any DN
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	filter := strings.ReplaceAll(l.cfg.UserFilter, "{login}", ldap.EscapeFilter(login))
+	entry, err := l.find(conn, l.cfg.BaseDN, ldap.ScopeWholeSubtree, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.checkGroups(conn, entry, login); err != nil {
+		return nil, err
+	}
+	// Groups are searched as the bind account, before binding as the user
+	// drops its rights
+	groups, err := l.groups(conn, entry, login)
+	if err != nil {
+		return nil, err
+	}
+	if err := bind(conn, entry.DN, password); err != nil {
+		return nil, err
+	}
+	identity, err := l.identity(entry)
+	if err != nil {
+		return nil, err
+	}
+	identity.Groups = groups
+	return identity, nil
+}
+
+func (l *LDAP) Verify(ctx context.Context, subject, password string) error {
+	if password == "" {
+		return ErrInvalidCredentials
+	}
+
+	conn, err := l.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The subject is the DN of the entry, or the hex encoded value of the
+	// ID attribute, whose every byte is escaped in the filter
+	var entry *ldap.Entry
+	if l.cfg.IDAttribute == "" {
+		entry, err = l.find(conn, subject, ldap.ScopeBaseObject, "(objectClass=*)")
+	} else {
+		raw, decodeErr := hex.DecodeString(subject)
+		if decodeErr != nil {
+			return ErrInvalidCredentials
+		}
+		var filter strings.Builder
+		filter.WriteString("(" + l.cfg.IDAttribute + "=")
+		for _, b := range raw {
+			fmt.Fprintf(&filter, `\%02x`, b)
+		}
+		filter.WriteString(")")
+		entry, err = l.find(conn, l.cfg.BaseDN, ldap.ScopeWholeSubtree, filter.String())
+	}
+	if err != nil {
+		return err
+	}
+	return bind(conn, entry.DN, password)
+}
+
+func (l *LDAP) Issuer() string { return l.cfg.URL }
+
+// connect opens a connection to the directory server, encrypted when the
+// configuration asks for it, and binds as the bind account.
+func (l *LDAP) connect(ctx context.Context) (*ldap.Conn, error) {
+	u, err := url.Parse(l.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	tlsConfig := &tls.Config{ServerName: host}
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+
+	var nc net.Conn
+	if u.Scheme == "ldaps" {
+		port := u.Port()
+		if port == "" {
+			port = ldap.DefaultLdapsPort
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConfig}
+		nc, err = tlsDialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	} else {
+		port := u.Port()
+		if port == "" {
+			port = ldap.DefaultLdapPort
+		}
+		nc, err = dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("connecting to directory server: %w", err)
+	}
+
+	conn := ldap.NewConn(nc, u.Scheme == "ldaps")
+	conn.Start()
+	conn.SetTimeout(ldapTimeout)
+
+	if l.cfg.StartTLS && u.Scheme == "ldap" {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starting TLS with directory server: %w", err)
+		}
+	}
+	if l.cfg.BindDN != "" {
+		if err := conn.Bind(l.cfg.BindDN, l.cfg.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("binding as %s: %w", l.cfg.BindDN, err)
+		}
+	}
+	return conn, nil
+}
+
+// find returns the single entry filter matches below base. Finding none, or
+// several that cannot be told apart, is ErrInvalidCredentials.
+func (l *LDAP) find(conn *ldap.Conn, base string, scope int, filter string) (*ldap.Entry, error) {
+	attributes := []string{l.cfg.NameAttribute, l.cfg.EmailAttribute}
+	if l.cfg.IDAttribute != "" {
+		attributes = append(attributes, l.cfg.IDAttribute)
+	}
+	request := ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 2, int(ldapTimeout.Seconds()),
+		false, filter, attributes, nil)
+
+	result, err := conn.Search(request)
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded):
+		return nil, fmt.Errorf("%w: %s matches several entries", ErrInvalidCredentials, filter)
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject):
+		return nil, fmt.Errorf("%w: %s does not exist", ErrInvalidCredentials, base)
+	case err != nil:
+		return nil, fmt.Errorf("searching directory: %w", err)
+	}
+
+	switch len(result.Entries) {
+	case 0:
+		return nil, ErrInvalidCredentials
+	case 1:
+		return result.Entries[0], nil
+	}
+	return nil, fmt.Errorf("%w: %s matches several entries", ErrInvalidCredentials, filter)
+}
+
+// checkGroups lets in the users the group filter finds a group for, or any
+// user when there is no group filter.
+func (l *LDAP) checkGroups(conn *ldap.Conn, entry *ldap.Entry, login string) error {
+	if l.cfg.GroupFilter == "" {
+		return nil
+	}
+
+	filter := memberFilter(l.cfg.GroupFilter, entry, login)
+	request := ldap.NewSearchRequest(l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(ldapTimeout.Seconds()),
+		false, filter, []string{"1.1"}, nil)
+
+	result, err := conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("searching directory groups: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return fmt.Errorf("%w: %s is in no group of the group filter", ErrInvalidCredentials, entry.DN)
+	}
+	return nil
+}
+
+// groups returns the names of the groups the member filter finds for the
+// user, which workspaces map.
+func (l *LDAP) groups(conn *ldap.Conn, entry *ldap.Entry, login string) ([]string, error) {
+	if l.cfg.MemberFilter == "" {
+		return nil, nil
+	}
+
+	filter := memberFilter(l.cfg.MemberFilter, entry, login)
+	request := ldap.NewSearchRequest(l.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, ldapMaxGroups, int(ldapTimeout.Seconds()),
+		false, filter, []string{l.cfg.GroupNameAttribute}, nil)
+
+	// Past the limit, the groups found so far are kept
+	result, err := conn.Search(request)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("searching directory groups: %w", err)
+	}
+	if result == nil {
+		return nil, nil
+	}
+	var groups []string
+	for _, group := range result.Entries {
+		if name := strings.TrimSpace(string(attribute(group, l.cfg.GroupNameAttribute))); name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+// memberFilter fills in a filter looking for the groups of the user of
+// entry, who signed in as login.
+func memberFilter(filter string, entry *ldap.Entry, login string) string {
+	return strings.NewReplacer(
+		"{dn}", ldap.EscapeFilter(entry.DN),
+		"{login}", ldap.EscapeFilter(login),
+	).Replace(filter)
+}
+
+// identity maps the attributes of the entry of a user to an identity. Nothing
+// checks who may write the mail attribute of an entry, so its address is not
+// taken as verified.
+func (l *LDAP) identity(entry *ldap.Entry) (*Identity, error) {
+	subject := entry.DN
+	if l.cfg.IDAttribute != "" {
+		raw := attribute(entry, l.cfg.IDAttribute)
+		if len(raw) == 0 {
+			return nil, fmt.Errorf("directory entry %s has no %s attribute", entry.DN, l.cfg.IDAttribute)
+		}
+		subject = hex.EncodeToString(raw)
+	}
+
+	return &Identity{
+		Issuer:  l.cfg.URL,
+		Subject: subject,
+		Email:   strings.TrimSpace(string(attribute(entry, l.cfg.EmailAttribute))),
+		Name:    strings.TrimSpace(string(attribute(entry, l.cfg.NameAttribute))),
+	}, nil
+}
+
+// attribute returns the first value of the attribute called name, whose case
+// does not matter.
+func attribute(entry *ldap.Entry, name string) []byte {
+	for _, attr := range entry.Attributes {
+		if strings.EqualFold(attr.Name, name) && len(attr.ByteValues) > 0 {
+			return attr.ByteValues[0]
+		}
+	}
+	return nil
+}
+
+// bind binds as dn with password, to check the password.
+func bind(conn *ldap.Conn, dn, password string) error {
+	err := conn.Bind(dn, password)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return ErrInvalidCredentials
+	}
+	if err != nil {
+		return fmt.Errorf("binding as %s: %w", dn, err)
+	}
+	return nil
+}
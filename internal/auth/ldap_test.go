@@ -0,0 +1,501 @@
+package auth
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/wrytehq/wryte/internal/config"
+)
+
+const (
+	ldapBase     = "dc=example,dc=org"
+	ldapAdmin    = "cn=admin,dc=example,dc=org"
+	ldapPassword = "secret"
+)
+
+// rawID is the entryUUID of ann, with bytes that must be escaped in a filter.
+var rawID = []byte{0x2a, 0x28, 0x29, 0x5c, 0x00, 0xff, 0x01, 0x02}
+
+// dirEntry is an entry of a directory, which binds with password when it is
+// set.
+type dirEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// directory is a directory server listening on a local port, answering
+// binds and searches over LDAP. It keeps its entries in memory and evaluates
+// search filters the way a server does, so a value that is not escaped in a
+// filter changes what is found.
+type directory struct {
+	t       *testing.T
+	url     string
+	entries []*dirEntry
+
+	mu  sync.Mutex
+	ops []string
+}
+
+func newDirectory(t *testing.T) *directory {
+	t.Helper()
+	person := func(uid, name, mail string) *dirEntry {
+		return &dirEntry{
+			dn:       "uid=" + ldap.EscapeDN(uid) + ",ou=people," + ldapBase,
+			password: uid + "-password",
+			attrs: map[string][]string{
+				"objectClass": {"inetOrgPerson"},
+				"uid":         {uid},
+				"cn":          {name},
+				"mail":        {mail},
+			},
+		}
+	}
+	group := func(cn string, attrs map[string][]string) *dirEntry {
+		attrs["objectClass"] = []string{"groupOfNames"}
+		attrs["cn"] = []string{cn}
+		return &dirEntry{dn: "cn=" + cn + ",ou=groups," + ldapBase, attrs: attrs}
+	}
+
+	ann := person("ann", "Ann Smith", "ann@example.org")
+	ann.attrs["entryUUID"] = []string{string(rawID)}
+	d := &directory{t: t, entries: []*dirEntry{
+		{dn: ldapBase, attrs: map[string][]string{"objectClass": {"domain"}}},
+		{dn: ldapAdmin, password: ldapPassword, attrs: map[string][]string{"objectClass": {"organizationalRole"}}},
+		ann,
+		person("bob", "Bob", "bob@example.org"),
+		person("x*(1)", "Starred", "star@example.org"),
+		person("xavier", "Xavier", "xavier@example.org"),
+		person("nomail", "No Mail", ""),
+		group("wryte", map[string][]string{"member": {ann.dn}}),
+		group("eng", map[string][]string{"member": {ann.dn, "uid=bob,ou=people," + ldapBase}}),
+		group("ops", map[string][]string{"memberUid": {"ann"}}),
+		group("empty", map[string][]string{}),
+	}}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	d.url = "ldap://" + ln.Addr().String()
+	return d
+}
+
+// config is the configuration of a provider using the directory.
+func (d *directory) config() config.LDAPConfig {
+	return config.LDAPConfig{
+		URL:                d.url,
+		BindDN:             ldapAdmin,
+		BindPassword:       ldapPassword,
+		BaseDN:             ldapBase,
+		UserFilter:         "(&(objectClass=inetOrgPerson)(|(uid={login})(mail={login})))",
+		MemberFilter:       "(|(member={dn})(uniqueMember={dn})(memberUid={login}))",
+		GroupNameAttribute: "cn",
+		NameAttribute:      "cn",
+		EmailAttribute:     "mail",
+	}
+}
+
+func (d *directory) record(op string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ops = append(d.ops, op)
+}
+
+// operations returns the operations received so far, and forgets them.
+func (d *directory) operations() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ops := d.ops
+	d.ops = nil
+	return ops
+}
+
+func (d *directory) entry(dn string) *dirEntry {
+	for _, e := range d.entries {
+		if strings.EqualFold(e.dn, dn) {
+			return e
+		}
+	}
+	return nil
+}
+
+// serve answers the requests of a connection until it is unbound or closed.
+func (d *directory) serve(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			responses = []*ber.Packet{d.bind(request)}
+		case ldap.ApplicationSearchRequest:
+			responses = d.search(request)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			responses = []*ber.Packet{ldapResult(ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform)}
+		}
+		for _, response := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+			envelope.AppendChild(response)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+	return result
+}
+
+// bind checks a simple bind: version, name and password.
+func (d *directory) bind(request *ber.Packet) *ber.Packet {
+	dn := request.Children[1].Data.String()
+	password := request.Children[2].Data.String()
+	d.record("bind " + dn)
+	if e := d.entry(dn); e != nil && e.password != "" && password == e.password {
+		return ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess)
+	}
+	return ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials)
+}
+
+// search answers a search: base, scope, aliases, size limit, time limit,
+// types only, filter and attributes.
+func (d *directory) search(request *ber.Packet) []*ber.Packet {
+	base := request.Children[0].Data.String()
+	scope := request.Children[1].Value.(int64)
+	sizeLimit := request.Children[3].Value.(int64)
+	filter := request.Children[6]
+	var attributes []string
+	for _, attr := range request.Children[7].Children {
+		attributes = append(attributes, attr.Data.String())
+	}
+
+	decompiled, err := ldap.DecompileFilter(filter)
+	if err != nil {
+		d.t.Errorf("decompiling filter: %v", err)
+	}
+	d.record("search " + base + " " + decompiled)
+	if d.entry(base) == nil {
+		return []*ber.Packet{ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject)}
+	}
+
+	var responses []*ber.Packet
+	for _, e := range d.entries {
+		if !e.in(base, scope) || !e.matches(filter) {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(responses)) == sizeLimit {
+			return append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded))
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "DN"))
+		attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+		for _, name := range attributes {
+			values := e.get(name)
+			if len(values) == 0 {
+				continue
+			}
+			attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+			for _, v := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		entry.AppendChild(attrs)
+		responses = append(responses, entry)
+	}
+	return append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+func (e *dirEntry) get(name string) []string {
+	for attr, values := range e.attrs {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// in reports whether the entry is within scope of base.
+func (e *dirEntry) in(base string, scope int64) bool {
+	dn, base := strings.ToLower(e.dn), strings.ToLower(base)
+	switch scope {
+	case ldap.ScopeBaseObject:
+		return dn == base
+	case ldap.ScopeSingleLevel:
+		rdn, parent, _ := strings.Cut(dn, ",")
+		return rdn != "" && parent == base
+	}
+	return dn == base || strings.HasSuffix(dn, ","+base)
+}
+
+// matches evaluates a compiled filter against the entry, matching values
+// without regard to case.
+func (e *dirEntry) matches(filter *ber.Packet) bool {
+	switch filter.Tag {
+	case ldap.FilterAnd:
+		for _, child := range filter.Children {
+			if !e.matches(child) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, child := range filter.Children {
+			if e.matches(child) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !e.matches(filter.Children[0])
+	case ldap.FilterPresent:
+		return len(e.get(filter.Data.String())) > 0
+	case ldap.FilterEqualityMatch:
+		want := filter.Children[1].Data.String()
+		return slices.ContainsFunc(e.get(filter.Children[0].Data.String()), func(v string) bool {
+			return strings.EqualFold(v, want)
+		})
+	case ldap.FilterSubstrings:
+		return slices.ContainsFunc(e.get(filter.Children[0].Data.String()), func(v string) bool {
+			v = strings.ToLower(v)
+			for _, part := range filter.Children[1].Children {
+				s := strings.ToLower(part.Data.String())
+				switch part.Tag {
+				case ldap.FilterSubstringsInitial:
+					if !strings.HasPrefix(v, s) {
+						return false
+					}
+					v = v[len(s):]
+				case ldap.FilterSubstringsAny:
+					i := strings.Index(v, s)
+					if i < 0 {
+						return false
+					}
+					v = v[i+len(s):]
+				case ldap.FilterSubstringsFinal:
+					if !strings.HasSuffix(v, s) {
+						return false
+					}
+				}
+			}
+			return true
+		})
+	}
+	return false
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	d := newDirectory(t)
+	l := NewLDAP(d.config())
+
+	identity, err := l.Authenticate(t.Context(), "ann", "ann-password")
+	if err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+	want := &Identity{
+		Issuer:  d.url,
+		Subject: "uid=ann,ou=people," + ldapBase,
+		Email:   "ann@example.org",
+		Name:    "Ann Smith",
+		Groups:  []string{"wryte", "eng", "ops"},
+	}
+	if !reflect.DeepEqual(identity, want) {
+		t.Errorf("Authenticate() = %+v, want %+v", identity, want)
+	}
+
+	// The groups are searched as the bind account, the user binding last
+	ops := d.operations()
+	if len(ops) != 4 || ops[0] != "bind "+ldapAdmin || ops[3] != "bind "+want.Subject {
+		t.Errorf("operations = %q", ops)
+	}
+
+	// Users sign in with their email address too, the groups matching by
+	// name then being left out
+	identity, err = l.Authenticate(t.Context(), "ANN@example.org", "ann-password")
+	if err != nil {
+		t.Fatalf("Authenticate() with the email address = %v", err)
+	}
+	if identity.Subject != want.Subject || !reflect.DeepEqual(identity.Groups, []string{"wryte", "eng"}) {
+		t.Errorf("Authenticate() with the email address = %+v", identity)
+	}
+}
+
+func TestLDAPAuthenticateRefuses(t *testing.T) {
+	d := newDirectory(t)
+	l := NewLDAP(d.config())
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+	}{
+		{"wrong password", "ann", "bob-password"},
+		{"empty password", "ann", ""},
+		{"unknown user", "carol", "carol-password"},
+		{"wildcard", "*", "ann-password"},
+		{"injected filter", "bob)(uid=ann", "ann-password"},
+		{"group entry", "eng", "eng-password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := l.Authenticate(t.Context(), tt.login, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Authenticate() = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+
+	// A login with characters of the filter syntax finds the user having
+	// exactly that name, not the others its wildcard would match
+	identity, err := l.Authenticate(t.Context(), "x*(1)", "x*(1)-password")
+	if err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+	if identity.Name != "Starred" {
+		t.Errorf("signed in as %s, want Starred", identity.Name)
+	}
+}
+
+func TestLDAPGroupFilter(t *testing.T) {
+	d := newDirectory(t)
+	cfg := d.config()
+	cfg.GroupFilter = "(&(objectClass=groupOfNames)(cn=wryte)(member={dn}))"
+	l := NewLDAP(cfg)
+
+	if _, err := l.Authenticate(t.Context(), "ann", "ann-password"); err != nil {
+		t.Errorf("Authenticate() of a member = %v", err)
+	}
+	d.operations()
+
+	if _, err := l.Authenticate(t.Context(), "bob", "bob-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() of someone outside the group = %v, want ErrInvalidCredentials", err)
+	}
+	for _, op := range d.operations() {
+		if strings.HasPrefix(op, "bind uid=bob") {
+			t.Error("the password of someone outside the group was checked")
+		}
+	}
+}
+
+func TestLDAPWithoutGroups(t *testing.T) {
+	d := newDirectory(t)
+	cfg := d.config()
+	cfg.MemberFilter = ""
+	l := NewLDAP(cfg)
+
+	identity, err := l.Authenticate(t.Context(), "nomail", "nomail-password")
+	if err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+	if identity.Groups != nil || identity.Email != "" {
+		t.Errorf("Authenticate() = %+v, want no groups and no email address", identity)
+	}
+	if ops := d.operations(); len(ops) != 3 {
+		t.Errorf("operations = %q, want no search for groups", ops)
+	}
+}
+
+func TestLDAPVerify(t *testing.T) {
+	d := newDirectory(t)
+	l := NewLDAP(d.config())
+	subject := "uid=ann,ou=people," + ldapBase
+
+	if err := l.Verify(t.Context(), subject, "ann-password"); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	for _, tt := range []struct {
+		subject  string
+		password string
+	}{
+		{subject, "bob-password"},
+		{subject, ""},
+		{"uid=carol,ou=people," + ldapBase, "carol-password"},
+	} {
+		if err := l.Verify(t.Context(), tt.subject, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Verify(%q, %q) = %v, want ErrInvalidCredentials", tt.subject, tt.password, err)
+		}
+	}
+}
+
+func TestLDAPIDAttribute(t *testing.T) {
+	d := newDirectory(t)
+	cfg := d.config()
+	cfg.IDAttribute = "entryUUID"
+	l := NewLDAP(cfg)
+
+	identity, err := l.Authenticate(t.Context(), "ann", "ann-password")
+	if err != nil {
+		t.Fatalf("Authenticate() = %v", err)
+	}
+	if identity.Subject != hex.EncodeToString(rawID) {
+		t.Errorf("Subject = %q, want the hex encoded entryUUID", identity.Subject)
+	}
+
+	// The ID is searched byte for byte, whatever the bytes are
+	if err := l.Verify(t.Context(), identity.Subject, "ann-password"); err != nil {
+		t.Errorf("Verify() = %v", err)
+	}
+	for _, subject := range []string{"2a", "not hex", hex.EncodeToString([]byte("*"))} {
+		if err := l.Verify(t.Context(), subject, "ann-password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Verify(%q) = %v, want ErrInvalidCredentials", subject, err)
+		}
+	}
+
+	// Entries without the attribute cannot sign in, as they could not be
+	// told apart later
+	if _, err := l.Authenticate(t.Context(), "bob", "bob-password"); err == nil {
+		t.Error("Authenticate() of an entry without entryUUID succeeded")
+	}
+}
+
+func TestLDAPUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	l := NewLDAP(config.LDAPConfig{URL: "ldap://" + addr, BaseDN: ldapBase, UserFilter: "(uid={login})"})
+	_, err = l.Authenticate(t.Context(), "ann", "ann-password")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate() = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,382 @@
+// Package backup writes a workspace to a portable zip archive and restores
+// such archives, on the same or on another instance, without relying on
+// database tools.
+//
+// An archive holds a JSON manifest describing the workspace, its members, its
+// documents and their revisions and permissions. The content of documents and
+// revisions is stored in Markdown files next to it so the archive stays
+// readable by hand, and attached files are stored as they were uploaded.
+// Sessions, invitations and public links are left out: they carry secrets and
+// are bound to the instance that issued them.
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/storage"
+)
+
+// Format identifies Wryte backups; Version is bumped whenever the layout of
+// the archive changes so restores can refuse archives they do not understand.
+const (
+	Format  = "wryte-backup"
+	Version = 1
+)
+
+const manifestName = "manifest.json"
+
+// Manifest is the description of a backed up workspace.
+type Manifest struct {
+	Format      string       `json:"format"`
+	Version     int          `json:"version"`
+	CreatedAt   time.Time    `json:"created_at"`
+	Workspace   Workspace    `json:"workspace"`
+	Users       []User       `json:"users"`
+	Members     []Member     `json:"members"`
+	Documents   []Document   `json:"documents"`
+	Revisions   []Revision   `json:"revisions"`
+	Permissions []Permission `json:"permissions"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	UserID    string    `json:"user_id"`
+	IsPublic  bool      `json:"is_public"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// User is an account referenced by the workspace. Users are matched by email
+// when restoring, so no credentials are stored.
+type User struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+}
+
+type Member struct {
+	UserID    string    `json:"user_id"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Document is a document of the workspace, its content being stored in File.
+type Document struct {
+	ID         string     `json:"id"`
+	ParentID   string     `json:"parent_id,omitempty"`
+	Title      string     `json:"title"`
+	File       string     `json:"file"`
+	UserID     string     `json:"user_id"`
+	IsPublic   bool       `json:"is_public"`
+	Version    int        `json:"version"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	ArchivedAt *time.Time `json:"archived_at,omitempty"`
+	ArchivedBy string     `json:"archived_by,omitempty"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+	DeletedBy  string     `json:"deleted_by,omitempty"`
+}
+
+type Revision struct {
+	ID         string    `json:"id"`
+	DocumentID string    `json:"document_id"`
+	UserID     string    `json:"user_id"`
+	Version    int       `json:"version"`
+	Title      string    `json:"title"`
+	File       string    `json:"file"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Permission is a role granted on a document, either to a user or to the
+// members of the backed up workspace.
+type Permission struct {
+	DocumentID string `json:"document_id"`
+	UserID     string `json:"user_id,omitempty"`
+	Workspace  bool   `json:"workspace,omitempty"`
+	Role       string `json:"role"`
+	GrantedBy  string `json:"granted_by"`
+}
+
+// Attachment is a file uploaded to a document, stored in File.
+type Attachment struct {
+	ID          string    `json:"id"`
+	DocumentID  string    `json:"document_id"`
+	UserID      string    `json:"user_id"`
+	Name        string    `json:"name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	File        string    `json:"file"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Write backs the workspace up to w, reading attached files from store.
+// Documents in the trash are included so that a restore brings the workspace
+// back exactly as it was.
+func Write(ctx context.Context, db *sql.DB, store storage.Backend, w io.Writer, workspaceID string) error {
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	m := &Manifest{Format: Format, Version: Version, CreatedAt: time.Now().UTC()}
+
+	err = tx.QueryRowContext(ctx, `SELECT id, name, user_id, is_public, created_at, updated_at FROM workspaces WHERE id = $1`, workspaceID).
+		Scan(&m.Workspace.ID, &m.Workspace.Name, &m.Workspace.UserID, &m.Workspace.IsPublic, &m.Workspace.CreatedAt, &m.Workspace.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("loading workspace: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+	users := make(map[string]bool)
+	users[m.Workspace.UserID] = true
+
+	if err := writeMembers(ctx, tx, m, users); err != nil {
+		return fmt.Errorf("loading members: %w", err)
+	}
+	if err := writeDocuments(ctx, tx, zw, m, users); err != nil {
+		return fmt.Errorf("loading documents: %w", err)
+	}
+	if err := writeRevisions(ctx, tx, zw, m, users); err != nil {
+		return fmt.Errorf("loading revisions: %w", err)
+	}
+	if err := writePermissions(ctx, tx, m, users); err != nil {
+		return fmt.Errorf("loading permissions: %w", err)
+	}
+	if err := writeAttachments(ctx, tx, store, zw, m, users); err != nil {
+		return fmt.Errorf("loading attachments: %w", err)
+	}
+	if err := writeUsers(ctx, tx, m, users); err != nil {
+		return fmt.Errorf("loading users: %w", err)
+	}
+
+	f, err := zw.Create(manifestName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writeMembers(ctx context.Context, tx *sql.Tx, m *Manifest, users map[string]bool) error {
+	rows, err := tx.QueryContext(ctx, `SELECT user_id, role, created_at FROM workspace_members WHERE workspace_id = $1 ORDER BY created_at`, m.Workspace.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Members = []Member{}
+	for rows.Next() {
+		var member Member
+		if err := rows.Scan(&member.UserID, &member.Role, &member.CreatedAt); err != nil {
+			return err
+		}
+		users[member.UserID] = true
+		m.Members = append(m.Members, member)
+	}
+	return rows.Err()
+}
+
+func writeDocuments(ctx context.Context, tx *sql.Tx, zw *zip.Writer, m *Manifest, users map[string]bool) error {
+	query := `SELECT id, COALESCE(parent_id::text, ''), title, COALESCE(content, ''), user_id, is_public, version,
+		created_at, updated_at, archived_at, COALESCE(archived_by::text, ''), deleted_at, COALESCE(deleted_by::text, '')
+		FROM documents
+		WHERE workspace_id = $1
+		ORDER BY document_path`
+	rows, err := tx.QueryContext(ctx, query, m.Workspace.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Documents = []Document{}
+	for rows.Next() {
+		var doc Document
+		var content string
+		var archivedAt, deletedAt sql.NullTime
+		err := rows.Scan(&doc.ID, &doc.ParentID, &doc.Title, &content, &doc.UserID, &doc.IsPublic, &doc.Version,
+			&doc.CreatedAt, &doc.UpdatedAt, &archivedAt, &doc.ArchivedBy, &deletedAt, &doc.DeletedBy)
+		if err != nil {
+			return err
+		}
+		doc.ArchivedAt = timePtr(archivedAt)
+		doc.DeletedAt = timePtr(deletedAt)
+		doc.File = "documents/" + doc.ID + ".md"
+		if err := writeFile(zw, doc.File, content); err != nil {
+			return err
+		}
+
+		for _, id := range []string{doc.UserID, doc.ArchivedBy, doc.DeletedBy} {
+			if id != "" {
+				users[id] = true
+			}
+		}
+		m.Documents = append(m.Documents, doc)
+	}
+	return rows.Err()
+}
+
+func writeRevisions(ctx context.Context, tx *sql.Tx, zw *zip.Writer, m *Manifest, users map[string]bool) error {
+	query := `SELECT r.id, r.document_id, r.user_id, r.version, r.title, COALESCE(r.content, ''), r.created_at, r.updated_at
+		FROM document_revisions r
+		JOIN documents d ON d.id = r.document_id
+		WHERE d.workspace_id = $1
+		ORDER BY r.document_id, r.created_at`
+	rows, err := tx.QueryContext(ctx, query, m.Workspace.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Revisions = []Revision{}
+	for rows.Next() {
+		var rev Revision
+		var content string
+		err := rows.Scan(&rev.ID, &rev.DocumentID, &rev.UserID, &rev.Version, &rev.Title, &content, &rev.CreatedAt, &rev.UpdatedAt)
+		if err != nil {
+			return err
+		}
+		rev.File = "revisions/" + rev.ID + ".md"
+		if err := writeFile(zw, rev.File, content); err != nil {
+			return err
+		}
+		users[rev.UserID] = true
+		m.Revisions = append(m.Revisions, rev)
+	}
+	return rows.Err()
+}
+
+func writePermissions(ctx context.Context, tx *sql.Tx, m *Manifest, users map[string]bool) error {
+	// Grants to other workspaces mean nothing outside of this instance
+	query := `SELECT p.document_id, COALESCE(p.user_id::text, ''), p.workspace_id IS NOT NULL, p.role, p.granted_by
+		FROM document_permissions p
+		JOIN documents d ON d.id = p.document_id
+		WHERE d.workspace_id = $1 AND (p.workspace_id IS NULL OR p.workspace_id = $1)
+		ORDER BY p.created_at`
+	rows, err := tx.QueryContext(ctx, query, m.Workspace.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Permissions = []Permission{}
+	for rows.Next() {
+		var p Permission
+		if err := rows.Scan(&p.DocumentID, &p.UserID, &p.Workspace, &p.Role, &p.GrantedBy); err != nil {
+			return err
+		}
+		if p.UserID != "" {
+			users[p.UserID] = true
+		}
+		users[p.GrantedBy] = true
+		m.Permissions = append(m.Permissions, p)
+	}
+	return rows.Err()
+}
+
+func writeAttachments(ctx context.Context, tx *sql.Tx, store storage.Backend, zw *zip.Writer, m *Manifest, users map[string]bool) error {
+	query := `SELECT a.id, a.document_id, a.user_id, a.name, a.content_type, a.size, a.storage_key,
+		COALESCE(a.width, 0), COALESCE(a.height, 0), a.created_at
+		FROM attachments a
+		JOIN documents d ON d.id = a.document_id
+		WHERE d.workspace_id = $1
+		ORDER BY a.document_id, a.created_at`
+	rows, err := tx.QueryContext(ctx, query, m.Workspace.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Attachments = []Attachment{}
+	for rows.Next() {
+		var a Attachment
+		var key string
+		if err := rows.Scan(&a.ID, &a.DocumentID, &a.UserID, &a.Name, &a.ContentType, &a.Size, &key, &a.Width, &a.Height, &a.CreatedAt); err != nil {
+			return err
+		}
+		a.File = "attachments/" + a.ID
+		err := copyAttachment(ctx, store, zw, &a, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			// The file is already lost, the backup reflects that
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("attachment %s: %w", a.ID, err)
+		}
+		users[a.UserID] = true
+		m.Attachments = append(m.Attachments, a)
+	}
+	return rows.Err()
+}
+
+// copyAttachment copies a stored file into the archive. Files are already
+// compressed more often than not, so they are stored as they are.
+func copyAttachment(ctx context.Context, store storage.Backend, zw *zip.Writer, a *Attachment, key string) error {
+	body, err := store.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: a.File, Method: zip.Store, Modified: a.CreatedAt})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, body)
+	return err
+}
+
+// writeUsers records every user referenced by the rest of the manifest.
+func writeUsers(ctx context.Context, tx *sql.Tx, m *Manifest, users map[string]bool) error {
+	ids := make([]string, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, username, email FROM users WHERE id = ANY($1::uuid[]) ORDER BY username`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.Users = []User{}
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email); err != nil {
+			return err
+		}
+		m.Users = append(m.Users, u)
+	}
+	return rows.Err()
+}
+
+func writeFile(zw *zip.Writer, name, content string) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, content)
+	return err
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
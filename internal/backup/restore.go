@@ -0,0 +1,332 @@
+package backup
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/storage"
+)
+
+// maxManifestSize bounds the manifest read from an archive.
+const maxManifestSize = 256 << 20
+
+// Options control how an archive is restored.
+type Options struct {
+	// Owner is the username or email of an existing user. The restored
+	// workspace is owned by them, and everything done by users of the backup
+	// that have no account on this instance is attributed to them.
+	Owner string
+	// Name renames the restored workspace. The name of the backup is kept
+	// when it is empty.
+	Name string
+}
+
+// Result summarizes a restore.
+type Result struct {
+	WorkspaceID string
+	Documents   int
+	Revisions   int
+	Attachments int
+	Members     int
+	// Unmatched lists the users of the backup without an account on this
+	// instance, whose work was attributed to the owner.
+	Unmatched []string
+}
+
+// Restore recreates the workspace of an archive as a new workspace. Every
+// record gets a new ID, so an archive can be restored next to the workspace it
+// was taken from, and links between its documents are rewritten to match.
+// Users are matched to existing accounts by email. Attached files are copied
+// to store. Nothing is kept unless the whole archive is restored.
+func Restore(ctx context.Context, db *sql.DB, store storage.Backend, zr *zip.Reader, opts Options) (*Result, error) {
+	m, err := readManifest(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	read := func(name string) (string, error) {
+		f, ok := files[name]
+		if !ok {
+			return "", fmt.Errorf("file %s is missing from the archive", name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		return string(data), err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Files are stored as the restore goes and removed again if it fails
+	var stored []string
+	committed := false
+	defer func() {
+		if committed {
+			return
+		}
+		for _, key := range stored {
+			if err := store.Delete(context.WithoutCancel(ctx), key); err != nil {
+				log.Printf("Error removing restored attachment %s: %v", key, err)
+			}
+		}
+	}()
+
+	var ownerID string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM users WHERE username = $1 OR lower(email) = lower($1)`, opts.Owner).Scan(&ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("no user named %q", opts.Owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{WorkspaceID: uuid.NewString()}
+
+	// Map users of the backup onto the accounts of this instance
+	users := make(map[string]string, len(m.Users))
+	for _, u := range m.Users {
+		var id string
+		err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE lower(email) = lower($1)`, u.Email).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			result.Unmatched = append(result.Unmatched, u.Username)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		users[u.ID] = id
+	}
+	user := func(id string) string {
+		if mapped, ok := users[id]; ok {
+			return mapped
+		}
+		return ownerID
+	}
+	optionalUser := func(id string) sql.NullString {
+		if id == "" {
+			return sql.NullString{}
+		}
+		return sql.NullString{String: user(id), Valid: true}
+	}
+
+	name := m.Workspace.Name
+	if opts.Name != "" {
+		name = opts.Name
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO workspaces (id, name, user_id, is_public, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)`,
+		result.WorkspaceID, name, ownerID, m.Workspace.IsPublic, m.Workspace.CreatedAt, m.Workspace.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("creating workspace: %w", err)
+	}
+
+	// Members without an account are dropped rather than merged into the
+	// owner, who joins as owner whatever their role in the backup
+	memberQuery := `INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+	for _, member := range m.Members {
+		id, ok := users[member.UserID]
+		if !ok || id == ownerID {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, memberQuery, result.WorkspaceID, id, member.Role, member.CreatedAt); err != nil {
+			return nil, fmt.Errorf("restoring members: %w", err)
+		}
+		result.Members++
+	}
+	if _, err := tx.ExecContext(ctx, memberQuery, result.WorkspaceID, ownerID, "owner", m.Workspace.CreatedAt); err != nil {
+		return nil, fmt.Errorf("restoring members: %w", err)
+	}
+	result.Members++
+
+	// New IDs are assigned up front so links between documents can be
+	// rewritten whatever order they are restored in
+	documents := make(map[string]string, len(m.Documents))
+	for _, doc := range m.Documents {
+		documents[doc.ID] = uuid.NewString()
+	}
+	attachments := make(map[string]string, len(m.Attachments))
+	for _, a := range m.Attachments {
+		attachments[a.ID] = uuid.NewString()
+	}
+	links := make([]string, 0, 2*(len(documents)+len(attachments)))
+	for oldID, newID := range documents {
+		links = append(links, "/documents/"+oldID, "/documents/"+newID)
+	}
+	for oldID, newID := range attachments {
+		links = append(links, "/attachments/"+oldID, "/attachments/"+newID)
+	}
+	rewrite := strings.NewReplacer(links...)
+
+	paths := make(map[string]string, len(m.Documents))
+	pending := m.Documents
+	for len(pending) > 0 {
+		var later []Document
+		for _, doc := range pending {
+			id := documents[doc.ID]
+			path := "/" + id
+			var parentID sql.NullString
+			if doc.ParentID != "" {
+				parentPath, ok := paths[doc.ParentID]
+				if !ok {
+					if _, exists := documents[doc.ParentID]; !exists {
+						return nil, fmt.Errorf("document %s has a parent missing from the archive", doc.ID)
+					}
+					later = append(later, doc)
+					continue
+				}
+				path = parentPath + path
+				parentID = sql.NullString{String: documents[doc.ParentID], Valid: true}
+			}
+
+			content, err := read(doc.File)
+			if err != nil {
+				return nil, err
+			}
+			_, err = tx.ExecContext(ctx, `INSERT INTO documents (id, title, parent_id, content, user_id, document_path, workspace_id,
+					is_public, is_archived, version, created_at, updated_at, archived_at, archived_by, deleted_at, deleted_by)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)`,
+				id, doc.Title, parentID, rewrite.Replace(content), user(doc.UserID), path, result.WorkspaceID,
+				doc.IsPublic, doc.ArchivedAt != nil, doc.Version, doc.CreatedAt, doc.UpdatedAt,
+				doc.ArchivedAt, optionalUser(doc.ArchivedBy), doc.DeletedAt, optionalUser(doc.DeletedBy))
+			if err != nil {
+				return nil, fmt.Errorf("restoring document %s: %w", doc.ID, err)
+			}
+			paths[doc.ID] = path
+			result.Documents++
+		}
+		if len(later) == len(pending) {
+			return nil, errors.New("the documents of the archive do not form a tree")
+		}
+		pending = later
+	}
+
+	for _, rev := range m.Revisions {
+		documentID, ok := documents[rev.DocumentID]
+		if !ok {
+			return nil, fmt.Errorf("revision %s belongs to a document missing from the archive", rev.ID)
+		}
+		content, err := read(rev.File)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT INTO document_revisions (id, document_id, user_id, version, title, content, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			uuid.NewString(), documentID, user(rev.UserID), rev.Version, rev.Title, rewrite.Replace(content), rev.CreatedAt, rev.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("restoring revision %s: %w", rev.ID, err)
+		}
+		result.Revisions++
+	}
+
+	for _, a := range m.Attachments {
+		documentID, ok := documents[a.DocumentID]
+		if !ok {
+			return nil, fmt.Errorf("attachment %s belongs to a document missing from the archive", a.ID)
+		}
+		f, ok := files[a.File]
+		if !ok {
+			return nil, fmt.Errorf("file %s is missing from the archive", a.File)
+		}
+		id := attachments[a.ID]
+		key := "documents/" + documentID + "/" + id
+		if err := restoreFile(ctx, store, f, key, a.ContentType); err != nil {
+			return nil, fmt.Errorf("restoring attachment %s: %w", a.ID, err)
+		}
+		stored = append(stored, key)
+
+		_, err := tx.ExecContext(ctx, `INSERT INTO attachments (id, document_id, user_id, name, content_type, size, storage_key, width, height, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0), $10, $10)`,
+			id, documentID, user(a.UserID), a.Name, a.ContentType, int64(f.UncompressedSize64), key, a.Width, a.Height, a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("restoring attachment %s: %w", a.ID, err)
+		}
+		result.Attachments++
+	}
+
+	for _, p := range m.Permissions {
+		documentID, ok := documents[p.DocumentID]
+		if !ok {
+			return nil, fmt.Errorf("a permission refers to document %s missing from the archive", p.DocumentID)
+		}
+		var userID, workspaceID sql.NullString
+		if p.Workspace {
+			workspaceID = sql.NullString{String: result.WorkspaceID, Valid: true}
+		} else if id, ok := users[p.UserID]; ok {
+			userID = sql.NullString{String: id, Valid: true}
+		} else {
+			// Shares with people who have no account here cannot be kept
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO document_permissions (document_id, user_id, workspace_id, role, granted_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+			ON CONFLICT DO NOTHING`,
+			documentID, userID, workspaceID, p.Role, user(p.GrantedBy))
+		if err != nil {
+			return nil, fmt.Errorf("restoring permissions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+	return result, nil
+}
+
+// restoreFile copies a file of the archive to store under key.
+func restoreFile(ctx context.Context, store storage.Backend, f *zip.File, key, contentType string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return store.Put(ctx, key, rc, int64(f.UncompressedSize64), contentType)
+}
+
+// readManifest reads and checks the manifest of an archive.
+func readManifest(zr *zip.Reader) (*Manifest, error) {
+	for _, f := range zr.File {
+		if f.Name != manifestName {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		var m Manifest
+		if err := json.NewDecoder(io.LimitReader(rc, maxManifestSize)).Decode(&m); err != nil {
+			return nil, fmt.Errorf("reading manifest: %w", err)
+		}
+		if m.Format != Format {
+			return nil, errors.New("the archive is not a Wryte backup")
+		}
+		if m.Version < 1 || m.Version > Version {
+			return nil, fmt.Errorf("backups of version %d are not supported, this version of Wryte reads versions 1 to %d", m.Version, Version)
+		}
+		return &m, nil
+	}
+	return nil, errors.New("the archive has no manifest, it is not a Wryte backup")
+}
@@ -0,0 +1,873 @@
+// Package collab synchronises collaborative editing sessions.
+//
+// Each document being edited has a room holding a CRDT replica of its content
+// and the event streams of the local editors. Operations are appended to the
+// document_ops table and announced with NOTIFY, so every server instance that
+// has the document open applies them in the same way. The merged text is
+// written back to the document shortly after the last change.
+//
+// Every replica of a document descends from one snapshot in document_crdt,
+// created from the content when the document is first opened. Changes made to
+// the content outside of a session, by a plain save for instance, are merged
+// into the replica as operations of the server, so edits on both sides are
+// kept.
+//
+// Participants, viewers included, are listed in the document_presence table
+// along with their cursors. Entries are renewed by the instance serving the
+// stream and expire when it stops doing so.
+package collab
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/wrytehq/wryte/internal/crdt"
+)
+
+const (
+	channel = "document_ops"
+
+	// flushDelay is how long a room waits after the last change before writing
+	// the merged content back to the document.
+	flushDelay = 2 * time.Second
+
+	// eventBuffer is the number of events a subscriber may lag behind before
+	// it is disconnected and has to resync.
+	eventBuffer = 64
+
+	// opRetention is how long operations are kept once they are covered by a
+	// snapshot.
+	opRetention = time.Hour
+)
+
+var (
+	ErrClosed = errors.New("collab: hub is shut down")
+	// ErrConflict is returned by Store.SaveContent when the document is no
+	// longer at the expected version.
+	ErrConflict = errors.New("collab: document changed in the meantime")
+	// ErrReadOnly is returned by Store.SaveContent when the document can no
+	// longer be edited, because it was archived or moved to the trash.
+	ErrReadOnly = errors.New("collab: document is read-only")
+)
+
+// Store loads and saves the plain text of documents.
+type Store interface {
+	// LoadContent returns the content and version of a document.
+	LoadContent(ctx context.Context, documentID string) (string, int, error)
+	// SaveContent writes merged content edited by userID within tx, provided
+	// the document is still at version, and returns the new version of the
+	// document.
+	SaveContent(ctx context.Context, tx *sql.Tx, documentID, content, userID string, version int) (int, error)
+}
+
+// Event is a server-sent event delivered to subscribers.
+type Event struct {
+	Name string
+	Data []byte
+}
+
+type Subscription struct {
+	Events   <-chan Event
+	Presence Presence
+
+	id     string
+	events chan Event
+	room   *room
+}
+
+type room struct {
+	mu         sync.Mutex
+	documentID string
+	doc        *crdt.Doc
+	seq        int64
+	subs       map[*Subscription]struct{}
+	dirty      bool
+	lastEditor string
+	timer      *time.Timer
+
+	// presenceMu serialises presence refreshes
+	presenceMu sync.Mutex
+}
+
+type Hub struct {
+	db    *sql.DB
+	store Store
+
+	mu     sync.Mutex
+	rooms  map[string]*room
+	closed bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHub creates a hub and starts listening for operations published by other
+// server instances.
+func NewHub(db *sql.DB, store Store) *Hub {
+	ctx, cancel := context.WithCancel(context.Background())
+	h := &Hub{
+		db:     db,
+		store:  store,
+		rooms:  make(map[string]*room),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	h.wg.Add(2)
+	go h.listen()
+	go h.heartbeat()
+
+	return h
+}
+
+// Subscribe joins the room of a document as participant p and returns the
+// subscription along with the current replica state, encoded as JSON. The
+// participant is announced to everyone in the room. Editors claim their
+// session as replica site, ErrSessionTaken is returned when it belongs to
+// someone else.
+func (h *Hub) Subscribe(ctx context.Context, documentID string, p Presence) (*Subscription, []byte, error) {
+	if p.Mode == ModeEdit {
+		if err := h.claimSite(ctx, documentID, p.Session, p.UserID); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for {
+		r, err := h.room(ctx, documentID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The room may have been unloaded or the hub shut down since it was
+		// looked up, registering must happen while both are ruled out
+		h.mu.Lock()
+		if h.closed {
+			h.mu.Unlock()
+			return nil, nil, ErrClosed
+		}
+		if h.rooms[documentID] != r {
+			h.mu.Unlock()
+			continue
+		}
+
+		r.mu.Lock()
+		state, err := json.Marshal(r.doc)
+		if err != nil {
+			r.mu.Unlock()
+			h.mu.Unlock()
+			return nil, nil, err
+		}
+
+		events := make(chan Event, eventBuffer)
+		sub := &Subscription{
+			Events:   events,
+			Presence: p,
+			id:       uuid.NewString(),
+			events:   events,
+			room:     r,
+		}
+		r.subs[sub] = struct{}{}
+		r.mu.Unlock()
+		h.mu.Unlock()
+
+		if err := h.join(ctx, sub); err != nil {
+			h.Unsubscribe(sub)
+			return nil, nil, err
+		}
+		return sub, state, nil
+	}
+}
+
+// Unsubscribe leaves the room and withdraws the participant. Rooms without
+// subscribers and without unsaved changes are unloaded.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	r := sub.room
+	h.leave(sub)
+
+	r.mu.Lock()
+	if _, ok := r.subs[sub]; ok {
+		delete(r.subs, sub)
+		close(sub.events)
+	}
+	idle := len(r.subs) == 0 && !r.dirty
+	r.mu.Unlock()
+
+	if idle {
+		h.unload(r)
+	}
+}
+
+// Apply publishes operations made by userID in session and applies them
+// locally. Editors only create elements of their own site, the session, and
+// operations may only refer to elements the log already holds; ErrMissing is
+// returned otherwise.
+func (h *Hub) Apply(ctx context.Context, documentID, userID, session string, ops []crdt.Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	// Invalid operations must never reach the log, every instance would fail
+	// to replay it
+	for _, op := range ops {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+		if op.Type == crdt.OpInsert && op.ID.Site != session {
+			return crdt.ErrInvalidOp
+		}
+	}
+
+	r, err := h.room(ctx, documentID)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(ops)
+	if err != nil {
+		return err
+	}
+
+	// The advisory lock serialises writers of the same document so sequence
+	// numbers become visible in commit order, which lets readers track their
+	// position with a single counter.
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, documentID); err != nil {
+		return err
+	}
+
+	var owner string
+	query := `SELECT user_id FROM document_sites WHERE document_id = $1 AND site = $2`
+	err = tx.QueryRowContext(ctx, query, documentID, session).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && owner != userID) {
+		return ErrNotJoined
+	}
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The replica must hold everything logged so far to tell whether the
+	// operations can be applied by every instance as soon as they read them
+	applied, err := h.catchUp(ctx, r)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		r.broadcast("ops", applied)
+	}
+	if err := r.doc.Check(ops); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO document_ops (document_id, user_id, ops, created_at) VALUES ($1, $2, $3, NOW())`
+	if _, err := tx.ExecContext(ctx, query, documentID, userID, payload); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, documentID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	applied, err = r.apply(ops)
+	if err != nil {
+		return err
+	}
+	if len(applied) > 0 {
+		r.lastEditor = userID
+		h.markDirty(r)
+		r.broadcast("ops", applied)
+	}
+	return nil
+}
+
+// Merge brings changes made to the content of a document outside of a
+// collaborative session, for instance by a plain save or a revision restore,
+// into the replica. The changes since the content the replica was last in
+// step with are replayed as operations, so unsaved edits of the session are
+// kept, and the merged content is saved when it differs from the content.
+func (h *Hub) Merge(ctx context.Context, documentID string) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, documentID); err != nil {
+		return err
+	}
+
+	var state []byte
+	var seq int64
+	var baseVersion int
+	var base string
+	query := `SELECT state, seq, base_version, base_content FROM document_crdt WHERE document_id = $1`
+	err = tx.QueryRowContext(ctx, query, documentID).Scan(&state, &seq, &baseVersion, &base)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The document was never opened, its replica will start from the
+		// content as it is
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	content, version, err := h.store.LoadContent(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	if version == baseVersion {
+		return nil
+	}
+
+	doc := crdt.New()
+	if err := json.Unmarshal(state, doc); err != nil {
+		return fmt.Errorf("decoding snapshot: %w", err)
+	}
+	seq, editor, err := replay(ctx, tx, documentID, doc, seq)
+	if err != nil {
+		return err
+	}
+
+	merged := content
+	if ops := mergeOps(doc, base, content, contentSite(version)); len(ops) > 0 {
+		payload, err := json.Marshal(ops)
+		if err != nil {
+			return err
+		}
+		query := `INSERT INTO document_ops (document_id, ops, created_at) VALUES ($1, $2, NOW()) RETURNING seq`
+		if err := tx.QueryRowContext(ctx, query, documentID, payload).Scan(&seq); err != nil {
+			return err
+		}
+		for _, op := range ops {
+			if _, err := doc.Apply(op); err != nil {
+				return err
+			}
+		}
+		merged = doc.Text()
+	}
+
+	// The replica only differs from the content when it holds edits of the
+	// session, so there is an editor to attribute them to
+	if merged != content && editor != "" {
+		version, err = h.store.SaveContent(ctx, tx, documentID, merged, editor, version)
+		if errors.Is(err, ErrConflict) {
+			// The content changed once more, whoever changed it merges again
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	state, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	query = `UPDATE document_crdt
+	         SET state = $1, seq = $2, base_version = $3, base_content = $4, updated_at = NOW()
+	         WHERE document_id = $5`
+	if _, err := tx.ExecContext(ctx, query, state, seq, version, merged, documentID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, documentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// replay applies the operations logged for a document after seq to doc. It
+// returns the sequence number of the last one and the last user who made any.
+func replay(ctx context.Context, tx *sql.Tx, documentID string, doc *crdt.Doc, seq int64) (int64, string, error) {
+	query := `SELECT seq, user_id, ops FROM document_ops WHERE document_id = $1 AND seq > $2 ORDER BY seq`
+	rows, err := tx.QueryContext(ctx, query, documentID, seq)
+	if err != nil {
+		return 0, "", err
+	}
+	defer rows.Close()
+
+	var editor string
+	for rows.Next() {
+		var userID sql.NullString
+		var payload []byte
+		if err := rows.Scan(&seq, &userID, &payload); err != nil {
+			return 0, "", err
+		}
+
+		var ops []crdt.Op
+		if err := json.Unmarshal(payload, &ops); err != nil {
+			return 0, "", fmt.Errorf("decoding operations %d: %w", seq, err)
+		}
+		for _, op := range ops {
+			if _, err := doc.Apply(op); err != nil {
+				return 0, "", err
+			}
+		}
+		if userID.Valid {
+			editor = userID.String
+		}
+	}
+	return seq, editor, rows.Err()
+}
+
+// Shutdown stops accepting subscribers, writes back unsaved changes and closes
+// every open stream so that their handlers return.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil
+	}
+	h.closed = true
+	rooms := make([]*room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	h.mu.Unlock()
+
+	h.cancel()
+	h.wg.Wait()
+
+	var errs []error
+	for _, r := range rooms {
+		r.mu.Lock()
+		if r.timer != nil {
+			r.timer.Stop()
+		}
+		for sub := range r.subs {
+			delete(r.subs, sub)
+			close(sub.events)
+		}
+		r.mu.Unlock()
+
+		if err := h.flush(ctx, r); err != nil {
+			errs = append(errs, fmt.Errorf("flushing document %s: %w", r.documentID, err))
+		}
+	}
+
+	log.Printf("Collaboration hub closed %d rooms", len(rooms))
+	return errors.Join(errs...)
+}
+
+// room returns the loaded room of a document, loading it when needed.
+func (h *Hub) room(ctx context.Context, documentID string) (*room, error) {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if r, ok := h.rooms[documentID]; ok {
+		h.mu.Unlock()
+		return r, nil
+	}
+	h.mu.Unlock()
+
+	// Loading queries the database, which must not hold up every other room
+	r := &room{
+		documentID: documentID,
+		subs:       make(map[*Subscription]struct{}),
+	}
+	if err := h.load(ctx, r); err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if loaded, ok := h.rooms[documentID]; ok {
+		// Someone else loaded the room in the meantime
+		h.mu.Unlock()
+		return loaded, nil
+	}
+	h.rooms[documentID] = r
+	h.mu.Unlock()
+
+	// Operations announced while loading went unnoticed, and the content may
+	// have changed while nobody had the document open
+	h.sync(r)
+	if err := h.Merge(ctx, documentID); err != nil {
+		log.Printf("Error merging collaborative document %s: %v", documentID, err)
+	}
+	return r, nil
+}
+
+// load initialises the replica of a room from the snapshot of the document,
+// creating it from the content when there is none, and catches up with the
+// operation log. The caller must hold r.mu or own r exclusively.
+func (h *Hub) load(ctx context.Context, r *room) error {
+	var state []byte
+	query := `SELECT state, seq FROM document_crdt WHERE document_id = $1`
+	err := h.db.QueryRowContext(ctx, query, r.documentID).Scan(&state, &r.seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		if err := h.createSnapshot(ctx, r.documentID); err != nil {
+			return err
+		}
+		err = h.db.QueryRowContext(ctx, query, r.documentID).Scan(&state, &r.seq)
+	}
+	if err != nil {
+		return err
+	}
+
+	r.doc = crdt.New()
+	if err := json.Unmarshal(state, r.doc); err != nil {
+		return fmt.Errorf("decoding snapshot: %w", err)
+	}
+
+	_, err = h.catchUp(ctx, r)
+	return err
+}
+
+// createSnapshot derives the first snapshot of a document from its content.
+// Instances opening the document at the same time agree on the one that made
+// it into the table.
+func (h *Hub) createSnapshot(ctx context.Context, documentID string) error {
+	content, version, err := h.store.LoadContent(ctx, documentID)
+	if err != nil {
+		return err
+	}
+	state, err := json.Marshal(crdt.FromText(content, contentSite(version)))
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO document_crdt (document_id, state, seq, base_version, base_content, updated_at)
+	          VALUES ($1, $2, 0, $3, $4, NOW())
+	          ON CONFLICT (document_id) DO NOTHING`
+	_, err = h.db.ExecContext(ctx, query, documentID, state, version, content)
+	return err
+}
+
+func (h *Hub) unload(r *room) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.subs) == 0 && !r.dirty && h.rooms[r.documentID] == r {
+		delete(h.rooms, r.documentID)
+	}
+}
+
+// catchUp applies operations logged after the room's position and returns the
+// ones that changed the replica. The caller must hold r.mu or own r
+// exclusively.
+func (h *Hub) catchUp(ctx context.Context, r *room) ([]crdt.Op, error) {
+	query := `SELECT seq, ops FROM document_ops WHERE document_id = $1 AND seq > $2 ORDER BY seq`
+	rows, err := h.db.QueryContext(ctx, query, r.documentID, r.seq)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var applied []crdt.Op
+	for rows.Next() {
+		var seq int64
+		var payload []byte
+		if err := rows.Scan(&seq, &payload); err != nil {
+			return nil, err
+		}
+
+		var ops []crdt.Op
+		if err := json.Unmarshal(payload, &ops); err != nil {
+			return nil, fmt.Errorf("decoding operations %d: %w", seq, err)
+		}
+		changed, err := r.apply(ops)
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, changed...)
+		r.seq = seq
+	}
+	return applied, rows.Err()
+}
+
+// apply integrates ops into the replica. The caller must hold r.mu or own r
+// exclusively.
+func (r *room) apply(ops []crdt.Op) ([]crdt.Op, error) {
+	var applied []crdt.Op
+	for _, op := range ops {
+		changed, err := r.doc.Apply(op)
+		if errors.Is(err, crdt.ErrBacklog) {
+			// Logged operations are checked first, this one can never be
+			// applied anywhere
+			log.Printf("Dropping operation %s of document %s: %v", op.ID, r.documentID, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		applied = append(applied, changed...)
+	}
+	return applied, nil
+}
+
+// broadcast sends an event to every subscriber of the room. Subscribers that
+// cannot keep up are disconnected; their clients reconnect and resync. The
+// caller must hold r.mu.
+func (r *room) broadcast(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", name, err)
+		return
+	}
+
+	for sub := range r.subs {
+		select {
+		case sub.events <- Event{Name: name, Data: data}:
+		default:
+			delete(r.subs, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// markDirty schedules the room to be written back. The caller must hold r.mu.
+func (h *Hub) markDirty(r *room) {
+	r.dirty = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(flushDelay, func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := h.flush(ctx, r); err != nil {
+			log.Printf("Error saving collaborative document %s: %v", r.documentID, err)
+		}
+
+		r.mu.Lock()
+		idle := len(r.subs) == 0 && !r.dirty
+		r.mu.Unlock()
+		if idle {
+			h.unload(r)
+		}
+	})
+}
+
+// flush writes the merged content of a dirty room back to the document and
+// stores a snapshot of the replica. When the content was changed outside of
+// the session in the meantime, the changes are merged instead.
+func (h *Hub) flush(ctx context.Context, r *room) error {
+	// The advisory lock keeps operations and merges out until the content and
+	// the snapshot are saved together
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, r.documentID); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	applied, err := h.catchUp(ctx, r)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	if len(applied) > 0 {
+		r.broadcast("ops", applied)
+	}
+	state, err := json.Marshal(r.doc)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	content, seq, editor := r.doc.Text(), r.seq, r.lastEditor
+	r.dirty = false
+	r.mu.Unlock()
+
+	var version int
+	var base string
+	query := `SELECT base_version, base_content FROM document_crdt WHERE document_id = $1`
+	if err := tx.QueryRowContext(ctx, query, r.documentID).Scan(&version, &base); err != nil {
+		h.redirty(r)
+		return err
+	}
+
+	saved := content != base
+	if saved {
+		version, err = h.store.SaveContent(ctx, tx, r.documentID, content, editor, version)
+		switch {
+		case errors.Is(err, ErrConflict):
+			tx.Rollback()
+			return h.Merge(ctx, r.documentID)
+		case errors.Is(err, ErrReadOnly):
+			// The changes stay in the log, they are saved with the next
+			// change once the document can be edited again
+			log.Printf("Not saving collaborative document %s: %v", r.documentID, err)
+			return nil
+		case err != nil:
+			h.redirty(r)
+			return err
+		}
+	}
+
+	query = `UPDATE document_crdt
+	         SET state = $1, seq = $2, base_version = $3, base_content = $4, updated_at = NOW()
+	         WHERE document_id = $5`
+	if _, err := tx.ExecContext(ctx, query, state, seq, version, content, r.documentID); err != nil {
+		h.redirty(r)
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		h.redirty(r)
+		return err
+	}
+
+	// Operations covered by the snapshot are only kept for a while so that
+	// instances lagging behind can still catch up
+	query = `DELETE FROM document_ops
+	         WHERE document_id = $1 AND seq <= $2 AND created_at < NOW() - make_interval(secs => $3)`
+	if _, err := h.db.ExecContext(ctx, query, r.documentID, seq, opRetention.Seconds()); err != nil {
+		log.Printf("Error pruning operations of document %s: %v", r.documentID, err)
+	}
+
+	if saved {
+		r.mu.Lock()
+		r.broadcast("saved", map[string]any{"version": version})
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+func (h *Hub) redirty(r *room) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.dirty = true
+}
+
+// listen follows notifications from every server instance, reconnecting with a
+// delay when the connection drops.
+func (h *Hub) listen() {
+	defer h.wg.Done()
+
+	for {
+		err := h.listenOnce()
+		if h.ctx.Err() != nil {
+			return
+		}
+		log.Printf("Collaboration listener disconnected: %v", err)
+
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (h *Hub) listenOnce() error {
+	conn, err := h.db.Conn(h.ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		pgConn := driverConn.(*stdlib.Conn).Conn()
+		if _, err := pgConn.Exec(h.ctx, "LISTEN "+channel); err != nil {
+			return err
+		}
+
+		// Notifications may have been missed while disconnected
+		h.resync()
+
+		for {
+			n, err := pgConn.WaitForNotification(h.ctx)
+			if err != nil {
+				return err
+			}
+			h.handleNotification(n.Payload)
+		}
+	})
+}
+
+func (h *Hub) loadedRoom(documentID string) *room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.rooms[documentID]
+}
+
+func (h *Hub) handleNotification(payload string) {
+	if documentID, ok := strings.CutPrefix(payload, "presence:"); ok {
+		if r := h.loadedRoom(documentID); r != nil {
+			h.refreshPresence(r)
+		}
+		return
+	}
+
+	if r := h.loadedRoom(payload); r != nil {
+		h.sync(r)
+	}
+}
+
+// resync reloads every open room. It runs after (re)connecting the listener,
+// when notifications may have been missed and the operations they announced
+// may already be pruned. Local changes are never lost since they are logged
+// before being applied.
+func (h *Hub) resync() {
+	for _, r := range h.loadedRooms() {
+		r.mu.Lock()
+		if err := h.load(h.ctx, r); err != nil {
+			log.Printf("Error reloading collaborative document %s: %v", r.documentID, err)
+		} else {
+			r.broadcast("reset", r.doc)
+		}
+		r.mu.Unlock()
+
+		h.refreshPresence(r)
+	}
+}
+
+func (h *Hub) loadedRooms() []*room {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rooms := make([]*room, 0, len(h.rooms))
+	for _, r := range h.rooms {
+		rooms = append(rooms, r)
+	}
+	return rooms
+}
+
+// sync applies operations published by other instances and forwards them to
+// local subscribers.
+func (h *Hub) sync(r *room) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	applied, err := h.catchUp(h.ctx, r)
+	if err != nil {
+		log.Printf("Error syncing collaborative document %s: %v", r.documentID, err)
+		return
+	}
+	if len(applied) > 0 {
+		r.broadcast("ops", applied)
+	}
+}
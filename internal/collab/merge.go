@@ -0,0 +1,88 @@
+package collab
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/wrytehq/wryte/internal/crdt"
+	"github.com/wrytehq/wryte/internal/diff"
+)
+
+// contentSite is the site of the elements the server creates for version of
+// the content, both when deriving a replica from it and when merging it.
+func contentSite(version int) string {
+	return "v" + strconv.Itoa(version)
+}
+
+// reservedSite reports whether site is one of the server's content sites,
+// which editors may not claim.
+func reservedSite(site string) bool {
+	digits, ok := strings.CutPrefix(site, "v")
+	if !ok || digits == "" {
+		return false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeOps returns the operations that carry the changes turning base into
+// text over to doc, a replica whose content was base before it was edited on
+// its own. Characters are matched between base and the replica first, the
+// changes are then made to the matching elements so that edits on both sides
+// are kept. New elements belong to site.
+func mergeOps(doc *crdt.Doc, base, text, site string) []crdt.Op {
+	// elements holds, for each character of base, the element of the replica
+	// that still shows it, or the zero ID when it was deleted or replaced
+	visible := doc.Visible()
+	elements := make([]crdt.ID, 0, utf8.RuneCountInString(base))
+	i := 0
+	for _, c := range diff.Chars(base, doc.Text()) {
+		n := utf8.RuneCountInString(c.Text)
+		switch c.Op {
+		case diff.Equal:
+			elements = append(elements, visible[i:i+n]...)
+			i += n
+		case diff.Delete:
+			for range n {
+				elements = append(elements, crdt.ID{})
+			}
+		case diff.Insert:
+			i += n
+		}
+	}
+
+	var ops []crdt.Op
+	clock := doc.Clock()
+	// prev is the element new characters follow, the zero ID being the head
+	var prev crdt.ID
+	j := 0
+	for _, c := range diff.Chars(base, text) {
+		switch c.Op {
+		case diff.Equal, diff.Delete:
+			for range utf8.RuneCountInString(c.Text) {
+				id := elements[j]
+				j++
+				if id.IsZero() {
+					continue
+				}
+				if c.Op == diff.Delete {
+					ops = append(ops, crdt.Op{Type: crdt.OpDelete, ID: id})
+				}
+				prev = id
+			}
+		case diff.Insert:
+			for _, r := range c.Text {
+				clock++
+				id := crdt.ID{Counter: clock, Site: site}
+				ops = append(ops, crdt.Op{Type: crdt.OpInsert, ID: id, After: prev, Value: string(r)})
+				prev = id
+			}
+		}
+	}
+	return ops
+}
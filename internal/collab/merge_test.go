@@ -0,0 +1,107 @@
+package collab
+
+import (
+	"testing"
+
+	"github.com/wrytehq/wryte/internal/crdt"
+)
+
+// edit applies text changes to doc as site would, by diffing against its
+// current content.
+func edit(t *testing.T, doc *crdt.Doc, text, site string) {
+	t.Helper()
+	for _, op := range mergeOps(doc, doc.Text(), text, site) {
+		if _, err := doc.Apply(op); err != nil {
+			t.Fatalf("Apply(%v) = %v", op, err)
+		}
+	}
+}
+
+func TestMergeOps(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		session string
+		content string
+		want    string
+	}{
+		{
+			name:    "separate changes",
+			base:    "hello world",
+			session: "ello big world",
+			content: "Hello world!",
+			want:    "Hello big world!",
+		},
+		{
+			name:    "no session changes",
+			base:    "one two",
+			session: "one two",
+			content: "one three",
+			want:    "one three",
+		},
+		{
+			name:    "no content changes",
+			base:    "one two",
+			session: "one two three",
+			content: "one two",
+			want:    "one two three",
+		},
+		{
+			name:    "same deletion on both sides",
+			base:    "abc",
+			session: "ac",
+			content: "ac",
+			want:    "ac",
+		},
+		{
+			name:    "content emptied",
+			base:    "abc",
+			session: "abcd",
+			content: "",
+			want:    "d",
+		},
+		{
+			name:    "from empty",
+			base:    "",
+			session: "typed",
+			content: "saved",
+			want:    "savedtyped",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := crdt.FromText(tt.base, contentSite(1))
+			edit(t, doc, tt.session, "session")
+
+			ops := mergeOps(doc, tt.base, tt.content, contentSite(2))
+			if err := doc.Check(ops); err != nil {
+				t.Fatalf("Check() = %v", err)
+			}
+			for _, op := range ops {
+				if _, err := doc.Apply(op); err != nil {
+					t.Fatalf("Apply(%v) = %v", op, err)
+				}
+			}
+			if got := doc.Text(); got != tt.want {
+				t.Errorf("merged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReservedSite(t *testing.T) {
+	tests := map[string]bool{
+		"v1":        true,
+		"v42":       true,
+		"v":         false,
+		"v1a":       false,
+		"lz4k2x9ab": false,
+		"":          false,
+	}
+	for site, want := range tests {
+		if got := reservedSite(site); got != want {
+			t.Errorf("reservedSite(%q) = %t, want %t", site, got, want)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package collab
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/crdt"
+)
+
+const (
+	// presenceTTL is how long a participant stays listed without a heartbeat
+	// from the instance serving it. It bounds how long the participants of a
+	// dead instance linger.
+	presenceTTL = 30 * time.Second
+
+	// presenceHeartbeat is how often an instance renews its participants and
+	// sweeps expired ones.
+	presenceHeartbeat = 10 * time.Second
+
+	ModeView = "view"
+	ModeEdit = "edit"
+)
+
+var (
+	// ErrNotJoined is returned when updating a session that has no open
+	// stream.
+	ErrNotJoined = errors.New("collab: session has not joined the document")
+	// ErrSessionTaken is returned when editing under a session that belongs
+	// to another user.
+	ErrSessionTaken = errors.New("collab: session belongs to someone else")
+)
+
+// Cursor is a caret or selection. Both ends are anchored to the element that
+// precedes them, the zero ID standing for the start of the document, so they
+// stay in place while other participants edit.
+type Cursor struct {
+	Anchor crdt.ID `json:"anchor"`
+	Head   crdt.ID `json:"head"`
+}
+
+// Presence describes a participant of a room. A session is a browser tab; the
+// same session may briefly hold several streams while reconnecting.
+type Presence struct {
+	Session  string  `json:"session"`
+	UserID   string  `json:"userId"`
+	Username string  `json:"username"`
+	Mode     string  `json:"mode"`
+	Cursor   *Cursor `json:"cursor,omitempty"`
+}
+
+// UpdateCursor records the cursor of a session and announces it to every
+// participant. A nil cursor clears it.
+func (h *Hub) UpdateCursor(ctx context.Context, documentID, userID, session string, cursor *Cursor) error {
+	var payload []byte
+	if cursor != nil {
+		var err error
+		if payload, err = json.Marshal(cursor); err != nil {
+			return err
+		}
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE document_presence SET cursor = $1, updated_at = NOW()
+	          WHERE document_id = $2 AND session = $3 AND user_id = $4`
+	res, err := tx.ExecContext(ctx, query, payload, documentID, session, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotJoined
+	}
+	if err := notifyPresence(ctx, tx, documentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// claimSite reserves the session as replica site of userID, who is the only one
+// allowed to create elements under it from now on.
+func (h *Hub) claimSite(ctx context.Context, documentID, session, userID string) error {
+	if reservedSite(session) {
+		return ErrSessionTaken
+	}
+
+	query := `INSERT INTO document_sites (document_id, site, user_id, created_at)
+	          VALUES ($1, $2, $3, NOW())
+	          ON CONFLICT (document_id, site) DO NOTHING`
+	if _, err := h.db.ExecContext(ctx, query, documentID, session, userID); err != nil {
+		return err
+	}
+
+	var owner string
+	query = `SELECT user_id FROM document_sites WHERE document_id = $1 AND site = $2`
+	if err := h.db.QueryRowContext(ctx, query, documentID, session).Scan(&owner); err != nil {
+		return err
+	}
+	if owner != userID {
+		return ErrSessionTaken
+	}
+	return nil
+}
+
+// join registers the stream of a subscription in the presence table.
+func (h *Hub) join(ctx context.Context, sub *Subscription) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO document_presence (id, document_id, user_id, session, mode, cursor, expires_at, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5,
+	              (SELECT cursor FROM document_presence WHERE document_id = $2 AND session = $4 AND user_id = $3
+	               ORDER BY updated_at DESC LIMIT 1),
+	              NOW() + make_interval(secs => $6), NOW(), NOW())`
+	_, err = tx.ExecContext(ctx, query, sub.id, sub.room.documentID, sub.Presence.UserID,
+		sub.Presence.Session, sub.Presence.Mode, presenceTTL.Seconds())
+	if err != nil {
+		return err
+	}
+	if err := notifyPresence(ctx, tx, sub.room.documentID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// leave removes the stream of a subscription from the presence table. It runs
+// after the request is gone, so it uses its own deadline.
+func (h *Hub) leave(sub *Subscription) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err == nil {
+		defer tx.Rollback()
+		_, err = tx.ExecContext(ctx, `DELETE FROM document_presence WHERE id = $1`, sub.id)
+		if err == nil {
+			err = notifyPresence(ctx, tx, sub.room.documentID)
+		}
+		if err == nil {
+			err = tx.Commit()
+		}
+	}
+	if err != nil {
+		// The entry expires on its own
+		log.Printf("Error leaving document %s: %v", sub.room.documentID, err)
+	}
+}
+
+func notifyPresence(ctx context.Context, tx *sql.Tx, documentID string) error {
+	_, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, channel, "presence:"+documentID)
+	return err
+}
+
+// listPresence returns the live participants of a document, one per session.
+func (h *Hub) listPresence(ctx context.Context, documentID string) ([]Presence, error) {
+	query := `SELECT DISTINCT ON (p.session) p.session, p.user_id, u.username, p.mode, p.cursor
+	          FROM document_presence p
+	          JOIN users u ON u.id = p.user_id
+	          WHERE p.document_id = $1 AND p.expires_at > NOW()
+	          ORDER BY p.session, p.updated_at DESC`
+	rows, err := h.db.QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	participants := []Presence{}
+	for rows.Next() {
+		var p Presence
+		var cursor []byte
+		if err := rows.Scan(&p.Session, &p.UserID, &p.Username, &p.Mode, &cursor); err != nil {
+			return nil, err
+		}
+		if cursor != nil {
+			p.Cursor = &Cursor{}
+			if err := json.Unmarshal(cursor, p.Cursor); err != nil {
+				return nil, err
+			}
+		}
+		participants = append(participants, p)
+	}
+	return participants, rows.Err()
+}
+
+// refreshPresence sends the current participants to the local subscribers of
+// a room. Refreshes of a room are serialised so that an older list never
+// overtakes a newer one.
+func (h *Hub) refreshPresence(r *room) {
+	r.presenceMu.Lock()
+	defer r.presenceMu.Unlock()
+
+	participants, err := h.listPresence(h.ctx, r.documentID)
+	if err != nil {
+		log.Printf("Error listing participants of document %s: %v", r.documentID, err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.broadcast("presence", participants)
+}
+
+// heartbeat keeps the participants served by this instance alive and removes
+// the ones that expired, which is how participants of an instance that died
+// disappear.
+func (h *Hub) heartbeat() {
+	defer h.wg.Done()
+
+	ticker := time.NewTicker(presenceHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.renewPresence(); err != nil {
+				log.Printf("Error renewing presence: %v", err)
+			}
+			if err := h.sweepPresence(); err != nil {
+				log.Printf("Error sweeping presence: %v", err)
+			}
+		}
+	}
+}
+
+func (h *Hub) renewPresence() error {
+	var ids []string
+	for _, r := range h.loadedRooms() {
+		r.mu.Lock()
+		for sub := range r.subs {
+			ids = append(ids, sub.id)
+		}
+		r.mu.Unlock()
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	query := `UPDATE document_presence SET expires_at = NOW() + make_interval(secs => $1)
+	          WHERE id = ANY($2::uuid[])`
+	_, err := h.db.ExecContext(h.ctx, query, presenceTTL.Seconds(), ids)
+	return err
+}
+
+func (h *Hub) sweepPresence() error {
+	tx, err := h.db.BeginTx(h.ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(h.ctx, `DELETE FROM document_presence WHERE expires_at <= NOW() RETURNING document_id`)
+	if err != nil {
+		return err
+	}
+	documents := make(map[string]struct{})
+	for rows.Next() {
+		var documentID string
+		if err := rows.Scan(&documentID); err != nil {
+			rows.Close()
+			return err
+		}
+		documents[documentID] = struct{}{}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for documentID := range documents {
+		if err := notifyPresence(h.ctx, tx, documentID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
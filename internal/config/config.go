@@ -2,20 +2,40 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 )
 
+const (
+	// developmentSecretKey is the secret key used in development when none is
+	// set, so that restarting the server keeps everyone's cookies valid.
+	developmentSecretKey = "wryte-development-secret-key-do-not-use"
+	// minSecretKeyLength is the shortest secret key accepted.
+	minSecretKeyLength = 32
+)
+
 type Config struct {
 	Project  ProjectConfig
 	Server   ServerConfig
 	Database DatabaseConfig
+	Document DocumentConfig
+	Storage  StorageConfig
+	Mail     MailConfig
+	Auth     AuthConfig
 }
 
 type ProjectConfig struct {
 	IsCloud bool
+	// EmailVerification is what cloud accounts can do before their email
+	// address is verified: "block" keeps them out until it is, "limit" keeps
+	// them from reaching other people, by inviting them or sharing and
+	// publishing documents, and "off" lets them do everything
+	EmailVerification string
 }
 
 type DatabaseConfig struct {
@@ -28,21 +48,191 @@ type DatabaseConfig struct {
 	Schema   string
 }
 
+type DocumentConfig struct {
+	// RevisionWindow is how long consecutive saves by the same user are
+	// coalesced into a single revision
+	RevisionWindow time.Duration
+	// TrashRetention is how long deleted documents stay in the trash before
+	// they are purged for good. Zero keeps them until the trash is emptied
+	TrashRetention time.Duration
+	// ImportMaxSize is the largest archive, in bytes, that can be uploaded to
+	// import documents
+	ImportMaxSize int64
+	// AttachmentMaxSize is the largest file, in bytes, that can be attached
+	// to a document
+	AttachmentMaxSize int64
+	// ImageMaxSize is the largest image, in bytes, that can be uploaded to
+	// be shown in a document
+	ImageMaxSize int64
+}
+
+type StorageConfig struct {
+	// Backend selects where attachments are stored, "local" or "s3"
+	Backend string
+	// Path is the directory attachments are stored in by the local backend
+	Path string
+	S3   S3Config
+}
+
+type S3Config struct {
+	// Endpoint is the URL of an S3-compatible service, AWS is used when it
+	// is empty
+	Endpoint        string
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	// PathStyle addresses buckets in the path rather than the host name, as
+	// most self-hosted services expect
+	PathStyle bool
+}
+
+type MailConfig struct {
+	// Backend selects how emails are delivered, "smtp" or "outbox", which
+	// writes them to files instead for development. It defaults to outbox
+	// in development when no SMTP host is set, and to smtp otherwise, so
+	// that emails are not kept where nobody reads them
+	Backend  string
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// Security is how the connection to the SMTP server is encrypted:
+	// "starttls" upgrades it, "tls" encrypts it from the start and "none"
+	// leaves it in clear text
+	Security string
+	// From is the sender of every email, such as "Wryte <wryte@example.com>"
+	From string
+	// OutboxPath is the directory the outbox backend writes emails to
+	OutboxPath string
+}
+
+type AuthConfig struct {
+	// RequireTwoFactor makes every user of the instance set up two-factor
+	// authentication. Workspaces can also require it of their members
+	RequireTwoFactor bool
+	// PasskeyRPID is the domain passkeys are bound to, the host name Wryte
+	// is reached at. Passkeys registered for one domain do not work on
+	// another
+	PasskeyRPID string
+	// PasskeyOrigins are the addresses Wryte is reached at, as browsers
+	// report them when a passkey is used. They default to https on
+	// PasskeyRPID, or http on the server port for localhost
+	PasskeyOrigins []string
+	// OIDC signs users in with an OpenID Connect identity provider
+	OIDC OIDCConfig
+	// SSOOnly turns off signing in with a password, leaving single sign-on
+	// as the only way in
+	SSOOnly bool
+	// Provider checks the passwords of users signing in: "database", the
+	// hashes kept by Wryte, or "ldap", a bind to a directory server. Users
+	// of the directory get an account on their first sign in, or are linked
+	// to the account with their email address once its owner confirms it
+	Provider string
+	LDAP     LDAPConfig
+}
+
+// ManagesPasswords reports whether Wryte keeps the passwords of users, who
+// can then change and reset them in Wryte.
+func (c AuthConfig) ManagesPasswords() bool {
+	return c.Provider == "database"
+}
+
+// MapsWorkspaces reports whether users signing in with single sign-on or a
+// directory join workspaces by the mappings of the workspaces.
+func (c AuthConfig) MapsWorkspaces() bool {
+	return c.OIDC.Enabled() || c.Provider == "ldap"
+}
+
+type LDAPConfig struct {
+	// URL is the address of the directory server, ldap:// or ldaps://
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool
+	// BindDN and BindPassword are the account searching the directory for
+	// users signing in. The search is anonymous when BindDN is empty
+	BindDN       string
+	BindPassword string
+	// BaseDN is the entry users and groups are searched below
+	BaseDN string
+	// UserFilter finds the entry of a user, with {login} standing for what
+	// they entered as their email address or name
+	UserFilter string
+	// GroupFilter, when set, only lets users in when it finds at least one
+	// group, with {dn} standing for the entry of the user and {login} as
+	// above
+	GroupFilter string
+	// MemberFilter finds the groups a user belongs to, with {dn} and
+	// {login} as in GroupFilter. Workspaces map them by the value of their
+	// GroupNameAttribute, like the groups of single sign-on. Groups are not
+	// looked up when it is empty
+	MemberFilter       string
+	GroupNameAttribute string
+	// IDAttribute holds a value naming a user for good, such as entryUUID
+	// or objectGUID. The DN of the entry is used when it is empty
+	IDAttribute    string
+	NameAttribute  string
+	EmailAttribute string
+}
+
+type OIDCConfig struct {
+	// Issuer is the address of the identity provider, its endpoints and
+	// keys are discovered from it. Single sign-on is off when it is empty
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the address the provider sends users back to, which
+	// must be registered with it. It defaults to /login/sso/callback on
+	// BaseURL
+	RedirectURL string
+	// Scopes are requested on top of openid, to get the email address,
+	// name and groups of users
+	Scopes []string
+	// GroupsClaim is the claim of the ID token listing the groups of a user
+	GroupsClaim string
+	// TrustedDomains are email domains workspaces may map without proving
+	// they own them with a DNS record, such as the domain of the company
+	// running the instance
+	TrustedDomains []string
+	// Name is the name of the provider shown on the sign in button
+	Name string
+}
+
+// Enabled reports whether single sign-on with an identity provider is set
+// up.
+func (c OIDCConfig) Enabled() bool {
+	return c.Issuer != ""
+}
+
 type ServerConfig struct {
 	Port int
 	Host string
 	Env  string
+	// BaseURL is the address Wryte is reached at, such as
+	// "https://wryte.example.com". Links sent by email or copied out of
+	// Wryte are built from it, never from the Host header of a request,
+	// which the client chooses. It defaults to http on the server port of
+	// localhost in development, and is required otherwise
+	BaseURL string
+	// SecretKey signs the values Wryte hands out for itself to check later,
+	// such as the cookies unlocking password protected links. Changing it
+	// invalidates them. It has a fixed default in development, and is
+	// required otherwise
+	SecretKey string
 }
 
 func Load() (*Config, error) {
 	cfg := &Config{
 		Project: ProjectConfig{
-			IsCloud: getEnv("IS_CLOUD", "false") == "true",
+			IsCloud:           getEnv("IS_CLOUD", "false") == "true",
+			EmailVerification: getEnv("EMAIL_VERIFICATION", "limit"),
 		},
 		Server: ServerConfig{
-			Port: getEnvAsInt("PORT", 8080),
-			Host: getEnv("HOST", "localhost"),
-			Env:  getEnv("ENV", "development"),
+			Port:      getEnvAsInt("PORT", 8080),
+			Host:      getEnv("HOST", "localhost"),
+			Env:       getEnv("ENV", "development"),
+			BaseURL:   strings.TrimSuffix(getEnv("BASE_URL", ""), "/"),
+			SecretKey: getEnv("SECRET_KEY", ""),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -53,6 +243,96 @@ func Load() (*Config, error) {
 			SSLMode:  getEnv("DB_SSL_MODE", "disable"),
 			Schema:   getEnv("DB_SCHEMA", "public"),
 		},
+		Document: DocumentConfig{
+			RevisionWindow:    time.Duration(getEnvAsInt("REVISION_WINDOW_SECONDS", 300)) * time.Second,
+			TrashRetention:    time.Duration(getEnvAsInt("TRASH_RETENTION_DAYS", 30)) * 24 * time.Hour,
+			ImportMaxSize:     int64(getEnvAsInt("IMPORT_MAX_SIZE_MB", 100)) << 20,
+			AttachmentMaxSize: int64(getEnvAsInt("ATTACHMENT_MAX_SIZE_MB", 25)) << 20,
+			ImageMaxSize:      int64(getEnvAsInt("IMAGE_MAX_SIZE_MB", 10)) << 20,
+		},
+		Storage: StorageConfig{
+			Backend: getEnv("STORAGE_BACKEND", "local"),
+			Path:    getEnv("STORAGE_PATH", "data/attachments"),
+			S3: S3Config{
+				Endpoint:        getEnv("S3_ENDPOINT", ""),
+				Region:          getEnv("S3_REGION", "us-east-1"),
+				Bucket:          getEnv("S3_BUCKET", ""),
+				AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+				SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+				PathStyle:       getEnv("S3_PATH_STYLE", "false") == "true",
+			},
+		},
+		Mail: MailConfig{
+			Backend:    getEnv("MAIL_BACKEND", ""),
+			Host:       getEnv("SMTP_HOST", ""),
+			Port:       getEnvAsInt("SMTP_PORT", 587),
+			Username:   getEnv("SMTP_USERNAME", ""),
+			Password:   getEnv("SMTP_PASSWORD", ""),
+			Security:   getEnv("SMTP_SECURITY", "starttls"),
+			From:       getEnv("MAIL_FROM", "Wryte <wryte@localhost>"),
+			OutboxPath: getEnv("MAIL_OUTBOX_PATH", "data/outbox"),
+		},
+		Auth: AuthConfig{
+			RequireTwoFactor: getEnv("REQUIRE_TWO_FACTOR", "false") == "true",
+			PasskeyRPID:      getEnv("PASSKEY_RP_ID", "localhost"),
+			OIDC: OIDCConfig{
+				Issuer:       getEnv("OIDC_ISSUER", ""),
+				ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+				ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+				RedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
+				Scopes:       strings.Fields(getEnv("OIDC_SCOPES", "email profile")),
+				GroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+				Name:         getEnv("OIDC_NAME", "SSO"),
+			},
+			SSOOnly:  getEnv("SSO_ONLY", "false") == "true",
+			Provider: getEnv("AUTH_PROVIDER", "database"),
+			LDAP: LDAPConfig{
+				URL:                getEnv("LDAP_URL", ""),
+				StartTLS:           getEnv("LDAP_START_TLS", "false") == "true",
+				BindDN:             getEnv("LDAP_BIND_DN", ""),
+				BindPassword:       getEnv("LDAP_BIND_PASSWORD", ""),
+				BaseDN:             getEnv("LDAP_BASE_DN", ""),
+				UserFilter:         getEnv("LDAP_USER_FILTER", "(&(objectClass=person)(|(uid={login})(mail={login})))"),
+				GroupFilter:        getEnv("LDAP_GROUP_FILTER", ""),
+				MemberFilter:       getEnv("LDAP_MEMBER_FILTER", "(|(member={dn})(uniqueMember={dn})(memberUid={login}))"),
+				GroupNameAttribute: getEnv("LDAP_GROUP_NAME_ATTRIBUTE", "cn"),
+				IDAttribute:        getEnv("LDAP_ID_ATTRIBUTE", ""),
+				NameAttribute:      getEnv("LDAP_NAME_ATTRIBUTE", "cn"),
+				EmailAttribute:     getEnv("LDAP_EMAIL_ATTRIBUTE", "mail"),
+			},
+		},
+	}
+
+	if cfg.Server.BaseURL == "" && cfg.IsDevelopment() {
+		cfg.Server.BaseURL = fmt.Sprintf("http://localhost:%d", cfg.Server.Port)
+	}
+	if cfg.Server.SecretKey == "" && cfg.IsDevelopment() {
+		cfg.Server.SecretKey = developmentSecretKey
+	}
+
+	if origins := getEnv("PASSKEY_ORIGINS", ""); origins != "" {
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.Auth.PasskeyOrigins = append(cfg.Auth.PasskeyOrigins, origin)
+			}
+		}
+	} else if cfg.Auth.PasskeyRPID == "localhost" {
+		cfg.Auth.PasskeyOrigins = []string{fmt.Sprintf("http://localhost:%d", cfg.Server.Port)}
+	} else {
+		cfg.Auth.PasskeyOrigins = []string{"https://" + cfg.Auth.PasskeyRPID}
+	}
+
+	for _, domain := range strings.Split(getEnv("OIDC_TRUSTED_DOMAINS", ""), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			cfg.Auth.OIDC.TrustedDomains = append(cfg.Auth.OIDC.TrustedDomains, domain)
+		}
+	}
+
+	if cfg.Mail.Backend == "" {
+		cfg.Mail.Backend = "smtp"
+		if cfg.Mail.Host == "" && cfg.IsDevelopment() {
+			cfg.Mail.Backend = "outbox"
+		}
 	}
 
 	if err := cfg.Validate(); err != nil {
@@ -77,6 +357,111 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid environment: %s (must be development, staging, or production)", c.Server.Env)
 	}
 
+	if c.Server.BaseURL == "" {
+		return fmt.Errorf("BASE_URL is required when ENV is %s", c.Server.Env)
+	}
+	if u, err := url.Parse(c.Server.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid base URL: %s (must be an http or https address)", c.Server.BaseURL)
+	}
+
+	if c.Server.SecretKey == "" {
+		return fmt.Errorf("SECRET_KEY is required when ENV is %s", c.Server.Env)
+	}
+	if len(c.Server.SecretKey) < minSecretKeyLength {
+		return fmt.Errorf("invalid secret key: %d characters (must be at least %d)", len(c.Server.SecretKey), minSecretKeyLength)
+	}
+
+	switch c.Project.EmailVerification {
+	case "block", "limit", "off":
+	default:
+		return fmt.Errorf("invalid email verification policy: %s (must be block, limit or off)", c.Project.EmailVerification)
+	}
+
+	if c.Document.ImportMaxSize <= 0 {
+		return fmt.Errorf("invalid import size limit: %d bytes (must be positive)", c.Document.ImportMaxSize)
+	}
+
+	if c.Document.AttachmentMaxSize <= 0 {
+		return fmt.Errorf("invalid attachment size limit: %d bytes (must be positive)", c.Document.AttachmentMaxSize)
+	}
+
+	if c.Document.ImageMaxSize <= 0 {
+		return fmt.Errorf("invalid image size limit: %d bytes (must be positive)", c.Document.ImageMaxSize)
+	}
+
+	switch c.Storage.Backend {
+	case "local":
+	case "s3":
+		if c.Storage.S3.Bucket == "" {
+			return fmt.Errorf("S3_BUCKET is required when STORAGE_BACKEND is s3")
+		}
+	default:
+		return fmt.Errorf("invalid storage backend: %s (must be local or s3)", c.Storage.Backend)
+	}
+
+	switch c.Mail.Backend {
+	case "outbox":
+	case "smtp":
+		if c.Mail.Host == "" {
+			return fmt.Errorf("SMTP_HOST is required when MAIL_BACKEND is smtp (set MAIL_BACKEND=outbox to write emails to files instead)")
+		}
+		if c.Mail.Port < 1 || c.Mail.Port > 65535 {
+			return fmt.Errorf("invalid SMTP port: %d (must be between 1-65535)", c.Mail.Port)
+		}
+		switch c.Mail.Security {
+		case "starttls", "tls", "none":
+		default:
+			return fmt.Errorf("invalid SMTP security: %s (must be starttls, tls or none)", c.Mail.Security)
+		}
+	default:
+		return fmt.Errorf("invalid mail backend: %s (must be smtp or outbox)", c.Mail.Backend)
+	}
+
+	if c.Auth.OIDC.Enabled() {
+		if u, err := url.Parse(c.Auth.OIDC.Issuer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid OIDC issuer: %s (must be an http or https address)", c.Auth.OIDC.Issuer)
+		}
+		if c.Auth.OIDC.ClientID == "" {
+			return fmt.Errorf("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+		}
+	}
+	if c.Auth.SSOOnly && !c.Auth.OIDC.Enabled() {
+		return fmt.Errorf("SSO_ONLY requires single sign-on to be set up with OIDC_ISSUER")
+	}
+
+	switch c.Auth.Provider {
+	case "database":
+	case "ldap":
+		if u, err := url.Parse(c.Auth.LDAP.URL); err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+			return fmt.Errorf("invalid LDAP URL: %s (must be an ldap or ldaps address)", c.Auth.LDAP.URL)
+		}
+		if c.Auth.LDAP.BaseDN == "" {
+			return fmt.Errorf("LDAP_BASE_DN is required when AUTH_PROVIDER is ldap")
+		}
+		if !strings.Contains(c.Auth.LDAP.UserFilter, "{login}") {
+			return fmt.Errorf("invalid LDAP user filter: %s (must contain {login})", c.Auth.LDAP.UserFilter)
+		}
+		if c.Auth.LDAP.NameAttribute == "" || c.Auth.LDAP.EmailAttribute == "" {
+			return fmt.Errorf("LDAP_NAME_ATTRIBUTE and LDAP_EMAIL_ATTRIBUTE must not be empty")
+		}
+		if c.Auth.LDAP.MemberFilter != "" && c.Auth.LDAP.GroupNameAttribute == "" {
+			return fmt.Errorf("LDAP_GROUP_NAME_ATTRIBUTE must not be empty when LDAP_MEMBER_FILTER is set")
+		}
+	default:
+		return fmt.Errorf("invalid auth provider: %s (must be database or ldap)", c.Auth.Provider)
+	}
+
+	if c.Auth.PasskeyRPID == "" || strings.ContainsAny(c.Auth.PasskeyRPID, ":/") {
+		return fmt.Errorf("invalid passkey relying party ID: %q (must be a host name)", c.Auth.PasskeyRPID)
+	}
+	for _, origin := range c.Auth.PasskeyOrigins {
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid passkey origin: %s (must be an http or https address)", origin)
+		}
+	}
+
 	return nil
 }
 
@@ -88,6 +473,12 @@ func (c *Config) IsProduction() bool {
 	return c.Server.Env == "production"
 }
 
+// URL turns path into an address on BaseURL, for links sent or copied out of
+// Wryte.
+func (c *Config) URL(path string) string {
+	return c.Server.BaseURL + path
+}
+
 func (c *Config) Addr() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
@@ -0,0 +1,312 @@
+// Package crdt implements a Replicated Growable Array (RGA), a sequence CRDT
+// used to merge concurrent edits to the text of a document.
+//
+// Every character is an element with a globally unique ID made of a Lamport
+// counter and the site that created it. Inserts name the element they follow
+// and deletes only mark elements as tombstones, so replicas that applied the
+// same set of operations hold the same text regardless of delivery order.
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	OpInsert = "ins"
+	OpDelete = "del"
+
+	// maxPending bounds the operations a replica holds back until their
+	// dependencies arrive, so that operations naming elements that never
+	// arrive cannot grow it without end.
+	maxPending = 1024
+)
+
+var (
+	ErrInvalidOp = errors.New("crdt: invalid operation")
+	// ErrMissing is returned when an operation refers to an element that has
+	// not been received yet.
+	ErrMissing = errors.New("crdt: unknown element")
+	// ErrBacklog is returned when an operation has to wait for its
+	// dependencies but too many operations already do.
+	ErrBacklog = errors.New("crdt: too many operations waiting for their dependencies")
+)
+
+// ID identifies an element. The zero ID stands for the head of the sequence.
+type ID struct {
+	Counter uint64 `json:"c"`
+	Site    string `json:"s"`
+}
+
+func (id ID) IsZero() bool {
+	return id.Counter == 0 && id.Site == ""
+}
+
+// after reports whether id sorts before other when both were inserted at the
+// same position. Higher counters win, ties are broken by site.
+func (id ID) after(other ID) bool {
+	if id.Counter != other.Counter {
+		return id.Counter > other.Counter
+	}
+	return id.Site > other.Site
+}
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Counter, 10) + "@" + id.Site
+}
+
+// Op is a single insert or delete.
+type Op struct {
+	Type  string `json:"t"`
+	ID    ID     `json:"id"`
+	After ID     `json:"a,omitzero"`
+	Value string `json:"v,omitempty"`
+}
+
+// Validate checks that op is well formed.
+func (op Op) Validate() error {
+	if op.ID.Counter == 0 || op.ID.Site == "" {
+		return ErrInvalidOp
+	}
+	switch op.Type {
+	case OpInsert:
+		if utf8.RuneCountInString(op.Value) != 1 {
+			return ErrInvalidOp
+		}
+		// An element is created after the one it follows, and the ordering
+		// of concurrent inserts relies on its counter being higher
+		if !op.After.IsZero() && op.ID.Counter <= op.After.Counter {
+			return ErrInvalidOp
+		}
+	case OpDelete:
+	default:
+		return ErrInvalidOp
+	}
+	return nil
+}
+
+type element struct {
+	ID      ID     `json:"id"`
+	Value   string `json:"v"`
+	Deleted bool   `json:"d,omitempty"`
+}
+
+// Doc is a replica of a sequence. It is not safe for concurrent use.
+type Doc struct {
+	elements []element
+	index    map[ID]int
+	clock    uint64
+
+	// pending holds operations whose dependencies have not arrived yet
+	pending []Op
+}
+
+func New() *Doc {
+	return &Doc{index: make(map[ID]int)}
+}
+
+// FromText builds a replica holding text. Element IDs are derived from site
+// only, so replicas built from the same text and site are identical.
+func FromText(text, site string) *Doc {
+	d := New()
+	var prev ID
+	for _, r := range text {
+		id := ID{Counter: d.clock + 1, Site: site}
+		d.Apply(Op{Type: OpInsert, ID: id, After: prev, Value: string(r)})
+		prev = id
+	}
+	return d
+}
+
+// Text returns the visible content of the replica.
+func (d *Doc) Text() string {
+	var b strings.Builder
+	for _, e := range d.elements {
+		if !e.Deleted {
+			b.WriteString(e.Value)
+		}
+	}
+	return b.String()
+}
+
+// Clock returns the highest Lamport counter seen by the replica.
+func (d *Doc) Clock() uint64 {
+	return d.clock
+}
+
+// Visible returns the IDs of the elements that are not deleted, in order.
+func (d *Doc) Visible() []ID {
+	var ids []ID
+	for _, e := range d.elements {
+		if !e.Deleted {
+			ids = append(ids, e.ID)
+		}
+	}
+	return ids
+}
+
+// Check validates ops and makes sure that each of them only refers to
+// elements of the replica or to elements inserted by an earlier operation of
+// ops. It returns ErrMissing otherwise, for operations that would have to
+// wait for elements that may never arrive.
+func (d *Doc) Check(ops []Op) error {
+	inserted := make(map[ID]struct{})
+	known := func(id ID) bool {
+		if _, ok := d.index[id]; ok {
+			return true
+		}
+		_, ok := inserted[id]
+		return ok
+	}
+
+	for _, op := range ops {
+		if err := op.Validate(); err != nil {
+			return err
+		}
+		switch op.Type {
+		case OpInsert:
+			if !op.After.IsZero() && !known(op.After) {
+				return ErrMissing
+			}
+			inserted[op.ID] = struct{}{}
+		case OpDelete:
+			if !known(op.ID) {
+				return ErrMissing
+			}
+		}
+	}
+	return nil
+}
+
+// Apply integrates op and any pending operations that it unblocked. It
+// returns the operations that changed the replica, in the order they were
+// applied. Operations that were already applied are ignored, which makes
+// redelivery harmless.
+func (d *Doc) Apply(op Op) ([]Op, error) {
+	if err := op.Validate(); err != nil {
+		return nil, err
+	}
+
+	changed, err := d.apply(op)
+	if errors.Is(err, ErrMissing) {
+		if len(d.pending) >= maxPending {
+			return nil, ErrBacklog
+		}
+		d.pending = append(d.pending, op)
+		return nil, nil
+	}
+	if err != nil || !changed {
+		return nil, err
+	}
+
+	applied := []Op{op}
+
+	// Retry pending operations until no more progress is made
+	for progress := true; progress && len(d.pending) > 0; {
+		progress = false
+		remaining := d.pending[:0]
+		for _, p := range d.pending {
+			changed, err := d.apply(p)
+			switch {
+			case errors.Is(err, ErrMissing):
+				remaining = append(remaining, p)
+			case err == nil:
+				progress = true
+				if changed {
+					applied = append(applied, p)
+				}
+			}
+		}
+		d.pending = remaining
+	}
+
+	return applied, nil
+}
+
+func (d *Doc) apply(op Op) (bool, error) {
+	if op.ID.Counter > d.clock {
+		d.clock = op.ID.Counter
+	}
+
+	switch op.Type {
+	case OpInsert:
+		if _, exists := d.index[op.ID]; exists {
+			return false, nil
+		}
+
+		pos := 0
+		if !op.After.IsZero() {
+			i, ok := d.index[op.After]
+			if !ok {
+				return false, ErrMissing
+			}
+			pos = i + 1
+		}
+
+		// Concurrent inserts at the same position are ordered by ID. Elements
+		// that follow a newer sibling were created after it and carry even
+		// newer IDs, so skipping while the next ID sorts first also skips
+		// their subtrees.
+		for pos < len(d.elements) && d.elements[pos].ID.after(op.ID) {
+			pos++
+		}
+
+		d.elements = append(d.elements, element{})
+		copy(d.elements[pos+1:], d.elements[pos:])
+		d.elements[pos] = element{ID: op.ID, Value: op.Value}
+		for i := pos; i < len(d.elements); i++ {
+			d.index[d.elements[i].ID] = i
+		}
+		return true, nil
+
+	case OpDelete:
+		i, ok := d.index[op.ID]
+		if !ok {
+			return false, ErrMissing
+		}
+		if d.elements[i].Deleted {
+			return false, nil
+		}
+		d.elements[i].Deleted = true
+		return true, nil
+	}
+
+	return false, ErrInvalidOp
+}
+
+type snapshot struct {
+	Clock    uint64    `json:"clock"`
+	Elements []element `json:"elements"`
+}
+
+// MarshalJSON encodes the full replica state, tombstones included, so that it
+// can be loaded by another replica.
+func (d *Doc) MarshalJSON() ([]byte, error) {
+	elements := d.elements
+	if elements == nil {
+		elements = []element{}
+	}
+	return json.Marshal(snapshot{Clock: d.clock, Elements: elements})
+}
+
+func (d *Doc) UnmarshalJSON(data []byte) error {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	d.elements = s.Elements
+	d.clock = s.Clock
+	d.pending = nil
+	d.index = make(map[ID]int, len(s.Elements))
+	for i, e := range s.Elements {
+		d.index[e.ID] = i
+		if e.ID.Counter > d.clock {
+			d.clock = e.ID.Counter
+		}
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package crdt
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func insert(counter uint64, site string, after ID, value string) Op {
+	return Op{Type: OpInsert, ID: ID{Counter: counter, Site: site}, After: after, Value: value}
+}
+
+func TestFromText(t *testing.T) {
+	a := FromText("héllo", "v1")
+	b := FromText("héllo", "v1")
+
+	if got := a.Text(); got != "héllo" {
+		t.Errorf("Text() = %q, want %q", got, "héllo")
+	}
+	if got := a.Clock(); got != 5 {
+		t.Errorf("Clock() = %d, want 5", got)
+	}
+
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	if string(ja) != string(jb) {
+		t.Errorf("replicas built from the same text differ:\n%s\n%s", ja, jb)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	head := ID{}
+	first := ID{Counter: 1, Site: "a"}
+
+	tests := []struct {
+		name string
+		op   Op
+		ok   bool
+	}{
+		{"insert at head", insert(1, "a", head, "x"), true},
+		{"insert after", insert(2, "a", first, "x"), true},
+		{"delete", Op{Type: OpDelete, ID: first}, true},
+		{"zero counter", insert(0, "a", head, "x"), false},
+		{"no site", insert(1, "", head, "x"), false},
+		{"empty value", insert(1, "a", head, ""), false},
+		{"several characters", insert(1, "a", head, "xy"), false},
+		{"counter not above predecessor", insert(1, "b", first, "x"), false},
+		{"unknown type", Op{Type: "move", ID: first}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.op.Validate()
+			if tt.ok && err != nil {
+				t.Errorf("Validate() = %v, want nil", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidOp) {
+				t.Errorf("Validate() = %v, want ErrInvalidOp", err)
+			}
+		})
+	}
+}
+
+func TestApplyConcurrentInserts(t *testing.T) {
+	a := ID{Counter: 1, Site: "v1"}
+
+	// Two sites insert after the same element without seeing each other,
+	// every replica must order them the same way
+	fromX := insert(3, "x", a, "b")
+	fromY := insert(3, "y", a, "B")
+
+	for _, order := range [][]Op{{fromX, fromY}, {fromY, fromX}} {
+		doc := FromText("ac", "v1")
+		for _, op := range order {
+			if _, err := doc.Apply(op); err != nil {
+				t.Fatalf("Apply(%v) = %v", op, err)
+			}
+		}
+		if got := doc.Text(); got != "aBbc" {
+			t.Errorf("Text() = %q, want %q", got, "aBbc")
+		}
+	}
+}
+
+func TestApplyOutOfOrder(t *testing.T) {
+	doc := New()
+	first := insert(1, "a", ID{}, "h")
+	second := insert(2, "a", first.ID, "i")
+	del := Op{Type: OpDelete, ID: second.ID}
+
+	for _, op := range []Op{del, second} {
+		applied, err := doc.Apply(op)
+		if err != nil || len(applied) != 0 {
+			t.Fatalf("Apply(%v) = %v, %v, want it held back", op, applied, err)
+		}
+	}
+
+	applied, err := doc.Apply(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 3 {
+		t.Errorf("Apply released %d operations, want 3", len(applied))
+	}
+	if got := doc.Text(); got != "h" {
+		t.Errorf("Text() = %q, want %q", got, "h")
+	}
+
+	// Redelivery changes nothing
+	for _, op := range []Op{first, second, del} {
+		if applied, err := doc.Apply(op); err != nil || len(applied) != 0 {
+			t.Errorf("Apply(%v) again = %v, %v", op, applied, err)
+		}
+	}
+}
+
+func TestApplyBacklog(t *testing.T) {
+	doc := New()
+	missing := ID{Counter: 1, Site: "gone"}
+
+	for i := range maxPending {
+		op := insert(uint64(i+2), "a", missing, "x")
+		if _, err := doc.Apply(op); err != nil {
+			t.Fatalf("Apply #%d = %v", i, err)
+		}
+	}
+
+	_, err := doc.Apply(insert(maxPending+2, "a", missing, "x"))
+	if !errors.Is(err, ErrBacklog) {
+		t.Errorf("Apply past the backlog = %v, want ErrBacklog", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	doc := FromText("ab", "v1")
+	a := ID{Counter: 1, Site: "v1"}
+	unknown := ID{Counter: 9, Site: "z"}
+
+	tests := []struct {
+		name string
+		ops  []Op
+		want error
+	}{
+		{"known elements", []Op{insert(3, "x", a, "c"), {Type: OpDelete, ID: a}}, nil},
+		{"earlier in the batch", []Op{insert(3, "x", a, "c"), insert(4, "x", ID{Counter: 3, Site: "x"}, "d")}, nil},
+		{"unknown predecessor", []Op{insert(10, "x", unknown, "c")}, ErrMissing},
+		{"unknown deletion", []Op{{Type: OpDelete, ID: unknown}}, ErrMissing},
+		{"later in the batch", []Op{insert(4, "x", ID{Counter: 3, Site: "x"}, "d"), insert(3, "x", a, "c")}, ErrMissing},
+		{"invalid", []Op{insert(1, "x", a, "c")}, ErrInvalidOp},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := doc.Check(tt.ops); !errors.Is(err, tt.want) {
+				t.Errorf("Check() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	doc := FromText("hello", "v1")
+	doc.Apply(Op{Type: OpDelete, ID: ID{Counter: 1, Site: "v1"}})
+	doc.Apply(insert(6, "a", ID{Counter: 5, Site: "v1"}, "!"))
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := New()
+	if err := json.Unmarshal(data, loaded); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := loaded.Text(); got != "ello!" {
+		t.Errorf("Text() = %q, want %q", got, "ello!")
+	}
+	if got := loaded.Clock(); got != doc.Clock() {
+		t.Errorf("Clock() = %d, want %d", got, doc.Clock())
+	}
+	// Tombstones are kept so late operations still find their element
+	if _, err := loaded.Apply(insert(7, "b", ID{Counter: 1, Site: "v1"}, "H")); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Text(); got != "Hello!" {
+		t.Errorf("Text() = %q, want %q", got, "Hello!")
+	}
+}
+
+// TestConvergence edits replicas concurrently and delivers the operations of
+// the others in random order, causal order included.
+func TestConvergence(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	sites := []string{"a", "b", "c"}
+
+	for range 200 {
+		replicas := make([]*Doc, len(sites))
+		for i := range replicas {
+			replicas[i] = FromText("hello", "v1")
+		}
+
+		var logs [][]Op
+		for i, doc := range replicas {
+			var ops []Op
+			for range 10 {
+				visible := doc.Visible()
+				var op Op
+				if len(visible) > 0 && rnd.Intn(3) == 0 {
+					op = Op{Type: OpDelete, ID: visible[rnd.Intn(len(visible))]}
+				} else {
+					var after ID
+					if len(visible) > 0 && rnd.Intn(5) > 0 {
+						after = visible[rnd.Intn(len(visible))]
+					}
+					op = insert(doc.Clock()+1, sites[i], after, string(rune('a'+rnd.Intn(26))))
+				}
+				if _, err := doc.Apply(op); err != nil {
+					t.Fatal(err)
+				}
+				ops = append(ops, op)
+			}
+			logs = append(logs, ops)
+		}
+
+		for i, doc := range replicas {
+			var others []Op
+			for j, ops := range logs {
+				if j != i {
+					others = append(others, ops...)
+				}
+			}
+			rnd.Shuffle(len(others), func(x, y int) { others[x], others[y] = others[y], others[x] })
+			for _, op := range others {
+				if _, err := doc.Apply(op); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		want := replicas[0].Text()
+		for i, doc := range replicas[1:] {
+			if got := doc.Text(); got != want {
+				t.Fatalf("replica %d = %q, replica 0 = %q", i+1, got, want)
+			}
+			if len(doc.pending) != 0 {
+				t.Fatalf("replica %d holds %d operations back", i+1, len(doc.pending))
+			}
+		}
+	}
+}
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_documents_document_path_pattern;
+
+ALTER TABLE documents ALTER COLUMN document_path TYPE VARCHAR(255);
\ No newline at end of file
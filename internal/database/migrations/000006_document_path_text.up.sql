@@ -0,0 +1,4 @@
+ALTER TABLE documents ALTER COLUMN document_path TYPE TEXT;
+
+-- Prefix lookups on document_path (LIKE 'path/%') need a pattern index
+CREATE INDEX IF NOT EXISTS idx_documents_document_path_pattern ON documents(document_path text_pattern_ops);
\ No newline at end of file
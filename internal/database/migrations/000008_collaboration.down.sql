@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS document_crdt;
+DROP TABLE IF EXISTS document_ops;
\ No newline at end of file
@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS document_ops (
+    seq BIGSERIAL PRIMARY KEY,
+    document_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    ops JSONB NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_document_ops_document_id FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+    CONSTRAINT fk_document_ops_user_id FOREIGN KEY (user_id) REFERENCES users(id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_ops_document_id ON document_ops(document_id, seq);
+
+CREATE TABLE IF NOT EXISTS document_crdt (
+    document_id UUID PRIMARY KEY,
+    state JSONB NOT NULL,
+    seq BIGINT NOT NULL DEFAULT 0,
+
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_document_crdt_document_id FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE
+);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS document_presence;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS document_presence (
+    id UUID PRIMARY KEY,
+    document_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    session VARCHAR(64) NOT NULL,
+    mode VARCHAR(16) NOT NULL,
+    cursor JSONB,
+
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_document_presence_document_id FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+    CONSTRAINT fk_document_presence_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_document_presence_document_id ON document_presence(document_id, session);
+CREATE INDEX IF NOT EXISTS idx_document_presence_expires_at ON document_presence(expires_at);
\ No newline at end of file
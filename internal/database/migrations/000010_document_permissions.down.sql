@@ -0,0 +1 @@
+DROP TABLE IF EXISTS document_permissions;
\ No newline at end of file
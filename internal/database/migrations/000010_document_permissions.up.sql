@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS document_permissions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL,
+    user_id UUID,
+    workspace_id UUID,
+    role VARCHAR(16) NOT NULL,
+    granted_by UUID NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_document_permissions_document_id FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+    CONSTRAINT fk_document_permissions_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT fk_document_permissions_workspace_id FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    CONSTRAINT fk_document_permissions_granted_by FOREIGN KEY (granted_by) REFERENCES users(id),
+    CONSTRAINT chk_document_permissions_role CHECK (role IN ('viewer', 'commenter', 'editor', 'owner')),
+    CONSTRAINT chk_document_permissions_grantee CHECK ((user_id IS NULL) <> (workspace_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_document_permissions_user ON document_permissions(document_id, user_id) WHERE user_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_document_permissions_workspace ON document_permissions(document_id, workspace_id) WHERE workspace_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_document_permissions_user_id ON document_permissions(user_id);
\ No newline at end of file
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS public_links (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    slug VARCHAR(64) NOT NULL UNIQUE,
+    document_id UUID,
+    workspace_id UUID,
+    password_hash VARCHAR(255),
+    expires_at TIMESTAMP WITH TIME ZONE,
+    allow_indexing BOOLEAN NOT NULL DEFAULT false,
+    created_by UUID NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_public_links_document_id FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+    CONSTRAINT fk_public_links_workspace_id FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    CONSTRAINT fk_public_links_created_by FOREIGN KEY (created_by) REFERENCES users(id),
+    CONSTRAINT chk_public_links_target CHECK ((document_id IS NULL) <> (workspace_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_public_links_document_id ON public_links(document_id) WHERE document_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_public_links_workspace_id ON public_links(workspace_id) WHERE workspace_id IS NOT NULL;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_documents_title_trgm;
+DROP INDEX IF EXISTS idx_documents_search_vector;
+ALTER TABLE documents DROP COLUMN IF EXISTS search_vector;
+DROP EXTENSION IF EXISTS pg_trgm;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_documents_deleted_at;
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS fk_documents_deleted_by;
+ALTER TABLE documents DROP COLUMN IF EXISTS deleted_by;
\ No newline at end of file
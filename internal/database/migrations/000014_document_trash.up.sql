@@ -0,0 +1,10 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS deleted_by UUID;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_documents_deleted_by') THEN
+        ALTER TABLE documents ADD CONSTRAINT fk_documents_deleted_by FOREIGN KEY (deleted_by) REFERENCES users(id) ON DELETE SET NULL;
+    END IF;
+END
+$$;
+
+CREATE INDEX IF NOT EXISTS idx_documents_deleted_at ON documents(deleted_at) WHERE deleted_at IS NOT NULL;
\ No newline at end of file
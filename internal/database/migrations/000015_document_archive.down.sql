@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_documents_archived_at;
+ALTER TABLE documents DROP CONSTRAINT IF EXISTS fk_documents_archived_by;
+ALTER TABLE documents DROP COLUMN IF EXISTS archived_by;
+ALTER TABLE documents DROP COLUMN IF EXISTS archived_at;
\ No newline at end of file
@@ -0,0 +1,18 @@
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS archived_by UUID;
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'fk_documents_archived_by') THEN
+        ALTER TABLE documents ADD CONSTRAINT fk_documents_archived_by FOREIGN KEY (archived_by) REFERENCES users(id) ON DELETE SET NULL;
+    END IF;
+END
+$$;
+
+-- Archiving applies to whole subtrees, carry the flag of documents archived so
+-- far down to their descendants
+UPDATE documents SET archived_at = updated_at WHERE is_archived AND archived_at IS NULL;
+UPDATE documents d SET is_archived = true, archived_at = a.archived_at
+FROM documents a
+WHERE a.is_archived AND NOT d.is_archived AND d.document_path LIKE a.document_path || '/%';
+
+CREATE INDEX IF NOT EXISTS idx_documents_archived_at ON documents(archived_at) WHERE archived_at IS NOT NULL;
\ No newline at end of file
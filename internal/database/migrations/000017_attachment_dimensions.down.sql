@@ -0,0 +1,2 @@
+ALTER TABLE attachments DROP COLUMN IF EXISTS height;
+ALTER TABLE attachments DROP COLUMN IF EXISTS width;
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS sso_mappings;
+DROP TABLE IF EXISTS sso_logins;
+DROP TABLE IF EXISTS user_identities;
\ No newline at end of file
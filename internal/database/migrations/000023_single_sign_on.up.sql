@@ -0,0 +1,43 @@
+-- Accounts of identity providers that users sign in with
+CREATE TABLE IF NOT EXISTS user_identities (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    last_login_at TIMESTAMP WITH TIME ZONE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_user_identities_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    CONSTRAINT uq_user_identities_subject UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- Sign ins sent to the identity provider, until it sends the user back
+CREATE TABLE IF NOT EXISTS sso_logins (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    state_hash VARCHAR(64) NOT NULL UNIQUE,
+    nonce VARCHAR(64) NOT NULL,
+    code_verifier VARCHAR(128) NOT NULL,
+    next TEXT NOT NULL DEFAULT '/',
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Workspaces that users signing in with single sign-on join, by the domain
+-- of their email address or a group they belong to
+CREATE TABLE IF NOT EXISTS sso_mappings (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    workspace_id UUID NOT NULL,
+    kind VARCHAR(16) NOT NULL CHECK (kind IN ('domain', 'group')),
+    value VARCHAR(255) NOT NULL,
+    role VARCHAR(16) NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_sso_mappings_workspace_id FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+    CONSTRAINT chk_sso_mappings_role CHECK (role IN ('member', 'admin')),
+    CONSTRAINT uq_sso_mappings_value UNIQUE (workspace_id, kind, value)
+);
\ No newline at end of file
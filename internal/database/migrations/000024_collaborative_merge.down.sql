@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS document_sites;
+
+DELETE FROM document_ops WHERE user_id IS NULL;
+ALTER TABLE document_ops ALTER COLUMN user_id SET NOT NULL;
+
+ALTER TABLE document_crdt DROP COLUMN IF EXISTS base_content;
+ALTER TABLE document_crdt DROP COLUMN IF EXISTS base_version;
\ No newline at end of file
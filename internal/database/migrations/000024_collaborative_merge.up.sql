@@ -0,0 +1,26 @@
+-- Version and content of the document the replica was last in step with, the
+-- starting point for merging changes made to the content outside of a session
+ALTER TABLE document_crdt ADD COLUMN IF NOT EXISTS base_version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE document_crdt ADD COLUMN IF NOT EXISTS base_content TEXT NOT NULL DEFAULT '';
+
+UPDATE document_crdt c
+SET base_version = d.version, base_content = COALESCE(d.content, '')
+FROM documents d
+WHERE d.id = c.document_id AND c.base_version = 0;
+
+-- Operations that merge outside changes are not made by anyone in particular
+ALTER TABLE document_ops ALTER COLUMN user_id DROP NOT NULL;
+
+-- Replica sites claimed by editors, so that nobody creates elements under the
+-- site of someone else
+CREATE TABLE IF NOT EXISTS document_sites (
+    document_id UUID NOT NULL,
+    site VARCHAR(64) NOT NULL,
+    user_id UUID NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (document_id, site),
+    CONSTRAINT fk_document_sites_document_id FOREIGN KEY (document_id) REFERENCES documents(id) ON DELETE CASCADE,
+    CONSTRAINT fk_document_sites_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
\ No newline at end of file
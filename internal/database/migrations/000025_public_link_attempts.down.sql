@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public_link_attempts;
\ No newline at end of file
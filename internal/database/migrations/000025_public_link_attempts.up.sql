@@ -0,0 +1,14 @@
+-- Failed attempts at the password of a protected public link, kept for a
+-- while to slow down guessing
+CREATE TABLE IF NOT EXISTS public_link_attempts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    link_id UUID NOT NULL,
+    ip_address VARCHAR(45) NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_public_link_attempts_link_id FOREIGN KEY (link_id) REFERENCES public_links(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_public_link_attempts_link_id ON public_link_attempts(link_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_public_link_attempts_ip_address ON public_link_attempts(ip_address, created_at);
\ No newline at end of file
@@ -0,0 +1,2 @@
+ALTER TABLE sso_mappings DROP COLUMN IF EXISTS verified_at;
+ALTER TABLE sso_mappings DROP COLUMN IF EXISTS verification_token;
\ No newline at end of file
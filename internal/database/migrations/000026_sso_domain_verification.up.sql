@@ -0,0 +1,8 @@
+-- Domain mappings only apply once the workspace proved it owns the domain,
+-- with a TXT record holding the token, or the instance trusts the domain
+ALTER TABLE sso_mappings ADD COLUMN IF NOT EXISTS verification_token VARCHAR(64);
+ALTER TABLE sso_mappings ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Existing domain mappings were never proven and wait for a TXT record
+UPDATE sso_mappings SET verification_token = replace(uuid_generate_v4()::text, '-', '')
+WHERE kind = 'domain' AND verification_token IS NULL;
\ No newline at end of file
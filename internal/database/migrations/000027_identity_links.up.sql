@@ -0,0 +1,18 @@
+-- Requests to connect an identity of a directory or an identity provider to
+-- an existing account with the same email address, until the owner of the
+-- account confirms them from the link sent to it
+CREATE TABLE IF NOT EXISTS identity_links (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    CONSTRAINT fk_identity_links_user_id FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_identity_links_user_id ON identity_links(user_id, created_at DESC);
\ No newline at end of file
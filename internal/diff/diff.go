@@ -0,0 +1,189 @@
+// Package diff computes word-level and character-level differences between
+// two texts.
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// Chunk is a run of text that is either shared by both texts, only present in
+// the new text (Insert) or only present in the old text (Delete).
+type Chunk struct {
+	Op   Op
+	Text string
+}
+
+// Words returns the chunks that turn a into b. Texts are compared word by word
+// and whitespace runs count as words of their own, so concatenating the Equal
+// and Delete chunks yields a while Equal and Insert chunks yield b.
+func Words(a, b string) []Chunk {
+	return compare(tokenize(a), tokenize(b))
+}
+
+// Chars returns the chunks that turn a into b, comparing them character by
+// character. It is meant for merging texts rather than showing differences.
+func Chars(a, b string) []Chunk {
+	return compare(characters(a), characters(b))
+}
+
+// compare returns the chunks that turn the tokens of a into those of b.
+func compare(a, b []string) []Chunk {
+	// Common prefixes and suffixes are by far the most frequent case when
+	// comparing revisions, strip them before running the diff proper.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var chunks []Chunk
+	chunks = appendChunk(chunks, Equal, a[:prefix]...)
+	for _, c := range myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]) {
+		chunks = appendChunk(chunks, c.Op, c.Text)
+	}
+	chunks = appendChunk(chunks, Equal, a[len(a)-suffix:]...)
+
+	return chunks
+}
+
+// Changed reports whether the chunks contain any insertion or deletion.
+func Changed(chunks []Chunk) bool {
+	for _, c := range chunks {
+		if c.Op != Equal {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenize splits s into alternating runs of whitespace and non-whitespace.
+func tokenize(s string) []string {
+	var tokens []string
+	start := 0
+	prevSpace := false
+	for i, r := range s {
+		space := unicode.IsSpace(r)
+		if i > 0 && space != prevSpace {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		prevSpace = space
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+// characters splits s into its characters.
+func characters(s string) []string {
+	chars := make([]string, 0, len(s))
+	for _, r := range s {
+		chars = append(chars, string(r))
+	}
+	return chars
+}
+
+// appendChunk appends tokens to chunks, merging them into the last chunk when
+// it has the same operation.
+func appendChunk(chunks []Chunk, op Op, tokens ...string) []Chunk {
+	if len(tokens) == 0 {
+		return chunks
+	}
+	text := strings.Join(tokens, "")
+	if n := len(chunks); n > 0 && chunks[n-1].Op == op {
+		chunks[n-1].Text += text
+		return chunks
+	}
+	return append(chunks, Chunk{Op: op, Text: text})
+}
+
+// myers implements the greedy O(ND) algorithm from Eugene Myers' "An O(ND)
+// Difference Algorithm and Its Variations". Only the diagonals reachable in
+// each round are kept in the trace, which bounds memory to O(D²).
+func myers(a, b []string) []Chunk {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+
+	// trace[d] holds v[k] for k in [-d-1, d+1] as it was before round d
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk the trace backwards to recover the edit script
+	var reversed []Chunk
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		get := func(k int) int { return trace[d][k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			reversed = append(reversed, Chunk{Op: Equal, Text: a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				reversed = append(reversed, Chunk{Op: Insert, Text: b[y-1]})
+			} else {
+				reversed = append(reversed, Chunk{Op: Delete, Text: a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	chunks := make([]Chunk, 0, len(reversed))
+	for i := len(reversed) - 1; i >= 0; i-- {
+		chunks = appendChunk(chunks, reversed[i].Op, reversed[i].Text)
+	}
+	return chunks
+}
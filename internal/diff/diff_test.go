@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Chunk
+	}{
+		{
+			name: "replaced words",
+			a:    "the quick brown fox",
+			b:    "the slow brown dog jumps",
+			want: []Chunk{
+				{Equal, "the "},
+				{Delete, "quick"},
+				{Insert, "slow"},
+				{Equal, " brown "},
+				{Delete, "fox"},
+				{Insert, "dog jumps"},
+			},
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a b",
+			want: []Chunk{{Insert, "a b"}},
+		},
+		{
+			name: "to empty",
+			a:    "a b",
+			b:    "",
+			want: []Chunk{{Delete, "a b"}},
+		},
+		{
+			name: "unchanged",
+			a:    "same",
+			b:    "same",
+			want: []Chunk{{Equal, "same"}},
+		},
+		{
+			name: "both empty",
+			a:    "",
+			b:    "",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Words(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Words(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestWordsRebuild checks on random texts that the chunks always rebuild both
+// sides.
+func TestWordsRebuild(t *testing.T) {
+	words := []string{"a", "b", "the", "fox", " ", "  ", "\n", "é"}
+	rnd := rand.New(rand.NewSource(1))
+	text := func() string {
+		var b strings.Builder
+		for i := rnd.Intn(30); i > 0; i-- {
+			b.WriteString(words[rnd.Intn(len(words))])
+		}
+		return b.String()
+	}
+
+	for range 2000 {
+		a, b := text(), text()
+		chunks := Words(a, b)
+
+		var gotA, gotB strings.Builder
+		for _, c := range chunks {
+			if c.Op != Insert {
+				gotA.WriteString(c.Text)
+			}
+			if c.Op != Delete {
+				gotB.WriteString(c.Text)
+			}
+		}
+		if gotA.String() != a || gotB.String() != b {
+			t.Fatalf("Words(%q, %q) = %v does not rebuild the texts", a, b, chunks)
+		}
+		if Changed(chunks) != (a != b) {
+			t.Fatalf("Changed(Words(%q, %q)) = %t", a, b, Changed(chunks))
+		}
+	}
+}
+
+func TestChars(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Chunk
+	}{
+		{
+			name: "substitutions",
+			a:    "kitten",
+			b:    "sitting",
+			want: []Chunk{
+				{Delete, "k"},
+				{Insert, "s"},
+				{Equal, "itt"},
+				{Delete, "e"},
+				{Insert, "i"},
+				{Equal, "n"},
+				{Insert, "g"},
+			},
+		},
+		{
+			name: "multibyte characters",
+			a:    "héllo",
+			b:    "hello",
+			want: []Chunk{{Equal, "h"}, {Delete, "é"}, {Insert, "e"}, {Equal, "llo"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Chars(tt.a, tt.b)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Chars(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
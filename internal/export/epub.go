@@ -0,0 +1,241 @@
+package export
+
+import (
+	"archive/zip"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/wrytehq/wryte/internal/markdown"
+)
+
+// xmlHeader starts every XML file of a publication. It is written outside of
+// the templates, which would escape it.
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+`
+
+const epubContainer = xmlHeader + `<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>
+`
+
+const epubStyle = `body { font-family: serif; line-height: 1.5; }
+h1.title { margin-bottom: 1.5em; }
+blockquote { margin-left: 0; padding-left: 1em; border-left: 3px solid #ccc; font-style: italic; }
+code, pre { font-family: monospace; }
+pre { white-space: pre-wrap; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.4em; }
+img { max-width: 100%; }
+.heading-anchor { display: none; }
+`
+
+var epubTemplates = template.Must(template.New("epub").Parse(`{{ define "package" }}<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="book-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="book-id">urn:uuid:{{ .ID }}</dc:identifier>
+    <dc:title>{{ .Title }}</dc:title>
+    <dc:language>en</dc:language>
+    {{ with .Author }}<dc:creator>{{ . }}</dc:creator>{{ end }}
+    <meta property="dcterms:modified">{{ .Modified }}</meta>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="style" href="style.css" media-type="text/css"/>
+    {{ range .Chapters }}<item id="{{ .Name }}" href="{{ .Name }}.xhtml" media-type="application/xhtml+xml"/>
+    {{ end }}{{ range .Images }}<item id="{{ .Name }}" href="{{ .Href }}" media-type="{{ .MediaType }}"/>
+    {{ end }}
+  </manifest>
+  <spine>
+    {{ range .Chapters }}<itemref idref="{{ .Name }}"/>
+    {{ end }}
+  </spine>
+</package>
+{{ end }}
+
+{{ define "nav" }}<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en" xml:lang="en">
+<head>
+<meta charset="utf-8"/>
+<title>{{ .Title }}</title>
+</head>
+<body>
+<nav epub:type="toc" id="toc">
+<h1>Contents</h1>
+{{ .TOC }}
+</nav>
+</body>
+</html>
+{{ end }}
+
+{{ define "chapter" }}<!DOCTYPE html>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops" lang="en" xml:lang="en">
+<head>
+<meta charset="utf-8"/>
+<title>{{ .Title }}</title>
+<link rel="stylesheet" type="text/css" href="style.css"/>
+</head>
+<body>
+<section epub:type="chapter">
+<h1 class="title">{{ .Title }}</h1>
+{{ .Content }}
+</section>
+</body>
+</html>
+{{ end }}`))
+
+type epubChapter struct {
+	Name    string
+	Title   string
+	Content template.HTML
+}
+
+type epubImage struct {
+	Name      string
+	Href      string
+	MediaType string
+	*Image
+}
+
+// writeEPUB packages book as an EPUB 3 publication with a chapter per
+// document. Images are stored inside the publication.
+func writeEPUB(w io.Writer, book *Book, images map[string]*Image) error {
+	// Give every image a file of its own, in the order they are referenced
+	files := make(map[string]*epubImage)
+	var stored []*epubImage
+	for _, doc := range book.Documents {
+		for _, dest := range imageDestinations(doc.Content) {
+			img, ok := images[dest]
+			if !ok || files[dest] != nil {
+				continue
+			}
+			name := fmt.Sprintf("image-%d", len(stored)+1)
+			file := &epubImage{Name: name, Href: "images/" + name + img.extension(), MediaType: img.MediaType, Image: img}
+			files[dest] = file
+			stored = append(stored, file)
+		}
+	}
+
+	chapters := make([]epubChapter, 0, len(book.Documents))
+	for i, doc := range book.Documents {
+		chapters = append(chapters, epubChapter{
+			Name:  fmt.Sprintf("chapter-%d", i+1),
+			Title: doc.Title,
+			Content: markdown.Export(doc.Content, markdown.Options{
+				XHTML: true,
+				Image: func(dest string) string {
+					if file, ok := files[dest]; ok {
+						return file.Href
+					}
+					return ""
+				},
+			}),
+		})
+	}
+
+	zw := zip.NewWriter(w)
+
+	// The media type comes first and uncompressed so readers can sniff it
+	mimetype, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(mimetype, "application/epub+zip"); err != nil {
+		return err
+	}
+
+	if err := writeZipString(zw, "META-INF/container.xml", epubContainer); err != nil {
+		return err
+	}
+	if err := writeZipString(zw, "OEBPS/style.css", epubStyle); err != nil {
+		return err
+	}
+
+	err = writeZipTemplate(zw, "OEBPS/content.opf", "package", map[string]any{
+		"ID":       book.Documents[0].ID,
+		"Title":    book.Title,
+		"Author":   book.Author,
+		"Modified": book.modified().Format("2006-01-02T15:04:05Z"),
+		"Chapters": chapters,
+		"Images":   stored,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = writeZipTemplate(zw, "OEBPS/nav.xhtml", "nav", map[string]any{
+		"Title": book.Title,
+		"TOC":   epubTOC(book, chapters),
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, chapter := range chapters {
+		if err := writeZipTemplate(zw, "OEBPS/"+chapter.Name+".xhtml", "chapter", chapter); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range stored {
+		f, err := zw.Create("OEBPS/" + file.Href)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(file.Data); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// epubTOC renders the table of contents as nested lists following the depth
+// of the documents.
+func epubTOC(book *Book, chapters []epubChapter) template.HTML {
+	var b strings.Builder
+	b.WriteString("<ol>")
+	prev := 0
+	for i, doc := range book.Documents {
+		if i > 0 {
+			if doc.Depth > prev {
+				b.WriteString("<ol>")
+			} else {
+				b.WriteString("</li>")
+				for d := prev; d > doc.Depth; d-- {
+					b.WriteString("</ol></li>")
+				}
+			}
+		}
+		fmt.Fprintf(&b, `<li><a href="%s.xhtml">%s</a>`, chapters[i].Name, template.HTMLEscapeString(doc.Title))
+		prev = doc.Depth
+	}
+	b.WriteString("</li>")
+	for d := prev; d > 0; d-- {
+		b.WriteString("</ol></li>")
+	}
+	b.WriteString("</ol>")
+	return template.HTML(b.String())
+}
+
+func writeZipString(zw *zip.Writer, name, content string) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(f, content)
+	return err
+}
+
+func writeZipTemplate(zw *zip.Writer, name, tmpl string, data any) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, xmlHeader); err != nil {
+		return err
+	}
+	return epubTemplates.ExecuteTemplate(f, tmpl, data)
+}
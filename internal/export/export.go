@@ -0,0 +1,87 @@
+// Package export writes documents to single files that can be read outside of
+// Wryte: Markdown, standalone HTML, PDF and EPUB. Every format is produced in
+// pure Go.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Format is a file format documents can be exported to.
+type Format string
+
+const (
+	Markdown Format = "md"
+	HTML     Format = "html"
+	PDF      Format = "pdf"
+	EPUB     Format = "epub"
+)
+
+var contentTypes = map[Format]string{
+	Markdown: "text/markdown; charset=utf-8",
+	HTML:     "text/html; charset=utf-8",
+	PDF:      "application/pdf",
+	EPUB:     "application/epub+zip",
+}
+
+// ContentType is the media type of files in the format.
+func (f Format) ContentType() string {
+	return contentTypes[f]
+}
+
+// Document is a single document of an export.
+type Document struct {
+	ID      string
+	Title   string
+	Content string
+	// Depth is how far the document is nested below the first document of
+	// the export.
+	Depth     int
+	UpdatedAt time.Time
+}
+
+// Book is a set of documents exported together into one file, in tree order
+// with the exported document first.
+type Book struct {
+	Title     string
+	Author    string
+	Documents []Document
+	// Images resolves the images referenced by the documents so they can be
+	// embedded into the file. It may be nil.
+	Images ImageResolver
+}
+
+// Write exports book to w in the given format.
+func Write(ctx context.Context, w io.Writer, format Format, book *Book) error {
+	if len(book.Documents) == 0 {
+		return errors.New("nothing to export")
+	}
+	images := resolveImages(ctx, book)
+
+	switch format {
+	case Markdown:
+		return writeMarkdown(w, book, images)
+	case HTML:
+		return writeHTML(w, book, images)
+	case PDF:
+		return writePDF(w, book, images)
+	case EPUB:
+		return writeEPUB(w, book, images)
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// modified is the time the most recently updated document of book changed.
+func (b *Book) modified() time.Time {
+	var t time.Time
+	for _, doc := range b.Documents {
+		if doc.UpdatedAt.After(t) {
+			t = doc.UpdatedAt
+		}
+	}
+	return t.UTC()
+}
@@ -0,0 +1,77 @@
+package export
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/wrytehq/wryte/internal/markdown"
+)
+
+var htmlTemplate = template.Must(template.New("export").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="generator" content="Wryte">
+{{ with .Author }}<meta name="author" content="{{ . }}">
+{{ end }}<title>{{ .Title }}</title>
+<style>
+body { max-width: 48rem; margin: 0 auto; padding: 2rem 1.5rem; font: 1rem/1.6 system-ui, sans-serif; color: #1f2937; }
+section + section { margin-top: 4rem; }
+h1.title { font-size: 2.5rem; margin-bottom: 2rem; }
+a { color: #2563eb; }
+.heading-anchor { margin-left: .5rem; color: #9ca3af; text-decoration: none; visibility: hidden; }
+:is(h1, h2, h3, h4, h5, h6):hover .heading-anchor { visibility: visible; }
+blockquote { margin-left: 0; padding-left: 1rem; border-left: 4px solid #e5e7eb; font-style: italic; }
+code { font-family: ui-monospace, monospace; font-size: .875em; background: #f3f4f6; border-radius: .25rem; padding: 0 .25rem; }
+pre { background: #f3f4f6; border-radius: .5rem; padding: 1rem; overflow-x: auto; }
+pre code { background: none; padding: 0; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #e5e7eb; padding: .25rem .5rem; }
+img { max-width: 100%; }
+li:has(> input[type="checkbox"]) { list-style: none; margin-left: -1.5rem; }
+.footnotes { font-size: .875rem; color: #4b5563; }
+</style>
+</head>
+<body>
+{{ range .Sections }}<section id="{{ .ID }}">
+<h1 class="title">{{ .Title }}</h1>
+{{ .Content }}
+</section>
+{{ end }}</body>
+</html>
+`))
+
+type htmlSection struct {
+	ID      string
+	Title   string
+	Content template.HTML
+}
+
+// writeHTML renders book to a standalone page, with images inlined as data
+// URIs.
+func writeHTML(w io.Writer, book *Book, images map[string]*Image) error {
+	sections := make([]htmlSection, 0, len(book.Documents))
+	for i, doc := range book.Documents {
+		sections = append(sections, htmlSection{
+			ID:    "document-" + doc.ID,
+			Title: doc.Title,
+			Content: markdown.Export(doc.Content, markdown.Options{
+				IDPrefix: fmt.Sprintf("d%d-", i+1),
+				Image: func(dest string) string {
+					if img, ok := images[dest]; ok {
+						return img.dataURI()
+					}
+					return ""
+				},
+			}),
+		})
+	}
+
+	return htmlTemplate.Execute(w, map[string]any{
+		"Title":    book.Title,
+		"Author":   book.Author,
+		"Sections": sections,
+	})
+}
@@ -0,0 +1,201 @@
+package export
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/markdown"
+	"github.com/yuin/goldmark/ast"
+)
+
+const (
+	// maxImageSize is the largest image embedded into an export.
+	maxImageSize = 10 << 20
+	// maxImages caps the number of distinct images embedded into an export.
+	maxImages = 100
+)
+
+// imageExtensions lists the image types that are embedded, which are the
+// ones every export format can display.
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Image is an image embedded into an export.
+type Image struct {
+	MediaType string
+	Data      []byte
+}
+
+// ImageResolver returns the image a document references with dest, or nil
+// when it cannot be embedded.
+type ImageResolver func(ctx context.Context, dest string) (*Image, error)
+
+// newImage checks that data holds an image of a type that can be embedded.
+func newImage(data []byte) (*Image, error) {
+	mediaType := http.DetectContentType(data)
+	if _, ok := imageExtensions[mediaType]; !ok {
+		return nil, fmt.Errorf("unsupported image type %s", mediaType)
+	}
+	return &Image{MediaType: mediaType, Data: data}, nil
+}
+
+// ReadImage reads an image to embed from r, refusing images that are too
+// large or of a type that cannot be embedded.
+func ReadImage(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageSize {
+		return nil, errors.New("image too large")
+	}
+	return newImage(data)
+}
+
+func (img *Image) extension() string {
+	return imageExtensions[img.MediaType]
+}
+
+func (img *Image) dataURI() string {
+	return "data:" + img.MediaType + ";base64," + base64.StdEncoding.EncodeToString(img.Data)
+}
+
+// imageDestinations lists the destinations of the images in Markdown source.
+func imageDestinations(source string) []string {
+	var dests []string
+	_ = ast.Walk(markdown.Parse([]byte(source)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); ok && entering {
+			dests = append(dests, string(image.Destination))
+		}
+		return ast.WalkContinue, nil
+	})
+	return dests
+}
+
+// resolveImages loads the images referenced by the documents of book, keyed
+// by destination. Images that cannot be loaded are left out and keep being
+// referenced by their destination.
+func resolveImages(ctx context.Context, book *Book) map[string]*Image {
+	images := make(map[string]*Image)
+	seen := make(map[string]bool)
+	for _, doc := range book.Documents {
+		for _, dest := range imageDestinations(doc.Content) {
+			if seen[dest] || len(seen) >= maxImages {
+				continue
+			}
+			seen[dest] = true
+
+			var img *Image
+			var err error
+			if strings.HasPrefix(dest, "data:") {
+				img, err = decodeDataURI(dest)
+			} else if book.Images != nil {
+				img, err = book.Images(ctx, dest)
+			}
+			if err == nil && img != nil {
+				images[dest] = img
+			}
+		}
+	}
+	return images
+}
+
+// decodeDataURI reads an image already inlined as a base64 data URI.
+func decodeDataURI(uri string) (*Image, error) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasSuffix(header, ";base64") {
+		return nil, errors.New("unsupported data URI")
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	return newImage(data)
+}
+
+var errPrivateAddress = errors.New("address is not public")
+
+// Images combines resolvers, asking each in turn until one returns an image.
+func Images(resolvers ...ImageResolver) ImageResolver {
+	return func(ctx context.Context, dest string) (*Image, error) {
+		for _, resolve := range resolvers {
+			img, err := resolve(ctx, dest)
+			if err != nil || img != nil {
+				return img, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+// RemoteImages fetches images from the web. Only public addresses are
+// contacted, so exports cannot be used to reach services of the local
+// network.
+func RemoteImages() ImageResolver {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: publicOnly}
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 5 * time.Second,
+		},
+	}
+
+	return func(ctx context.Context, dest string) (*Image, error) {
+		u, err := url.Parse(dest)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, nil
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching image: %s", resp.Status)
+		}
+
+		return ReadImage(resp.Body)
+	}
+}
+
+// publicOnly refuses connections to loopback, private, link-local and other
+// addresses that are not reachable from the internet. It runs after name
+// resolution, so host names pointing inside the network are refused as well.
+func publicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// sharedAddressSpace is used by carrier-grade NAT and not routed publicly.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
@@ -0,0 +1,28 @@
+package export
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// writeMarkdown concatenates the documents of book, each introduced by its
+// title as a heading matching its depth. Images are inlined as data URIs.
+func writeMarkdown(w io.Writer, book *Book, images map[string]*Image) error {
+	bw := bufio.NewWriter(w)
+	for i, doc := range book.Documents {
+		if i > 0 {
+			bw.WriteString("\n\n")
+		}
+		bw.WriteString(strings.Repeat("#", min(doc.Depth+1, 6)) + " " + doc.Title + "\n\n")
+
+		content := doc.Content
+		for _, dest := range imageDestinations(content) {
+			if img, ok := images[dest]; ok && !strings.HasPrefix(dest, "data:") {
+				content = strings.ReplaceAll(content, "]("+dest, "]("+img.dataURI())
+			}
+		}
+		bw.WriteString(strings.TrimRight(content, "\n") + "\n")
+	}
+	return bw.Flush()
+}
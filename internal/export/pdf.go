@@ -0,0 +1,459 @@
+package export
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"github.com/wrytehq/wryte/internal/markdown"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/gomonobolditalic"
+	"golang.org/x/image/font/gofont/gomonoitalic"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// Page layout of PDF exports, in millimeters and points.
+const (
+	pdfMargin     = 20.0
+	pdfBodySize   = 11.0
+	pdfCodeSize   = 9.0
+	pdfIndent     = 6.0
+	pdfLineFactor = 0.5 // line height in mm per point of font size
+)
+
+var pdfHeadingSizes = [...]float64{22, 18, 15, 13, 12, 11}
+
+// writePDF lays book out as an A4 PDF. Every document starts on a new page
+// and appears in the outline of the file.
+func writePDF(w io.Writer, book *Book, images map[string]*Image) error {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.SetTitle(book.Title, true)
+	pdf.SetAuthor(book.Author, true)
+	pdf.SetCreator("Wryte", true)
+	pdf.SetCreationDate(book.modified())
+	pdf.SetModificationDate(book.modified())
+
+	// The Go fonts cover far more than the Latin-1 core fonts of PDF
+	for _, font := range []struct {
+		family, style string
+		ttf           []byte
+	}{
+		{"go", "", goregular.TTF},
+		{"go", "B", gobold.TTF},
+		{"go", "I", goitalic.TTF},
+		{"go", "BI", gobolditalic.TTF},
+		{"gomono", "", gomono.TTF},
+		{"gomono", "B", gomonobold.TTF},
+		{"gomono", "I", gomonoitalic.TTF},
+		{"gomono", "BI", gomonobolditalic.TTF},
+	} {
+		pdf.AddUTF8FontFromBytes(font.family, font.style, font.ttf)
+	}
+
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 5)
+		pdf.SetFont("go", "", 8)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(0, 5, strconv.Itoa(pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+
+	p := &pdfWriter{pdf: pdf, images: images, registered: make(map[string]bool)}
+	for _, doc := range book.Documents {
+		pdf.AddPage()
+		pdf.Bookmark(doc.Title, doc.Depth, -1)
+
+		p.size = pdfHeadingSizes[0] + 4
+		p.bold = true
+		p.apply()
+		pdf.MultiCell(0, p.lineHeight(), doc.Title, "", "L", false)
+		pdf.Ln(p.lineHeight() / 2)
+
+		source := []byte(doc.Content)
+		p.source = source
+		p.size, p.bold = pdfBodySize, false
+		p.apply()
+		p.blocks(markdown.Parse(source))
+	}
+
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+	return pdf.Output(w)
+}
+
+// pdfWriter draws the Markdown syntax tree of a document. The style fields
+// describe the font of the text being written.
+type pdfWriter struct {
+	pdf        *fpdf.Fpdf
+	source     []byte
+	images     map[string]*Image
+	registered map[string]bool
+
+	size                       float64
+	bold, italic, strike, mono bool
+	link                       string
+	red, green, blue           int
+	indent                     float64
+}
+
+func (p *pdfWriter) lineHeight() float64 {
+	return p.size * pdfLineFactor
+}
+
+// apply sets the font and color of the pdf to the current style.
+func (p *pdfWriter) apply() {
+	family, style := "go", ""
+	if p.mono {
+		family = "gomono"
+	}
+	if p.bold {
+		style += "B"
+	}
+	if p.italic {
+		style += "I"
+	}
+	if p.strike {
+		style += "S"
+	}
+	if p.link != "" {
+		style += "U"
+	}
+	p.pdf.SetFont(family, style, p.size)
+	if p.link != "" {
+		p.pdf.SetTextColor(37, 99, 235)
+	} else {
+		p.pdf.SetTextColor(p.red, p.green, p.blue)
+	}
+}
+
+// styled writes with a changed style, restoring the previous one afterwards.
+func (p *pdfWriter) styled(change func(), write func()) {
+	saved := *p
+	change()
+	p.apply()
+	write()
+	p.size, p.bold, p.italic, p.strike, p.mono, p.link = saved.size, saved.bold, saved.italic, saved.strike, saved.mono, saved.link
+	p.red, p.green, p.blue = saved.red, saved.green, saved.blue
+	p.apply()
+}
+
+// setIndent moves the left margin for nested blocks.
+func (p *pdfWriter) setIndent(indent float64) {
+	p.indent = indent
+	p.pdf.SetLeftMargin(pdfMargin + indent)
+	p.pdf.SetX(pdfMargin + indent)
+}
+
+func (p *pdfWriter) write(text string) {
+	if p.link != "" {
+		p.pdf.WriteLinkString(p.lineHeight(), text, p.link)
+		return
+	}
+	p.pdf.Write(p.lineHeight(), text)
+}
+
+// newline ends the current line, unless nothing was written on it yet.
+func (p *pdfWriter) newline() {
+	if p.pdf.GetX() > pdfMargin+p.indent+0.01 {
+		p.pdf.Ln(p.lineHeight())
+	}
+}
+
+func (p *pdfWriter) gap() {
+	p.pdf.Ln(p.lineHeight() / 2)
+}
+
+func (p *pdfWriter) blocks(parent ast.Node) {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		p.block(n)
+	}
+}
+
+func (p *pdfWriter) block(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Heading:
+		p.styled(func() {
+			p.size = pdfHeadingSizes[min(n.Level, len(pdfHeadingSizes))-1]
+			p.bold = true
+		}, func() {
+			p.gap()
+			p.inlines(n)
+			p.newline()
+		})
+		p.gap()
+
+	case *ast.Paragraph:
+		p.inlines(n)
+		p.newline()
+		p.gap()
+
+	case *ast.TextBlock:
+		p.inlines(n)
+		p.newline()
+
+	case *ast.List:
+		p.list(n)
+		p.gap()
+
+	case *ast.Blockquote:
+		saved := p.indent
+		p.setIndent(saved + pdfIndent)
+		p.styled(func() {
+			p.italic = true
+			p.red, p.green, p.blue = 90, 90, 90
+		}, func() {
+			p.blocks(n)
+		})
+		p.setIndent(saved)
+
+	case *ast.FencedCodeBlock, *ast.CodeBlock:
+		var code strings.Builder
+		lines := n.Lines()
+		for i := 0; i < lines.Len(); i++ {
+			seg := lines.At(i)
+			code.Write(seg.Value(p.source))
+		}
+		p.styled(func() {
+			p.mono = true
+			p.size = pdfCodeSize
+		}, func() {
+			p.pdf.SetFillColor(243, 244, 246)
+			p.pdf.MultiCell(0, p.lineHeight(), strings.TrimRight(code.String(), "\n"), "", "L", true)
+		})
+		p.gap()
+
+	case *ast.ThematicBreak:
+		y := p.pdf.GetY() + p.lineHeight()/2
+		width, _ := p.pdf.GetPageSize()
+		p.pdf.SetDrawColor(200, 200, 200)
+		p.pdf.Line(pdfMargin+p.indent, y, width-pdfMargin, y)
+		p.pdf.Ln(p.lineHeight())
+
+	case *east.Table:
+		p.table(n)
+		p.gap()
+
+	case *east.FootnoteList:
+		p.styled(func() {
+			p.size = pdfCodeSize
+			p.red, p.green, p.blue = 90, 90, 90
+		}, func() {
+			p.gap()
+			for item := n.FirstChild(); item != nil; item = item.NextSibling() {
+				if footnote, ok := item.(*east.Footnote); ok {
+					p.write(strconv.Itoa(footnote.Index) + ". ")
+					p.blocks(footnote)
+				}
+			}
+		})
+
+	case *ast.HTMLBlock:
+		// Raw HTML is left out, as in the page
+
+	default:
+		p.blocks(n)
+	}
+}
+
+func (p *pdfWriter) list(list *ast.List) {
+	saved := p.indent
+	p.setIndent(saved + pdfIndent)
+	number := list.Start
+	for item := list.FirstChild(); item != nil; item = item.NextSibling() {
+		marker := "•"
+		if list.IsOrdered() {
+			marker = strconv.Itoa(number) + "."
+			number++
+		}
+		p.pdf.SetX(pdfMargin + saved)
+		p.pdf.CellFormat(pdfIndent, p.lineHeight(), marker, "", 0, "L", false, 0, "")
+		p.blocks(item)
+		if !list.IsTight {
+			p.gap()
+		}
+	}
+	p.setIndent(saved)
+}
+
+// table draws a table with columns of equal width, growing each row to the
+// tallest of its cells.
+func (p *pdfWriter) table(table *east.Table) {
+	var rows [][]string
+	var header int
+	for row := table.FirstChild(); row != nil; row = row.NextSibling() {
+		var cells []string
+		for cell := row.FirstChild(); cell != nil; cell = cell.NextSibling() {
+			cells = append(cells, p.plainText(cell))
+		}
+		if _, ok := row.(*east.TableHeader); ok {
+			header++
+		}
+		rows = append(rows, cells)
+	}
+	if len(rows) == 0 || len(table.Alignments) == 0 {
+		return
+	}
+
+	pageWidth, pageHeight := p.pdf.GetPageSize()
+	colWidth := (pageWidth - 2*pdfMargin - p.indent) / float64(len(table.Alignments))
+	lh := p.lineHeight()
+	p.pdf.SetDrawColor(200, 200, 200)
+
+	for i, cells := range rows {
+		p.styled(func() { p.bold = i < header }, func() {
+			lines := make([][]string, len(cells))
+			height := lh
+			for j, cell := range cells {
+				lines[j] = p.pdf.SplitText(cell, colWidth-2)
+				height = max(height, float64(len(lines[j]))*lh)
+			}
+			if p.pdf.GetY()+height > pageHeight-pdfMargin {
+				p.pdf.AddPage()
+			}
+
+			x, y := pdfMargin+p.indent, p.pdf.GetY()
+			for j := range table.Alignments {
+				p.pdf.Rect(x, y, colWidth, height, "D")
+				if j < len(cells) {
+					align := "L"
+					switch table.Alignments[j] {
+					case east.AlignCenter:
+						align = "C"
+					case east.AlignRight:
+						align = "R"
+					}
+					for k, line := range lines[j] {
+						p.pdf.SetXY(x, y+float64(k)*lh)
+						p.pdf.CellFormat(colWidth, lh, line, "", 0, align, false, 0, "")
+					}
+				}
+				x += colWidth
+			}
+			p.pdf.SetXY(pdfMargin+p.indent, y+height)
+		})
+	}
+}
+
+func (p *pdfWriter) inlines(parent ast.Node) {
+	for n := parent.FirstChild(); n != nil; n = n.NextSibling() {
+		p.inline(n)
+	}
+}
+
+func (p *pdfWriter) inline(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Text:
+		p.write(string(n.Segment.Value(p.source)))
+		if n.HardLineBreak() {
+			p.pdf.Ln(p.lineHeight())
+		} else if n.SoftLineBreak() {
+			p.write(" ")
+		}
+
+	case *ast.String:
+		p.write(string(n.Value))
+
+	case *ast.CodeSpan:
+		p.styled(func() { p.mono = true }, func() { p.inlines(n) })
+
+	case *ast.Emphasis:
+		p.styled(func() {
+			if n.Level >= 2 {
+				p.bold = true
+			} else {
+				p.italic = true
+			}
+		}, func() { p.inlines(n) })
+
+	case *east.Strikethrough:
+		p.styled(func() { p.strike = true }, func() { p.inlines(n) })
+
+	case *ast.Link:
+		if markdown.HeadingAnchor(n) {
+			return
+		}
+		p.styled(func() { p.link = string(n.Destination) }, func() { p.inlines(n) })
+
+	case *ast.AutoLink:
+		url := string(n.URL(p.source))
+		p.styled(func() { p.link = url }, func() { p.write(string(n.Label(p.source))) })
+
+	case *ast.Image:
+		p.image(n)
+
+	case *east.TaskCheckBox:
+		if n.IsChecked {
+			p.write("[x] ")
+		} else {
+			p.write("[ ] ")
+		}
+
+	case *east.FootnoteLink:
+		p.styled(func() { p.size = p.size * 0.75 }, func() { p.write("[" + strconv.Itoa(n.Index) + "]") })
+
+	case *east.FootnoteBacklink, *ast.RawHTML:
+		// Backlinks only make sense in a page, raw HTML is left out
+
+	default:
+		p.inlines(n)
+	}
+}
+
+// image draws an embedded image on lines of its own, scaled down to the width
+// of the page. Images that could not be embedded show their alternative text.
+func (p *pdfWriter) image(n *ast.Image) {
+	dest := string(n.Destination)
+	img, ok := p.images[dest]
+	if !ok {
+		p.styled(func() { p.italic = true }, func() { p.write("[" + p.plainText(n) + "]") })
+		return
+	}
+
+	options := fpdf.ImageOptions{ImageType: strings.TrimPrefix(img.extension(), "."), ReadDpi: true}
+	if !p.registered[dest] {
+		p.pdf.RegisterImageOptionsReader(dest, options, bytes.NewReader(img.Data))
+		p.registered[dest] = true
+	}
+	info := p.pdf.GetImageInfo(dest)
+	if info == nil {
+		return
+	}
+
+	pageWidth, _ := p.pdf.GetPageSize()
+	width, _ := info.Extent()
+	width = min(width, pageWidth-2*pdfMargin-p.indent)
+
+	p.newline()
+	p.pdf.ImageOptions(dest, pdfMargin+p.indent, -1, width, 0, true, options, 0, "")
+}
+
+// plainText is the text of the inline content of n without formatting.
+func (p *pdfWriter) plainText(n ast.Node) string {
+	var b strings.Builder
+	_ = ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			b.Write(c.Segment.Value(p.source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(c.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return b.String()
+}
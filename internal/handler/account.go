@@ -0,0 +1,401 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wrytehq/wryte/internal/auth"
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// emailChangeTTL is how long the link confirming a new email address can be
+// used.
+const emailChangeTTL = 24 * time.Hour
+
+// Account is the signed in user as shown in their settings.
+type Account struct {
+	ID       string
+	Username string
+	Email    string
+	// EmailVerified is whether the user proved they own Email.
+	EmailVerified bool
+	// TwoFactor is whether signing in asks for a code on top of the
+	// password.
+	TwoFactor bool
+	// Passkeys is how many passkeys the user can sign in with.
+	Passkeys int
+	// PendingEmail is the address the account moves to once it is
+	// confirmed, if any.
+	PendingEmail string
+}
+
+// findAccount loads the account of a user.
+func (h *Handler) findAccount(ctx context.Context, userID string) (*Account, error) {
+	var a Account
+	query := `SELECT u.id, u.username, u.email, u.email_verified_at IS NOT NULL, u.totp_enabled_at IS NOT NULL,
+		(SELECT COUNT(*) FROM passkeys p WHERE p.user_id = u.id), COALESCE(c.email, '')
+		FROM users u
+		LEFT JOIN email_changes c ON c.user_id = u.id AND c.expires_at > NOW()
+		WHERE u.id = $1`
+	err := h.db.GetDB().QueryRowContext(ctx, query, userID).Scan(&a.ID, &a.Username, &a.Email, &a.EmailVerified, &a.TwoFactor, &a.Passkeys, &a.PendingEmail)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// checkPassword reports whether password is the current password of a user,
+// as checked by the auth provider.
+func (h *Handler) checkPassword(ctx context.Context, userID, password string) (bool, error) {
+	// Accounts of a directory are named by their identity there
+	subject := userID
+	if issuer := h.auth.Issuer(); issuer != "" {
+		query := `SELECT subject FROM user_identities WHERE user_id = $1 AND issuer = $2`
+		err := h.db.GetDB().QueryRowContext(ctx, query, userID, issuer).Scan(&subject)
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err := h.auth.Verify(ctx, subject, password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// renderAccountForm renders one of the forms of the account settings after
+// it was submitted.
+func (h *Handler) renderAccountForm(w http.ResponseWriter, r *http.Request, tmpl *template.Template, name string, form any, errs *validator.ValidationErrors) {
+	userID, _ := middleware.GetUserID(r)
+	account, err := h.findAccount(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error querying account: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Account": account,
+		"Form":    form,
+		"Errors":  errs,
+	}
+	if errs.HasErrors() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// AccountSettings shows the settings of the signed in user.
+func (h *Handler) AccountSettings() http.HandlerFunc {
+	tmpl := h.templates.MustRender("account")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+		account, err := h.findAccount(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying account: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Account":          account,
+			"Errors":           &validator.ValidationErrors{},
+			"Flash":            h.GetFlashMessage(w, r),
+			"ManagesPasswords": h.config.Auth.ManagesPasswords(),
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// UpdateProfile changes the username of the signed in user.
+func (h *Handler) UpdateProfile() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("account")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.ProfileForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		form.Username = strings.TrimSpace(form.Username)
+		if validationErrs.HasErrors() {
+			h.renderAccountForm(w, r, tmpl, "account_profile_form", &form, validationErrs)
+			return
+		}
+
+		query := `UPDATE users SET username = $1, updated_at = NOW() WHERE id = $2`
+		if _, err := h.db.GetDB().ExecContext(r.Context(), query, form.Username, userID); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				validationErrs.AddError("username", "This name is already taken")
+				h.renderAccountForm(w, r, tmpl, "account_profile_form", &form, validationErrs)
+				return
+			}
+			log.Printf("Error updating username: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Your name has been changed.")
+		redirect(w, r, "/settings/account")
+	}
+}
+
+// ChangeEmail asks to move the account of the signed in user to another email
+// address. The current password is checked, and a link confirming the change
+// is sent to the new address: the account keeps its current address until
+// the link is followed.
+func (h *Handler) ChangeEmail() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("account")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.ChangeEmailForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		form.Email = strings.TrimSpace(form.Email)
+		if validationErrs.HasErrors() {
+			h.renderAccountForm(w, r, tmpl, "account_email_form", &form, validationErrs)
+			return
+		}
+
+		ok, err := h.checkPassword(r.Context(), userID, form.CurrentPassword)
+		if err != nil {
+			log.Printf("Error querying password: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			validationErrs.AddError("currentpassword", "Incorrect password")
+			h.renderAccountForm(w, r, tmpl, "account_email_form", &form, validationErrs)
+			return
+		}
+
+		var taken bool
+		query := `SELECT EXISTS (SELECT 1 FROM users WHERE lower(email) = lower($1))`
+		if err := h.db.GetDB().QueryRowContext(r.Context(), query, form.Email).Scan(&taken); err != nil {
+			log.Printf("Error querying users: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if taken {
+			validationErrs.AddError("email", "This email is already registered")
+			h.renderAccountForm(w, r, tmpl, "account_email_form", &form, validationErrs)
+			return
+		}
+
+		token := rand.Text()
+		query = `INSERT INTO email_changes (user_id, email, token_hash, expires_at, created_at, updated_at)
+		         VALUES ($1, $2, $3, $4, NOW(), NOW())
+		         ON CONFLICT (user_id) DO UPDATE SET email = EXCLUDED.email, token_hash = EXCLUDED.token_hash,
+		             expires_at = EXCLUDED.expires_at, created_at = NOW(), updated_at = NOW()`
+		_, err = h.db.GetDB().ExecContext(r.Context(), query, userID, form.Email, hashToken(token), time.Now().Add(emailChangeTTL))
+		if err != nil {
+			log.Printf("Error creating email change: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		account, err := h.findAccount(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying account: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.sendEmail(form.Email, "email_change", map[string]any{
+			"Username": account.Username,
+			"Link":     h.config.URL("/settings/account/email/confirm?token=" + url.QueryEscape(token)),
+		})
+
+		flash.SetSuccess(w, "Follow the link we sent to "+form.Email+" to confirm the change.")
+		redirect(w, r, "/settings/account")
+	}
+}
+
+// ConfirmEmail moves the account of the signed in user to the address a
+// confirmation link was sent to.
+func (h *Handler) ConfirmEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+		token := r.URL.Query().Get("token")
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var email string
+		query := `DELETE FROM email_changes WHERE token_hash = $1 AND user_id = $2 AND expires_at > NOW() RETURNING email`
+		err = tx.QueryRowContext(r.Context(), query, hashToken(token), userID).Scan(&email)
+		if errors.Is(err, sql.ErrNoRows) {
+			flash.SetError(w, "This link has expired or was already used.")
+			http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying email change: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Following the link proved the new address is theirs
+		query = `UPDATE users SET email = $1, email_verified_at = NOW(), updated_at = NOW() WHERE id = $2`
+		if _, err := tx.ExecContext(r.Context(), query, email, userID); err != nil {
+			var pgErr *pgconn.PgError
+			if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+				flash.SetError(w, email+" is already registered to another account.")
+				http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+				return
+			}
+			log.Printf("Error updating email: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// Reset links sent to the old address must not outlive it
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM password_resets WHERE user_id = $1`, userID); err != nil {
+			log.Printf("Error deleting password resets: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM email_verifications WHERE user_id = $1`, userID); err != nil {
+			log.Printf("Error deleting email verifications: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Your email address is now "+email+".")
+		http.Redirect(w, r, "/settings/account", http.StatusSeeOther)
+	}
+}
+
+// ChangePassword sets a new password for the signed in user after checking
+// the current one. Other sessions are signed out when asked to; the session
+// making the change always stays signed in.
+func (h *Handler) ChangePassword() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("account")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.ChangePasswordForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			h.renderAccountForm(w, r, tmpl, "account_password_form", &form, validationErrs)
+			return
+		}
+
+		ok, err := h.checkPassword(r.Context(), userID, form.CurrentPassword)
+		if err != nil {
+			log.Printf("Error querying password: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			validationErrs.AddError("currentpassword", "Incorrect password")
+			h.renderAccountForm(w, r, tmpl, "account_password_form", &form, validationErrs)
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(form.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error generating password hash: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		query := `UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2`
+		if _, err := tx.ExecContext(r.Context(), query, hash, userID); err != nil {
+			log.Printf("Error updating password: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM password_resets WHERE user_id = $1`, userID); err != nil {
+			log.Printf("Error deleting password resets: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if form.SignOutOthers {
+			session, err := middleware.GetSession(r, h.db)
+			if err != nil {
+				log.Printf("Error querying session: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			query = `DELETE FROM sessions WHERE user_id = $1 AND token <> $2`
+			if _, err := tx.ExecContext(r.Context(), query, userID, session.Token); err != nil {
+				log.Printf("Error deleting sessions: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		message := "Your password has been changed."
+		if form.SignOutOthers {
+			message = "Your password has been changed and your other sessions were signed out."
+		}
+		flash.SetSuccess(w, message)
+		redirect(w, r, "/settings/account")
+	}
+}
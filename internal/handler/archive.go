@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// archivedError is the response to changes made to an archived document.
+const archivedError = "Document is archived and read-only"
+
+var errParentArchived = errors.New("parent document is archived")
+
+// ArchivedDocument is an entry of the archive: a document archived together
+// with its subtree, whose parent is still active.
+type ArchivedDocument struct {
+	ID         string
+	Title      string
+	ArchivedAt time.Time
+	ArchivedBy string
+	// CanManage is set when the user may unarchive or trash the document.
+	CanManage bool
+}
+
+// listArchive returns the entries of the archive of a workspace that the user
+// may view, most recently archived first.
+func (h *Handler) listArchive(ctx context.Context, workspaceID, userID string) ([]ArchivedDocument, error) {
+	query := `SELECT d.id, d.title, d.archived_at, COALESCE(u.username, ''), ` + roleExpr("d", "$2") + `
+		FROM documents d
+		LEFT JOIN documents p ON p.id = d.parent_id
+		LEFT JOIN users u ON u.id = d.archived_by
+		WHERE d.workspace_id = $1 AND d.is_archived AND d.deleted_at IS NULL
+		AND (p.id IS NULL OR NOT p.is_archived)
+		AND ` + roleAtLeast("d", "$2", RoleViewer) + `
+		ORDER BY d.archived_at DESC`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ArchivedDocument
+	for rows.Next() {
+		var e ArchivedDocument
+		var role Role
+		if err := rows.Scan(&e.ID, &e.Title, &e.ArchivedAt, &e.ArchivedBy, &role); err != nil {
+			return nil, err
+		}
+		e.CanManage = role.CanManage()
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// archiveDocument archives doc and every document nested beneath it that is
+// not archived yet. Documents archived in one go share their archival time,
+// which is how they are unarchived together.
+func (h *Handler) archiveDocument(ctx context.Context, doc *Document, userID string) error {
+	query := `UPDATE documents SET is_archived = true, archived_at = NOW(), archived_by = $2
+	          WHERE (document_path = $1 OR document_path LIKE $1 || '/%') AND NOT is_archived`
+	_, err := h.db.GetDB().ExecContext(ctx, query, doc.DocumentPath, userID)
+	return err
+}
+
+// unarchiveDocument makes doc active again together with the part of its
+// subtree that was archived with it. It returns errParentArchived when doc was
+// archived along with its parent.
+func (h *Handler) unarchiveDocument(ctx context.Context, doc *Document) error {
+	if doc.ParentID != "" {
+		parent, err := h.findDocument(ctx, doc.ParentID)
+		if err != nil && !errors.Is(err, errDocumentNotFound) {
+			return err
+		}
+		if parent != nil && parent.IsArchived {
+			return errParentArchived
+		}
+	}
+
+	query := `UPDATE documents SET is_archived = false, archived_at = NULL, archived_by = NULL, updated_at = NOW()
+	          WHERE (document_path = $1 OR document_path LIKE $1 || '/%') AND archived_at = $2`
+	_, err := h.db.GetDB().ExecContext(ctx, query, doc.DocumentPath, doc.ArchivedAt.Time)
+	return err
+}
+
+// ArchiveDocument archives a document with its subtree, which makes them read
+// only and leaves them out of the document tree and of search results.
+func (h *Handler) ArchiveDocument() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		if err := h.archiveDocument(r.Context(), doc, userID); err != nil {
+			log.Printf("Error archiving document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Document archived.")
+		redirect(w, r, "/documents/"+doc.ID)
+	}
+}
+
+// UnarchiveDocument takes a document out of the archive.
+func (h *Handler) UnarchiveDocument() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+		if !doc.IsArchived {
+			redirect(w, r, "/documents/"+doc.ID)
+			return
+		}
+
+		if err := h.unarchiveDocument(r.Context(), doc); err != nil {
+			if errors.Is(err, errParentArchived) {
+				http.Error(w, "Unarchive the parent document first", http.StatusConflict)
+				return
+			}
+			log.Printf("Error unarchiving document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Document unarchived.")
+		redirect(w, r, "/documents/"+doc.ID)
+	}
+}
+
+// renderArchive renders the list of archived documents of a workspace. A
+// non-empty message is shown above the list.
+func (h *Handler) renderArchive(w http.ResponseWriter, r *http.Request, tmpl *template.Template, workspace *Workspace, userID, message string) {
+	entries, err := h.listArchive(r.Context(), workspace.ID, userID)
+	if err != nil {
+		log.Printf("Error listing archive: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Workspace": workspace,
+		"Archive":   entries,
+		"Message":   message,
+	}
+	if err := tmpl.ExecuteTemplate(w, "archive_list", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// Archive shows the archived documents of a workspace.
+func (h *Handler) Archive() http.HandlerFunc {
+	tmpl := h.templates.MustRender("archive")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		entries, err := h.listArchive(r.Context(), workspace.ID, userID)
+		if err != nil {
+			log.Printf("Error listing archive: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, workspace.ID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Workspace": workspace,
+			"Switcher":  switcher,
+			"Archive":   entries,
+			"Flash":     h.GetFlashMessage(w, r),
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// BulkArchive unarchives or trashes the selected documents of the archive of
+// a workspace. Documents the user may not manage are left untouched.
+func (h *Handler) BulkArchive() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("archive")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		var form validator.BulkArchiveForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			h.renderArchive(w, r, tmpl, workspace, userID, "Select at least one document.")
+			return
+		}
+
+		skipped := 0
+		for _, id := range form.DocumentIDs {
+			doc, err := h.findDocument(r.Context(), id)
+			if errors.Is(err, errDocumentNotFound) || (err == nil && (doc.WorkspaceID != workspace.ID || !doc.IsArchived)) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				log.Printf("Error querying document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			role, err := h.documentRole(r.Context(), doc, userID)
+			if err != nil {
+				log.Printf("Error querying document role: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !role.CanManage() {
+				skipped++
+				continue
+			}
+
+			switch form.Action {
+			case "unarchive":
+				err = h.unarchiveDocument(r.Context(), doc)
+			case "trash":
+				err = h.trashDocument(r.Context(), doc, userID)
+			}
+			if errors.Is(err, errParentArchived) {
+				skipped++
+				continue
+			}
+			if err != nil {
+				log.Printf("Error updating archived document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		var message string
+		if skipped > 0 {
+			message = strconv.Itoa(skipped) + " of the selected documents could not be changed."
+		}
+		h.renderArchive(w, r, tmpl, workspace, userID, message)
+	}
+}
@@ -0,0 +1,515 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/export"
+	"github.com/wrytehq/wryte/internal/imaging"
+	"github.com/wrytehq/wryte/internal/storage"
+)
+
+// Attachment is a file uploaded to a document. Its content lives in the
+// storage backend under StorageKey.
+type Attachment struct {
+	ID          string
+	DocumentID  string
+	Name        string
+	ContentType string
+	Size        int64
+	StorageKey  string
+	// Width and Height are known for images uploaded as such, zero
+	// otherwise.
+	Width     int
+	Height    int
+	Uploader  string
+	CreatedAt time.Time
+}
+
+var errAttachmentNotFound = errors.New("attachment not found")
+
+// attachmentLink matches the address of an attachment in document content.
+var attachmentLink = regexp.MustCompile(`^/documents/([0-9a-f-]{36})/attachments/([0-9a-f-]{36})$`)
+
+// URL is the address the attachment is downloaded from, and referenced by in
+// document content.
+func (a *Attachment) URL() string {
+	return "/documents/" + a.DocumentID + "/attachments/" + a.ID
+}
+
+// IsImage reports whether the attachment is shown inline rather than
+// downloaded, and can be scaled down.
+func (a *Attachment) IsImage() bool {
+	return imaging.Supported(a.ContentType)
+}
+
+// blobKeys lists the storage keys of the attachment and of the variants it
+// may have.
+func (a *Attachment) blobKeys() []string {
+	keys := []string{a.StorageKey}
+	if a.IsImage() {
+		for _, v := range imaging.Variants() {
+			keys = append(keys, variantKey(a, v))
+		}
+	}
+	return keys
+}
+
+// Markdown is the snippet inserted into a document to reference the
+// attachment, an image or a link depending on its type.
+func (a *Attachment) Markdown() string {
+	name := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(a.Name)
+	if a.IsImage() {
+		return "![" + name + "](" + a.URL() + ")"
+	}
+	return "[" + name + "](" + a.URL() + ")"
+}
+
+// HumanSize formats the size of the attachment for display.
+func (a *Attachment) HumanSize() string {
+	switch {
+	case a.Size >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	case a.Size >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	}
+	return strconv.FormatInt(a.Size, 10) + " B"
+}
+
+const attachmentColumns = `a.id, a.document_id, a.name, a.content_type, a.size, a.storage_key,
+	COALESCE(a.width, 0), COALESCE(a.height, 0), COALESCE(u.username, ''), a.created_at`
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	var a Attachment
+	err := row.Scan(&a.ID, &a.DocumentID, &a.Name, &a.ContentType, &a.Size, &a.StorageKey, &a.Width, &a.Height, &a.Uploader, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// findAttachment loads an attachment of a document. It returns
+// errAttachmentNotFound when the ID is malformed or the attachment belongs to
+// another document.
+func (h *Handler) findAttachment(ctx context.Context, documentID, attachmentID string) (*Attachment, error) {
+	if _, err := uuid.Parse(attachmentID); err != nil {
+		return nil, errAttachmentNotFound
+	}
+
+	query := `SELECT ` + attachmentColumns + ` FROM attachments a
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE a.id = $1 AND a.document_id = $2`
+	a, err := scanAttachment(h.db.GetDB().QueryRowContext(ctx, query, attachmentID, documentID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errAttachmentNotFound
+	}
+	return a, err
+}
+
+// listAttachments returns the attachments of a document, most recent first.
+func (h *Handler) listAttachments(ctx context.Context, documentID string) ([]*Attachment, error) {
+	query := `SELECT ` + attachmentColumns + ` FROM attachments a
+		LEFT JOIN users u ON u.id = a.user_id
+		WHERE a.document_id = $1
+		ORDER BY a.created_at DESC`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []*Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// renderAttachments renders the list of attachments of a document.
+func (h *Handler) renderAttachments(w http.ResponseWriter, r *http.Request, tmpl *template.Template, doc *Document, role Role) {
+	attachments, err := h.listAttachments(r.Context(), doc.ID)
+	if err != nil {
+		log.Printf("Error listing attachments: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Document":    doc,
+		"Attachments": attachments,
+		"CanEdit":     role.CanEdit() && !doc.IsArchived,
+	}
+	if err := tmpl.ExecuteTemplate(w, "document_attachments", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// DocumentAttachments lists the files attached to a document.
+func (h *Handler) DocumentAttachments() http.HandlerFunc {
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, role := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+		h.renderAttachments(w, r, tmpl, doc, role)
+	}
+}
+
+// readUpload parses a multipart upload and returns its "file" field. Files
+// larger than maxSize are refused. It writes the error response and returns
+// a nil file when the request cannot proceed; the caller closes the file and
+// removes the form.
+func readUpload(w http.ResponseWriter, r *http.Request, maxSize int64) (multipart.File, *multipart.FileHeader) {
+	tooLarge := fmt.Sprintf("Files cannot be larger than %d MB", maxSize>>20)
+
+	// Leave room for the multipart framing around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+			return nil, nil
+		}
+		log.Printf("Error parsing upload: %v", err)
+		http.Error(w, "Error processing form", http.StatusBadRequest)
+		return nil, nil
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		r.MultipartForm.RemoveAll()
+		http.Error(w, "Choose a file to upload", http.StatusBadRequest)
+		return nil, nil
+	}
+	if header.Size > maxSize {
+		file.Close()
+		r.MultipartForm.RemoveAll()
+		http.Error(w, tooLarge, http.StatusRequestEntityTooLarge)
+		return nil, nil
+	}
+	return file, header
+}
+
+// saveAttachment stores the content of a new attachment and records it.
+func (h *Handler) saveAttachment(ctx context.Context, a *Attachment, userID string, content io.Reader) error {
+	a.StorageKey = "documents/" + a.DocumentID + "/" + a.ID
+	if err := h.storage.Put(ctx, a.StorageKey, content, a.Size, a.ContentType); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO attachments (id, document_id, user_id, name, content_type, size, storage_key, width, height, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, 0), NULLIF($9, 0), NOW(), NOW())`
+	_, err := h.db.GetDB().ExecContext(ctx, query, a.ID, a.DocumentID, userID, a.Name, a.ContentType, a.Size, a.StorageKey, a.Width, a.Height)
+	if err != nil {
+		removeBlobs(h.storage, []string{a.StorageKey})
+		return err
+	}
+	return nil
+}
+
+// writeAttachment answers an upload with the address of the new attachment
+// and the Markdown referencing it, for the editor to insert.
+func writeAttachment(w http.ResponseWriter, a *Attachment) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err := json.NewEncoder(w).Encode(map[string]string{
+		"id":       a.ID,
+		"name":     a.Name,
+		"url":      a.URL(),
+		"markdown": a.Markdown(),
+	})
+	if err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// UploadAttachment stores a file sent as the "file" field of a multipart
+// form and attaches it to the document as it is.
+func (h *Handler) UploadAttachment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeEditableDocument(w, r)
+		if doc == nil {
+			return
+		}
+
+		file, header := readUpload(w, r, h.config.Document.AttachmentMaxSize)
+		if file == nil {
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		defer file.Close()
+
+		// The type is taken from the content, never from what the client
+		// claims, since it decides whether the file is shown inline
+		mt, err := mimetype.DetectReader(file)
+		if err != nil {
+			log.Printf("Error reading attachment: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Error reading attachment: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		a := &Attachment{
+			ID:          uuid.NewString(),
+			DocumentID:  doc.ID,
+			Name:        attachmentName(header.Filename),
+			ContentType: mt.String(),
+			Size:        header.Size,
+		}
+		if err := h.saveAttachment(r.Context(), a, userID, file); err != nil {
+			log.Printf("Error saving attachment: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeAttachment(w, a)
+	}
+}
+
+// attachmentName keeps the base name of an uploaded file, which some browsers
+// send with its full path.
+func attachmentName(filename string) string {
+	name := filename[strings.LastIndexAny(filename, `/\`)+1:]
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "" {
+		return "file"
+	}
+	if len(name) > 255 {
+		name = strings.ToValidUTF8(name[:255], "")
+	}
+	return name
+}
+
+// DownloadAttachment sends an attachment to anyone who can view its document.
+func (h *Handler) DownloadAttachment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, _ := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+		h.serveAttachment(w, r, doc, "private, max-age=86400")
+	}
+}
+
+// serveAttachment writes the attachment named in the request path. Images are
+// shown inline, every other file is downloaded, and the content is never
+// interpreted as a page of this site.
+func (h *Handler) serveAttachment(w http.ResponseWriter, r *http.Request, doc *Document, cacheControl string) {
+	a, err := h.findAttachment(r.Context(), doc.ID, r.PathValue("attachmentId"))
+	if err != nil {
+		if errors.Is(err, errAttachmentNotFound) {
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error querying attachment: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var variant imaging.Variant
+	if name := r.URL.Query().Get("variant"); name != "" {
+		v, ok := imaging.LookupVariant(name)
+		if !ok || !a.IsImage() {
+			http.Error(w, "Unknown image variant", http.StatusNotFound)
+			return
+		}
+		variant = v
+	}
+
+	// Attachments never change, their ID is enough to tell versions apart
+	etag := `"` + a.ID + `"`
+	if variant.Name != "" {
+		etag = `"` + a.ID + "." + variant.Name + `"`
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	disposition := "attachment"
+	if a.IsImage() {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+
+	if variant.Name != "" {
+		data, err := h.imageVariant(r.Context(), a, variant)
+		if err != nil {
+			log.Printf("Error rendering image variant: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		if _, err := w.Write(data); err != nil {
+			log.Printf("Error writing image variant: %v", err)
+		}
+		return
+	}
+
+	body, err := h.storage.Get(r.Context(), a.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Printf("Attachment %s is missing from storage", a.ID)
+			http.Error(w, "Attachment not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Error reading attachment: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer body.Close()
+
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+	if _, err := io.Copy(w, body); err != nil {
+		log.Printf("Error writing attachment: %v", err)
+	}
+}
+
+// DeleteAttachment removes a file from a document. References to it in the
+// content of the document are left for the editor to clean up.
+func (h *Handler) DeleteAttachment() http.HandlerFunc {
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, role := h.authorizeEditableDocument(w, r)
+		if doc == nil {
+			return
+		}
+
+		a, err := h.findAttachment(r.Context(), doc.ID, r.PathValue("attachmentId"))
+		if err != nil {
+			if errors.Is(err, errAttachmentNotFound) {
+				http.Error(w, "Attachment not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error querying attachment: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := h.db.GetDB().ExecContext(r.Context(), `DELETE FROM attachments WHERE id = $1`, a.ID); err != nil {
+			log.Printf("Error deleting attachment: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		removeBlobs(h.storage, a.blobKeys())
+
+		h.renderAttachments(w, r, tmpl, doc, role)
+	}
+}
+
+// deleteDocuments deletes the documents matching the condition for good,
+// within tx. Their attachments go with them; the storage keys of the files
+// are returned for removeBlobs once the transaction is committed.
+func deleteDocuments(ctx context.Context, tx *sql.Tx, condition string, args ...any) ([]string, int64, error) {
+	query := `DELETE FROM attachments WHERE document_id IN (SELECT id FROM documents WHERE ` + condition + `)
+		RETURNING storage_key, content_type`
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var a Attachment
+		if err := rows.Scan(&a.StorageKey, &a.ContentType); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		keys = append(keys, a.blobKeys()...)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	res, err := tx.ExecContext(ctx, `DELETE FROM documents WHERE `+condition, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := res.RowsAffected()
+	return keys, n, err
+}
+
+// removeBlobs deletes the files of attachments that are gone from the
+// database. Failures only leave unreachable files behind, so they are logged.
+func removeBlobs(store storage.Backend, keys []string) {
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := store.Delete(ctx, key); err != nil {
+			log.Printf("Error removing attachment %s from storage: %v", key, err)
+		}
+		cancel()
+	}
+}
+
+// attachmentImages embeds the images attached to documents the user can view
+// into exports.
+func (h *Handler) attachmentImages(userID string) export.ImageResolver {
+	return func(ctx context.Context, dest string) (*export.Image, error) {
+		m := attachmentLink.FindStringSubmatch(dest)
+		if m == nil {
+			return nil, nil
+		}
+		doc, err := h.findDocument(ctx, m[1])
+		if err != nil {
+			return nil, err
+		}
+		role, err := h.documentRole(ctx, doc, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !role.CanView() {
+			return nil, nil
+		}
+		a, err := h.findAttachment(ctx, doc.ID, m[2])
+		if err != nil {
+			return nil, err
+		}
+		if !a.IsImage() {
+			return nil, nil
+		}
+
+		body, err := h.storage.Get(ctx, a.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		return export.ReadImage(body)
+	}
+}
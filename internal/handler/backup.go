@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/backup"
+)
+
+// BackupWorkspace downloads a backup archive of a workspace, the same archive
+// `wryte backup` writes. It can be restored with `wryte restore`.
+func (h *Handler) BackupWorkspace() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		filename := fmt.Sprintf("wryte-backup-%s-%s.zip", workspace.Name, time.Now().Format("2006-01-02"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": filename,
+		}))
+
+		// The archive is streamed, so a failure can only cut the download
+		// short, leaving a zip file without its directory
+		if err := backup.Write(r.Context(), h.db.GetDB(), h.storage, w, workspace.ID); err != nil {
+			log.Printf("Error backing up workspace: %v", err)
+		}
+	}
+}
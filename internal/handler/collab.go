@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/collab"
+	"github.com/wrytehq/wryte/internal/crdt"
+)
+
+const (
+	// maxOpsBody bounds the size of a batch of collaborative operations.
+	maxOpsBody = 1 << 20
+
+	// maxCursorBody bounds the size of a cursor update.
+	maxCursorBody = 4 << 10
+)
+
+// collabStore gives the collaboration hub access to document content.
+type collabStore struct {
+	h *Handler
+}
+
+func (s collabStore) LoadContent(ctx context.Context, documentID string) (string, int, error) {
+	doc, err := s.h.findDocument(ctx, documentID)
+	if err != nil {
+		return "", 0, err
+	}
+	return doc.Content, doc.Version, nil
+}
+
+func (s collabStore) SaveContent(ctx context.Context, tx *sql.Tx, documentID, content, userID string, version int) (int, error) {
+	doc := &Document{ID: documentID, Content: content}
+	query := `UPDATE documents
+	          SET content = $1, version = version + 1, updated_at = NOW()
+	          WHERE id = $2 AND version = $3 AND deleted_at IS NULL AND NOT is_archived
+	          RETURNING title, version, updated_at`
+	err := tx.QueryRowContext(ctx, query, content, documentID, version).Scan(&doc.Title, &doc.Version, &doc.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		var editable bool
+		query := `SELECT deleted_at IS NULL AND NOT is_archived FROM documents WHERE id = $1`
+		err := tx.QueryRowContext(ctx, query, documentID).Scan(&editable)
+		switch {
+		case errors.Is(err, sql.ErrNoRows) || (err == nil && !editable):
+			return 0, collab.ErrReadOnly
+		case err != nil:
+			return 0, err
+		}
+		return 0, collab.ErrConflict
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := s.h.recordRevision(ctx, tx, doc, userID, true); err != nil {
+		return 0, err
+	}
+	return doc.Version, nil
+}
+
+// validSession reports whether s can identify a browser session: a short,
+// non-empty run of letters and digits chosen by the client.
+func validSession(s string) bool {
+	if len(s) == 0 || len(s) > 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// CollabStream streams the collaborative editing session of a document as
+// server-sent events. The first event carries the full replica state, later
+// events carry operations from other editors and the list of participants.
+// Viewers join with mode=view to appear as participants; only editors may join
+// with mode=edit.
+func (h *Handler) CollabStream() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, role := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+
+		session := r.URL.Query().Get("session")
+		if !validSession(session) {
+			http.Error(w, "Invalid session", http.StatusBadRequest)
+			return
+		}
+		mode := collab.ModeView
+		if r.URL.Query().Get("mode") == collab.ModeEdit && role.CanEdit() && !doc.IsArchived {
+			mode = collab.ModeEdit
+		}
+
+		var username string
+		err := h.db.GetDB().QueryRowContext(r.Context(), `SELECT username FROM users WHERE id = $1`, userID).Scan(&username)
+		if err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		sub, state, err := h.collab.Subscribe(r.Context(), doc.ID, collab.Presence{
+			Session:  session,
+			UserID:   userID,
+			Username: username,
+			Mode:     mode,
+		})
+		if err != nil {
+			if errors.Is(err, collab.ErrClosed) {
+				http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+			if errors.Is(err, collab.ErrSessionTaken) {
+				http.Error(w, "Session belongs to someone else", http.StatusConflict)
+				return
+			}
+			log.Printf("Error joining collaborative session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer h.collab.Unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+
+		rc := http.NewResponseController(w)
+		send := func(event string, data []byte) error {
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		if err := send("state", state); err != nil {
+			return
+		}
+
+		// Comments keep idle connections from being closed by proxies
+		heartbeat := time.NewTicker(25 * time.Second)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case event, ok := <-sub.Events:
+				if !ok {
+					// Closed by the hub, the client reconnects on its own
+					return
+				}
+				if err := send(event.Name, event.Data); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// CollabOps receives a batch of operations from an editor. The operations
+// must create elements under the session of the editor only and may only
+// refer to elements the server already knows.
+func (h *Handler) CollabOps() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeEditableDocument(w, r)
+		if doc == nil {
+			return
+		}
+
+		var body struct {
+			Session string    `json:"session"`
+			Ops     []crdt.Op `json:"ops"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxOpsBody)).Decode(&body); err != nil || !validSession(body.Session) {
+			http.Error(w, "Invalid operations", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.collab.Apply(r.Context(), doc.ID, userID, body.Session, body.Ops); err != nil {
+			switch {
+			case errors.Is(err, crdt.ErrInvalidOp):
+				http.Error(w, "Invalid operations", http.StatusBadRequest)
+			case errors.Is(err, crdt.ErrMissing):
+				http.Error(w, "Operations refer to unknown elements", http.StatusConflict)
+			case errors.Is(err, collab.ErrNotJoined):
+				http.Error(w, "Session has not joined the document", http.StatusConflict)
+			case errors.Is(err, collab.ErrClosed):
+				http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			default:
+				log.Printf("Error applying operations: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// CollabCursor receives the cursor of a participant, which is shown to the
+// others. Viewers may share where they are reading as well, only operations
+// require edit access.
+func (h *Handler) CollabCursor() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+
+		var body struct {
+			Session string         `json:"session"`
+			Cursor  *collab.Cursor `json:"cursor"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCursorBody)).Decode(&body); err != nil || !validSession(body.Session) {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+
+		if err := h.collab.UpdateCursor(r.Context(), doc.ID, userID, body.Session, body.Cursor); err != nil {
+			if errors.Is(err, collab.ErrNotJoined) {
+				http.Error(w, "Session has not joined the document", http.StatusConflict)
+				return
+			}
+			log.Printf("Error updating cursor: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -1,92 +1,391 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/flash"
 	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
 )
 
 type Document struct {
-	ID          string
-	Title       string
-	ParentID    string
-	IsPublic    bool
-	IsArchived  bool
-	WorkspaceID string
-	Content     string
-	UserID      string
-	CreatedAt   string
-	UpdatedAt   string
-	DeletedAt   string
+	ID           string
+	Title        string
+	ParentID     string
+	DocumentPath string
+	IsPublic     bool
+	IsArchived   bool
+	WorkspaceID  string
+	Content      string
+	UserID       string
+	Version      int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    sql.NullTime
+	ArchivedAt   sql.NullTime
+}
+
+var errDocumentNotFound = errors.New("document not found")
+
+// documentColumns lists the columns read by scanDocument, in scan order.
+const documentColumns = `id, title, COALESCE(parent_id::text, ''), document_path, is_public, is_archived,
+	workspace_id, COALESCE(content, ''), user_id, version, created_at, updated_at, deleted_at, archived_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanDocument(row rowScanner) (*Document, error) {
+	var doc Document
+	err := row.Scan(
+		&doc.ID,
+		&doc.Title,
+		&doc.ParentID,
+		&doc.DocumentPath,
+		&doc.IsPublic,
+		&doc.IsArchived,
+		&doc.WorkspaceID,
+		&doc.Content,
+		&doc.UserID,
+		&doc.Version,
+		&doc.CreatedAt,
+		&doc.UpdatedAt,
+		&doc.DeletedAt,
+		&doc.ArchivedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// findDocument loads a document by ID. It returns errDocumentNotFound when the
+// ID is malformed or no such document exists outside of the trash.
+func (h *Handler) findDocument(ctx context.Context, documentID string) (*Document, error) {
+	return h.lookupDocument(ctx, documentID, false)
+}
+
+// findTrashedDocument is findDocument for documents in the trash.
+func (h *Handler) findTrashedDocument(ctx context.Context, documentID string) (*Document, error) {
+	return h.lookupDocument(ctx, documentID, true)
+}
+
+func (h *Handler) lookupDocument(ctx context.Context, documentID string, trashed bool) (*Document, error) {
+	if _, err := uuid.Parse(documentID); err != nil {
+		return nil, errDocumentNotFound
+	}
+
+	query := `SELECT ` + documentColumns + ` FROM documents WHERE id = $1 AND (deleted_at IS NOT NULL) = $2`
+	doc, err := scanDocument(h.db.GetDB().QueryRowContext(ctx, query, documentID, trashed))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errDocumentNotFound
+	}
+	return doc, err
 }
 
 func (h *Handler) ViewDocument() http.HandlerFunc {
 	tmpl := h.templates.MustRender("document")
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Get document ID from URL path
-		documentID := r.PathValue("documentId")
-		if documentID == "" {
-			http.Error(w, "Document ID is required", http.StatusBadRequest)
+		doc, userID, role := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+
+		workspace, err := h.findWorkspace(r.Context(), doc.WorkspaceID)
+		if err != nil {
+			log.Printf("Error querying workspace: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		breadcrumbs, err := h.documentAncestors(r.Context(), doc, userID)
+		if err != nil {
+			log.Printf("Error querying document ancestors: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var moveTargets []DocumentNode
+		if role.CanManage() {
+			moveTargets, err = h.moveTargets(r.Context(), doc, userID)
+			if err != nil {
+				log.Printf("Error querying move targets: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, doc.WorkspaceID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		// Get authenticated user ID from context
+		// Documents archived along with their parent are unarchived from there
+		archivedParent := false
+		if doc.IsArchived && doc.ParentID != "" {
+			parent, err := h.findDocument(r.Context(), doc.ParentID)
+			if err != nil && !errors.Is(err, errDocumentNotFound) {
+				log.Printf("Error querying parent document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			archivedParent = parent != nil && parent.IsArchived
+		}
+
+		// Render template
+		data := map[string]any{
+			"Document":        doc,
+			"Workspace":       workspace,
+			"WorkspaceMember": switcher.Current != nil,
+			"Switcher":        switcher,
+			"Breadcrumbs":     breadcrumbs,
+			"MoveTargets":     moveTargets,
+			"Role":            role,
+			"ArchivedParent":  archivedParent,
+			"Editing":         role.CanEdit() && !doc.IsArchived && r.URL.Query().Get("mode") == "edit",
+			"Flash":           h.GetFlashMessage(w, r),
+		}
+
+		err = tmpl.ExecuteTemplate(w, "layout.html", data)
+		if err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+func (h *Handler) CreateDocument() http.HandlerFunc {
+	v := validator.New()
+
+	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := middleware.GetUserID(r)
 		if !ok {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		// Query document from database
-		var doc Document
-		query := `SELECT 
-			id, title, parent_id, is_public, is_archived, workspace_id, content, user_id, created_at, updated_at, deleted_at
-		          FROM documents WHERE id = $1`
-		err := h.db.GetDB().QueryRowContext(r.Context(), query, documentID).Scan(
-			&doc.ID,
-			&doc.Title,
-			&doc.ParentID,
-			&doc.IsPublic,
-			&doc.IsArchived,
-			&doc.WorkspaceID,
-			&doc.Content,
-			&doc.UserID,
-			&doc.CreatedAt,
-			&doc.UpdatedAt,
-		)
+		var form validator.CreateDocumentForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			http.Error(w, "Invalid document", http.StatusUnprocessableEntity)
+			return
+		}
+
+		title := strings.TrimSpace(form.Title)
+		if title == "" {
+			title = "Untitled"
+		}
+
+		// The ID is generated up front so the document path can include it
+		id := uuid.NewString()
+		documentPath := "/" + id
+		workspaceID := form.WorkspaceID
+		var parentID sql.NullString
+
+		if form.ParentID != "" {
+			parent, err := h.findDocument(r.Context(), form.ParentID)
+			if err != nil {
+				if errors.Is(err, errDocumentNotFound) {
+					http.Error(w, "Parent document not found", http.StatusBadRequest)
+					return
+				}
+				log.Printf("Error querying parent document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			role, err := h.documentRole(r.Context(), parent, userID)
+			if err != nil {
+				log.Printf("Error querying document role: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !role.CanEdit() {
+				http.Error(w, "Forbidden - You don't have access to this document", http.StatusForbidden)
+				return
+			}
+			if parent.IsArchived {
+				http.Error(w, archivedError, http.StatusConflict)
+				return
+			}
+
+			workspaceID = parent.WorkspaceID
+			documentPath = parent.DocumentPath + "/" + id
+			parentID = sql.NullString{String: parent.ID, Valid: true}
+		}
+
+		if workspaceID == "" {
+			workspaceID, err = h.defaultWorkspaceID(r.Context(), userID)
+			if err != nil {
+				log.Printf("Error resolving default workspace: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		} else if !parentID.Valid {
+			member, err := h.isWorkspaceMember(r.Context(), workspaceID, userID)
+			if err != nil {
+				log.Printf("Error querying workspace: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !member {
+				http.Error(w, "Forbidden - You don't have access to this workspace", http.StatusForbidden)
+				return
+			}
+		}
+
+		query := `INSERT INTO documents (id, title, parent_id, content, user_id, document_path, workspace_id, created_at, updated_at)
+		          VALUES ($1, $2, $3, '', $4, $5, $6, NOW(), NOW())`
+		_, err = h.db.GetDB().ExecContext(r.Context(), query, id, title, parentID, userID, documentPath, workspaceID)
+		if err != nil {
+			log.Printf("Error creating document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		redirect(w, r, "/documents/"+id+"?mode=edit")
+	}
+}
+
+// UpdateDocument saves the title and content of a document. PUT replaces both
+// fields while PATCH only touches the fields present in the request. Every save
+// must carry the version it was based on; a stale version is rejected with 409
+// Conflict so concurrent editors cannot overwrite each other silently.
+func (h *Handler) UpdateDocument() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeEditableDocument(w, r)
+		if doc == nil {
+			return
+		}
+
+		var form validator.UpdateDocumentForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		title, content := doc.Title, doc.Content
+		if r.Method == http.MethodPut || r.PostForm.Has("title") {
+			title = strings.TrimSpace(form.Title)
+			if title == "" {
+				validationErrs.AddError("title", "Title is required")
+			}
+		}
+		if r.Method == http.MethodPut || r.PostForm.Has("content") {
+			content = form.Content
+		}
+
+		if validationErrs.HasErrors() {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			data := map[string]any{
+				"Document": doc,
+				"Errors":   validationErrs,
+			}
+			if err := tmpl.ExecuteTemplate(w, "document_save_status", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+			return
+		}
 
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		query := `UPDATE documents
+		          SET title = $1, content = $2, version = version + 1, updated_at = NOW()
+		          WHERE id = $3 AND version = $4
+		          RETURNING version, updated_at`
+		err = tx.QueryRowContext(r.Context(), query, title, content, doc.ID, form.Version).
+			Scan(&doc.Version, &doc.UpdatedAt)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				http.Error(w, "Document not found", http.StatusNotFound)
+				// Someone else saved since this editor loaded the document
+				w.WriteHeader(http.StatusConflict)
+				data := map[string]any{
+					"Document": doc,
+					"Conflict": true,
+				}
+				if err := tmpl.ExecuteTemplate(w, "document_save_status", data); err != nil {
+					log.Printf("Error rendering template: %v", err)
+				}
 				return
 			}
-			log.Printf("Error querying document: %v", err)
+			log.Printf("Error updating document: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		// Check if user has access to this document
-		// For now, simple check: document must belong to the user
-		// TODO: Add sharing/permissions logic
-		if doc.UserID != userID {
-			http.Error(w, "Forbidden - You don't have access to this document", http.StatusForbidden)
+		contentChanged := content != doc.Content
+		doc.Title, doc.Content = title, content
+		if err := h.recordRevision(r.Context(), tx, doc, userID, true); err != nil {
+			log.Printf("Error recording revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		// Render template
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// Open collaborative sessions must pick up content saved outside of them
+		if contentChanged {
+			if err := h.collab.Merge(r.Context(), doc.ID); err != nil {
+				log.Printf("Error merging into collaborative session: %v", err)
+			}
+		}
+
 		data := map[string]any{
 			"Document": doc,
 		}
+		if err := tmpl.ExecuteTemplate(w, "document_saved", data); err != nil {
+			log.Printf("Error rendering template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// DeleteDocument moves a document together with every document nested beneath
+// it to the trash of its workspace.
+func (h *Handler) DeleteDocument() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
 
-		err = tmpl.ExecuteTemplate(w, "layout.html", data)
-		if err != nil {
-			log.Printf("Error executing template: %v", err)
+		if err := h.trashDocument(r.Context(), doc, userID); err != nil {
+			log.Printf("Error trashing document: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+
+		flash.SetSuccess(w, "Document moved to trash.")
+		redirect(w, r, "/?workspace="+doc.WorkspaceID)
 	}
 }
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/wrytehq/wryte/internal/export"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// exportDocuments returns doc followed by its descendants when requested, in
+// tree order with siblings sorted by title. Documents in the trash are left
+// out.
+func (h *Handler) exportDocuments(ctx context.Context, doc *Document, descendants bool) ([]export.Document, error) {
+	documents := []export.Document{{ID: doc.ID, Title: doc.Title, Content: doc.Content, UpdatedAt: doc.UpdatedAt}}
+	if !descendants {
+		return documents, nil
+	}
+
+	query := `SELECT id, parent_id, title, COALESCE(content, ''), updated_at
+		FROM documents
+		WHERE document_path LIKE $1 || '/%' AND deleted_at IS NULL
+		ORDER BY title, created_at`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, doc.DocumentPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	children := make(map[string][]export.Document)
+	for rows.Next() {
+		var d export.Document
+		var parentID string
+		if err := rows.Scan(&d.ID, &parentID, &d.Title, &d.Content, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		children[parentID] = append(children[parentID], d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var walk func(parentID string, depth int)
+	walk = func(parentID string, depth int) {
+		for _, d := range children[parentID] {
+			d.Depth = depth
+			documents = append(documents, d)
+			walk(d.ID, depth+1)
+		}
+	}
+	walk(doc.ID, 1)
+	return documents, nil
+}
+
+// ExportDocument downloads a document, optionally with its subpages, as a
+// single Markdown, HTML, PDF or EPUB file.
+func (h *Handler) ExportDocument() http.HandlerFunc {
+	v := validator.New()
+	images := export.RemoteImages()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+
+		var form validator.ExportForm
+		validationErrs, err := v.DecodeQueryAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating export: %v", err)
+			http.Error(w, "Error processing export", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			http.Error(w, "Unsupported export format", http.StatusBadRequest)
+			return
+		}
+
+		documents, err := h.exportDocuments(r.Context(), doc, form.Descendants)
+		if err != nil {
+			log.Printf("Error loading documents to export: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var author string
+		err = h.db.GetDB().QueryRowContext(r.Context(), `SELECT username FROM users WHERE id = $1`, doc.UserID).Scan(&author)
+		if err != nil {
+			log.Printf("Error querying document author: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		title := doc.Title
+		if title == "" {
+			title = "Untitled"
+		}
+		book := &export.Book{
+			Title:     title,
+			Author:    author,
+			Documents: documents,
+			Images:    export.Images(h.attachmentImages(userID), images),
+		}
+
+		// Render the whole file first so failures can still be reported
+		format := export.Format(form.Format)
+		var buf bytes.Buffer
+		if err := export.Write(r.Context(), &buf, format, book); err != nil {
+			log.Printf("Error exporting document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", format.ContentType())
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": title + "." + form.Format,
+		}))
+		if _, err := buf.WriteTo(w); err != nil {
+			log.Printf("Error writing export: %v", err)
+		}
+	}
+}
@@ -1,12 +1,22 @@
 package handler
 
 import (
+	"context"
+	"log"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/wrytehq/wryte/internal/auth"
+	"github.com/wrytehq/wryte/internal/collab"
 	"github.com/wrytehq/wryte/internal/config"
 	"github.com/wrytehq/wryte/internal/database"
 	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/mailer"
 	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/storage"
 	"github.com/wrytehq/wryte/internal/templates"
 )
 
@@ -14,14 +24,61 @@ type Handler struct {
 	templates *templates.Manager
 	db        database.Service
 	config    *config.Config
+	collab    *collab.Hub
+	storage   storage.Backend
+	mailer    mailer.Mailer
+	auth      auth.Provider
+	passkeys  *webauthn.WebAuthn
+
+	// sso is the identity provider of single sign-on, discovered when first
+	// needed
+	ssoMu sync.Mutex
+	sso   *ssoProvider
+
+	stopPurge context.CancelFunc
+	purgeDone chan struct{}
 }
 
-func New(tmpl *templates.Manager, db database.Service, cfg *config.Config) *Handler {
-	return &Handler{
+func New(tmpl *templates.Manager, db database.Service, cfg *config.Config, store storage.Backend, mail mailer.Mailer, provider auth.Provider) *Handler {
+	h := &Handler{
 		templates: tmpl,
 		db:        db,
 		config:    cfg,
+		storage:   store,
+		mailer:    mail,
+		auth:      provider,
+	}
+
+	passkeys, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.Auth.PasskeyRPID,
+		RPDisplayName: "Wryte",
+		RPOrigins:     cfg.Auth.PasskeyOrigins,
+	})
+	if err != nil {
+		// The relying party is checked when the configuration is loaded
+		panic(err)
+	}
+	h.passkeys = passkeys
+	h.collab = collab.NewHub(db.GetDB(), collabStore{h})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.stopPurge = cancel
+	h.purgeDone = make(chan struct{})
+	go h.purgeTrash(ctx)
+
+	return h
+}
+
+// Shutdown stops background work and closes long-lived connections such as
+// collaborative editing streams, writing back any pending changes.
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.stopPurge()
+	select {
+	case <-h.purgeDone:
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+	return h.collab.Shutdown(ctx)
 }
 
 func (h *Handler) Authenticated(next http.Handler) http.Handler {
@@ -32,6 +89,14 @@ func (h *Handler) Guest(next http.Handler) http.Handler {
 	return middleware.Guest(h.db)(next)
 }
 
+func (h *Handler) EmailVerified(next http.Handler) http.Handler {
+	return middleware.EmailVerified(h.db, h.config.Project.EmailVerification)(next)
+}
+
+func (h *Handler) TwoFactorRequired(next http.Handler) http.Handler {
+	return middleware.TwoFactorRequired(h.db, h.config.Auth.RequireTwoFactor)(next)
+}
+
 func (h *Handler) SelfHosted(next http.Handler) http.Handler {
 	return middleware.SelfHosted(h.db)(next)
 }
@@ -40,3 +105,43 @@ func (h *Handler) GetFlashMessage(w http.ResponseWriter, r *http.Request) *flash
 	msg, _ := flash.Get(w, r)
 	return msg
 }
+
+// redirect sends the client to url. htmx requests get an HX-Redirect header so
+// the browser performs a full navigation instead of swapping the response.
+func redirect(w http.ResponseWriter, r *http.Request, url string) {
+	if r.Header.Get("HX-Request") == "true" {
+		w.Header().Set("HX-Redirect", url)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusSeeOther)
+}
+
+// localPath returns next when it is a path on this site, and "/" otherwise,
+// so that redirects taken from user input cannot lead elsewhere.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// sendEmail renders the email called name with data and sends it to the
+// address to in the background, so that requests do not wait for the mail
+// server.
+func (h *Handler) sendEmail(to, name string, data map[string]any) {
+	email, err := h.templates.RenderEmail(name, data)
+	if err != nil {
+		log.Printf("Error rendering email %s: %v", name, err)
+		return
+	}
+	msg := &mailer.Message{To: to, Subject: email.Subject, Text: email.Text, HTML: email.HTML}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := h.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Error sending email %s to %s: %v", name, to, err)
+		}
+	}()
+}
@@ -3,17 +3,65 @@ package handler
 import (
 	"log"
 	"net/http"
+
+	"github.com/wrytehq/wryte/internal/middleware"
 )
 
 func (h *Handler) Home() http.HandlerFunc {
 	tmpl := h.templates.MustRender("home")
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		workspaces, err := h.listWorkspaces(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Show the requested workspace, falling back to the first one
+		var current *Workspace
+		for i := range workspaces {
+			if workspaces[i].ID == r.URL.Query().Get("workspace") {
+				current = &workspaces[i]
+				break
+			}
+		}
+		if current == nil && len(workspaces) > 0 {
+			current = &workspaces[0]
+		}
+
+		var documents []DocumentNode
+		if current != nil {
+			documents, err = h.listChildDocuments(r.Context(), current.ID, "")
+			if err != nil {
+				log.Printf("Error listing documents: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		shared, err := h.listSharedDocuments(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error listing shared documents: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
 		data := map[string]any{
-			// Add home page data here
+			"Switcher":  &WorkspaceSwitcher{Workspaces: workspaces, Current: current},
+			"Workspace": current,
+			"Documents": documents,
+			"Shared":    shared,
+			"Flash":     h.GetFlashMessage(w, r),
 		}
 
-		err := tmpl.ExecuteTemplate(w, "layout.html", data)
+		err = tmpl.ExecuteTemplate(w, "layout.html", data)
 		if err != nil {
 			log.Printf("Error executing template: %v", err)
 			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
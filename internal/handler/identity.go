@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/auth"
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+const (
+	// identityLinkTTL is how long a link connecting an identity to an
+	// existing account can be used.
+	identityLinkTTL = time.Hour
+	// identityLinkInterval is how long to wait before sending another link
+	// to the same account, so signing in cannot be used to flood an inbox.
+	identityLinkInterval = time.Minute
+)
+
+// identityProviderName is the name shown to users for the directory or the
+// identity provider an identity comes from.
+func (h *Handler) identityProviderName(issuer string) string {
+	if issuer == h.auth.Issuer() || !h.config.Auth.OIDC.Enabled() {
+		return "your directory account"
+	}
+	return h.config.Auth.OIDC.Name
+}
+
+// requestIdentityLink emails the owner of the account using the address of
+// an identity a link connecting the identity to it. Until they follow it,
+// the identity cannot sign in: an address given by a directory or an
+// identity provider that does not verify it does not prove that whoever
+// signs in owns the account. Nothing is sent when a link was sent to the
+// account less than identityLinkInterval ago.
+func (h *Handler) requestIdentityLink(r *http.Request, identity *auth.Identity) error {
+	ctx := r.Context()
+
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the user keeps concurrent sign ins from both passing the
+	// check below
+	var userID, username, email string
+	query := `SELECT id, username, email FROM users WHERE lower(email) = lower($1) FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, identity.Email).Scan(&userID, &username, &email); err != nil {
+		return err
+	}
+	var recent bool
+	query = `SELECT EXISTS (SELECT 1 FROM identity_links WHERE user_id = $1 AND created_at > $2)`
+	if err := tx.QueryRowContext(ctx, query, userID, time.Now().Add(-identityLinkInterval)).Scan(&recent); err != nil {
+		return err
+	}
+	if recent {
+		return nil
+	}
+
+	query = `DELETE FROM identity_links WHERE expires_at < NOW() OR (user_id = $1 AND issuer = $2 AND subject = $3)`
+	if _, err := tx.ExecContext(ctx, query, userID, identity.Issuer, identity.Subject); err != nil {
+		return err
+	}
+	token := rand.Text()
+	query = `INSERT INTO identity_links (user_id, email, issuer, subject, token_hash, expires_at, created_at)
+	         VALUES ($1, $2, $3, $4, $5, $6, NOW())`
+	_, err = tx.ExecContext(ctx, query, userID, email, identity.Issuer, identity.Subject, hashToken(token), time.Now().Add(identityLinkTTL))
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	h.sendEmail(email, "identity_link", map[string]any{
+		"Username": username,
+		"Provider": h.identityProviderName(identity.Issuer),
+		"Link":     h.config.URL("/link-account?token=" + url.QueryEscape(token)),
+	})
+	return nil
+}
+
+// LinkAccountPage asks the owner of an account following a link sent by
+// requestIdentityLink to confirm the connection. Following the link alone
+// changes nothing, so that mail scanners opening it do not confirm it.
+func (h *Handler) LinkAccountPage() http.HandlerFunc {
+	tmpl := h.templates.MustRender("auth/link_account")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+
+		var username, issuer string
+		query := `SELECT u.username, l.issuer FROM identity_links l
+		          JOIN users u ON u.id = l.user_id AND lower(u.email) = lower(l.email)
+		          WHERE l.token_hash = $1 AND l.expires_at > NOW()`
+		err := h.db.GetDB().QueryRowContext(r.Context(), query, hashToken(token)).Scan(&username, &issuer)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error querying identity link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Form":     &validator.LinkAccountForm{Token: token},
+			"Username": username,
+			"Invalid":  err != nil,
+		}
+		if err == nil {
+			data["Provider"] = h.identityProviderName(issuer)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// LinkAccountForm connects an identity to the account a link was sent for.
+// The link is used up, and only works while the account still uses the
+// address it was sent to.
+func (h *Handler) LinkAccountForm() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("auth/link_account")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var form validator.LinkAccountForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		invalid := func() {
+			data := map[string]any{"Form": &form, "Invalid": true}
+			if err := tmpl.ExecuteTemplate(w, "link_account_form", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+		}
+		if validationErrs.HasErrors() {
+			invalid()
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var userID, email, issuer, subject string
+		query := `DELETE FROM identity_links WHERE token_hash = $1 AND expires_at > NOW()
+		          RETURNING user_id, email, issuer, subject`
+		err = tx.QueryRowContext(r.Context(), query, hashToken(form.Token)).Scan(&userID, &email, &issuer, &subject)
+		if errors.Is(err, sql.ErrNoRows) {
+			invalid()
+			return
+		}
+		if err != nil {
+			log.Printf("Error using identity link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// The link was sent to the address of the account, following it
+		// proves it is theirs
+		query = `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		         WHERE id = $1 AND lower(email) = lower($2)`
+		res, err := tx.ExecContext(r.Context(), query, userID, email)
+		if err != nil {
+			log.Printf("Error updating user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			invalid()
+			return
+		}
+
+		// An identity that got connected to another account in the meantime
+		// stays there
+		query = `INSERT INTO user_identities (user_id, issuer, subject, created_at)
+		         VALUES ($1, $2, $3, NOW())
+		         ON CONFLICT (issuer, subject) DO NOTHING`
+		res, err = tx.ExecContext(r.Context(), query, userID, issuer, subject)
+		if err != nil {
+			log.Printf("Error linking identity: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			invalid()
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Your accounts are connected. Sign in again with "+h.identityProviderName(issuer)+".")
+		redirect(w, r, "/login")
+	}
+}
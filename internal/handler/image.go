@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/imaging"
+	"github.com/wrytehq/wryte/internal/storage"
+	"golang.org/x/sync/singleflight"
+)
+
+// variantGroup makes concurrent requests for a variant that is not stored
+// yet wait for a single rendering of it.
+var variantGroup singleflight.Group
+
+// variantKey is where the variant v of an image attachment is stored once
+// rendered.
+func variantKey(a *Attachment, v imaging.Variant) string {
+	return a.StorageKey + "." + v.Name
+}
+
+// UploadImage stores an image sent as the "file" field of a multipart form
+// and attaches it to the document. Unlike other attachments, images are
+// checked to really be PNG, JPEG, GIF or WebP, their metadata is removed and
+// they are turned upright before being stored.
+func (h *Handler) UploadImage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeEditableDocument(w, r)
+		if doc == nil {
+			return
+		}
+
+		file, header := readUpload(w, r, h.config.Document.ImageMaxSize)
+		if file == nil {
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+		defer file.Close()
+
+		data, err := io.ReadAll(file)
+		if err != nil {
+			log.Printf("Error reading image: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		img, err := imaging.Process(data)
+		if err != nil {
+			switch {
+			case errors.Is(err, imaging.ErrUnsupported):
+				http.Error(w, "Only PNG, JPEG, GIF and WebP images can be uploaded", http.StatusUnsupportedMediaType)
+			case errors.Is(err, imaging.ErrTooLarge):
+				http.Error(w, fmt.Sprintf("Images cannot have more than %d million pixels", imaging.MaxPixels/1_000_000), http.StatusRequestEntityTooLarge)
+			default:
+				log.Printf("Error processing image: %v", err)
+				http.Error(w, "The image could not be read", http.StatusUnprocessableEntity)
+			}
+			return
+		}
+
+		a := &Attachment{
+			ID:          uuid.NewString(),
+			DocumentID:  doc.ID,
+			Name:        attachmentName(header.Filename),
+			ContentType: img.ContentType,
+			Size:        int64(len(img.Data)),
+			Width:       img.Width,
+			Height:      img.Height,
+		}
+		if err := h.saveAttachment(r.Context(), a, userID, bytes.NewReader(img.Data)); err != nil {
+			log.Printf("Error saving image: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeAttachment(w, a)
+	}
+}
+
+// imageVariant returns the variant v of an image attachment. Variants are
+// rendered the first time they are asked for and kept in storage next to the
+// original. Images too small for a variant, or that cannot be scaled, are
+// stored as they are under its key so that the work is not repeated.
+func (h *Handler) imageVariant(ctx context.Context, a *Attachment, v imaging.Variant) ([]byte, error) {
+	key := variantKey(a, v)
+	if data, err := h.readBlob(ctx, key); err == nil {
+		return data, nil
+	} else if !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	data, err, _ := variantGroup.Do(key, func() (any, error) {
+		// Rendering goes on for other waiting requests if this one is
+		// cancelled
+		ctx := context.WithoutCancel(ctx)
+
+		original, err := h.readBlob(ctx, a.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+		data := original
+		img, err := imaging.Resize(original, v)
+		switch {
+		case err == nil:
+			data = img.Data
+		case !errors.Is(err, imaging.ErrNoVariant):
+			log.Printf("Error rendering variant %s of attachment %s: %v", v.Name, a.ID, err)
+		}
+
+		if err := h.storage.Put(ctx, key, bytes.NewReader(data), int64(len(data)), http.DetectContentType(data)); err != nil {
+			log.Printf("Error storing variant %s of attachment %s: %v", v.Name, a.ID, err)
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// readBlob reads a whole object from storage.
+func (h *Handler) readBlob(ctx context.Context, key string) ([]byte, error) {
+	body, err := h.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
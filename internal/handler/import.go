@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/wrytehq/wryte/internal/importer"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// importTargets lists the documents of a workspace that imported documents
+// can be placed under, in tree order.
+func (h *Handler) importTargets(ctx context.Context, workspaceID string) ([]DocumentNode, error) {
+	query := `SELECT id, title, document_path FROM documents
+		WHERE workspace_id = $1 AND deleted_at IS NULL AND NOT is_archived
+		ORDER BY document_path`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []DocumentNode
+	for rows.Next() {
+		var node DocumentNode
+		var path string
+		if err := rows.Scan(&node.ID, &node.Title, &path); err != nil {
+			return nil, err
+		}
+		node.Depth = strings.Count(path, "/") - 1
+		targets = append(targets, node)
+	}
+	return targets, rows.Err()
+}
+
+// insertImportedPages creates the documents of an import below parent, or at
+// the top level of the workspace when parent is nil.
+func insertImportedPages(ctx context.Context, tx *sql.Tx, pages []*importer.Page, parent *Document, workspaceID, userID string) error {
+	query := `INSERT INTO documents (id, title, parent_id, content, user_id, document_path, workspace_id, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())`
+	for _, page := range pages {
+		documentPath := "/" + page.ID
+		var parentID sql.NullString
+		if parent != nil {
+			documentPath = parent.DocumentPath + documentPath
+			parentID = sql.NullString{String: parent.ID, Valid: true}
+		}
+		_, err := tx.ExecContext(ctx, query, page.ID, page.Title, parentID, page.Content, userID, documentPath, workspaceID)
+		if err != nil {
+			return err
+		}
+		doc := &Document{ID: page.ID, DocumentPath: documentPath}
+		if err := insertImportedPages(ctx, tx, page.Children, doc, workspaceID, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderImport renders the import form with the report of the last import,
+// or with an error message when nothing was imported.
+func (h *Handler) renderImport(w http.ResponseWriter, r *http.Request, tmpl *template.Template, workspace *Workspace, result *importer.Result, message string) {
+	targets, err := h.importTargets(r.Context(), workspace.ID)
+	if err != nil {
+		log.Printf("Error listing import targets: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Workspace": workspace,
+		"Targets":   targets,
+		"Result":    result,
+		"Message":   message,
+	}
+	if message != "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, "import_form", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// Import shows the form for importing documents into a workspace.
+func (h *Handler) Import() http.HandlerFunc {
+	tmpl := h.templates.MustRender("import")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		targets, err := h.importTargets(r.Context(), workspace.ID)
+		if err != nil {
+			log.Printf("Error listing import targets: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, workspace.ID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Workspace": workspace,
+			"Switcher":  switcher,
+			"Targets":   targets,
+			"Flash":     h.GetFlashMessage(w, r),
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// ImportDocuments imports a zip archive of Markdown files, an Obsidian vault
+// or a Notion export into a workspace. The folders of the archive become
+// documents holding the pages inside them, and the whole import is stored at
+// once so a failure leaves nothing half imported. The response lists what
+// happened to every file of the archive.
+func (h *Handler) ImportDocuments() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("import")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		maxSize := h.config.Document.ImportMaxSize
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				h.renderImport(w, r, tmpl, workspace, nil, fmt.Sprintf("The archive is larger than %d MB.", maxSize>>20))
+				return
+			}
+			log.Printf("Error parsing import upload: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		var form validator.ImportForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			http.Error(w, "Invalid import", http.StatusUnprocessableEntity)
+			return
+		}
+
+		var parent *Document
+		if form.ParentID != "" {
+			parent, err = h.findDocument(r.Context(), form.ParentID)
+			if err != nil && !errors.Is(err, errDocumentNotFound) {
+				log.Printf("Error querying parent document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if parent == nil || parent.WorkspaceID != workspace.ID {
+				h.renderImport(w, r, tmpl, workspace, nil, "The selected parent document does not exist in this workspace.")
+				return
+			}
+			if parent.IsArchived {
+				h.renderImport(w, r, tmpl, workspace, nil, "The selected parent document is archived.")
+				return
+			}
+		}
+
+		file, header, err := r.FormFile("archive")
+		if err != nil {
+			h.renderImport(w, r, tmpl, workspace, nil, "Choose a zip archive to import.")
+			return
+		}
+		defer file.Close()
+
+		zr, err := zip.NewReader(file, header.Size)
+		if err != nil {
+			h.renderImport(w, r, tmpl, workspace, nil, "The file is not a zip archive.")
+			return
+		}
+		result, err := importer.Read(zr)
+		if err != nil {
+			h.renderImport(w, r, tmpl, workspace, nil, "The archive cannot be imported: "+err.Error()+".")
+			return
+		}
+		if len(result.Pages) == 0 {
+			h.renderImport(w, r, tmpl, workspace, result, "The archive contains no Markdown or HTML files.")
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		if err := insertImportedPages(r.Context(), tx, result.Pages, parent, workspace.ID, userID); err != nil {
+			log.Printf("Error creating imported documents: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderImport(w, r, tmpl, workspace, result, "")
+	}
+}
@@ -1,27 +1,29 @@
 package handler
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/auth"
+	"github.com/wrytehq/wryte/internal/flash"
 	"github.com/wrytehq/wryte/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (h *Handler) LoginPage() http.HandlerFunc {
 	tmpl := h.templates.MustRender("auth/login")
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		data := map[string]any{
-			"Form":         &validator.LoginForm{},
-			"Errors":       &validator.ValidationErrors{},
-			"IsSelfHosted": h.config.IsSelfHosted(),
-			"Flash":        h.GetFlashMessage(w, r),
-		}
+		data := h.loginData(&validator.LoginForm{Next: localPath(r.URL.Query().Get("next"))}, &validator.ValidationErrors{})
+		data["Flash"] = h.GetFlashMessage(w, r)
 		err := tmpl.ExecuteTemplate(w, "layout.html", data)
 		if err != nil {
 			log.Printf("Error executing template: %v", err)
@@ -31,6 +33,21 @@ func (h *Handler) LoginPage() http.HandlerFunc {
 	}
 }
 
+// loginData is the data of the sign in page and form.
+func (h *Handler) loginData(form *validator.LoginForm, errs *validator.ValidationErrors) map[string]any {
+	data := map[string]any{
+		"Form":             form,
+		"Errors":           errs,
+		"IsSelfHosted":     h.config.IsSelfHosted(),
+		"SSOOnly":          h.config.Auth.SSOOnly,
+		"ManagesPasswords": h.config.Auth.ManagesPasswords(),
+	}
+	if h.config.Auth.OIDC.Enabled() {
+		data["SSOName"] = h.config.Auth.OIDC.Name
+	}
+	return data
+}
+
 func (h *Handler) LoginForm() http.HandlerFunc {
 	v := validator.New()
 	tmpl := h.templates.MustRender("auth/login")
@@ -38,8 +55,17 @@ func (h *Handler) LoginForm() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var form validator.LoginForm
 
-		// Decode and validate the form
-		validationErrs, err := v.DecodeAndValidate(r, &form)
+		// Decode and validate the form, users of a directory may sign in
+		// with a name instead of an email address
+		var validationErrs *validator.ValidationErrors
+		var err error
+		if h.config.Auth.ManagesPasswords() {
+			validationErrs, err = v.DecodeAndValidate(r, &form)
+		} else {
+			var directoryForm validator.DirectoryLoginForm
+			validationErrs, err = v.DecodeAndValidate(r, &directoryForm)
+			form = validator.LoginForm(directoryForm)
+		}
 		if err != nil {
 			log.Printf("Error decoding/validating form: %v", err)
 			http.Error(w, "Error processing form", http.StatusBadRequest)
@@ -48,11 +74,7 @@ func (h *Handler) LoginForm() http.HandlerFunc {
 
 		// If there are validation errors, render the form with errors
 		if validationErrs.HasErrors() {
-			data := map[string]any{
-				"Errors":       validationErrs,
-				"Form":         &form,
-				"IsSelfHosted": h.config.IsSelfHosted(),
-			}
+			data := h.loginData(&form, validationErrs)
 			if err := tmpl.ExecuteTemplate(w, "login_form", data); err != nil {
 				log.Printf("Error rendering template: %v", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -60,84 +82,467 @@ func (h *Handler) LoginForm() http.HandlerFunc {
 			return
 		}
 
-		// Query user by email
-		var userID, passwordHash string
-		query := `SELECT id, password_hash FROM users WHERE email = $1`
-		err = h.db.GetDB().QueryRowContext(r.Context(), query, form.Email).Scan(&userID, &passwordHash)
-
+		identity, err := h.auth.Authenticate(r.Context(), form.Email, form.Password)
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			if err != auth.ErrInvalidCredentials {
+				log.Printf("Refused sign in of %s: %v", form.Email, err)
+			}
+			// Return a generic error for security
+			validationErrs.AddError("email", "Invalid credentials")
+			data := h.loginData(&form, validationErrs)
+			tmpl.ExecuteTemplate(w, "login_form", data)
+			return
+		}
 		if err != nil {
-			if errors.Is(err, sql.ErrNoRows) {
-				// User not found - return generic error for security
-				validationErrs.AddError("email", "Invalid credentials")
-				data := map[string]any{
-					"Errors":       validationErrs,
-					"Form":         &form,
-					"IsSelfHosted": h.config.IsSelfHosted(),
+			log.Printf("Error checking credentials: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// Users of a directory get an account the first time they sign in
+		userID := identity.UserID
+		if userID == "" {
+			var created bool
+			userID, created, err = h.provisionUser(r.Context(), identity)
+			if errors.Is(err, errNoEmail) {
+				log.Printf("Refused sign in of %s: %v", form.Email, err)
+				validationErrs.AddError("email", "Your account has no email address, ask your administrator to add one")
+				data := h.loginData(&form, validationErrs)
+				tmpl.ExecuteTemplate(w, "login_form", data)
+				return
+			}
+			if errors.Is(err, errEmailTaken) {
+				log.Printf("Refused sign in of %s: %v", form.Email, err)
+				if err := h.requestIdentityLink(r, identity); err != nil {
+					log.Printf("Error requesting identity link: %v", err)
 				}
+				validationErrs.AddError("email", "An account already uses your email address. We sent it a link to connect your directory account, follow it and sign in again")
+				data := h.loginData(&form, validationErrs)
 				tmpl.ExecuteTemplate(w, "login_form", data)
 				return
 			}
+			if err != nil {
+				log.Printf("Error provisioning user: %v", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			if created && !identity.EmailVerified {
+				if _, err := h.requestEmailVerification(r, userID); err != nil {
+					log.Printf("Error requesting email verification: %v", err)
+				}
+			}
+		}
+
+		var twoFactor bool
+		query := `SELECT totp_enabled_at IS NOT NULL OR EXISTS (SELECT 1 FROM passkeys p WHERE p.user_id = users.id)
+			FROM users WHERE id = $1`
+		if err := h.db.GetDB().QueryRowContext(r.Context(), query, userID).Scan(&twoFactor); err != nil {
 			log.Printf("Error querying user: %v", err)
 			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
 
-		// Verify password
-		err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(form.Password))
+		// With two-factor authentication, the session only starts once the
+		// code or the passkey is checked
+		if twoFactor {
+			if err := h.startLoginChallenge(w, r, userID, form.Next); err != nil {
+				log.Printf("Error creating login challenge: %v", err)
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("HX-Redirect", "/login/two-factor")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if err := h.startSession(w, r, userID); err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		// Redirect to the page that asked for a sign in, or home
+		w.Header().Set("HX-Redirect", localPath(form.Next))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+var (
+	errNoEmail    = errors.New("identity has no email address")
+	errEmailTaken = errors.New("email address belongs to another account and is not verified by the provider")
+)
+
+// provisionUser returns the account of an identity from a directory,
+// creating it the first time, and adds it to the workspaces mapped to its
+// groups. created reports whether the account is new.
+func (h *Handler) provisionUser(ctx context.Context, identity *auth.Identity) (userID string, created bool, err error) {
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer tx.Rollback()
+
+	userID, created, err = findExternalUser(ctx, tx, identity)
+	if err != nil {
+		return "", false, err
+	}
+	if err := joinMappedWorkspaces(ctx, tx, userID, identity); err != nil {
+		return "", false, err
+	}
+	return userID, created, tx.Commit()
+}
+
+// externalUsername is the name an account created for an identity of a
+// directory or an identity provider starts with.
+func externalUsername(identity *auth.Identity) string {
+	name := strings.TrimSpace(identity.Name)
+	if len([]rune(name)) < 2 {
+		name, _, _ = strings.Cut(identity.Email, "@")
+	}
+	// Leave room for the suffix telling apart users of the same name
+	if runes := []rune(name); len(runes) > 90 {
+		name = string(runes[:90])
+	}
+	return name
+}
+
+// findExternalUser returns the account an identity of a directory or an
+// identity provider signs in to. Accounts are found by the identity first,
+// then by email address for someone who signs in that way for the first
+// time, and created otherwise. The address is only trusted to link an
+// existing account when the provider verified it, others are linked once
+// the owner of the account confirms it with requestIdentityLink. created
+// reports whether the account is new.
+func findExternalUser(ctx context.Context, tx *sql.Tx, identity *auth.Identity) (userID string, created bool, err error) {
+	query := `UPDATE user_identities SET last_login_at = NOW()
+	          WHERE issuer = $1 AND subject = $2
+	          RETURNING user_id`
+	err = tx.QueryRowContext(ctx, query, identity.Issuer, identity.Subject).Scan(&userID)
+	if err == nil {
+		return userID, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", false, err
+	}
+	if identity.Email == "" {
+		return "", false, errNoEmail
+	}
+
+	query = `SELECT id FROM users WHERE lower(email) = lower($1)`
+	err = tx.QueryRowContext(ctx, query, identity.Email).Scan(&userID)
+	switch {
+	case err == nil:
+		if !identity.EmailVerified {
+			return "", false, errEmailTaken
+		}
+		query = `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()) WHERE id = $1`
+		if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+			return "", false, err
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		// These accounts have no password, until one is set by resetting it
+		// when Wryte manages passwords
+		name := externalUsername(identity)
+		for i := 1; userID == ""; i++ {
+			username := name
+			if i > 1 {
+				username = name + " " + strconv.Itoa(i)
+			}
+			query = `INSERT INTO users (username, email, password_hash, email_verified_at, created_at, updated_at)
+			         VALUES ($1, $2, '', CASE WHEN $3 THEN NOW() END, NOW(), NOW())
+			         ON CONFLICT (username) DO NOTHING
+			         RETURNING id`
+			err = tx.QueryRowContext(ctx, query, username, identity.Email, identity.EmailVerified).Scan(&userID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return "", false, err
+			}
+		}
+		created = true
+	default:
+		return "", false, err
+	}
+
+	query = `INSERT INTO user_identities (user_id, issuer, subject, last_login_at, created_at)
+	         VALUES ($1, $2, $3, NOW(), NOW())`
+	if _, err := tx.ExecContext(ctx, query, userID, identity.Issuer, identity.Subject); err != nil {
+		return "", false, err
+	}
+	return userID, created, nil
+}
+
+// startSession signs a user in: it creates a session and sets its cookie.
+func (h *Handler) startSession(w http.ResponseWriter, r *http.Request, userID string) error {
+	token := uuid.NewString()
+	expiresAt := time.Now().Add(time.Hour * 24 * 7) // 7 days
+
+	query := `INSERT INTO sessions (user_id, token, expires_at, created_at)
+	          VALUES ($1, $2, $3, NOW())`
+	if _, err := h.db.GetDB().ExecContext(r.Context(), query, userID, token, expiresAt); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "wryte_session",
+		Value:    token,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+	return nil
+}
+
+// PasskeyLoginOptions starts signing in with a passkey instead of a
+// password, and answers with the options the browser looks for one with. The
+// browser offers the passkeys it has for Wryte, and the one picked tells who
+// is signing in. The authenticator must verify the user, with a PIN or
+// biometrics, for the passkey to stand for both factors.
+func (h *Handler) PasskeyLoginOptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		options, session, err := h.passkeys.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			log.Printf("Error starting passkey login: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.startPasskeyCeremony(w, r, passkeyLogin, "", "", session); err != nil {
+			log.Printf("Error saving passkey ceremony: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, options)
+	}
+}
+
+// PasskeyLogin checks the passkey the browser signed in with and starts the
+// session, then sends the user to the page in the next parameter.
+func (h *Handler) PasskeyLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ceremony, err := h.finishPasskeyCeremony(w, r, passkeyLogin)
 		if err != nil {
-			// Password doesn't match - return generic error for security
-			validationErrs.AddError("email", "Invalid credentials")
-			data := map[string]any{
-				"Errors":       validationErrs,
-				"Form":         &form,
-				"IsSelfHosted": h.config.IsSelfHosted(),
+			log.Printf("Error querying passkey ceremony: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if ceremony == nil {
+			http.Error(w, "Signing in took too long, try again.", http.StatusBadRequest)
+			return
+		}
+		response, err := protocol.ParseCredentialRequestResponseBody(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "The answer of the browser could not be read.", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// The user handle kept by the authenticator is the ID of the user
+		findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+			id, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, err
 			}
-			tmpl.ExecuteTemplate(w, "login_form", data)
+			user, err := findPasskeyUser(r.Context(), tx, id.String())
+			if err != nil {
+				if !errors.Is(err, sql.ErrNoRows) {
+					log.Printf("Error querying passkeys: %v", err)
+				}
+				return nil, err
+			}
+			return user, nil
+		}
+		user, credential, err := h.passkeys.ValidatePasskeyLogin(findUser, ceremony.Session, response)
+		if err != nil {
+			http.Error(w, "This passkey is not registered, or could not be verified.", http.StatusUnauthorized)
+			return
+		}
+		userID := user.(*passkeyUser).id.String()
+		err = usePasskey(r.Context(), tx, credential)
+		if errors.Is(err, errPasskeyCloned) {
+			log.Printf("Refused passkey of user %s: %v", userID, err)
+			http.Error(w, "This passkey was refused. Sign in with your password and check your passkeys.", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating passkey: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.startSession(w, r, userID); err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"redirect": localPath(r.URL.Query().Get("next"))})
+	}
+}
+
+// restartPasskeyLogin is restartLogin for the requests of the passkey
+// script, which follows the redirect it answers with.
+func restartPasskeyLogin(w http.ResponseWriter, message string) {
+	endLoginChallenge(w)
+	flash.SetError(w, message)
+	writeJSON(w, http.StatusUnauthorized, map[string]string{"redirect": "/login"})
+}
+
+// TwoFactorPasskeyOptions answers with the options the browser uses one of
+// the passkeys of the user with, as the second step of a sign in after the
+// password.
+func (h *Handler) TwoFactorPasskeyOptions() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(loginChallengeCookie)
+		if err != nil {
+			restartPasskeyLogin(w, "Your sign in expired. Enter your password again.")
 			return
 		}
 
-		// Start transaction for session creation
 		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
 		if err != nil {
 			log.Printf("Error starting transaction: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 		defer tx.Rollback()
 
-		// Create session
-		token := uuid.NewString()
-		expiresAt := time.Now().Add(time.Hour * 24 * 7) // 7 days
+		var userID string
+		query := `SELECT user_id FROM login_challenges WHERE token_hash = $1 AND expires_at > NOW()`
+		err = tx.QueryRowContext(r.Context(), query, hashToken(cookie.Value)).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			restartPasskeyLogin(w, "Your sign in expired. Enter your password again.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying login challenge: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		user, err := findPasskeyUser(r.Context(), tx, userID)
+		if err != nil {
+			log.Printf("Error querying passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		// Saving the ceremony refers to the user, and would wait for the lock
+		tx.Rollback()
+
+		if len(user.credentials) == 0 {
+			http.Error(w, "You have no passkey, enter a code instead.", http.StatusBadRequest)
+			return
+		}
 
-		sessionQuery := `INSERT INTO sessions (user_id, token, expires_at, created_at)
-		                 VALUES ($1, $2, $3, NOW())`
-		_, err = tx.ExecContext(r.Context(), sessionQuery, userID, token, expiresAt)
+		options, session, err := h.passkeys.BeginLogin(user)
 		if err != nil {
-			log.Printf("Error creating session: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			log.Printf("Error starting passkey login: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
+		if err := h.startPasskeyCeremony(w, r, passkeyTwoFactor, userID, "", session); err != nil {
+			log.Printf("Error saving passkey ceremony: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, options)
+	}
+}
 
-		// Commit transaction
+// TwoFactorPasskey completes a sign in with a passkey of the user, and starts
+// the session.
+func (h *Handler) TwoFactorPasskey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(loginChallengeCookie)
+		if err != nil {
+			restartPasskeyLogin(w, "Your sign in expired. Enter your password again.")
+			return
+		}
+		ceremony, err := h.finishPasskeyCeremony(w, r, passkeyTwoFactor)
+		if err != nil {
+			log.Printf("Error querying passkey ceremony: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if ceremony == nil {
+			http.Error(w, "Using the passkey took too long, try again.", http.StatusBadRequest)
+			return
+		}
+		response, err := protocol.ParseCredentialRequestResponseBody(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "The answer of the browser could not be read.", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var challengeID, userID, next string
+		query := `SELECT id, user_id, next FROM login_challenges WHERE token_hash = $1 AND expires_at > NOW() FOR UPDATE`
+		err = tx.QueryRowContext(r.Context(), query, hashToken(cookie.Value)).Scan(&challengeID, &userID, &next)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && userID != ceremony.UserID) {
+			restartPasskeyLogin(w, "Your sign in expired. Enter your password again.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying login challenge: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		user, err := findPasskeyUser(r.Context(), tx, userID)
+		if err != nil {
+			log.Printf("Error querying passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		credential, err := h.passkeys.ValidateLogin(user, ceremony.Session, response)
+		if err != nil {
+			http.Error(w, "This passkey could not be verified.", http.StatusUnauthorized)
+			return
+		}
+		err = usePasskey(r.Context(), tx, credential)
+		if errors.Is(err, errPasskeyCloned) {
+			log.Printf("Refused passkey of user %s: %v", userID, err)
+			http.Error(w, "This passkey was refused. Use another one, or a code.", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating passkey: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM login_challenges WHERE id = $1`, challengeID); err != nil {
+			log.Printf("Error deleting login challenge: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
 		if err := tx.Commit(); err != nil {
 			log.Printf("Error committing transaction: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			return
 		}
 
-		// Set session cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:     "wryte_session",
-			Value:    token,
-			Expires:  expiresAt,
-			HttpOnly: true,
-			Secure:   true,
-			SameSite: http.SameSiteStrictMode,
-			Path:     "/",
-		})
-
-		// Redirect to home
-		w.Header().Set("HX-Redirect", "/")
-		w.WriteHeader(http.StatusOK)
+		if err := h.startSession(w, r, userID); err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		endLoginChallenge(w)
+		writeJSON(w, http.StatusOK, map[string]string{"redirect": localPath(next)})
 	}
 }
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/wrytehq/wryte/internal/mailer"
+)
+
+// DevMailbox lists the emails kept by the outbox mailer, so that links sent by
+// email can be followed during development. It is only routed in development,
+// when emails are not sent.
+func (h *Handler) DevMailbox() http.HandlerFunc {
+	tmpl := h.templates.MustRender("dev_mailbox")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		outbox, ok := h.mailer.(*mailer.Outbox)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		messages, err := outbox.List()
+		if err != nil {
+			log.Printf("Error listing outbox: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Messages": messages,
+		}
+		if name := r.URL.Query().Get("message"); name != "" {
+			msg, _, err := outbox.Read(name)
+			if errors.Is(err, mailer.ErrNotFound) {
+				http.Error(w, "Message not found", http.StatusNotFound)
+				return
+			}
+			if err != nil {
+				log.Printf("Error reading message %s: %v", name, err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data["Message"] = msg
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// DevMailboxMessage serves a part of an email of the outbox: its HTML body,
+// shown in a frame of the mailbox page, or with ?raw=1 the message as it would
+// have been sent.
+func (h *Handler) DevMailboxMessage() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		outbox, ok := h.mailer.(*mailer.Outbox)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		msg, raw, err := outbox.Read(r.PathValue("name"))
+		if errors.Is(err, mailer.ErrNotFound) {
+			http.Error(w, "Message not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error reading message: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if r.URL.Query().Get("raw") == "1" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write(raw)
+			return
+		}
+
+		// The body is shown the way a mail client would: inline styles and
+		// remote images only, no scripts
+		w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; img-src * data:; sandbox allow-popups allow-popups-to-escape-sandbox")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(msg.HTML))
+	}
+}
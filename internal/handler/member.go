@@ -0,0 +1,632 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// invitationTTL is how long an invitation can be accepted.
+const invitationTTL = 7 * 24 * time.Hour
+
+// Member is a user belonging to a workspace.
+type Member struct {
+	ID       string
+	UserID   string
+	Username string
+	Email    string
+	Role     WorkspaceRole
+	// TwoFactor is whether the member uses two-factor authentication, with
+	// an authenticator app or a passkey.
+	TwoFactor bool
+}
+
+// Invitation asks the owner of an email address to join a workspace. Only the
+// hash of its token is stored; the token itself is part of the accept link.
+type Invitation struct {
+	ID            string
+	WorkspaceID   string
+	WorkspaceName string
+	Email         string
+	Role          WorkspaceRole
+	InvitedBy     string
+	ExpiresAt     time.Time
+	AcceptedAt    sql.NullTime
+}
+
+func (i *Invitation) Expired() bool {
+	return !time.Now().Before(i.ExpiresAt)
+}
+
+var (
+	errMemberNotFound     = errors.New("member not found")
+	errLastOwner          = errors.New("workspace needs at least one owner")
+	errInvitationNotFound = errors.New("invitation not found")
+)
+
+// hashToken returns the digest under which a secret token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// listMembers returns the members of a workspace, owners first.
+func (h *Handler) listMembers(ctx context.Context, workspaceID string) ([]Member, error) {
+	query := `SELECT m.id, m.user_id, u.username, u.email, m.role,
+		u.totp_enabled_at IS NOT NULL OR EXISTS (SELECT 1 FROM passkeys p WHERE p.user_id = u.id)
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = $1
+		ORDER BY CASE m.role WHEN 'owner' THEN 0 WHEN 'admin' THEN 1 ELSE 2 END, u.username`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var members []Member
+	for rows.Next() {
+		var m Member
+		var role string
+		if err := rows.Scan(&m.ID, &m.UserID, &m.Username, &m.Email, &role, &m.TwoFactor); err != nil {
+			return nil, err
+		}
+		m.Role, _ = parseWorkspaceRole(role)
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// findMember loads a member of a workspace by membership ID.
+func (h *Handler) findMember(ctx context.Context, workspaceID, memberID string) (*Member, error) {
+	if _, err := uuid.Parse(memberID); err != nil {
+		return nil, errMemberNotFound
+	}
+
+	var m Member
+	var role string
+	query := `SELECT m.id, m.user_id, u.username, u.email, m.role,
+		u.totp_enabled_at IS NOT NULL OR EXISTS (SELECT 1 FROM passkeys p WHERE p.user_id = u.id)
+		FROM workspace_members m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.id = $1 AND m.workspace_id = $2`
+	err := h.db.GetDB().QueryRowContext(ctx, query, memberID, workspaceID).
+		Scan(&m.ID, &m.UserID, &m.Username, &m.Email, &role, &m.TwoFactor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errMemberNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	m.Role, _ = parseWorkspaceRole(role)
+	return &m, nil
+}
+
+// listInvitations returns the invitations of a workspace that were not
+// accepted yet, including expired ones so they can be renewed or revoked.
+func (h *Handler) listInvitations(ctx context.Context, workspaceID string) ([]Invitation, error) {
+	query := `SELECT i.id, i.workspace_id, w.name, i.email, i.role, u.username, i.expires_at, i.accepted_at
+		FROM workspace_invitations i
+		JOIN workspaces w ON w.id = i.workspace_id
+		JOIN users u ON u.id = i.invited_by
+		WHERE i.workspace_id = $1 AND i.accepted_at IS NULL
+		ORDER BY i.created_at`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []Invitation
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
+
+func scanInvitation(row rowScanner) (*Invitation, error) {
+	var inv Invitation
+	var role string
+	err := row.Scan(&inv.ID, &inv.WorkspaceID, &inv.WorkspaceName, &inv.Email, &role, &inv.InvitedBy,
+		&inv.ExpiresAt, &inv.AcceptedAt)
+	if err != nil {
+		return nil, err
+	}
+	inv.Role, _ = parseWorkspaceRole(role)
+	return &inv, nil
+}
+
+// findInvitation loads an invitation by the token of its accept link.
+func (h *Handler) findInvitation(ctx context.Context, token string) (*Invitation, error) {
+	query := `SELECT i.id, i.workspace_id, w.name, i.email, i.role, u.username, i.expires_at, i.accepted_at
+		FROM workspace_invitations i
+		JOIN workspaces w ON w.id = i.workspace_id
+		JOIN users u ON u.id = i.invited_by
+		WHERE i.token_hash = $1`
+	inv, err := scanInvitation(h.db.GetDB().QueryRowContext(ctx, query, hashToken(token)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errInvitationNotFound
+	}
+	return inv, err
+}
+
+// changeMember gives a member another role, or removes them from the
+// workspace when role is WorkspaceNone. The last owner can neither leave nor
+// step down.
+func (h *Handler) changeMember(ctx context.Context, workspaceID string, member *Member, role WorkspaceRole) error {
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialise membership changes of the workspace so that two owners
+	// cannot step down at the same time
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM workspaces WHERE id = $1 FOR UPDATE`, workspaceID); err != nil {
+		return err
+	}
+
+	if member.Role.IsOwner() && !role.IsOwner() {
+		var owners int
+		query := `SELECT COUNT(*) FROM workspace_members WHERE workspace_id = $1 AND role = $2`
+		if err := tx.QueryRowContext(ctx, query, workspaceID, WorkspaceOwner.String()).Scan(&owners); err != nil {
+			return err
+		}
+		if owners <= 1 {
+			return errLastOwner
+		}
+	}
+
+	if role == WorkspaceNone {
+		_, err = tx.ExecContext(ctx, `DELETE FROM workspace_members WHERE id = $1`, member.ID)
+	} else {
+		query := `UPDATE workspace_members SET role = $1, updated_at = NOW() WHERE id = $2`
+		_, err = tx.ExecContext(ctx, query, role.String(), member.ID)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// mayChangeMember reports whether actor, holding role actorRole, may give
+// member the role role. Everyone may leave; otherwise admins manage members
+// and only owners may appoint or demote owners.
+func mayChangeMember(actorID string, actorRole WorkspaceRole, member *Member, role WorkspaceRole) bool {
+	if role == WorkspaceNone && member.UserID == actorID {
+		return true
+	}
+	if !actorRole.CanManage() {
+		return false
+	}
+	if member.Role.IsOwner() || role.IsOwner() {
+		return actorRole.IsOwner()
+	}
+	return true
+}
+
+// membersData is the data of the members panel of a workspace.
+func (h *Handler) membersData(ctx context.Context, workspace *Workspace, userID string) (map[string]any, error) {
+	members, err := h.listMembers(ctx, workspace.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := map[string]any{
+		"Workspace": workspace,
+		"Members":   members,
+		"UserID":    userID,
+		"Form":      &validator.InviteMemberForm{Role: WorkspaceMember.String()},
+	}
+	if workspace.Role.CanManage() {
+		invitations, err := h.listInvitations(ctx, workspace.ID)
+		if err != nil {
+			return nil, err
+		}
+		data["Invitations"] = invitations
+	}
+	return data, nil
+}
+
+// renderMembers renders the members panel of a workspace. inviteURL is the
+// accept link of an invitation that was just created, shown once so it can be
+// passed on.
+func (h *Handler) renderMembers(w http.ResponseWriter, r *http.Request, tmpl *template.Template, workspace *Workspace, userID string, errs *validator.ValidationErrors, inviteURL string) {
+	data, err := h.membersData(r.Context(), workspace, userID)
+	if err != nil {
+		log.Printf("Error listing workspace members: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data["Errors"] = errs
+	data["InviteURL"] = inviteURL
+
+	if errs != nil && errs.HasErrors() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, "workspace_members", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// InviteMember invites an email address to join a workspace with a role,
+// replacing any invitation still pending for that address.
+func (h *Handler) InviteMember() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		var form validator.InviteMemberForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			h.renderMembers(w, r, tmpl, workspace, userID, validationErrs, "")
+			return
+		}
+
+		role, _ := parseWorkspaceRole(form.Role)
+		if role.IsOwner() && !workspace.Role.IsOwner() {
+			validationErrs.AddError("role", "Only owners can invite owners")
+			h.renderMembers(w, r, tmpl, workspace, userID, validationErrs, "")
+			return
+		}
+
+		email := strings.TrimSpace(form.Email)
+		var member bool
+		query := `SELECT EXISTS (SELECT 1 FROM workspace_members m JOIN users u ON u.id = m.user_id
+		          WHERE m.workspace_id = $1 AND lower(u.email) = lower($2))`
+		if err := h.db.GetDB().QueryRowContext(r.Context(), query, workspace.ID, email).Scan(&member); err != nil {
+			log.Printf("Error querying workspace members: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if member {
+			validationErrs.AddError("email", "This person is already a member")
+			h.renderMembers(w, r, tmpl, workspace, userID, validationErrs, "")
+			return
+		}
+
+		var inviter string
+		query = `SELECT username FROM users WHERE id = $1`
+		if err := h.db.GetDB().QueryRowContext(r.Context(), query, userID).Scan(&inviter); err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		token := rand.Text()
+		expiresAt := time.Now().Add(invitationTTL)
+		query = `INSERT INTO workspace_invitations (workspace_id, email, role, token_hash, invited_by, expires_at, created_at, updated_at)
+		         VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+		         ON CONFLICT (workspace_id, lower(email)) WHERE accepted_at IS NULL
+		         DO UPDATE SET email = EXCLUDED.email, role = EXCLUDED.role, token_hash = EXCLUDED.token_hash,
+		             invited_by = EXCLUDED.invited_by, expires_at = EXCLUDED.expires_at, updated_at = NOW()`
+		_, err = h.db.GetDB().ExecContext(r.Context(), query, workspace.ID, email, role.String(),
+			hashToken(token), userID, expiresAt)
+		if err != nil {
+			log.Printf("Error creating invitation: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		link := h.config.URL("/invitations/" + token)
+		h.sendEmail(email, "invitation", map[string]any{
+			"InvitedBy":     inviter,
+			"WorkspaceName": workspace.Name,
+			"Role":          role.String(),
+			"ExpiresAt":     expiresAt,
+			"Link":          link,
+		})
+
+		h.renderMembers(w, r, tmpl, workspace, userID, nil, link)
+	}
+}
+
+// RevokeInvitation withdraws a pending invitation.
+func (h *Handler) RevokeInvitation() http.HandlerFunc {
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		invitationID := r.PathValue("invitationId")
+		if _, err := uuid.Parse(invitationID); err != nil {
+			http.Error(w, "Invitation not found", http.StatusNotFound)
+			return
+		}
+
+		query := `DELETE FROM workspace_invitations WHERE id = $1 AND workspace_id = $2 AND accepted_at IS NULL`
+		if _, err := h.db.GetDB().ExecContext(r.Context(), query, invitationID, workspace.ID); err != nil {
+			log.Printf("Error revoking invitation: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderMembers(w, r, tmpl, workspace, userID, nil, "")
+	}
+}
+
+// UpdateMember changes the role of a member.
+func (h *Handler) UpdateMember() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		member, err := h.findMember(r.Context(), workspace.ID, r.PathValue("memberId"))
+		if err != nil {
+			if errors.Is(err, errMemberNotFound) {
+				http.Error(w, "Member not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error querying member: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var form validator.UpdateMemberForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			h.renderMembers(w, r, tmpl, workspace, userID, validationErrs, "")
+			return
+		}
+
+		role, _ := parseWorkspaceRole(form.Role)
+		if !mayChangeMember(userID, workspace.Role, member, role) {
+			http.Error(w, "Forbidden - Only owners can appoint or demote owners", http.StatusForbidden)
+			return
+		}
+
+		if err := h.changeMember(r.Context(), workspace.ID, member, role); err != nil {
+			if errors.Is(err, errLastOwner) {
+				validationErrs.AddError("members", "The workspace needs at least one owner")
+				h.renderMembers(w, r, tmpl, workspace, userID, validationErrs, "")
+				return
+			}
+			log.Printf("Error updating member: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Stepping down may take away the right to manage the workspace
+		if member.UserID == userID {
+			redirect(w, r, "/workspaces/"+workspace.ID)
+			return
+		}
+		h.renderMembers(w, r, tmpl, workspace, userID, nil, "")
+	}
+}
+
+// RemoveMember removes a member from a workspace. Members may remove
+// themselves to leave it.
+func (h *Handler) RemoveMember() http.HandlerFunc {
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		member, err := h.findMember(r.Context(), workspace.ID, r.PathValue("memberId"))
+		if err != nil {
+			if errors.Is(err, errMemberNotFound) {
+				http.Error(w, "Member not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error querying member: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if !mayChangeMember(userID, workspace.Role, member, WorkspaceNone) {
+			http.Error(w, "Forbidden - You don't have permission to remove this member", http.StatusForbidden)
+			return
+		}
+
+		if err := h.changeMember(r.Context(), workspace.ID, member, WorkspaceNone); err != nil {
+			if errors.Is(err, errLastOwner) {
+				validationErrs := &validator.ValidationErrors{}
+				validationErrs.AddError("members", "The workspace needs at least one owner")
+				h.renderMembers(w, r, tmpl, workspace, userID, validationErrs, "")
+				return
+			}
+			log.Printf("Error removing member: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if member.UserID == userID {
+			flash.SetSuccess(w, "You left "+workspace.Name+".")
+			redirect(w, r, "/")
+			return
+		}
+		h.renderMembers(w, r, tmpl, workspace, userID, nil, "")
+	}
+}
+
+// InvitationPage shows an invitation to the signed in user and offers to
+// accept it.
+func (h *Handler) InvitationPage() http.HandlerFunc {
+	tmpl := h.templates.MustRender("invitation")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, status, err := h.invitationData(r, userID)
+		if err != nil {
+			log.Printf("Error querying invitation: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, "")
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data["Switcher"] = switcher
+
+		w.WriteHeader(status)
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// invitationData loads the invitation named in the request path and works out
+// whether userID may accept it, returning the page data and status.
+func (h *Handler) invitationData(r *http.Request, userID string) (map[string]any, int, error) {
+	token := r.PathValue("token")
+	data := map[string]any{"Token": token}
+
+	inv, err := h.findInvitation(r.Context(), token)
+	if errors.Is(err, errInvitationNotFound) {
+		data["Missing"] = true
+		return data, http.StatusNotFound, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	data["Invitation"] = inv
+
+	if inv.AcceptedAt.Valid || inv.Expired() {
+		data["Expired"] = true
+		return data, http.StatusGone, nil
+	}
+
+	var email string
+	if err := h.db.GetDB().QueryRowContext(r.Context(), `SELECT email FROM users WHERE id = $1`, userID).Scan(&email); err != nil {
+		return nil, 0, err
+	}
+	if !strings.EqualFold(email, inv.Email) {
+		data["WrongEmail"] = true
+		data["Email"] = email
+		return data, http.StatusForbidden, nil
+	}
+
+	return data, http.StatusOK, nil
+}
+
+// AcceptInvitation adds the signed in user to the workspace of an invitation
+// sent to their email address.
+func (h *Handler) AcceptInvitation() http.HandlerFunc {
+	tmpl := h.templates.MustRender("invitation")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		data, status, err := h.invitationData(r, userID)
+		if err != nil {
+			log.Printf("Error querying invitation: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			if err := tmpl.ExecuteTemplate(w, "invitation_card", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+			return
+		}
+		inv := data["Invitation"].(*Invitation)
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Claim the invitation first so that it is used only once
+		query := `UPDATE workspace_invitations SET accepted_at = NOW(), updated_at = NOW()
+		          WHERE id = $1 AND accepted_at IS NULL`
+		res, err := tx.ExecContext(r.Context(), query, inv.ID)
+		if err != nil {
+			log.Printf("Error accepting invitation: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err != nil {
+				log.Printf("Error accepting invitation: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			// Accepted from another tab in the meantime
+			data["Expired"] = true
+			w.WriteHeader(http.StatusGone)
+			if err := tmpl.ExecuteTemplate(w, "invitation_card", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+			return
+		}
+
+		// Members keep their role when invited again
+		query = `INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)
+		         VALUES ($1, $2, $3, NOW(), NOW())
+		         ON CONFLICT (workspace_id, user_id) DO NOTHING`
+		if _, err := tx.ExecContext(r.Context(), query, inv.WorkspaceID, userID, inv.Role.String()); err != nil {
+			log.Printf("Error adding member: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "You joined "+inv.WorkspaceName+".")
+		redirect(w, r, "/?workspace="+inv.WorkspaceID)
+	}
+}
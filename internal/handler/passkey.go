@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+const (
+	// passkeyCookie holds the token of a passkey ceremony waiting for the
+	// answer of the browser.
+	passkeyCookie = "wryte_passkey"
+	// passkeyCeremonyTTL is how long the browser has to answer.
+	passkeyCeremonyTTL = 5 * time.Minute
+)
+
+// Purposes of passkey ceremonies, so that the answer to one cannot be used
+// for another.
+const (
+	passkeyRegistration = "registration"
+	passkeyLogin        = "login"
+	passkeyTwoFactor    = "two-factor"
+)
+
+// Passkey is a passkey registered for an account.
+type Passkey struct {
+	ID   string
+	Name string
+	// Synced is whether the passkey is backed up, such as by a password
+	// manager, and can be used from other devices.
+	Synced     bool
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+// passkeyUser is a user as seen by the WebAuthn library. Their ID is the
+// user handle that authenticators keep with discoverable passkeys, which
+// identifies them when signing in without an email address.
+type passkeyUser struct {
+	id          uuid.UUID
+	name        string
+	displayName string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return u.id[:] }
+func (u *passkeyUser) WebAuthnName() string                       { return u.name }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.displayName }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// findPasskeyUser loads a user and the credentials of their passkeys. The
+// user is locked until tx ends, so that concurrent sign ins compare the
+// signature counters one after the other.
+func findPasskeyUser(ctx context.Context, tx *sql.Tx, userID string) (*passkeyUser, error) {
+	id, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	u := passkeyUser{id: id}
+	query := `SELECT email, username FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&u.name, &u.displayName); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT credential_id, public_key, attestation_type, transports, aaguid, sign_count,
+		user_verified, backup_eligible, backup_state
+		FROM passkeys WHERE user_id = $1 ORDER BY created_at`
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c webauthn.Credential
+		var transports string
+		var signCount int64
+		err := rows.Scan(&c.ID, &c.PublicKey, &c.AttestationType, &transports, &c.Authenticator.AAGUID, &signCount,
+			&c.Flags.UserVerified, &c.Flags.BackupEligible, &c.Flags.BackupState)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range strings.Split(transports, ",") {
+			if t != "" {
+				c.Transport = append(c.Transport, protocol.AuthenticatorTransport(t))
+			}
+		}
+		c.Flags.UserPresent = true
+		c.Authenticator.SignCount = uint32(signCount)
+		u.credentials = append(u.credentials, c)
+	}
+	return &u, rows.Err()
+}
+
+// usePasskey records a successful assertion with a credential checked by the
+// WebAuthn library. The library flags a signature counter that did not
+// increase, which means the private key was copied to another authenticator,
+// or the answer replayed: the passkey is then refused.
+func usePasskey(ctx context.Context, tx *sql.Tx, credential *webauthn.Credential) error {
+	if credential.Authenticator.CloneWarning {
+		return errPasskeyCloned
+	}
+	query := `UPDATE passkeys SET sign_count = $1, user_verified = $2, backup_state = $3, last_used_at = NOW()
+	          WHERE credential_id = $4`
+	_, err := tx.ExecContext(ctx, query, int64(credential.Authenticator.SignCount), credential.Flags.UserVerified,
+		credential.Flags.BackupState, credential.ID)
+	return err
+}
+
+// errPasskeyCloned is returned for an assertion whose signature counter went
+// backwards.
+var errPasskeyCloned = errors.New("passkey signature counter did not increase")
+
+// startPasskeyCeremony keeps the state of a passkey ceremony until the
+// browser answers, under a cookie. userID is empty when signing in with a
+// passkey the browser picks, and name is that of a passkey being registered.
+func (h *Handler) startPasskeyCeremony(w http.ResponseWriter, r *http.Request, purpose, userID, name string, session *webauthn.SessionData) error {
+	if _, err := h.db.GetDB().ExecContext(r.Context(), `DELETE FROM webauthn_sessions WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	token := rand.Text()
+	expiresAt := time.Now().Add(passkeyCeremonyTTL)
+	query := `INSERT INTO webauthn_sessions (token_hash, user_id, purpose, name, data, expires_at, created_at)
+	          VALUES ($1, NULLIF($2, '')::uuid, $3, $4, $5, $6, NOW())`
+	if _, err := h.db.GetDB().ExecContext(r.Context(), query, hashToken(token), userID, purpose, name, data, expiresAt); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyCookie,
+		Value:    token,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+	return nil
+}
+
+// passkeyCeremony is the state of a passkey ceremony kept while the browser
+// answers.
+type passkeyCeremony struct {
+	UserID  string
+	Name    string
+	Session webauthn.SessionData
+}
+
+// finishPasskeyCeremony uses up the passkey ceremony of a request, which
+// must be for purpose. It returns nil when there is none, or it expired.
+func (h *Handler) finishPasskeyCeremony(w http.ResponseWriter, r *http.Request, purpose string) (*passkeyCeremony, error) {
+	cookie, err := r.Cookie(passkeyCookie)
+	if err != nil {
+		return nil, nil
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     passkeyCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/",
+	})
+
+	var c passkeyCeremony
+	var data []byte
+	query := `DELETE FROM webauthn_sessions WHERE token_hash = $1 AND purpose = $2 AND expires_at > NOW()
+	          RETURNING COALESCE(user_id::text, ''), name, data`
+	err = h.db.GetDB().QueryRowContext(r.Context(), query, hashToken(cookie.Value), purpose).Scan(&c.UserID, &c.Name, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.Session); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// writeJSON answers a request made by the passkey script with v.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+// listPasskeys returns the passkeys of a user, oldest first.
+func (h *Handler) listPasskeys(ctx context.Context, userID string) ([]Passkey, error) {
+	query := `SELECT id, name, backup_state, created_at, last_used_at
+		FROM passkeys WHERE user_id = $1 ORDER BY created_at`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var passkeys []Passkey
+	for rows.Next() {
+		var p Passkey
+		if err := rows.Scan(&p.ID, &p.Name, &p.Synced, &p.CreatedAt, &p.LastUsedAt); err != nil {
+			return nil, err
+		}
+		passkeys = append(passkeys, p)
+	}
+	return passkeys, rows.Err()
+}
+
+// PasskeySettings lists the passkeys of the signed in user, and lets them
+// add more.
+func (h *Handler) PasskeySettings() http.HandlerFunc {
+	tmpl := h.templates.MustRender("passkeys")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		account, err := h.findAccount(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying account: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		passkeys, err := h.listPasskeys(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Account":  account,
+			"Passkeys": passkeys,
+			"Flash":    h.GetFlashMessage(w, r),
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// PasskeyRegistrationOptions starts registering a passkey for the signed in
+// user, after checking their password, and answers with the options the
+// browser creates it with. Passkeys the user already has are excluded, so
+// that an authenticator is not registered twice.
+func (h *Handler) PasskeyRegistrationOptions() http.HandlerFunc {
+	v := validator.New()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.PasskeyForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if !validationErrs.HasErrors() {
+			ok, err := h.checkPassword(r.Context(), userID, form.CurrentPassword)
+			if err != nil {
+				log.Printf("Error checking password: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				validationErrs.AddError("currentpassword", "Password is incorrect")
+			}
+		}
+		if validationErrs.HasErrors() {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"errors": validationErrs.All()})
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		user, err := findPasskeyUser(r.Context(), tx, userID)
+		if err != nil {
+			log.Printf("Error querying passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Saving the ceremony refers to the user, and would wait for the lock
+		tx.Rollback()
+
+		// Discoverable passkeys sign in without a password, security keys
+		// without room for them still serve as a second factor
+		options, session, err := h.passkeys.BeginRegistration(user,
+			webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+			webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		)
+		if err != nil {
+			log.Printf("Error starting passkey registration: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := h.startPasskeyCeremony(w, r, passkeyRegistration, userID, strings.TrimSpace(form.Name), session); err != nil {
+			log.Printf("Error saving passkey ceremony: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, options)
+	}
+}
+
+// RegisterPasskey checks the passkey the browser created for the signed in
+// user and saves it.
+func (h *Handler) RegisterPasskey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		ceremony, err := h.finishPasskeyCeremony(w, r, passkeyRegistration)
+		if err != nil {
+			log.Printf("Error querying passkey ceremony: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if ceremony == nil || ceremony.UserID != userID {
+			http.Error(w, "Adding the passkey took too long, try again.", http.StatusBadRequest)
+			return
+		}
+		response, err := protocol.ParseCredentialCreationResponseBody(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, "The answer of the browser could not be read.", http.StatusBadRequest)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+		user, err := findPasskeyUser(r.Context(), tx, userID)
+		if err != nil {
+			log.Printf("Error querying passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		credential, err := h.passkeys.CreateCredential(user, ceremony.Session, response)
+		if err != nil {
+			log.Printf("Error verifying passkey registration: %v", err)
+			http.Error(w, "The passkey could not be verified.", http.StatusBadRequest)
+			return
+		}
+		transports := make([]string, len(credential.Transport))
+		for i, t := range credential.Transport {
+			transports[i] = string(t)
+		}
+
+		query := `INSERT INTO passkeys (user_id, name, credential_id, public_key, attestation_type, transports, aaguid,
+		          sign_count, user_verified, backup_eligible, backup_state, created_at)
+		          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW())
+		          ON CONFLICT (credential_id) DO NOTHING`
+		res, err := tx.ExecContext(r.Context(), query, userID, ceremony.Name, credential.ID, credential.PublicKey,
+			credential.AttestationType, strings.Join(transports, ","), credential.Authenticator.AAGUID,
+			int64(credential.Authenticator.SignCount), credential.Flags.UserVerified,
+			credential.Flags.BackupEligible, credential.Flags.BackupState)
+		if err != nil {
+			log.Printf("Error saving passkey: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.Error(w, "This passkey is already registered.", http.StatusConflict)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Passkey added. You can now sign in with it.")
+		writeJSON(w, http.StatusCreated, map[string]string{"redirect": "/settings/account/passkeys"})
+	}
+}
+
+// DeletePasskey removes a passkey of the signed in user. The last way to
+// complete a sign in with two factors cannot be removed when they are
+// required of the user.
+func (h *Handler) DeletePasskey() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+		passkeyID := r.PathValue("passkeyId")
+		if _, err := uuid.Parse(passkeyID); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		tf, err := h.findTwoFactor(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying two-factor authentication: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Locking the user keeps concurrent deletions from removing every
+		// passkey between them
+		if _, err := findPasskeyUser(r.Context(), tx, userID); err != nil {
+			log.Printf("Error querying passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		res, err := tx.ExecContext(r.Context(), `DELETE FROM passkeys WHERE id = $1 AND user_id = $2`, passkeyID, userID)
+		if err != nil {
+			log.Printf("Error deleting passkey: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		var remaining int
+		if err := tx.QueryRowContext(r.Context(), `SELECT COUNT(*) FROM passkeys WHERE user_id = $1`, userID).Scan(&remaining); err != nil {
+			log.Printf("Error counting passkeys: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if tf.Required && !tf.Enabled && remaining == 0 {
+			flash.SetError(w, "Two-factor authentication is required of your account. Set up an authenticator app before removing your last passkey.")
+			redirect(w, r, "/settings/account/passkeys")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Passkey removed.")
+		redirect(w, r, "/settings/account/passkeys")
+	}
+}
@@ -0,0 +1,324 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const passkeyOrigin = "https://wryte.example"
+
+// softAuthenticator is a passkey kept in memory, answering ceremonies the way
+// a platform authenticator does, with "none" attestation.
+type softAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	handle  []byte
+	counter uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{key: key, id: []byte(rand.Text())}
+}
+
+// authenticatorData is the data the authenticator signs, flags telling
+// whether the user was present and verified and whether a credential is
+// attested.
+func (a *softAuthenticator) authenticatorData(rpID string, flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(rpID))
+	data := append(rpHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	return append(data, attested...)
+}
+
+func clientData(t *testing.T, ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64, origin string) []byte {
+	t.Helper()
+	data, err := json.Marshal(map[string]string{"type": string(ceremony), "challenge": challenge.String(), "origin": origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) create(t *testing.T, options *protocol.CredentialCreation, origin string) *protocol.ParsedCredentialCreationData {
+	t.Helper()
+	a.handle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	pub := a.key.PublicKey
+	coseKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // EC2
+		3:  -7, // ES256
+		-1: 1,  // P-256
+		-2: pub.X.FillBytes(make([]byte, 32)),
+		-3: pub.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(attested, a.id...)
+	attested = append(attested, coseKey...)
+
+	flags := protocol.FlagUserPresent | protocol.FlagUserVerified | protocol.FlagAttestedCredentialData
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(options.Response.RelyingParty.ID, flags, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := base64.RawURLEncoding
+	body, _ := json.Marshal(map[string]any{
+		"id":    enc.EncodeToString(a.id),
+		"rawId": enc.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    enc.EncodeToString(clientData(t, protocol.CreateCeremony, options.Response.Challenge, origin)),
+			"attestationObject": enc.EncodeToString(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func (a *softAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion, origin string) *protocol.ParsedCredentialAssertionData {
+	t.Helper()
+	data := a.authenticatorData(options.Response.RelyingPartyID, protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	client := clientData(t, protocol.AssertCeremony, options.Response.Challenge, origin)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(append([]byte(nil), data...), clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := base64.RawURLEncoding
+	body, _ := json.Marshal(map[string]any{
+		"id":    enc.EncodeToString(a.id),
+		"rawId": enc.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    enc.EncodeToString(client),
+			"authenticatorData": enc.EncodeToString(data),
+			"signature":         enc.EncodeToString(signature),
+			"userHandle":        enc.EncodeToString(a.handle),
+		},
+	})
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func newPasskeyTest(t *testing.T) *webauthn.WebAuthn {
+	t.Helper()
+	wa, err := webauthn.New(&webauthn.Config{
+		RPID:          "wryte.example",
+		RPDisplayName: "Wryte",
+		RPOrigins:     []string{passkeyOrigin},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wa
+}
+
+// storedSession round trips a ceremony through JSON, as webauthn_sessions
+// keeps it.
+func storedSession(t *testing.T, session *webauthn.SessionData) webauthn.SessionData {
+	t.Helper()
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored webauthn.SessionData
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+// registerPasskey registers a passkey for user the way RegisterPasskey does.
+func registerPasskey(t *testing.T, wa *webauthn.WebAuthn, user *passkeyUser, a *softAuthenticator, origin string) (*webauthn.Credential, error) {
+	t.Helper()
+	options, session, err := wa.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wa.CreateCredential(user, storedSession(t, session), a.create(t, options, origin))
+}
+
+// signInWithPasskey signs in the way PasskeyLogin does, finding the user by
+// the handle the authenticator kept.
+func signInWithPasskey(t *testing.T, wa *webauthn.WebAuthn, users []*passkeyUser, a *softAuthenticator, origin string) (*passkeyUser, *webauthn.Credential, error) {
+	t.Helper()
+	options, session, err := wa.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		t.Fatal(err)
+	}
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			if u.id == id {
+				return u, nil
+			}
+		}
+		return nil, errors.New("unknown user")
+	}
+	user, credential, err := wa.ValidatePasskeyLogin(findUser, storedSession(t, session), a.get(t, options, origin))
+	if err != nil {
+		return nil, nil, err
+	}
+	return user.(*passkeyUser), credential, nil
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	wa := newPasskeyTest(t)
+	alice := &passkeyUser{id: uuid.New(), name: "alice@example.com", displayName: "alice"}
+	bob := &passkeyUser{id: uuid.New(), name: "bob@example.com", displayName: "bob"}
+	a := newSoftAuthenticator(t)
+
+	credential, err := registerPasskey(t, wa, alice, a, passkeyOrigin)
+	if err != nil {
+		t.Fatalf("registration = %v", err)
+	}
+	if !bytes.Equal(credential.ID, a.id) || !credential.Flags.UserVerified {
+		t.Errorf("registered credential %x, user verified %t", credential.ID, credential.Flags.UserVerified)
+	}
+	alice.credentials = []webauthn.Credential{*credential}
+
+	a.counter++
+	user, credential, err := signInWithPasskey(t, wa, []*passkeyUser{bob, alice}, a, passkeyOrigin)
+	if err != nil {
+		t.Fatalf("login = %v", err)
+	}
+	if user != alice {
+		t.Errorf("signed in as %s, want alice", user.displayName)
+	}
+	if credential.Authenticator.CloneWarning {
+		t.Error("a fresh signature counter was flagged")
+	}
+
+	// The passkey is not registered for bob, whose handle it cannot use
+	a.counter++
+	a.handle = bob.id[:]
+	if _, _, err := signInWithPasskey(t, wa, []*passkeyUser{bob, alice}, a, passkeyOrigin); err == nil {
+		t.Error("the passkey of alice signed bob in")
+	}
+}
+
+func TestPasskeyWrongOrigin(t *testing.T) {
+	wa := newPasskeyTest(t)
+	user := &passkeyUser{id: uuid.New(), name: "alice@example.com", displayName: "alice"}
+	a := newSoftAuthenticator(t)
+
+	if _, err := registerPasskey(t, wa, user, a, "https://evil.example"); err == nil {
+		t.Error("registration from another origin was accepted")
+	}
+
+	credential, err := registerPasskey(t, wa, user, a, passkeyOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.credentials = []webauthn.Credential{*credential}
+	a.counter++
+	if _, _, err := signInWithPasskey(t, wa, []*passkeyUser{user}, a, "https://evil.example"); err == nil {
+		t.Error("login from another origin was accepted")
+	}
+}
+
+func TestPasskeyReplay(t *testing.T) {
+	wa := newPasskeyTest(t)
+	user := &passkeyUser{id: uuid.New(), name: "alice@example.com", displayName: "alice"}
+	a := newSoftAuthenticator(t)
+
+	credential, err := registerPasskey(t, wa, user, a, passkeyOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.counter = 5
+	credential.Authenticator.SignCount = 5
+	user.credentials = []webauthn.Credential{*credential}
+
+	// An authenticator answering with a counter that did not increase holds
+	// a copy of the key
+	_, credential, err = signInWithPasskey(t, wa, []*passkeyUser{user}, a, passkeyOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := usePasskey(t.Context(), nil, credential); !errors.Is(err, errPasskeyCloned) {
+		t.Errorf("usePasskey() = %v, want errPasskeyCloned", err)
+	}
+}
+
+func TestPasskeySecondFactor(t *testing.T) {
+	wa := newPasskeyTest(t)
+	user := &passkeyUser{id: uuid.New(), name: "alice@example.com", displayName: "alice"}
+	registered := newSoftAuthenticator(t)
+	other := newSoftAuthenticator(t)
+
+	credential, err := registerPasskey(t, wa, user, registered, passkeyOrigin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.credentials = []webauthn.Credential{*credential}
+
+	for _, tt := range []struct {
+		name string
+		a    *softAuthenticator
+		ok   bool
+	}{
+		{"registered passkey", registered, true},
+		{"other passkey", other, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			options, session, err := wa.BeginLogin(user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(options.Response.AllowedCredentials) != 1 {
+				t.Errorf("%d passkeys allowed, want 1", len(options.Response.AllowedCredentials))
+			}
+			tt.a.handle = user.id[:]
+			tt.a.counter++
+			_, err = wa.ValidateLogin(user, storedSession(t, session), tt.a.get(t, options, passkeyOrigin))
+			if tt.ok && err != nil {
+				t.Errorf("ValidateLogin() = %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Error("ValidateLogin() accepted a passkey that is not registered")
+			}
+		})
+	}
+}
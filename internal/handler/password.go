@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// passwordResetTTL is how long a password reset link can be used.
+	passwordResetTTL = time.Hour
+	// passwordResetInterval is how long to wait before sending another reset
+	// link to the same user, so the form cannot be used to flood an inbox.
+	passwordResetInterval = time.Minute
+)
+
+// ForgotPasswordPage asks for the email address of the account to reset.
+func (h *Handler) ForgotPasswordPage() http.HandlerFunc {
+	tmpl := h.templates.MustRender("auth/forgot_password")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{
+			"Form":   &validator.ForgotPasswordForm{},
+			"Errors": &validator.ValidationErrors{},
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// ForgotPasswordForm emails a password reset link to the owner of an
+// account. The response is the same whether an account exists for the
+// address or not, so the form does not reveal who has one.
+func (h *Handler) ForgotPasswordForm() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("auth/forgot_password")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var form validator.ForgotPasswordForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		data := map[string]any{
+			"Form":   &form,
+			"Errors": validationErrs,
+		}
+		if !validationErrs.HasErrors() {
+			if err := h.requestPasswordReset(r, form.Email); err != nil {
+				log.Printf("Error requesting password reset: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data["Sent"] = true
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "forgot_password_form", data); err != nil {
+			log.Printf("Error rendering template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// requestPasswordReset creates a reset token for the account using email, if
+// there is one, and sends the link using it. Links requested earlier stop
+// working. Since the email is sent in the background, the response takes as
+// long whether an account exists or not.
+func (h *Handler) requestPasswordReset(r *http.Request, email string) error {
+	ctx := r.Context()
+
+	var userID, address string
+	query := `SELECT id, email FROM users WHERE lower(email) = lower($1)`
+	err := h.db.GetDB().QueryRowContext(ctx, query, email).Scan(&userID, &address)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the user so that concurrent requests cannot both pass the check
+	// below
+	if _, err := tx.ExecContext(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return err
+	}
+	var recent bool
+	query = `SELECT EXISTS (SELECT 1 FROM password_resets WHERE user_id = $1 AND created_at > $2)`
+	if err := tx.QueryRowContext(ctx, query, userID, time.Now().Add(-passwordResetInterval)).Scan(&recent); err != nil {
+		return err
+	}
+	if recent {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+	token := rand.Text()
+	query = `INSERT INTO password_resets (user_id, token_hash, expires_at, created_at, updated_at)
+	         VALUES ($1, $2, $3, NOW(), NOW())`
+	if _, err := tx.ExecContext(ctx, query, userID, hashToken(token), time.Now().Add(passwordResetTTL)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	h.sendEmail(address, "password_reset", map[string]any{
+		"Link": h.config.URL("/reset-password?token=" + url.QueryEscape(token)),
+	})
+	return nil
+}
+
+// validPasswordReset reports whether token belongs to a reset link that can
+// still be used.
+func (h *Handler) validPasswordReset(ctx context.Context, token string) (bool, error) {
+	var valid bool
+	query := `SELECT EXISTS (SELECT 1 FROM password_resets
+	          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW())`
+	err := h.db.GetDB().QueryRowContext(ctx, query, hashToken(token)).Scan(&valid)
+	return valid, err
+}
+
+// ResetPasswordPage asks for a new password, for someone following a reset
+// link.
+func (h *Handler) ResetPasswordPage() http.HandlerFunc {
+	tmpl := h.templates.MustRender("auth/reset_password")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		valid := false
+		if token != "" {
+			var err error
+			valid, err = h.validPasswordReset(r.Context(), token)
+			if err != nil {
+				log.Printf("Error querying password reset: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		data := map[string]any{
+			"Form":    &validator.ResetPasswordForm{Token: token},
+			"Errors":  &validator.ValidationErrors{},
+			"Invalid": !valid,
+		}
+		if !valid {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// ResetPasswordForm sets a new password using a reset link. The link is used
+// up, and every session of the user is signed out, since whoever knew the
+// old password may have one.
+func (h *Handler) ResetPasswordForm() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("auth/reset_password")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var form validator.ResetPasswordForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		render := func(invalid bool) {
+			data := map[string]any{
+				"Form":    &form,
+				"Errors":  validationErrs,
+				"Invalid": invalid,
+			}
+			if err := tmpl.ExecuteTemplate(w, "reset_password_form", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			}
+		}
+		if validationErrs.HasErrors() {
+			render(form.Token == "")
+			return
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(form.Password), bcrypt.DefaultCost)
+		if err != nil {
+			log.Printf("Error generating password hash: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Using the token up in the same statement that checks it keeps two
+		// concurrent requests from both succeeding
+		var userID string
+		query := `UPDATE password_resets SET used_at = NOW(), updated_at = NOW()
+		          WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		          RETURNING user_id`
+		err = tx.QueryRowContext(r.Context(), query, hashToken(form.Token)).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			render(true)
+			return
+		}
+		if err != nil {
+			log.Printf("Error using password reset: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Reset links are sent to the address of the account, following one
+		// proves it is theirs
+		query = `UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+		         WHERE id = $2`
+		if _, err := tx.ExecContext(r.Context(), query, hash, userID); err != nil {
+			log.Printf("Error updating password: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM password_resets WHERE user_id = $1 AND used_at IS NULL`, userID); err != nil {
+			log.Printf("Error deleting password resets: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM sessions WHERE user_id = $1`, userID); err != nil {
+			log.Printf("Error deleting sessions: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Your password has been changed. Sign in with your new password.")
+		redirect(w, r, "/login")
+	}
+}
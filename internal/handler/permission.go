@@ -0,0 +1,397 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// Role is the access a user has to a document. Every role includes the
+// abilities of the roles below it.
+type Role int
+
+const (
+	RoleNone Role = iota
+	// RoleViewer may read the document and its history.
+	RoleViewer
+	// RoleCommenter may read the document and take part in discussions.
+	RoleCommenter
+	// RoleEditor may change the document and add pages beneath it.
+	RoleEditor
+	// RoleOwner may also share, move and delete the document.
+	RoleOwner
+)
+
+var roleNames = map[Role]string{
+	RoleViewer:    "viewer",
+	RoleCommenter: "commenter",
+	RoleEditor:    "editor",
+	RoleOwner:     "owner",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+func parseRole(name string) (Role, bool) {
+	for role, n := range roleNames {
+		if n == name {
+			return role, true
+		}
+	}
+	return RoleNone, false
+}
+
+func (r Role) CanView() bool   { return r >= RoleViewer }
+func (r Role) CanEdit() bool   { return r >= RoleEditor }
+func (r Role) CanManage() bool { return r >= RoleOwner }
+
+// memberWorkspaces is a subquery selecting the workspaces the user in
+// parameter user belongs to.
+func memberWorkspaces(user string) string {
+	return `SELECT workspace_id FROM workspace_members WHERE user_id = ` + user
+}
+
+// roleExpr is an SQL expression evaluating to the role of the user in
+// parameter user on the document aliased doc, as the numeric value of Role.
+// Access is inherited down the tree: workspace admins and the creators of the
+// document or any of its ancestors own it, and grants made on an ancestor
+// apply to the whole subtree. Other workspace members edit the document
+// unless it or an ancestor was shared with them or with their workspace, in
+// which case the grants decide, so that members can be given less access to
+// some documents. The strongest role wins.
+func roleExpr(doc, user string) string {
+	ancestry := `ANY(string_to_array(trim(both '/' from ` + doc + `.document_path), '/')::uuid[])`
+	return `(SELECT COALESCE(MAX(rank), 0) FROM (
+		SELECT 4 AS rank
+		FROM workspace_members m WHERE m.workspace_id = ` + doc + `.workspace_id AND m.user_id = ` + user + `
+		AND m.role <> 'member'
+		UNION ALL
+		SELECT 3
+		FROM workspace_members m WHERE m.workspace_id = ` + doc + `.workspace_id AND m.user_id = ` + user + `
+		AND m.role = 'member'
+		AND NOT EXISTS (
+			SELECT 1 FROM document_permissions p
+			WHERE p.document_id = ` + ancestry + `
+			AND (p.user_id = ` + user + ` OR p.workspace_id = ` + doc + `.workspace_id)
+		)
+		UNION ALL
+		SELECT 4 FROM documents a WHERE a.id = ` + ancestry + ` AND a.user_id = ` + user + `
+		UNION ALL
+		SELECT CASE p.role WHEN 'owner' THEN 4 WHEN 'editor' THEN 3 WHEN 'commenter' THEN 2 ELSE 1 END
+		FROM document_permissions p
+		WHERE p.document_id = ` + ancestry + `
+		AND (p.user_id = ` + user + ` OR p.workspace_id IN (` + memberWorkspaces(user) + `))
+	) ranks)`
+}
+
+// roleAtLeast is an SQL condition holding when the user in parameter user has
+// at least role on the document aliased doc.
+func roleAtLeast(doc, user string, role Role) string {
+	return roleExpr(doc, user) + ` >= ` + strconv.Itoa(int(role))
+}
+
+// documentRole returns the role of userID on doc.
+func (h *Handler) documentRole(ctx context.Context, doc *Document, userID string) (Role, error) {
+	var role Role
+	query := `SELECT ` + roleExpr("d", "$2") + ` FROM documents d WHERE d.id = $1`
+	err := h.db.GetDB().QueryRowContext(ctx, query, doc.ID, userID).Scan(&role)
+	return role, err
+}
+
+// authorizeDocument loads the document named in the request path and checks
+// that the signed in user holds at least the needed role on it. Every document
+// handler goes through it except MoveDocument, which locks the document and
+// its new parent in its transaction before checking the role on both. It
+// writes the error response and returns a nil document when the request
+// cannot proceed.
+func (h *Handler) authorizeDocument(w http.ResponseWriter, r *http.Request, need Role) (*Document, string, Role) {
+	return h.authorizeDocumentFrom(w, r, need, h.findDocument)
+}
+
+// authorizeEditableDocument is authorizeDocument for changes to the content of
+// a document, which archived documents refuse.
+func (h *Handler) authorizeEditableDocument(w http.ResponseWriter, r *http.Request) (*Document, string, Role) {
+	doc, userID, role := h.authorizeDocument(w, r, RoleEditor)
+	if doc != nil && doc.IsArchived {
+		http.Error(w, archivedError, http.StatusConflict)
+		return nil, "", RoleNone
+	}
+	return doc, userID, role
+}
+
+// authorizeTrashedDocument is authorizeDocument for documents in the trash.
+func (h *Handler) authorizeTrashedDocument(w http.ResponseWriter, r *http.Request, need Role) (*Document, string, Role) {
+	return h.authorizeDocumentFrom(w, r, need, h.findTrashedDocument)
+}
+
+func (h *Handler) authorizeDocumentFrom(w http.ResponseWriter, r *http.Request, need Role, find func(context.Context, string) (*Document, error)) (*Document, string, Role) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, "", RoleNone
+	}
+
+	doc, err := find(r.Context(), r.PathValue("documentId"))
+	if err != nil {
+		if errors.Is(err, errDocumentNotFound) {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return nil, "", RoleNone
+		}
+		log.Printf("Error querying document: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, "", RoleNone
+	}
+
+	role, err := h.documentRole(r.Context(), doc, userID)
+	if err != nil {
+		log.Printf("Error querying document role: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, "", RoleNone
+	}
+
+	if role < need {
+		http.Error(w, "Forbidden - You don't have access to this document", http.StatusForbidden)
+		return nil, "", RoleNone
+	}
+
+	return doc, userID, role
+}
+
+// Permission is a role granted on a document, either to a single user or to
+// the members of a workspace.
+type Permission struct {
+	ID            string
+	DocumentID    string
+	DocumentTitle string
+	UserID        string
+	Username      string
+	Email         string
+	WorkspaceID   string
+	WorkspaceName string
+	Role          string
+	// Inherited is set for grants made on an ancestor of the document.
+	Inherited bool
+}
+
+// listPermissions returns the grants that apply to doc, the ones made on its
+// ancestors first.
+func (h *Handler) listPermissions(ctx context.Context, doc *Document) ([]Permission, error) {
+	ids := strings.Split(strings.Trim(doc.DocumentPath, "/"), "/")
+
+	query := `SELECT p.id, p.document_id, d.title,
+		COALESCE(p.user_id::text, ''), COALESCE(u.username, ''), COALESCE(u.email, ''),
+		COALESCE(p.workspace_id::text, ''), COALESCE(w.name, ''), p.role
+		FROM document_permissions p
+		JOIN documents d ON d.id = p.document_id
+		LEFT JOIN users u ON u.id = p.user_id
+		LEFT JOIN workspaces w ON w.id = p.workspace_id
+		WHERE p.document_id = ANY($1::uuid[])
+		ORDER BY length(d.document_path), p.created_at`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []Permission
+	for rows.Next() {
+		var p Permission
+		err := rows.Scan(&p.ID, &p.DocumentID, &p.DocumentTitle, &p.UserID, &p.Username, &p.Email,
+			&p.WorkspaceID, &p.WorkspaceName, &p.Role)
+		if err != nil {
+			return nil, err
+		}
+		p.Inherited = p.DocumentID != doc.ID
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+
+// renderPermissions renders the sharing panel of a document, which also holds
+// the settings of its public link.
+func (h *Handler) renderPermissions(w http.ResponseWriter, r *http.Request, tmpl *template.Template, doc *Document, errs *validator.ValidationErrors) {
+	permissions, err := h.listPermissions(r.Context(), doc)
+	if err != nil {
+		log.Printf("Error listing permissions: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	workspace, err := h.findWorkspace(r.Context(), doc.WorkspaceID)
+	if err != nil {
+		log.Printf("Error querying workspace: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	link, err := h.publicLinkFor(r.Context(), publicDocument, doc.ID)
+	if err != nil {
+		log.Printf("Error querying public link: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Document":    doc,
+		"Workspace":   workspace,
+		"Permissions": permissions,
+		"Errors":      errs,
+		"Link":        link,
+		"Action":      "/documents/" + doc.ID + "/public-link",
+		"Target":      "document-permissions",
+	}
+	if link != nil {
+		data["URL"] = h.publicURL(link.Slug)
+	}
+	if errs != nil && errs.HasErrors() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, "document_permissions", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// DocumentPermissions renders the sharing panel of a document.
+func (h *Handler) DocumentPermissions() http.HandlerFunc {
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+		h.renderPermissions(w, r, tmpl, doc, nil)
+	}
+}
+
+// ShareDocument grants a role on a document to a user or to the members of a
+// workspace, replacing any role they were granted on it before.
+func (h *Handler) ShareDocument() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		var form validator.ShareDocumentForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		username := strings.TrimSpace(form.Username)
+		if username == "" && form.WorkspaceID == "" {
+			validationErrs.AddError("username", "Enter a username or email address")
+		}
+		if validationErrs.HasErrors() {
+			h.renderPermissions(w, r, tmpl, doc, validationErrs)
+			return
+		}
+
+		role, _ := parseRole(form.Role)
+
+		if form.WorkspaceID != "" {
+			member, err := h.isWorkspaceMember(r.Context(), form.WorkspaceID, userID)
+			if err != nil {
+				log.Printf("Error querying workspace: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !member {
+				http.Error(w, "Forbidden - You don't have access to this workspace", http.StatusForbidden)
+				return
+			}
+
+			query := `INSERT INTO document_permissions (document_id, workspace_id, role, granted_by, created_at, updated_at)
+			          VALUES ($1, $2, $3, $4, NOW(), NOW())
+			          ON CONFLICT (document_id, workspace_id) WHERE workspace_id IS NOT NULL
+			          DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, updated_at = NOW()`
+			_, err = h.db.GetDB().ExecContext(r.Context(), query, doc.ID, form.WorkspaceID, role.String(), userID)
+			if err != nil {
+				log.Printf("Error sharing document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			h.renderPermissions(w, r, tmpl, doc, nil)
+			return
+		}
+
+		var granteeID string
+		query := `SELECT id FROM users WHERE username = $1 OR email = $1`
+		err = h.db.GetDB().QueryRowContext(r.Context(), query, username).Scan(&granteeID)
+		if errors.Is(err, sql.ErrNoRows) {
+			validationErrs.AddError("username", "No user with that username or email address")
+			h.renderPermissions(w, r, tmpl, doc, validationErrs)
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if granteeID == userID {
+			validationErrs.AddError("username", "You already have access to this document")
+			h.renderPermissions(w, r, tmpl, doc, validationErrs)
+			return
+		}
+
+		query = `INSERT INTO document_permissions (document_id, user_id, role, granted_by, created_at, updated_at)
+		         VALUES ($1, $2, $3, $4, NOW(), NOW())
+		         ON CONFLICT (document_id, user_id) WHERE user_id IS NOT NULL
+		         DO UPDATE SET role = EXCLUDED.role, granted_by = EXCLUDED.granted_by, updated_at = NOW()`
+		_, err = h.db.GetDB().ExecContext(r.Context(), query, doc.ID, granteeID, role.String(), userID)
+		if err != nil {
+			log.Printf("Error sharing document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderPermissions(w, r, tmpl, doc, nil)
+	}
+}
+
+// RevokePermission removes a grant made on the document itself. Grants
+// inherited from an ancestor have to be revoked there.
+func (h *Handler) RevokePermission() http.HandlerFunc {
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		permissionID := r.PathValue("permissionId")
+		if _, err := uuid.Parse(permissionID); err != nil {
+			http.Error(w, "Permission not found", http.StatusNotFound)
+			return
+		}
+
+		query := `DELETE FROM document_permissions WHERE id = $1 AND document_id = $2`
+		if _, err := h.db.GetDB().ExecContext(r.Context(), query, permissionID, doc.ID); err != nil {
+			log.Printf("Error revoking permission: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderPermissions(w, r, tmpl, doc, nil)
+	}
+}
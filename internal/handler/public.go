@@ -0,0 +1,756 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/validator"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// publicCacheAge is how long browsers and proxies may reuse a shared page
+	// that is not password protected.
+	publicCacheAge = time.Minute
+
+	// publicUnlockAge is how long a visitor stays unlocked after entering the
+	// password of a link.
+	publicUnlockAge = 24 * time.Hour
+
+	// publicUnlockWindow is the period over which wrong passwords are counted.
+	publicUnlockWindow = 15 * time.Minute
+
+	// publicUnlockLinkAttempts is how many wrong passwords a link accepts from
+	// anyone within publicUnlockWindow, and publicUnlockIPAttempts how many an
+	// address may enter for any link, before further attempts are refused.
+	publicUnlockLinkAttempts = 50
+	publicUnlockIPAttempts   = 10
+)
+
+// PublicLink exposes a public document, with its subpages, or a public
+// workspace to visitors that are not signed in.
+type PublicLink struct {
+	ID            string
+	Slug          string
+	DocumentID    string
+	WorkspaceID   string
+	PasswordHash  string
+	ExpiresAt     sql.NullTime
+	AllowIndexing bool
+	UpdatedAt     time.Time
+}
+
+func (l *PublicLink) Protected() bool {
+	return l.PasswordHash != ""
+}
+
+func (l *PublicLink) Expired() bool {
+	return l.ExpiresAt.Valid && !time.Now().Before(l.ExpiresAt.Time)
+}
+
+// ExpiryDate returns the last day the link is valid, as accepted by the
+// settings form.
+func (l *PublicLink) ExpiryDate() string {
+	if !l.ExpiresAt.Valid {
+		return ""
+	}
+	return l.ExpiresAt.Time.UTC().AddDate(0, 0, -1).Format("2006-01-02")
+}
+
+// covers reports whether doc may be shown through the link.
+func (l *PublicLink) covers(doc *Document) bool {
+	if l.WorkspaceID != "" {
+		return doc.WorkspaceID == l.WorkspaceID
+	}
+	return strings.Contains(doc.DocumentPath+"/", "/"+l.DocumentID+"/")
+}
+
+// unlockToken is the cookie value proving that the visitor knew the password,
+// valid until expiresAt. It is signed with the secret key of the server. The
+// password hash is signed along, so changing the password locks everyone out
+// again, and so is the expiry, so that it does not depend on the browser
+// dropping the cookie.
+func (l *PublicLink) unlockToken(key []byte, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + l.unlockMAC(key, expiry)
+}
+
+func (l *PublicLink) unlockMAC(key []byte, expiry string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(l.ID + "." + expiry + "." + l.PasswordHash))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (l *PublicLink) cookieName() string {
+	return "wryte_link_" + l.Slug
+}
+
+func (l *PublicLink) unlocked(r *http.Request, key []byte) bool {
+	if !l.Protected() {
+		return true
+	}
+	cookie, err := r.Cookie(l.cookieName())
+	if err != nil {
+		return false
+	}
+	expiry, mac, ok := strings.Cut(cookie.Value, ".")
+	if !ok {
+		return false
+	}
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || !time.Now().Before(time.Unix(seconds, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(l.unlockMAC(key, expiry)))
+}
+
+var errPublicLinkNotFound = errors.New("public link not found")
+
+const publicLinkColumns = `l.id, l.slug, COALESCE(l.document_id::text, ''), COALESCE(l.workspace_id::text, ''),
+	COALESCE(l.password_hash, ''), l.expires_at, l.allow_indexing, l.updated_at`
+
+func scanPublicLink(row rowScanner) (*PublicLink, error) {
+	var l PublicLink
+	err := row.Scan(&l.ID, &l.Slug, &l.DocumentID, &l.WorkspaceID, &l.PasswordHash, &l.ExpiresAt,
+		&l.AllowIndexing, &l.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// findPublicLink loads a link by slug. Links whose document or workspace is
+// no longer public, or whose document is in the trash, are reported as
+// missing.
+func (h *Handler) findPublicLink(ctx context.Context, slug string) (*PublicLink, error) {
+	query := `SELECT ` + publicLinkColumns + `
+		FROM public_links l
+		LEFT JOIN documents d ON d.id = l.document_id
+		LEFT JOIN workspaces w ON w.id = l.workspace_id
+		WHERE l.slug = $1 AND ((d.is_public AND d.deleted_at IS NULL) OR w.is_public)`
+	link, err := scanPublicLink(h.db.GetDB().QueryRowContext(ctx, query, slug))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errPublicLinkNotFound
+	}
+	return link, err
+}
+
+// Targets of public links. column is the public_links column naming the
+// target and table the table holding its is_public flag.
+type publicTarget struct {
+	column string
+	table  string
+}
+
+var (
+	publicDocument  = publicTarget{column: "document_id", table: "documents"}
+	publicWorkspace = publicTarget{column: "workspace_id", table: "workspaces"}
+)
+
+// publicLinkFor returns the link of a document or workspace, or nil when it is
+// not shared publicly.
+func (h *Handler) publicLinkFor(ctx context.Context, target publicTarget, id string) (*PublicLink, error) {
+	query := `SELECT ` + publicLinkColumns + ` FROM public_links l WHERE l.` + target.column + ` = $1`
+	link, err := scanPublicLink(h.db.GetDB().QueryRowContext(ctx, query, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return link, err
+}
+
+// savePublicLink marks the target public and creates or updates its link. The
+// password is only replaced when a new one is given or removal is requested.
+func (h *Handler) savePublicLink(ctx context.Context, target publicTarget, id, userID string, form *validator.PublicLinkForm, expiresAt sql.NullTime) error {
+	var passwordHash string
+	if form.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(form.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		passwordHash = string(hash)
+	}
+	changePassword := form.Password != "" || form.RemovePassword
+
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE ` + target.table + ` SET is_public = true, updated_at = NOW() WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO public_links (slug, ` + target.column + `, password_hash, expires_at, allow_indexing, created_by, created_at, updated_at)
+	         VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, NOW(), NOW())
+	         ON CONFLICT (` + target.column + `) WHERE ` + target.column + ` IS NOT NULL
+	         DO UPDATE SET
+	             password_hash = CASE WHEN $7 THEN EXCLUDED.password_hash ELSE public_links.password_hash END,
+	             expires_at = EXCLUDED.expires_at,
+	             allow_indexing = EXCLUDED.allow_indexing,
+	             updated_at = NOW()`
+	_, err = tx.ExecContext(ctx, query, strings.ToLower(rand.Text()), id, passwordHash, expiresAt,
+		form.AllowIndexing, userID, changePassword)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deletePublicLink removes the link of the target and makes it private again.
+func (h *Handler) deletePublicLink(ctx context.Context, target publicTarget, id string) error {
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE ` + target.table + ` SET is_public = false, updated_at = NOW() WHERE id = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+	query = `DELETE FROM public_links WHERE ` + target.column + ` = $1`
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// decodePublicLinkForm reads the link settings and resolves the expiry date to
+// the end of that day, UTC.
+func decodePublicLinkForm(v *validator.Validator, r *http.Request) (*validator.PublicLinkForm, sql.NullTime, *validator.ValidationErrors, error) {
+	var form validator.PublicLinkForm
+	validationErrs, err := v.DecodeAndValidate(r, &form)
+	if err != nil || validationErrs.HasErrors() {
+		return &form, sql.NullTime{}, validationErrs, err
+	}
+
+	var expiresAt sql.NullTime
+	if form.ExpiresAt != "" {
+		day, _ := time.Parse("2006-01-02", form.ExpiresAt)
+		expiresAt = sql.NullTime{Time: day.AddDate(0, 0, 1), Valid: true}
+		if !expiresAt.Time.After(time.Now()) {
+			validationErrs.AddError("expiresat", "Expiry date must be in the future")
+		}
+	}
+	return &form, expiresAt, validationErrs, nil
+}
+
+// publicURL returns the absolute address of a link, to be copied and shared.
+func (h *Handler) publicURL(slug string) string {
+	return h.config.URL("/p/" + slug)
+}
+
+// etagOf derives a strong entity tag from the values a page is rendered from.
+func etagOf(parts ...string) string {
+	sum := sha256.New()
+	for _, p := range parts {
+		sum.Write([]byte(p))
+		sum.Write([]byte{0})
+	}
+	return `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+}
+
+// setPublicHeaders applies the headers shared by every response of a link.
+// Links are kept out of search engines unless the owner allowed indexing, and
+// the slug is never leaked to other sites through the referrer.
+func setPublicHeaders(w http.ResponseWriter, link *PublicLink) {
+	if link == nil || !link.AllowIndexing {
+		w.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	}
+	w.Header().Set("Referrer-Policy", "no-referrer")
+}
+
+// cachePublicPage sets the caching headers of a shared page and answers
+// conditional requests. It reports whether the response is already complete.
+func cachePublicPage(w http.ResponseWriter, r *http.Request, link *PublicLink, etag string, modified time.Time) bool {
+	if link.Protected() {
+		// Only the visitor who unlocked the page may reuse it
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Set("Vary", "Cookie")
+	} else {
+		age := publicCacheAge
+		if link.ExpiresAt.Valid {
+			age = min(age, time.Until(link.ExpiresAt.Time))
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(age.Seconds())))
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+func (h *Handler) renderPublic(w http.ResponseWriter, tmpl *template.Template, status int, data map[string]any) {
+	if status != http.StatusOK {
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+// PublicPage shows a shared document, or the index of a shared workspace, to
+// anyone holding the link. Pages are read-only.
+func (h *Handler) PublicPage() http.HandlerFunc {
+	tmpl := h.templates.MustRender("public")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, err := h.findPublicLink(r.Context(), r.PathValue("slug"))
+		if err != nil {
+			if errors.Is(err, errPublicLinkNotFound) {
+				setPublicHeaders(w, nil)
+				h.renderPublic(w, tmpl, http.StatusNotFound, map[string]any{"Missing": true})
+				return
+			}
+			log.Printf("Error querying public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		setPublicHeaders(w, link)
+
+		if link.Expired() {
+			h.renderPublic(w, tmpl, http.StatusGone, map[string]any{"Link": link, "Expired": true})
+			return
+		}
+		if !link.unlocked(r, []byte(h.config.Server.SecretKey)) {
+			h.renderPublic(w, tmpl, http.StatusUnauthorized, map[string]any{
+				"Link":   link,
+				"Locked": true,
+				"Next":   r.URL.Path,
+			})
+			return
+		}
+
+		documentID := r.PathValue("documentId")
+		if documentID == "" {
+			documentID = link.DocumentID
+		}
+
+		data := map[string]any{"Link": link}
+		var etag string
+		var modified time.Time
+
+		if documentID != "" {
+			doc, err := h.findDocument(r.Context(), documentID)
+			if err != nil || !link.covers(doc) {
+				if err != nil && !errors.Is(err, errDocumentNotFound) {
+					log.Printf("Error querying document: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				h.renderPublic(w, tmpl, http.StatusNotFound, map[string]any{"Link": link, "Missing": true})
+				return
+			}
+
+			children, err := h.listChildDocuments(r.Context(), doc.WorkspaceID, doc.ID)
+			if err != nil {
+				log.Printf("Error listing child documents: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			// Attachments are reached through the link, the visitor cannot
+			// sign in to download them
+			doc.Content = publicAttachmentLinks.ReplaceAllString(doc.Content, "](/p/"+link.Slug+"/$1/attachments/")
+
+			data["Document"] = doc
+			data["Documents"] = children
+			data["IsRoot"] = doc.ID == link.DocumentID
+			etag = etagOf(link.ID, link.UpdatedAt.String(), doc.ID, strconv.Itoa(doc.Version), doc.UpdatedAt.String(), nodesKey(children))
+			modified = link.UpdatedAt
+			if doc.UpdatedAt.After(modified) {
+				modified = doc.UpdatedAt
+			}
+		} else {
+			workspace, err := h.findWorkspace(r.Context(), link.WorkspaceID)
+			if err != nil {
+				log.Printf("Error querying workspace: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			documents, err := h.listChildDocuments(r.Context(), workspace.ID, "")
+			if err != nil {
+				log.Printf("Error listing documents: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+
+			data["Workspace"] = workspace
+			data["Documents"] = documents
+			data["IsRoot"] = true
+			etag = etagOf(link.ID, link.UpdatedAt.String(), workspace.Name, nodesKey(documents))
+			modified = link.UpdatedAt
+		}
+
+		if cachePublicPage(w, r, link, etag, modified) {
+			return
+		}
+		h.renderPublic(w, tmpl, http.StatusOK, data)
+	}
+}
+
+// publicAttachmentLinks matches the references to attachments in document
+// content, up to the attachment ID.
+var publicAttachmentLinks = regexp.MustCompile(`\]\(/documents/([0-9a-f-]{36})/attachments/`)
+
+// PublicAttachment sends a file attached to a document shown through a link.
+func (h *Handler) PublicAttachment() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, err := h.findPublicLink(r.Context(), r.PathValue("slug"))
+		if err != nil {
+			if errors.Is(err, errPublicLinkNotFound) {
+				setPublicHeaders(w, nil)
+				http.Error(w, "Not Found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error querying public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		setPublicHeaders(w, link)
+
+		if link.Expired() {
+			http.Error(w, "This link has expired", http.StatusGone)
+			return
+		}
+		if !link.unlocked(r, []byte(h.config.Server.SecretKey)) {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		doc, err := h.findDocument(r.Context(), r.PathValue("documentId"))
+		if err != nil || !link.covers(doc) {
+			if err != nil && !errors.Is(err, errDocumentNotFound) {
+				log.Printf("Error querying document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+
+		cacheControl := "private, no-cache"
+		if link.Protected() {
+			w.Header().Set("Vary", "Cookie")
+		} else {
+			age := publicCacheAge
+			if link.ExpiresAt.Valid {
+				age = min(age, time.Until(link.ExpiresAt.Time))
+			}
+			cacheControl = "public, max-age=" + strconv.Itoa(int(age.Seconds()))
+		}
+		h.serveAttachment(w, r, doc, cacheControl)
+	}
+}
+
+// nodesKey summarises a list of tree nodes for an entity tag.
+func nodesKey(nodes []DocumentNode) string {
+	var b strings.Builder
+	for _, n := range nodes {
+		fmt.Fprintf(&b, "%s:%s:%t;", n.ID, n.Title, n.HasChildren)
+	}
+	return b.String()
+}
+
+// UnlockPublicLink checks the password of a protected link and remembers the
+// visitor with a cookie scoped to the link. Wrong passwords are counted per
+// link and per address, and attempts are refused for a while once either
+// has seen too many.
+func (h *Handler) UnlockPublicLink() http.HandlerFunc {
+	tmpl := h.templates.MustRender("public")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		link, err := h.findPublicLink(r.Context(), r.PathValue("slug"))
+		if err != nil {
+			if errors.Is(err, errPublicLinkNotFound) {
+				setPublicHeaders(w, nil)
+				h.renderPublic(w, tmpl, http.StatusNotFound, map[string]any{"Missing": true})
+				return
+			}
+			log.Printf("Error querying public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		setPublicHeaders(w, link)
+
+		if link.Expired() {
+			h.renderPublic(w, tmpl, http.StatusGone, map[string]any{"Link": link, "Expired": true})
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		base := "/p/" + link.Slug
+		next := r.PostForm.Get("next")
+		if next != base && !strings.HasPrefix(next, base+"/") {
+			next = base
+		}
+
+		if !link.Protected() {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		ip := clientIP(r)
+		throttled, err := h.unlockThrottled(r.Context(), link, ip)
+		if err != nil {
+			log.Printf("Error counting unlock attempts: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if throttled {
+			w.Header().Set("Retry-After", strconv.Itoa(int(publicUnlockWindow.Seconds())))
+			h.renderPublic(w, tmpl, http.StatusTooManyRequests, map[string]any{
+				"Link":      link,
+				"Locked":    true,
+				"Next":      next,
+				"Throttled": true,
+			})
+			return
+		}
+
+		err = bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(r.PostForm.Get("password")))
+		if err != nil {
+			if err := h.recordUnlockFailure(r.Context(), link, ip); err != nil {
+				log.Printf("Error recording unlock attempt: %v", err)
+			}
+			h.renderPublic(w, tmpl, http.StatusUnauthorized, map[string]any{
+				"Link":        link,
+				"Locked":      true,
+				"Next":        next,
+				"BadPassword": true,
+			})
+			return
+		}
+
+		expiresAt := time.Now().Add(publicUnlockAge)
+		if link.ExpiresAt.Valid && link.ExpiresAt.Time.Before(expiresAt) {
+			expiresAt = link.ExpiresAt.Time
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     link.cookieName(),
+			Value:    link.unlockToken([]byte(h.config.Server.SecretKey), expiresAt),
+			Expires:  expiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     base,
+		})
+
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	}
+}
+
+// unlockThrottled reports whether too many wrong passwords were entered lately
+// for the link, or from the address ip.
+func (h *Handler) unlockThrottled(ctx context.Context, link *PublicLink, ip string) (bool, error) {
+	var forLink, fromIP int
+	query := `SELECT COUNT(*) FILTER (WHERE link_id = $1), COUNT(*) FILTER (WHERE ip_address = $2)
+	          FROM public_link_attempts
+	          WHERE (link_id = $1 OR ip_address = $2) AND created_at > NOW() - make_interval(secs => $3)`
+	err := h.db.GetDB().QueryRowContext(ctx, query, link.ID, ip, publicUnlockWindow.Seconds()).Scan(&forLink, &fromIP)
+	if err != nil {
+		return false, err
+	}
+	return forLink >= publicUnlockLinkAttempts || fromIP >= publicUnlockIPAttempts, nil
+}
+
+// recordUnlockFailure counts a wrong password entered for the link from ip,
+// clearing attempts too old to count on the way.
+func (h *Handler) recordUnlockFailure(ctx context.Context, link *PublicLink, ip string) error {
+	db := h.db.GetDB()
+	query := `DELETE FROM public_link_attempts WHERE created_at <= NOW() - make_interval(secs => $1)`
+	if _, err := db.ExecContext(ctx, query, publicUnlockWindow.Seconds()); err != nil {
+		return err
+	}
+	query = `INSERT INTO public_link_attempts (link_id, ip_address, created_at) VALUES ($1, $2, NOW())`
+	_, err := db.ExecContext(ctx, query, link.ID, ip)
+	return err
+}
+
+// clientIP returns the address the request came from, without port or zone.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.WithZone("").String()
+	}
+	return host
+}
+
+// SaveDocumentPublicLink publishes a document and its subpages, or updates
+// the settings of its link.
+func (h *Handler) SaveDocumentPublicLink() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		form, expiresAt, validationErrs, err := decodePublicLinkForm(v, r)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			h.renderPermissions(w, r, tmpl, doc, validationErrs)
+			return
+		}
+
+		if err := h.savePublicLink(r.Context(), publicDocument, doc.ID, userID, form, expiresAt); err != nil {
+			log.Printf("Error saving public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderPermissions(w, r, tmpl, doc, nil)
+	}
+}
+
+// DeleteDocumentPublicLink makes a document private again.
+func (h *Handler) DeleteDocumentPublicLink() http.HandlerFunc {
+	tmpl := h.templates.MustRender("document")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, _ := h.authorizeDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		if err := h.deletePublicLink(r.Context(), publicDocument, doc.ID); err != nil {
+			log.Printf("Error deleting public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderPermissions(w, r, tmpl, doc, nil)
+	}
+}
+
+func (h *Handler) renderWorkspacePublicLink(w http.ResponseWriter, r *http.Request, tmpl *template.Template, workspace *Workspace, errs *validator.ValidationErrors) {
+	link, err := h.publicLinkFor(r.Context(), publicWorkspace, workspace.ID)
+	if err != nil {
+		log.Printf("Error querying public link: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Link":   link,
+		"Action": "/workspaces/" + workspace.ID + "/public-link",
+		"Target": "workspace-public-link",
+		"Errors": errs,
+	}
+	if link != nil {
+		data["URL"] = h.publicURL(link.Slug)
+	}
+	if errs != nil && errs.HasErrors() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, "workspace_public_link", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// WorkspacePublicLink renders the public link settings of a workspace.
+func (h *Handler) WorkspacePublicLink() http.HandlerFunc {
+	tmpl := h.templates.MustRender("home")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+		h.renderWorkspacePublicLink(w, r, tmpl, workspace, nil)
+	}
+}
+
+// SaveWorkspacePublicLink publishes a workspace, or updates the settings of
+// its link.
+func (h *Handler) SaveWorkspacePublicLink() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("home")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		form, expiresAt, validationErrs, err := decodePublicLinkForm(v, r)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			h.renderWorkspacePublicLink(w, r, tmpl, workspace, validationErrs)
+			return
+		}
+
+		if err := h.savePublicLink(r.Context(), publicWorkspace, workspace.ID, userID, form, expiresAt); err != nil {
+			log.Printf("Error saving public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderWorkspacePublicLink(w, r, tmpl, workspace, nil)
+	}
+}
+
+// DeleteWorkspacePublicLink makes a workspace private again.
+func (h *Handler) DeleteWorkspacePublicLink() http.HandlerFunc {
+	tmpl := h.templates.MustRender("home")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		if err := h.deletePublicLink(r.Context(), publicWorkspace, workspace.ID); err != nil {
+			log.Printf("Error deleting public link: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderWorkspacePublicLink(w, r, tmpl, workspace, nil)
+	}
+}
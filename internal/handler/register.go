@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/wrytehq/wryte/internal/flash"
 	"github.com/wrytehq/wryte/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -61,9 +62,11 @@ func (h *Handler) RegisterForm() http.HandlerFunc {
 			return
 		}
 
+		var userID string
 		query := `INSERT INTO users (username, email, password_hash, created_at, updated_at)
-		          VALUES ($1, $2, $3, NOW(), NOW())`
-		_, err = h.db.GetDB().ExecContext(r.Context(), query, form.Name, form.Email, hash)
+		          VALUES ($1, $2, $3, NOW(), NOW())
+		          RETURNING id`
+		err = h.db.GetDB().QueryRowContext(r.Context(), query, form.Name, form.Email, hash).Scan(&userID)
 		if err != nil {
 			// Check for duplicate email or username
 			var pgErr *pgconn.PgError
@@ -95,6 +98,12 @@ func (h *Handler) RegisterForm() http.HandlerFunc {
 			return
 		}
 
+		// The account exists either way, the link can be sent again later
+		if _, err := h.requestEmailVerification(r, userID); err != nil {
+			log.Printf("Error requesting email verification: %v", err)
+		}
+		flash.SetSuccess(w, "Account created. Follow the link we sent to "+form.Email+" to verify your address.")
+
 		w.Header().Set("HX-Redirect", "/login")
 		w.WriteHeader(http.StatusOK)
 	}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/diff"
+	"github.com/wrytehq/wryte/internal/flash"
+)
+
+type Revision struct {
+	ID         string
+	DocumentID string
+	UserID     string
+	Username   string
+	Version    int
+	Title      string
+	Content    string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// recordRevision stores the current state of doc as a revision authored by
+// userID. When coalesce is set and the latest revision was started by the same
+// user within the configured window, that revision is updated instead so a
+// burst of autosaves does not flood the history.
+func (h *Handler) recordRevision(ctx context.Context, tx *sql.Tx, doc *Document, userID string, coalesce bool) error {
+	if coalesce && h.config.Document.RevisionWindow > 0 {
+		query := `UPDATE document_revisions
+		          SET title = $1, content = $2, version = $3, updated_at = NOW()
+		          WHERE id = (
+		              SELECT id FROM document_revisions
+		              WHERE document_id = $4
+		              ORDER BY created_at DESC
+		              LIMIT 1
+		          )
+		          AND user_id = $5
+		          AND created_at > NOW() - make_interval(secs => $6)`
+		res, err := tx.ExecContext(ctx, query,
+			doc.Title, doc.Content, doc.Version, doc.ID, userID, h.config.Document.RevisionWindow.Seconds())
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			return nil
+		}
+	}
+
+	query := `INSERT INTO document_revisions (document_id, user_id, version, title, content, created_at, updated_at)
+	          VALUES ($1, $2, $3, $4, $5, NOW(), NOW())`
+	_, err := tx.ExecContext(ctx, query, doc.ID, userID, doc.Version, doc.Title, doc.Content)
+	return err
+}
+
+// listRevisions returns the revisions of a document, newest first.
+func (h *Handler) listRevisions(ctx context.Context, documentID string) ([]Revision, error) {
+	query := `SELECT r.id, r.document_id, r.user_id, u.username, r.version, r.title, COALESCE(r.content, ''),
+		r.created_at, r.updated_at
+		FROM document_revisions r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.document_id = $1
+		ORDER BY r.created_at DESC`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, documentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var rev Revision
+		err := rows.Scan(&rev.ID, &rev.DocumentID, &rev.UserID, &rev.Username, &rev.Version,
+			&rev.Title, &rev.Content, &rev.CreatedAt, &rev.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (h *Handler) DocumentHistory() http.HandlerFunc {
+	tmpl := h.templates.MustRender("history")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, role := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, doc.WorkspaceID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		revisions, err := h.listRevisions(r.Context(), doc.ID)
+		if err != nil {
+			log.Printf("Error listing revisions: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// Compare the requested pair of revisions. By default the newest
+		// revision is compared against the one before it.
+		var from, to *Revision
+		for i := range revisions {
+			switch revisions[i].ID {
+			case r.URL.Query().Get("from"):
+				from = &revisions[i]
+			case r.URL.Query().Get("to"):
+				to = &revisions[i]
+			}
+		}
+		if to == nil && len(revisions) > 0 {
+			to = &revisions[0]
+		}
+		if from == nil && to != nil {
+			for i := range revisions {
+				if revisions[i].CreatedAt.Before(to.CreatedAt) {
+					from = &revisions[i]
+					break
+				}
+			}
+		}
+
+		var titleDiff, contentDiff []diff.Chunk
+		if to != nil {
+			var oldTitle, oldContent string
+			if from != nil {
+				oldTitle, oldContent = from.Title, from.Content
+			}
+			titleDiff = diff.Words(oldTitle, to.Title)
+			contentDiff = diff.Words(oldContent, to.Content)
+		}
+
+		data := map[string]any{
+			"Document":    doc,
+			"Role":        role,
+			"Switcher":    switcher,
+			"Revisions":   revisions,
+			"From":        from,
+			"To":          to,
+			"TitleDiff":   titleDiff,
+			"ContentDiff": contentDiff,
+			"Changed":     diff.Changed(titleDiff) || diff.Changed(contentDiff),
+			"Flash":       h.GetFlashMessage(w, r),
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// RestoreRevision copies a past revision back into the document. The restore
+// is recorded as a new revision so it can itself be undone.
+func (h *Handler) RestoreRevision() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeEditableDocument(w, r)
+		if doc == nil {
+			return
+		}
+
+		revisionID := r.PathValue("revisionId")
+		if _, err := uuid.Parse(revisionID); err != nil {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		query := `UPDATE documents d
+		          SET title = r.title, content = r.content, version = d.version + 1, updated_at = NOW()
+		          FROM document_revisions r
+		          WHERE d.id = $1 AND r.id = $2 AND r.document_id = d.id
+		          RETURNING d.title, COALESCE(d.content, ''), d.version, d.updated_at`
+		err = tx.QueryRowContext(r.Context(), query, doc.ID, revisionID).
+			Scan(&doc.Title, &doc.Content, &doc.Version, &doc.UpdatedAt)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, "Revision not found", http.StatusNotFound)
+				return
+			}
+			log.Printf("Error restoring revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.recordRevision(r.Context(), tx, doc, userID, false); err != nil {
+			log.Printf("Error recording revision: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.collab.Merge(r.Context(), doc.ID); err != nil {
+			log.Printf("Error merging into collaborative session: %v", err)
+		}
+
+		flash.SetSuccess(w, "Revision restored.")
+		redirect(w, r, "/documents/"+doc.ID)
+	}
+}
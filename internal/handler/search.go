@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// searchLimit caps the number of results of a search.
+const searchLimit = 50
+
+// Private use characters mark the matches in headlines. They cannot be typed
+// into a document by accident and survive HTML escaping, so the matches can be
+// highlighted after the rest of the text was escaped.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
+)
+
+// SearchResult is a document matching a search, with its title and an excerpt
+// of its content highlighted.
+type SearchResult struct {
+	ID            string
+	Title         template.HTML
+	Snippet       template.HTML
+	WorkspaceID   string
+	WorkspaceName string
+	Author        string
+	IsArchived    bool
+	UpdatedAt     time.Time
+}
+
+// highlight escapes a headline produced by ts_headline and wraps its matches
+// in mark elements.
+func highlight(headline string) template.HTML {
+	escaped := html.EscapeString(headline)
+	escaped = strings.ReplaceAll(escaped, highlightStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, highlightStop, "</mark>")
+	return template.HTML(escaped)
+}
+
+// searchDocuments runs a full-text query, combined with fuzzy matching on
+// titles, over the documents userID can view. Results are ranked by relevance.
+func (h *Handler) searchDocuments(ctx context.Context, userID string, form *validator.SearchForm) ([]SearchResult, error) {
+	args := []any{strings.TrimSpace(form.Query), userID}
+	conds := []string{
+		`(d.search_vector @@ q.query OR d.title % $1 OR $1 <% d.title)`,
+		`d.deleted_at IS NULL`,
+		roleAtLeast("d", "$2", RoleViewer),
+	}
+	// filter adds a condition on a new argument, written as ? in cond
+	filter := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, strings.ReplaceAll(cond, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if form.WorkspaceID != "" {
+		filter(`d.workspace_id = ?`, form.WorkspaceID)
+	}
+	if author := strings.TrimSpace(form.Author); author != "" {
+		filter(`(lower(u.username) = lower(?) OR lower(u.email) = lower(?))`, author)
+	}
+	if form.From != "" {
+		from, _ := time.Parse("2006-01-02", form.From)
+		filter(`d.updated_at >= ?`, from)
+	}
+	if form.To != "" {
+		to, _ := time.Parse("2006-01-02", form.To)
+		filter(`d.updated_at < ?`, to.AddDate(0, 0, 1))
+	}
+	switch form.Archived {
+	case "include":
+	case "only":
+		conds = append(conds, `d.is_archived`)
+	default:
+		conds = append(conds, `NOT d.is_archived`)
+	}
+
+	marks := `StartSel="` + highlightStart + `", StopSel="` + highlightStop + `"`
+	query := `SELECT d.id,
+		ts_headline('english', d.title, q.query, 'HighlightAll=true, ` + marks + `'),
+		ts_headline('english', COALESCE(d.content, ''), q.query,
+			'MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=" … ", ` + marks + `'),
+		d.workspace_id, w.name, u.username, d.is_archived, d.updated_at
+		FROM documents d
+		CROSS JOIN (SELECT websearch_to_tsquery('english', $1) AS query) q
+		JOIN workspaces w ON w.id = d.workspace_id
+		JOIN users u ON u.id = d.user_id
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY ts_rank(d.search_vector, q.query) + word_similarity($1, d.title) DESC, d.updated_at DESC
+		LIMIT ` + strconv.Itoa(searchLimit)
+
+	rows, err := h.db.GetDB().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []SearchResult
+	for rows.Next() {
+		var res SearchResult
+		var title, snippet string
+		err := rows.Scan(&res.ID, &title, &snippet, &res.WorkspaceID, &res.WorkspaceName, &res.Author,
+			&res.IsArchived, &res.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		res.Title = highlight(title)
+		res.Snippet = highlight(snippet)
+		results = append(results, res)
+	}
+	return results, rows.Err()
+}
+
+// Search finds documents by their title and content. The results fragment is
+// rendered alone for htmx requests so the list updates while typing.
+func (h *Handler) Search() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("search")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var form validator.SearchForm
+		validationErrs, err := v.DecodeQueryAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating search: %v", err)
+			http.Error(w, "Error processing search", http.StatusBadRequest)
+			return
+		}
+
+		var results []SearchResult
+		if strings.TrimSpace(form.Query) != "" && !validationErrs.HasErrors() {
+			results, err = h.searchDocuments(r.Context(), userID, &form)
+			if err != nil {
+				log.Printf("Error searching documents: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		data := map[string]any{
+			"Form":    &form,
+			"Errors":  validationErrs,
+			"Results": results,
+		}
+
+		if r.Header.Get("HX-Request") == "true" {
+			if err := tmpl.ExecuteTemplate(w, "search_results", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, form.WorkspaceID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data["Switcher"] = switcher
+		data["Workspaces"] = switcher.Workspaces
+		data["Flash"] = h.GetFlashMessage(w, r)
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
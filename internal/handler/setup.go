@@ -63,9 +63,19 @@ func (h *Handler) SetupForm() http.HandlerFunc {
 			return
 		}
 
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var userID string
 		query := `INSERT INTO users (username, email, password_hash, created_at, updated_at)
-		          VALUES ($1, $2, $3, NOW(), NOW())`
-		_, err = h.db.GetDB().ExecContext(r.Context(), query, form.Name, form.Email, hash)
+		          VALUES ($1, $2, $3, NOW(), NOW())
+		          RETURNING id`
+		err = tx.QueryRowContext(r.Context(), query, form.Name, form.Email, hash).Scan(&userID)
 		if err != nil {
 			// Check for duplicate email or username
 			var pgErr *pgconn.PgError
@@ -97,6 +107,23 @@ func (h *Handler) SetupForm() http.HandlerFunc {
 			return
 		}
 
+		// The first user starts out owning the default workspace of the instance
+		if _, err := createWorkspace(r.Context(), tx, "General", userID); err != nil {
+			log.Printf("Error creating default workspace: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		if _, err := h.requestEmailVerification(r, userID); err != nil {
+			log.Printf("Error requesting email verification: %v", err)
+		}
+
 		flash.SetSuccess(w, "Setup completed, please log in with your credentials.")
 
 		// Redirect to login page
@@ -0,0 +1,689 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/auth"
+	"github.com/wrytehq/wryte/internal/config"
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/validator"
+	"golang.org/x/oauth2"
+)
+
+const (
+	// ssoCookie holds the state of a sign in sent to the identity provider.
+	// It is Lax, since the provider sends the user back from another site.
+	ssoCookie = "wryte_sso"
+	// ssoLoginTTL is how long signing in at the identity provider can take.
+	ssoLoginTTL = 10 * time.Minute
+)
+
+// ssoClient talks to the identity provider, which must not hold up requests
+// for long.
+var ssoClient = &http.Client{Timeout: 10 * time.Second}
+
+// ssoVerificationRecord is the name, under a mapped domain, of the TXT record
+// proving that the workspace mapping it owns the domain. Its value is
+// ssoVerificationPrefix followed by the token of the mapping.
+const (
+	ssoVerificationRecord = "_wryte-verification."
+	ssoVerificationPrefix = "wryte-verification="
+)
+
+// lookupTXT resolves the TXT records of a name.
+var lookupTXT = net.DefaultResolver.LookupTXT
+
+// publicEmailDomains are domains of email services anyone can sign up to.
+// Mapping one would have any of their users join the workspace, so they are
+// refused whoever asks.
+var publicEmailDomains = map[string]bool{
+	"163.com": true, "126.com": true, "aol.com": true, "fastmail.com": true, "free.fr": true,
+	"gmail.com": true, "gmx.com": true, "gmx.de": true, "gmx.net": true, "googlemail.com": true,
+	"hey.com": true, "hotmail.com": true, "icloud.com": true, "laposte.net": true, "libero.it": true,
+	"live.com": true, "mac.com": true, "mail.com": true, "mail.ru": true, "me.com": true,
+	"msn.com": true, "naver.com": true, "orange.fr": true, "outlook.com": true, "pm.me": true,
+	"proton.me": true, "protonmail.com": true, "qq.com": true, "t-online.de": true, "tutanota.com": true,
+	"web.de": true, "yahoo.com": true, "yandex.com": true, "yandex.ru": true, "ymail.com": true,
+	"zoho.com": true,
+}
+
+// ssoProvider is the identity provider single sign-on goes through, with its
+// endpoints and keys as discovered from its issuer.
+type ssoProvider struct {
+	provider    *oidc.Provider
+	oauth2      oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	groupsClaim string
+}
+
+// SSOMapping has users signing in with single sign-on join a workspace.
+type SSOMapping struct {
+	ID    string
+	Kind  string
+	Value string
+	Role  string
+	// Token is what the TXT record proving the workspace owns the domain of
+	// a domain mapping holds, and Verified whether it was found. Unverified
+	// domain mappings have no effect.
+	Token    string
+	Verified bool
+}
+
+// newSSOProvider discovers the identity provider of cfg.
+func newSSOProvider(ctx context.Context, cfg config.OIDCConfig) (*ssoProvider, error) {
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, ssoClient), cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &ssoProvider{
+		provider: provider,
+		oauth2: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       append([]string{oidc.ScopeOpenID}, cfg.Scopes...),
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		groupsClaim: cfg.GroupsClaim,
+	}, nil
+}
+
+// ssoProvider returns the identity provider, discovering it the first time
+// it is needed. Failures are not kept, so that Wryte starts while the
+// provider is unreachable and signs in once it is back.
+func (h *Handler) ssoProvider(ctx context.Context) (*ssoProvider, error) {
+	h.ssoMu.Lock()
+	defer h.ssoMu.Unlock()
+
+	if h.sso == nil {
+		sso, err := newSSOProvider(ctx, h.config.Auth.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		h.sso = sso
+	}
+	return h.sso, nil
+}
+
+// ssoRedirectURL is the address the identity provider sends users back to.
+func (h *Handler) ssoRedirectURL() string {
+	if h.config.Auth.OIDC.RedirectURL != "" {
+		return h.config.Auth.OIDC.RedirectURL
+	}
+	return h.config.URL("/login/sso/callback")
+}
+
+// authCodeURL is the address of the identity provider to sign in at. The
+// nonce ties the ID token to this sign in, and the verifier proves to the
+// provider that the code is redeemed by whoever asked for it (PKCE).
+func (p *ssoProvider) authCodeURL(redirectURL, state, nonce, verifier string) string {
+	config := p.oauth2
+	config.RedirectURL = redirectURL
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// identify redeems the code the identity provider sent the user back with,
+// and returns who the ID token it answers with stands for.
+func (p *ssoProvider) identify(ctx context.Context, redirectURL, code, verifier, nonce string) (*auth.Identity, error) {
+	ctx = oidc.ClientContext(ctx, ssoClient)
+	config := p.oauth2
+	config.RedirectURL = redirectURL
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no ID token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verifying ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("reading ID token claims: %w", err)
+	}
+	identity := &auth.Identity{Issuer: idToken.Issuer, Subject: idToken.Subject}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified = claimBool(claims["email_verified"])
+	identity.Groups = claimStrings(claims[p.groupsClaim])
+	identity.Name, _ = claims["name"].(string)
+	if identity.Name == "" {
+		identity.Name, _ = claims["preferred_username"].(string)
+	}
+
+	// Some providers leave the address out of the ID token, and only hand it
+	// out at the user info endpoint
+	if identity.Email == "" && p.provider.UserInfoEndpoint() != "" {
+		info, err := p.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("querying user info: %w", err)
+		}
+		if info.Subject != identity.Subject {
+			return nil, errors.New("user info subject does not match")
+		}
+		identity.Email = info.Email
+		identity.EmailVerified = info.EmailVerified
+	}
+	identity.Email = strings.TrimSpace(identity.Email)
+	return identity, nil
+}
+
+// claimBool reads a boolean claim, which some providers send as a string.
+func claimBool(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		b, _ := strconv.ParseBool(v)
+		return b
+	}
+	return false
+}
+
+// claimStrings reads a claim holding a list of strings, or a single one.
+func claimStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// emailDomain returns the lowercased domain of an email address.
+func emailDomain(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.ToLower(domain)
+}
+
+// ssoDomainError returns why domain cannot be mapped, or an empty string when
+// it can.
+func ssoDomainError(domain string) string {
+	if publicEmailDomains[domain] {
+		return "Anyone can get an address at " + domain + ", map a domain of your organization instead"
+	}
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, " @/:") ||
+		strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return "Enter a domain such as example.com"
+	}
+	return ""
+}
+
+// trustedSSODomain reports whether the instance lets workspaces map domain
+// without proving they own it.
+func (h *Handler) trustedSSODomain(domain string) bool {
+	return slices.Contains(h.config.Auth.OIDC.TrustedDomains, domain)
+}
+
+// ownsSSODomain reports whether the TXT record proving ownership of domain
+// holds token.
+func ownsSSODomain(ctx context.Context, domain, token string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	records, err := lookupTXT(ctx, ssoVerificationRecord+domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == ssoVerificationPrefix+token {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// joinMappedWorkspaces adds the user of an identity of the identity provider
+// or the directory to the workspaces mapped to the domain of their verified
+// email address or to one of their groups, with the highest role mapped.
+// Domains only count once the workspace proved it owns them. Workspaces the
+// user already belongs to are left as they are.
+func joinMappedWorkspaces(ctx context.Context, tx *sql.Tx, userID string, identity *auth.Identity) error {
+	domain := ""
+	if identity.EmailVerified {
+		domain = emailDomain(identity.Email)
+	}
+	groups := identity.Groups
+	if groups == nil {
+		groups = []string{}
+	}
+
+	query := `INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)
+	          SELECT DISTINCT ON (m.workspace_id) m.workspace_id, $1, m.role, NOW(), NOW()
+	          FROM sso_mappings m
+	          WHERE (m.kind = 'domain' AND m.value = $2 AND m.verified_at IS NOT NULL)
+	             OR (m.kind = 'group' AND m.value = ANY($3))
+	          ORDER BY m.workspace_id, m.role = 'admin' DESC
+	          ON CONFLICT (workspace_id, user_id) DO NOTHING`
+	_, err := tx.ExecContext(ctx, query, userID, domain, groups)
+	return err
+}
+
+// SSOLogin sends the user to sign in at the identity provider, which sends
+// them back to SSOCallback.
+func (h *Handler) SSOLogin() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		provider, err := h.ssoProvider(r.Context())
+		if err != nil {
+			log.Printf("Error discovering identity provider: %v", err)
+			flash.SetError(w, h.config.Auth.OIDC.Name+" cannot be reached, try again later.")
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		if _, err := h.db.GetDB().ExecContext(r.Context(), `DELETE FROM sso_logins WHERE expires_at < NOW()`); err != nil {
+			log.Printf("Error deleting expired sign ins: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		state := rand.Text()
+		nonce := rand.Text()
+		verifier := oauth2.GenerateVerifier()
+		expiresAt := time.Now().Add(ssoLoginTTL)
+		query := `INSERT INTO sso_logins (state_hash, nonce, code_verifier, next, expires_at, created_at)
+		          VALUES ($1, $2, $3, $4, $5, NOW())`
+		_, err = h.db.GetDB().ExecContext(r.Context(), query, hashToken(state), nonce, verifier,
+			localPath(r.URL.Query().Get("next")), expiresAt)
+		if err != nil {
+			log.Printf("Error creating sign in: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     ssoCookie,
+			Value:    state,
+			Expires:  expiresAt,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/login/sso",
+		})
+		http.Redirect(w, r, provider.authCodeURL(h.ssoRedirectURL(), state, nonce, verifier), http.StatusSeeOther)
+	}
+}
+
+// SSOCallback finishes a sign in at the identity provider. The account of
+// the user is found or created, joins the workspaces mapped to it, and the
+// session starts, after the second factor when the account has one.
+func (h *Handler) SSOCallback() http.HandlerFunc {
+	tmpl := h.templates.MustRender("auth/sso_redirect")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := h.config.Auth.OIDC.Name
+		fail := func(message string) {
+			flash.SetError(w, message)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+		}
+
+		query := r.URL.Query()
+		state := query.Get("state")
+		cookie, err := r.Cookie(ssoCookie)
+		http.SetCookie(w, &http.Cookie{
+			Name:     ssoCookie,
+			Value:    "",
+			MaxAge:   -1,
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteLaxMode,
+			Path:     "/login/sso",
+		})
+		if err != nil || state == "" || cookie.Value != state {
+			fail("Your sign in expired, try again.")
+			return
+		}
+
+		var nonce, verifier, next string
+		row := h.db.GetDB().QueryRowContext(r.Context(),
+			`DELETE FROM sso_logins WHERE state_hash = $1 AND expires_at > NOW() RETURNING nonce, code_verifier, next`,
+			hashToken(state))
+		err = row.Scan(&nonce, &verifier, &next)
+		if errors.Is(err, sql.ErrNoRows) {
+			fail("Your sign in expired, try again.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying sign in: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if e := query.Get("error"); e != "" {
+			log.Printf("Identity provider refused sign in: %s: %s", e, query.Get("error_description"))
+			fail("Signing in with " + name + " did not succeed.")
+			return
+		}
+
+		provider, err := h.ssoProvider(r.Context())
+		if err != nil {
+			log.Printf("Error discovering identity provider: %v", err)
+			fail(name + " cannot be reached, try again later.")
+			return
+		}
+		identity, err := provider.identify(r.Context(), h.ssoRedirectURL(), query.Get("code"), verifier, nonce)
+		if err != nil {
+			log.Printf("Error verifying sign in with identity provider: %v", err)
+			fail("Signing in with " + name + " did not succeed.")
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		userID, created, err := findExternalUser(r.Context(), tx, identity)
+		if errors.Is(err, errNoEmail) {
+			log.Printf("Refused sign in of %s: %v", identity.Subject, err)
+			fail(name + " did not share your email address, which Wryte needs.")
+			return
+		}
+		if errors.Is(err, errEmailTaken) {
+			log.Printf("Refused sign in of %s: %v", identity.Subject, err)
+			if err := h.requestIdentityLink(r, identity); err != nil {
+				log.Printf("Error requesting identity link: %v", err)
+			}
+			fail("An account already uses " + identity.Email + ". We sent it a link to connect your " + name + " account, follow it and sign in again.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error provisioning user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := joinMappedWorkspaces(r.Context(), tx, userID, identity); err != nil {
+			log.Printf("Error adding user to workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		var twoFactor bool
+		err = tx.QueryRowContext(r.Context(), `SELECT totp_enabled_at IS NOT NULL
+			OR EXISTS (SELECT 1 FROM passkeys p WHERE p.user_id = users.id)
+			FROM users WHERE id = $1`, userID).Scan(&twoFactor)
+		if err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if created && !identity.EmailVerified {
+			if _, err := h.requestEmailVerification(r, userID); err != nil {
+				log.Printf("Error requesting email verification: %v", err)
+			}
+		}
+
+		target := localPath(next)
+		if twoFactor {
+			if err := h.startLoginChallenge(w, r, userID, next); err != nil {
+				log.Printf("Error creating login challenge: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			target = "/login/two-factor"
+		} else if err := h.startSession(w, r, userID); err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// The provider sent the user here from another site, so a redirect
+		// would arrive without the strict session cookie. Navigating from a
+		// page of Wryte sends it.
+		if err := tmpl.ExecuteTemplate(w, "layout.html", map[string]any{"Next": target}); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// listSSOMappings returns the single sign-on mappings of a workspace.
+func (h *Handler) listSSOMappings(ctx context.Context, workspaceID string) ([]SSOMapping, error) {
+	query := `SELECT id, kind, value, role, COALESCE(verification_token, ''), verified_at IS NOT NULL
+	          FROM sso_mappings WHERE workspace_id = $1 ORDER BY kind, value`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var mappings []SSOMapping
+	for rows.Next() {
+		var m SSOMapping
+		if err := rows.Scan(&m.ID, &m.Kind, &m.Value, &m.Role, &m.Token, &m.Verified); err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, m)
+	}
+	return mappings, rows.Err()
+}
+
+// ssoMappingsData is the data of the single sign-on panel of a workspace.
+func (h *Handler) ssoMappingsData(ctx context.Context, workspace *Workspace, form *validator.SSOMappingForm) (map[string]any, error) {
+	mappings, err := h.listSSOMappings(ctx, workspace.ID)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{
+		"Workspace": workspace,
+		"Mappings":  mappings,
+		"Form":      form,
+		"SSOName":   h.mappingsProviderName(),
+		"Record":    ssoVerificationRecord,
+		"Prefix":    ssoVerificationPrefix,
+	}, nil
+}
+
+// mappingsProviderName names what users sign in with to join workspaces by
+// their mappings.
+func (h *Handler) mappingsProviderName() string {
+	if h.config.Auth.OIDC.Enabled() {
+		return h.config.Auth.OIDC.Name
+	}
+	return "the directory"
+}
+
+// renderSSOMappings renders the single sign-on panel of a workspace.
+func (h *Handler) renderSSOMappings(w http.ResponseWriter, r *http.Request, tmpl *template.Template, workspace *Workspace, form *validator.SSOMappingForm, errs *validator.ValidationErrors) {
+	data, err := h.ssoMappingsData(r.Context(), workspace, form)
+	if err != nil {
+		log.Printf("Error listing single sign-on mappings: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data["Errors"] = errs
+
+	if errs != nil && errs.HasErrors() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, "workspace_sso", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// AddSSOMapping has users signing in with single sign-on join the workspace
+// by the domain of their email address, or a group they belong to. Domains
+// the instance does not trust wait for the workspace to prove it owns them.
+func (h *Handler) AddSSOMapping() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		var form validator.SSOMappingForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		form.Value = strings.TrimSpace(form.Value)
+		if form.Kind == "domain" {
+			form.Value = strings.ToLower(strings.TrimPrefix(form.Value, "@"))
+		}
+		if form.Value == "" && !validationErrs.Has("value") {
+			validationErrs.AddError("value", "Value is required")
+		}
+		if form.Kind == "domain" && !validationErrs.Has("value") {
+			if message := ssoDomainError(form.Value); message != "" {
+				validationErrs.AddError("value", message)
+			}
+		}
+		if validationErrs.HasErrors() {
+			h.renderSSOMappings(w, r, tmpl, workspace, &form, validationErrs)
+			return
+		}
+
+		var token sql.NullString
+		if form.Kind == "domain" {
+			token = sql.NullString{String: rand.Text(), Valid: true}
+		}
+		trusted := form.Kind == "domain" && h.trustedSSODomain(form.Value)
+		query := `INSERT INTO sso_mappings (workspace_id, kind, value, role, verification_token, verified_at, created_at)
+		          VALUES ($1, $2, $3, $4, $5, CASE WHEN $6 THEN NOW() END, NOW())
+		          ON CONFLICT (workspace_id, kind, value) DO UPDATE
+		          SET role = EXCLUDED.role, verified_at = COALESCE(sso_mappings.verified_at, EXCLUDED.verified_at)`
+		_, err = h.db.GetDB().ExecContext(r.Context(), query, workspace.ID, form.Kind, form.Value, form.Role, token, trusted)
+		if err != nil {
+			log.Printf("Error creating single sign-on mapping: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderSSOMappings(w, r, tmpl, workspace, &validator.SSOMappingForm{Kind: form.Kind, Role: form.Role}, nil)
+	}
+}
+
+// VerifySSOMapping looks for the TXT record proving that the workspace owns
+// the domain of a mapping, which has users join from then on.
+func (h *Handler) VerifySSOMapping() http.HandlerFunc {
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		mappingID := r.PathValue("mappingId")
+		if _, err := uuid.Parse(mappingID); err != nil {
+			http.Error(w, "Mapping not found", http.StatusNotFound)
+			return
+		}
+
+		var domain, token string
+		var verified bool
+		query := `SELECT value, COALESCE(verification_token, ''), verified_at IS NOT NULL
+		          FROM sso_mappings WHERE id = $1 AND workspace_id = $2 AND kind = 'domain'`
+		err := h.db.GetDB().QueryRowContext(r.Context(), query, mappingID, workspace.ID).Scan(&domain, &token, &verified)
+		if errors.Is(err, sql.ErrNoRows) {
+			http.Error(w, "Mapping not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying single sign-on mapping: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		errs := &validator.ValidationErrors{}
+		if !verified {
+			owned := false
+			if message := ssoDomainError(domain); message != "" {
+				errs.AddError("mappings", message)
+			} else if h.trustedSSODomain(domain) {
+				owned = true
+			} else if owned, err = ownsSSODomain(r.Context(), domain, token); err != nil {
+				log.Printf("Error looking up TXT record of %s: %v", domain, err)
+				errs.AddError("mappings", "The DNS records of "+domain+" could not be read, try again later.")
+			} else if !owned {
+				errs.AddError("mappings", "No TXT record at "+ssoVerificationRecord+domain+
+					" holds the token yet. DNS changes can take a while to show up.")
+			}
+
+			if owned {
+				query := `UPDATE sso_mappings SET verified_at = NOW() WHERE id = $1 AND verified_at IS NULL`
+				if _, err := h.db.GetDB().ExecContext(r.Context(), query, mappingID); err != nil {
+					log.Printf("Error verifying single sign-on mapping: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		h.renderSSOMappings(w, r, tmpl, workspace, &validator.SSOMappingForm{Kind: "domain", Role: WorkspaceMember.String()}, errs)
+	}
+}
+
+// DeleteSSOMapping stops users signing in with single sign-on from joining
+// the workspace by a mapping. Members who joined by it stay.
+func (h *Handler) DeleteSSOMapping() http.HandlerFunc {
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		mappingID := r.PathValue("mappingId")
+		if _, err := uuid.Parse(mappingID); err != nil {
+			http.Error(w, "Mapping not found", http.StatusNotFound)
+			return
+		}
+
+		query := `DELETE FROM sso_mappings WHERE id = $1 AND workspace_id = $2`
+		if _, err := h.db.GetDB().ExecContext(r.Context(), query, mappingID, workspace.ID); err != nil {
+			log.Printf("Error deleting single sign-on mapping: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderSSOMappings(w, r, tmpl, workspace, &validator.SSOMappingForm{Kind: "domain", Role: WorkspaceMember.String()}, nil)
+	}
+}
@@ -0,0 +1,322 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/config"
+	"golang.org/x/oauth2"
+)
+
+const ssoCallback = "https://wryte.example/login/sso/callback"
+
+// mockProvider is an OpenID Connect provider answering the authorization
+// code flow. It hands out the code "c0de" to whoever holds the verifier of
+// the challenge it was given, and signs ID tokens with its own key, or with
+// signer when set.
+type mockProvider struct {
+	srv       *httptest.Server
+	key       *rsa.PrivateKey
+	signer    *rsa.PrivateKey
+	challenge string
+	nonce     string
+	expiry    time.Duration
+	// claims are added to the ID token, and info is what the user info
+	// endpoint answers
+	claims map[string]any
+	info   map[string]any
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{key: key, expiry: time.Minute}
+
+	enc := base64.RawURLEncoding
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                m.srv.URL,
+			"authorization_endpoint":                m.srv.URL + "/authorize",
+			"token_endpoint":                        m.srv.URL + "/token",
+			"jwks_uri":                              m.srv.URL + "/keys",
+			"userinfo_endpoint":                     m.srv.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   enc.EncodeToString(m.key.N.Bytes()),
+			"e":   enc.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if r.Form.Get("code") != "c0de" || enc.EncodeToString(sum[:]) != m.challenge {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		claims := map[string]any{
+			"iss":   m.srv.URL,
+			"aud":   "wryte",
+			"sub":   "user-1",
+			"nonce": m.nonce,
+			"iat":   time.Now().Unix(),
+			"exp":   time.Now().Add(m.expiry).Unix(),
+		}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     m.idToken(t, claims),
+		})
+	})
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer access" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(m.info)
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockProvider) idToken(t *testing.T, claims map[string]any) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+
+	key := m.key
+	if m.signer != nil {
+		key = m.signer
+	}
+	sum := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + enc.EncodeToString(signature)
+}
+
+// start begins a sign in the way SSOLogin does, and returns the verifier to
+// redeem the code with.
+func (m *mockProvider) start(t *testing.T, p *ssoProvider, nonce string) string {
+	t.Helper()
+	verifier := oauth2.GenerateVerifier()
+	u, err := url.Parse(p.authCodeURL(ssoCallback, "state", nonce, verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("redirect_uri") != ssoCallback || q.Get("state") != "state" {
+		t.Fatalf("authorization URL = %s", u)
+	}
+	m.challenge = q.Get("code_challenge")
+	m.nonce = q.Get("nonce")
+	return verifier
+}
+
+func newTestSSOProvider(t *testing.T, m *mockProvider, clientID string) *ssoProvider {
+	t.Helper()
+	p, err := newSSOProvider(t.Context(), config.OIDCConfig{
+		Issuer:      m.srv.URL,
+		ClientID:    clientID,
+		Scopes:      []string{"email", "profile"},
+		GroupsClaim: "groups",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSSOIdentify(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestSSOProvider(t, m, "wryte")
+	m.claims = map[string]any{
+		"email":          " Ann@Corp.example ",
+		"email_verified": "true",
+		"name":           "Ann",
+		"groups":         []string{"eng", "ops"},
+	}
+
+	verifier := m.start(t, p, "nonce")
+	identity, err := p.identify(t.Context(), ssoCallback, "c0de", verifier, "nonce")
+	if err != nil {
+		t.Fatalf("identify() = %v", err)
+	}
+	if identity.Issuer != m.srv.URL || identity.Subject != "user-1" {
+		t.Errorf("identity is %s at %s", identity.Subject, identity.Issuer)
+	}
+	if identity.Email != "Ann@Corp.example" || !identity.EmailVerified || identity.Name != "Ann" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"eng", "ops"}) {
+		t.Errorf("Groups = %q, want [eng ops]", identity.Groups)
+	}
+}
+
+func TestSSOIdentifyUserInfo(t *testing.T) {
+	m := newMockProvider(t)
+	p := newTestSSOProvider(t, m, "wryte")
+	m.claims = map[string]any{"preferred_username": "ann", "groups": "eng"}
+	m.info = map[string]any{"sub": "user-1", "email": "ann@corp.example", "email_verified": true}
+
+	verifier := m.start(t, p, "nonce")
+	identity, err := p.identify(t.Context(), ssoCallback, "c0de", verifier, "nonce")
+	if err != nil {
+		t.Fatalf("identify() = %v", err)
+	}
+	if identity.Email != "ann@corp.example" || !identity.EmailVerified || identity.Name != "ann" {
+		t.Errorf("identity = %+v", identity)
+	}
+	if !reflect.DeepEqual(identity.Groups, []string{"eng"}) {
+		t.Errorf("Groups = %q, want [eng]", identity.Groups)
+	}
+
+	// The address of another user is not taken
+	m.info["sub"] = "user-2"
+	verifier = m.start(t, p, "nonce")
+	if _, err := p.identify(t.Context(), ssoCallback, "c0de", verifier, "nonce"); err == nil {
+		t.Error("identify() took the user info of another subject")
+	}
+}
+
+func TestSSOIdentifyRefuses(t *testing.T) {
+	tests := []struct {
+		name     string
+		clientID string
+		setup    func(m *mockProvider)
+		verifier string
+		nonce    string
+		want     string
+	}{
+		{name: "other verifier", verifier: oauth2.GenerateVerifier(), want: "exchanging code"},
+		{name: "other nonce", nonce: "other", want: "nonce"},
+		{name: "other audience", clientID: "other", want: "verifying ID token"},
+		{name: "expired token", setup: func(m *mockProvider) { m.expiry = -time.Hour }, want: "verifying ID token"},
+		{
+			name: "forged signature",
+			setup: func(m *mockProvider) {
+				m.signer, _ = rsa.GenerateKey(rand.Reader, 2048)
+			},
+			want: "verifying ID token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			clientID := tt.clientID
+			if clientID == "" {
+				clientID = "wryte"
+			}
+			p := newTestSSOProvider(t, m, clientID)
+			m.claims = map[string]any{"email": "ann@corp.example", "email_verified": true}
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+
+			verifier := m.start(t, p, "nonce")
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			nonce := "nonce"
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			_, err := p.identify(t.Context(), ssoCallback, "c0de", verifier, nonce)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("identify() = %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSODomainError(t *testing.T) {
+	tests := []struct {
+		domain string
+		ok     bool
+	}{
+		{"corp.example", true},
+		{"mail.corp.example", true},
+		{"gmail.com", false},
+		{"outlook.com", false},
+		{"localhost", false},
+		{"corp.example/x", false},
+		{"ann@corp.example", false},
+		{".corp.example", false},
+	}
+
+	for _, tt := range tests {
+		if got := ssoDomainError(tt.domain); (got == "") != tt.ok {
+			t.Errorf("ssoDomainError(%q) = %q", tt.domain, got)
+		}
+	}
+}
+
+func TestOwnsSSODomain(t *testing.T) {
+	records := map[string][]string{
+		"_wryte-verification.corp.example":  {"v=spf1 -all", " wryte-verification=token "},
+		"_wryte-verification.other.example": {"wryte-verification=other"},
+	}
+	lookup := lookupTXT
+	lookupTXT = func(ctx context.Context, name string) ([]string, error) {
+		if name == "_wryte-verification.broken.example" {
+			return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+		}
+		if r, ok := records[name]; ok {
+			return r, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	t.Cleanup(func() { lookupTXT = lookup })
+
+	tests := []struct {
+		domain string
+		want   bool
+		err    bool
+	}{
+		{"corp.example", true, false},
+		{"other.example", false, false},
+		{"missing.example", false, false},
+		{"broken.example", false, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ownsSSODomain(t.Context(), tt.domain, "token")
+		if got != tt.want || (err != nil) != tt.err {
+			t.Errorf("ownsSSODomain(%q) = %t, %v", tt.domain, got, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/flash"
+)
+
+// trashPurgeInterval is how often documents whose retention ran out are
+// purged.
+const trashPurgeInterval = time.Hour
+
+// TrashedDocument is an entry of the trash: a document deleted together with
+// its subtree, whose parent is still in place.
+type TrashedDocument struct {
+	ID        string
+	Title     string
+	DeletedAt time.Time
+	DeletedBy string
+	// PurgeAt is when the document is deleted for good, zero when it is kept
+	// until the trash is emptied.
+	PurgeAt time.Time
+}
+
+// listTrash returns the entries of the trash of a workspace that the user may
+// restore, most recently deleted first.
+func (h *Handler) listTrash(ctx context.Context, workspaceID, userID string) ([]TrashedDocument, error) {
+	query := `SELECT d.id, d.title, d.deleted_at, COALESCE(u.username, '')
+		FROM documents d
+		LEFT JOIN documents p ON p.id = d.parent_id
+		LEFT JOIN users u ON u.id = d.deleted_by
+		WHERE d.workspace_id = $1 AND d.deleted_at IS NOT NULL
+		AND (p.id IS NULL OR p.deleted_at IS NULL)
+		AND ` + roleAtLeast("d", "$2", RoleOwner) + `
+		ORDER BY d.deleted_at DESC`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []TrashedDocument
+	for rows.Next() {
+		var e TrashedDocument
+		if err := rows.Scan(&e.ID, &e.Title, &e.DeletedAt, &e.DeletedBy); err != nil {
+			return nil, err
+		}
+		if retention := h.config.Document.TrashRetention; retention > 0 {
+			e.PurgeAt = e.DeletedAt.Add(retention)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// renderTrash renders the list of trashed documents of a workspace. A non-empty
+// message is shown above the list with a conflict status.
+func (h *Handler) renderTrash(w http.ResponseWriter, r *http.Request, tmpl *template.Template, workspace *Workspace, userID, message string) {
+	entries, err := h.listTrash(r.Context(), workspace.ID, userID)
+	if err != nil {
+		log.Printf("Error listing trash: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Workspace": workspace,
+		"Trash":     entries,
+		"Error":     message,
+	}
+	if message != "" {
+		w.WriteHeader(http.StatusConflict)
+	}
+	if err := tmpl.ExecuteTemplate(w, "trash_list", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// Trash shows the trash of a workspace.
+func (h *Handler) Trash() http.HandlerFunc {
+	tmpl := h.templates.MustRender("trash")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		entries, err := h.listTrash(r.Context(), workspace.ID, userID)
+		if err != nil {
+			log.Printf("Error listing trash: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, workspace.ID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Workspace": workspace,
+			"Switcher":  switcher,
+			"Trash":     entries,
+			"Retention": int(h.config.Document.TrashRetention.Hours() / 24),
+			"Flash":     h.GetFlashMessage(w, r),
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// trashDocument moves doc together with every document nested beneath it to
+// the trash of its workspace. Documents trashed in one go share their deletion
+// time, which is how they are restored together.
+func (h *Handler) trashDocument(ctx context.Context, doc *Document, userID string) error {
+	query := `UPDATE documents SET deleted_at = NOW(), deleted_by = $2
+	          WHERE (document_path = $1 OR document_path LIKE $1 || '/%') AND deleted_at IS NULL`
+	_, err := h.db.GetDB().ExecContext(ctx, query, doc.DocumentPath, userID)
+	return err
+}
+
+// RestoreDocument takes a document out of the trash together with the part of
+// its subtree that was deleted with it. Documents deleted on their own before
+// stay in the trash.
+func (h *Handler) RestoreDocument() http.HandlerFunc {
+	tmpl := h.templates.MustRender("trash")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeTrashedDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		if doc.ParentID != "" {
+			if _, err := h.findTrashedDocument(r.Context(), doc.ParentID); err == nil {
+				workspace, err := h.findWorkspace(r.Context(), doc.WorkspaceID)
+				if err != nil {
+					log.Printf("Error querying workspace: %v", err)
+					http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+					return
+				}
+				h.renderTrash(w, r, tmpl, workspace, userID, "Restore the parent of "+doc.Title+" first.")
+				return
+			}
+		}
+
+		query := `UPDATE documents SET deleted_at = NULL, deleted_by = NULL, updated_at = NOW()
+		          WHERE (document_path = $1 OR document_path LIKE $1 || '/%') AND deleted_at = $2`
+		_, err := h.db.GetDB().ExecContext(r.Context(), query, doc.DocumentPath, doc.DeletedAt.Time)
+		if err != nil {
+			log.Printf("Error restoring document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Document restored.")
+		redirect(w, r, "/documents/"+doc.ID)
+	}
+}
+
+// PurgeDocument deletes a trashed document and its subtree for good.
+func (h *Handler) PurgeDocument() http.HandlerFunc {
+	tmpl := h.templates.MustRender("trash")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, userID, _ := h.authorizeTrashedDocument(w, r, RoleOwner)
+		if doc == nil {
+			return
+		}
+
+		condition := `document_path = $1 OR document_path LIKE $1 || '/%'`
+		if _, err := h.purgeDocuments(r.Context(), condition, doc.DocumentPath); err != nil {
+			log.Printf("Error purging document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		workspace, err := h.findWorkspace(r.Context(), doc.WorkspaceID)
+		if err != nil {
+			log.Printf("Error querying workspace: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		h.renderTrash(w, r, tmpl, workspace, userID, "")
+	}
+}
+
+// EmptyTrash deletes every trashed document of a workspace for good.
+func (h *Handler) EmptyTrash() http.HandlerFunc {
+	tmpl := h.templates.MustRender("trash")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		// The descendants of a trashed document are all trashed as well, so
+		// the deleted rows never leave a child without its parent
+		condition := `workspace_id = $1 AND deleted_at IS NOT NULL`
+		if _, err := h.purgeDocuments(r.Context(), condition, workspace.ID); err != nil {
+			log.Printf("Error emptying trash: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		h.renderTrash(w, r, tmpl, workspace, userID, "")
+	}
+}
+
+// purgeTrash deletes documents that stayed in the trash longer than the
+// configured retention, until ctx is cancelled.
+func (h *Handler) purgeTrash(ctx context.Context) {
+	defer close(h.purgeDone)
+
+	retention := h.config.Document.TrashRetention
+	if retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		n, err := h.purgeExpired(ctx, retention)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error purging trash: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d documents from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeExpired deletes the documents trashed more than retention ago. A
+// descendant is never trashed after its ancestor, so whole subtrees expire
+// together.
+func (h *Handler) purgeExpired(ctx context.Context, retention time.Duration) (int64, error) {
+	return h.purgeDocuments(ctx, `deleted_at < NOW() - make_interval(secs => $1)`, retention.Seconds())
+}
+
+// purgeDocuments deletes the documents matching the condition for good,
+// together with the files attached to them.
+func (h *Handler) purgeDocuments(ctx context.Context, condition string, args ...any) (int64, error) {
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	keys, n, err := deleteDocuments(ctx, tx, condition, args...)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	removeBlobs(h.storage, keys)
+	return n, nil
+}
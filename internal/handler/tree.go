@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/middleware"
+)
+
+// DocumentNode is a single entry of the document tree or a breadcrumb trail.
+type DocumentNode struct {
+	ID          string
+	Title       string
+	HasChildren bool
+	Depth       int
+}
+
+// listChildDocuments returns the direct children of parentID in the workspace,
+// or the root documents when parentID is empty.
+func (h *Handler) listChildDocuments(ctx context.Context, workspaceID, parentID string) ([]DocumentNode, error) {
+	query := `SELECT d.id, d.title,
+		EXISTS (SELECT 1 FROM documents c WHERE c.parent_id = d.id AND c.deleted_at IS NULL AND NOT c.is_archived)
+		FROM documents d
+		WHERE d.workspace_id = $1 AND d.parent_id IS NOT DISTINCT FROM $2
+		AND d.deleted_at IS NULL AND NOT d.is_archived
+		ORDER BY d.title, d.created_at`
+
+	var parent sql.NullString
+	if parentID != "" {
+		parent = sql.NullString{String: parentID, Valid: true}
+	}
+
+	rows, err := h.db.GetDB().QueryContext(ctx, query, workspaceID, parent)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []DocumentNode
+	for rows.Next() {
+		var node DocumentNode
+		if err := rows.Scan(&node.ID, &node.Title, &node.HasChildren); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// listSharedDocuments returns the documents shared with the user that live
+// outside of the workspaces they belong to.
+func (h *Handler) listSharedDocuments(ctx context.Context, userID string) ([]DocumentNode, error) {
+	query := `SELECT DISTINCT d.id, d.title,
+		EXISTS (SELECT 1 FROM documents c WHERE c.parent_id = d.id AND c.deleted_at IS NULL AND NOT c.is_archived)
+		FROM documents d
+		JOIN document_permissions p ON p.document_id = d.id
+		WHERE (p.user_id = $1 OR p.workspace_id IN (` + memberWorkspaces("$1") + `))
+		AND d.workspace_id NOT IN (` + memberWorkspaces("$1") + `)
+		AND d.deleted_at IS NULL AND NOT d.is_archived
+		ORDER BY d.title`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var nodes []DocumentNode
+	for rows.Next() {
+		var node DocumentNode
+		if err := rows.Scan(&node.ID, &node.Title, &node.HasChildren); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, rows.Err()
+}
+
+// documentAncestors returns the ancestors of doc from the root down, derived
+// from its document path. Ancestors the user cannot view are left out, which
+// happens when only a subpage was shared with them.
+func (h *Handler) documentAncestors(ctx context.Context, doc *Document, userID string) ([]DocumentNode, error) {
+	ids := strings.Split(strings.Trim(doc.DocumentPath, "/"), "/")
+	if len(ids) < 2 {
+		return nil, nil
+	}
+	ids = ids[:len(ids)-1]
+
+	query := `SELECT d.id, d.title FROM documents d
+		WHERE d.id = ANY($1::uuid[]) AND ` + roleAtLeast("d", "$2", RoleViewer)
+	rows, err := h.db.GetDB().QueryContext(ctx, query, ids, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	titles := make(map[string]string, len(ids))
+	for rows.Next() {
+		var id, title string
+		if err := rows.Scan(&id, &title); err != nil {
+			return nil, err
+		}
+		titles[id] = title
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	ancestors := make([]DocumentNode, 0, len(ids))
+	for depth, id := range ids {
+		if title, ok := titles[id]; ok {
+			ancestors = append(ancestors, DocumentNode{ID: id, Title: title, Depth: depth})
+		}
+	}
+	return ancestors, nil
+}
+
+// moveTargets lists every document in the workspace that doc could be moved
+// under, that is everything outside of doc's own subtree that the user may
+// edit, in tree order.
+func (h *Handler) moveTargets(ctx context.Context, doc *Document, userID string) ([]DocumentNode, error) {
+	query := `SELECT d.id, d.title, d.document_path FROM documents d
+		WHERE d.workspace_id = $1 AND d.document_path <> $2 AND d.document_path NOT LIKE $2 || '/%'
+		AND d.deleted_at IS NULL AND NOT d.is_archived
+		AND ` + roleAtLeast("d", "$3", RoleEditor) + `
+		ORDER BY d.document_path`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, doc.WorkspaceID, doc.DocumentPath, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var targets []DocumentNode
+	for rows.Next() {
+		var node DocumentNode
+		var path string
+		if err := rows.Scan(&node.ID, &node.Title, &path); err != nil {
+			return nil, err
+		}
+		node.Depth = strings.Count(path, "/") - 1
+		targets = append(targets, node)
+	}
+	return targets, rows.Err()
+}
+
+// DocumentChildren renders the children of a document as a tree fragment, used
+// to lazily expand the sidebar tree.
+func (h *Handler) DocumentChildren() http.HandlerFunc {
+	tmpl := h.templates.MustRender("home")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, _, _ := h.authorizeDocument(w, r, RoleViewer)
+		if doc == nil {
+			return
+		}
+
+		nodes, err := h.listChildDocuments(r.Context(), doc.WorkspaceID, doc.ID)
+		if err != nil {
+			log.Printf("Error listing child documents: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tmpl.ExecuteTemplate(w, "document_tree", nodes); err != nil {
+			log.Printf("Error rendering template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// MoveDocument re-parents a document. The document path of the document and
+// of every descendant is rewritten in a single transaction so the tree is never
+// observed half moved.
+func (h *Handler) MoveDocument() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		id, err := uuid.Parse(r.PathValue("documentId"))
+		if err != nil {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+		documentID := id.String()
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		var parentID string
+		if r.PostForm.Get("parentId") != "" {
+			id, err := uuid.Parse(r.PostForm.Get("parentId"))
+			if err != nil {
+				http.Error(w, "Invalid parent document", http.StatusBadRequest)
+				return
+			}
+			parentID = id.String()
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Lock the moved document and its new parent so concurrent moves cannot
+		// produce a cycle. Both rows are locked in id order in one statement, so
+		// moves in opposite directions wait for each other instead of deadlocking
+		lockQuery := `SELECT ` + documentColumns + ` FROM documents
+		              WHERE id IN ($1, $2) AND deleted_at IS NULL ORDER BY id FOR UPDATE`
+		lockParentID := parentID
+		if lockParentID == "" {
+			lockParentID = documentID
+		}
+		rows, err := tx.QueryContext(r.Context(), lockQuery, documentID, lockParentID)
+		if err != nil {
+			log.Printf("Error querying document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		var doc, parent *Document
+		for rows.Next() {
+			d, err := scanDocument(rows)
+			if err != nil {
+				rows.Close()
+				log.Printf("Error scanning document: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if d.ID == documentID {
+				doc = d
+			}
+			if d.ID == parentID {
+				parent = d
+			}
+		}
+		if err := rows.Err(); err != nil {
+			log.Printf("Error querying document: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if doc == nil {
+			http.Error(w, "Document not found", http.StatusNotFound)
+			return
+		}
+
+		role, err := h.documentRole(r.Context(), doc, userID)
+		if err != nil {
+			log.Printf("Error querying document role: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !role.CanManage() {
+			http.Error(w, "Forbidden - You don't have access to this document", http.StatusForbidden)
+			return
+		}
+		if doc.IsArchived {
+			http.Error(w, archivedError, http.StatusConflict)
+			return
+		}
+
+		newPath := "/" + doc.ID
+		workspaceID := doc.WorkspaceID
+		var newParent sql.NullString
+
+		if parentID != "" {
+			if parent == nil {
+				http.Error(w, "Parent document not found", http.StatusBadRequest)
+				return
+			}
+
+			parentRole, err := h.documentRole(r.Context(), parent, userID)
+			if err != nil {
+				log.Printf("Error querying document role: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !parentRole.CanEdit() {
+				http.Error(w, "Forbidden - You don't have access to this document", http.StatusForbidden)
+				return
+			}
+			if parent.IsArchived {
+				http.Error(w, archivedError, http.StatusConflict)
+				return
+			}
+
+			if parent.DocumentPath == doc.DocumentPath || strings.HasPrefix(parent.DocumentPath, doc.DocumentPath+"/") {
+				http.Error(w, "A document cannot be moved inside itself", http.StatusBadRequest)
+				return
+			}
+
+			newPath = parent.DocumentPath + "/" + doc.ID
+			workspaceID = parent.WorkspaceID
+			newParent = sql.NullString{String: parent.ID, Valid: true}
+		} else {
+			// The top level of a workspace is only reachable by its members
+			member, err := h.isWorkspaceMember(r.Context(), workspaceID, userID)
+			if err != nil {
+				log.Printf("Error querying workspace: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !member {
+				http.Error(w, "Forbidden - You don't have access to this workspace", http.StatusForbidden)
+				return
+			}
+		}
+
+		_, err = tx.ExecContext(r.Context(), `UPDATE documents SET parent_id = $1 WHERE id = $2`, newParent, doc.ID)
+		if err != nil {
+			log.Printf("Error updating document parent: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		subtreeQuery := `UPDATE documents
+		                 SET document_path = $2 || substring(document_path FROM length($1) + 1),
+		                     workspace_id = $3,
+		                     updated_at = NOW()
+		                 WHERE document_path = $1 OR document_path LIKE $1 || '/%'`
+		_, err = tx.ExecContext(r.Context(), subtreeQuery, doc.DocumentPath, newPath, workspaceID)
+		if err != nil {
+			log.Printf("Error updating document paths: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		redirect(w, r, "/documents/"+doc.ID)
+	}
+}
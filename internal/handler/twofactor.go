@@ -0,0 +1,655 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/totp"
+	"github.com/wrytehq/wryte/internal/validator"
+	"rsc.io/qr"
+)
+
+const (
+	// loginChallengeCookie holds the token of a sign in waiting for its
+	// second step.
+	loginChallengeCookie = "wryte_login"
+	// loginChallengeTTL is how long the second step of a sign in can take.
+	loginChallengeTTL = 10 * time.Minute
+	// loginChallengeAttempts is how many wrong codes a sign in survives
+	// before the password has to be entered again.
+	loginChallengeAttempts = 5
+	// recoveryCodeCount is how many recovery codes are handed out at once.
+	recoveryCodeCount = 10
+)
+
+// TwoFactor is the two-factor authentication state of an account.
+type TwoFactor struct {
+	Enabled bool
+	// Secret is the key being set up, until a first code from it is
+	// confirmed.
+	Secret string
+	// RecoveryCodes is how many recovery codes are left unused.
+	RecoveryCodes int
+	// Passkeys is how many passkeys the user has, which also complete a
+	// sign in as the second factor.
+	Passkeys int
+	// Required is whether the instance, or a workspace of the user, requires
+	// two-factor authentication.
+	Required bool
+}
+
+// findTwoFactor loads the two-factor authentication state of a user.
+func (h *Handler) findTwoFactor(ctx context.Context, userID string) (*TwoFactor, error) {
+	var tf TwoFactor
+	var secret sql.NullString
+	query := `SELECT u.totp_enabled_at IS NOT NULL, u.totp_secret,
+		(SELECT COUNT(*) FROM recovery_codes c WHERE c.user_id = u.id AND c.used_at IS NULL),
+		(SELECT COUNT(*) FROM passkeys p WHERE p.user_id = u.id),
+		EXISTS (SELECT 1 FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+		        WHERE m.user_id = u.id AND w.require_two_factor)
+		FROM users u WHERE u.id = $1`
+	err := h.db.GetDB().QueryRowContext(ctx, query, userID).Scan(&tf.Enabled, &secret, &tf.RecoveryCodes, &tf.Passkeys, &tf.Required)
+	if err != nil {
+		return nil, err
+	}
+	if !tf.Enabled {
+		tf.Secret = secret.String
+	}
+	tf.Required = tf.Required || h.config.Auth.RequireTwoFactor
+	return &tf, nil
+}
+
+// generateRecoveryCodes replaces the recovery codes of a user with new ones,
+// which are returned since only their hashes are stored.
+func generateRecoveryCodes(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		text := strings.ToLower(rand.Text())
+		codes[i] = text[:5] + "-" + text[5:10]
+
+		query := `INSERT INTO recovery_codes (user_id, code_hash, created_at) VALUES ($1, $2, NOW())`
+		if _, err := tx.ExecContext(ctx, query, userID, hashRecoveryCode(codes[i])); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// hashRecoveryCode returns the digest a recovery code is stored under. Case,
+// dashes and spaces do not matter when typing a code.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return hashToken(code)
+}
+
+// qrCode renders text as a QR code in an inline SVG image.
+func qrCode(text string) (template.HTML, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+
+	var path strings.Builder
+	for y := 0; y < code.Size; y++ {
+		for x := 0; x < code.Size; x++ {
+			if code.Black(x, y) {
+				fmt.Fprintf(&path, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	// The quiet zone around the code is part of the image
+	svg := fmt.Sprintf(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="-4 -4 %d %d" class="w-48 h-48 bg-white rounded-lg" shape-rendering="crispEdges"><path d="%s" fill="#000"/></svg>`,
+		code.Size+8, code.Size+8, path.String())
+	return template.HTML(svg), nil
+}
+
+// startLoginChallenge holds a sign in whose password was checked until its
+// second step, remembering the page to go to afterwards.
+func (h *Handler) startLoginChallenge(w http.ResponseWriter, r *http.Request, userID, next string) error {
+	if _, err := h.db.GetDB().ExecContext(r.Context(), `DELETE FROM login_challenges WHERE expires_at < NOW()`); err != nil {
+		return err
+	}
+
+	token := rand.Text()
+	expiresAt := time.Now().Add(loginChallengeTTL)
+	query := `INSERT INTO login_challenges (user_id, token_hash, next, expires_at, created_at)
+	          VALUES ($1, $2, $3, $4, NOW())`
+	if _, err := h.db.GetDB().ExecContext(r.Context(), query, userID, hashToken(token), localPath(next), expiresAt); err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    token,
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/login",
+	})
+	return nil
+}
+
+// endLoginChallenge removes the cookie of a sign in waiting for its second
+// step.
+func endLoginChallenge(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginChallengeCookie,
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+		Path:     "/login",
+	})
+}
+
+// restartLogin sends someone whose sign in cannot continue back to the
+// password step.
+func restartLogin(w http.ResponseWriter, r *http.Request, message string) {
+	endLoginChallenge(w)
+	flash.SetError(w, message)
+	redirect(w, r, "/login")
+}
+
+// TwoFactorPage asks for a code from the authenticator app, or a passkey,
+// after the password was checked.
+func (h *Handler) TwoFactorPage() http.HandlerFunc {
+	tmpl := h.templates.MustRender("auth/two_factor")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(loginChallengeCookie)
+		if err != nil {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		// The page offers whichever of a code and a passkey the user has
+		var code, passkey bool
+		query := `SELECT u.totp_enabled_at IS NOT NULL, EXISTS (SELECT 1 FROM passkeys p WHERE p.user_id = u.id)
+			FROM login_challenges c
+			JOIN users u ON u.id = c.user_id
+			WHERE c.token_hash = $1 AND c.expires_at > NOW()`
+		err = h.db.GetDB().QueryRowContext(r.Context(), query, hashToken(cookie.Value)).Scan(&code, &passkey)
+		if errors.Is(err, sql.ErrNoRows) {
+			restartLogin(w, r, "Your sign in expired. Enter your password again.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying login challenge: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Form":    &validator.TwoFactorForm{},
+			"Errors":  &validator.ValidationErrors{},
+			"Code":    code,
+			"Passkey": passkey,
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// TwoFactorForm completes a sign in with a code from the authenticator app or
+// a recovery code, and starts the session. Too many wrong codes send the user
+// back to the password step.
+func (h *Handler) TwoFactorForm() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("auth/two_factor")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(loginChallengeCookie)
+		if err != nil {
+			restartLogin(w, r, "Your sign in expired. Enter your password again.")
+			return
+		}
+
+		var form validator.TwoFactorForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		render := func() {
+			data := map[string]any{
+				"Form":   &validator.TwoFactorForm{},
+				"Errors": validationErrs,
+			}
+			if err := tmpl.ExecuteTemplate(w, "two_factor_form", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+		}
+		if validationErrs.HasErrors() {
+			render()
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Locking the challenge and the user keeps concurrent attempts from
+		// getting past the limit, or using a code twice
+		var challengeID, userID, next, secret string
+		var attempts int
+		var lastStep int64
+		query := `SELECT c.id, c.user_id, c.next, c.attempts, u.totp_secret, u.totp_last_step
+			FROM login_challenges c
+			JOIN users u ON u.id = c.user_id
+			WHERE c.token_hash = $1 AND c.expires_at > NOW() AND u.totp_enabled_at IS NOT NULL
+			FOR UPDATE`
+		err = tx.QueryRowContext(r.Context(), query, hashToken(cookie.Value)).
+			Scan(&challengeID, &userID, &next, &attempts, &secret, &lastStep)
+		if errors.Is(err, sql.ErrNoRows) {
+			restartLogin(w, r, "Your sign in expired. Enter your password again.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error querying login challenge: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		recovery := false
+		step, ok := totp.Validate(secret, form.Code, time.Now(), lastStep)
+		if ok {
+			query = `UPDATE users SET totp_last_step = $1 WHERE id = $2`
+			if _, err := tx.ExecContext(r.Context(), query, step, userID); err != nil {
+				log.Printf("Error updating user: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		} else {
+			query = `UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+			res, err := tx.ExecContext(r.Context(), query, userID, hashRecoveryCode(form.Code))
+			if err != nil {
+				log.Printf("Error using recovery code: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			n, _ := res.RowsAffected()
+			ok, recovery = n == 1, n == 1
+		}
+
+		if !ok {
+			attempts++
+			if attempts >= loginChallengeAttempts {
+				query = `DELETE FROM login_challenges WHERE id = $1`
+			} else {
+				query = `UPDATE login_challenges SET attempts = attempts + 1 WHERE id = $1`
+			}
+			if _, err := tx.ExecContext(r.Context(), query, challengeID); err != nil {
+				log.Printf("Error updating login challenge: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				log.Printf("Error committing transaction: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if attempts >= loginChallengeAttempts {
+				restartLogin(w, r, "Too many wrong codes. Enter your password again.")
+				return
+			}
+			validationErrs.AddError("code", "This code is not valid")
+			render()
+			return
+		}
+
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM login_challenges WHERE id = $1`, challengeID); err != nil {
+			log.Printf("Error deleting login challenge: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		var remaining int
+		query = `SELECT COUNT(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL`
+		if err := tx.QueryRowContext(r.Context(), query, userID).Scan(&remaining); err != nil {
+			log.Printf("Error counting recovery codes: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if err := h.startSession(w, r, userID); err != nil {
+			log.Printf("Error creating session: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		endLoginChallenge(w)
+
+		if recovery {
+			flash.SetWarning(w, fmt.Sprintf("You signed in with a recovery code, %d left. New ones can be generated in your account settings.", remaining))
+		}
+		w.Header().Set("HX-Redirect", localPath(next))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// twoFactorData returns what the two-factor settings show for a user. While
+// setting up, a key is created if the user has none yet.
+func (h *Handler) twoFactorData(ctx context.Context, userID string) (map[string]any, error) {
+	account, err := h.findAccount(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := h.findTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]any{
+		"Account":   account,
+		"TwoFactor": tf,
+		"Errors":    &validator.ValidationErrors{},
+		"Action":    "",
+	}
+	if tf.Enabled {
+		return data, nil
+	}
+
+	// The key only changes when setting up anew, so that reloading the page
+	// does not invalidate a key already scanned
+	if tf.Secret == "" {
+		tf.Secret = totp.GenerateSecret()
+		query := `UPDATE users SET totp_secret = $1 WHERE id = $2 AND totp_enabled_at IS NULL`
+		if _, err := h.db.GetDB().ExecContext(ctx, query, tf.Secret, userID); err != nil {
+			return nil, err
+		}
+	}
+	code, err := qrCode(totp.URI(tf.Secret, "Wryte", account.Email))
+	if err != nil {
+		return nil, err
+	}
+	data["QRCode"] = code
+	return data, nil
+}
+
+// TwoFactorSettings sets up two-factor authentication for the signed in
+// user, or manages it once it is on.
+func (h *Handler) TwoFactorSettings() http.HandlerFunc {
+	tmpl := h.templates.MustRender("two_factor")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+		data, err := h.twoFactorData(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying two-factor authentication: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		data["Flash"] = h.GetFlashMessage(w, r)
+
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// renderTwoFactor renders the two-factor settings after the form of action
+// was submitted.
+func (h *Handler) renderTwoFactor(w http.ResponseWriter, r *http.Request, tmpl *template.Template, action string, errs *validator.ValidationErrors) {
+	userID, _ := middleware.GetUserID(r)
+	data, err := h.twoFactorData(r.Context(), userID)
+	if err != nil {
+		log.Printf("Error querying two-factor authentication: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	data["Errors"] = errs
+	data["Action"] = action
+
+	if errs.HasErrors() {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := tmpl.ExecuteTemplate(w, "two_factor_settings", data); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// renderRecoveryCodes shows recovery codes that were just generated. They are
+// not stored, so this is the only time they can be seen.
+func renderRecoveryCodes(w http.ResponseWriter, tmpl *template.Template, codes []string) {
+	if err := tmpl.ExecuteTemplate(w, "two_factor_recovery_codes", map[string]any{"Codes": codes}); err != nil {
+		log.Printf("Error rendering template: %v", err)
+	}
+}
+
+// EnableTwoFactor turns on two-factor authentication once a code from the
+// key being set up is confirmed, and hands out recovery codes.
+func (h *Handler) EnableTwoFactor() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("two_factor")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.TwoFactorForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if validationErrs.HasErrors() {
+			h.renderTwoFactor(w, r, tmpl, "enable", validationErrs)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var secret sql.NullString
+		var enabled bool
+		query := `SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(r.Context(), query, userID).Scan(&secret, &enabled); err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if enabled {
+			redirect(w, r, "/settings/account/two-factor")
+			return
+		}
+
+		step, ok := totp.Validate(secret.String, form.Code, time.Now(), 0)
+		if !secret.Valid || !ok {
+			validationErrs.AddError("code", "This code is not valid, check the time of your device")
+			h.renderTwoFactor(w, r, tmpl, "enable", validationErrs)
+			return
+		}
+
+		query = `UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $1, updated_at = NOW() WHERE id = $2`
+		if _, err := tx.ExecContext(r.Context(), query, step, userID); err != nil {
+			log.Printf("Error enabling two-factor authentication: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		codes, err := generateRecoveryCodes(r.Context(), tx, userID)
+		if err != nil {
+			log.Printf("Error generating recovery codes: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		renderRecoveryCodes(w, tmpl, codes)
+	}
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the signed in user,
+// after checking their password.
+func (h *Handler) RegenerateRecoveryCodes() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("two_factor")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.ConfirmPasswordForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if !validationErrs.HasErrors() {
+			ok, err := h.checkPassword(r.Context(), userID, form.CurrentPassword)
+			if err != nil {
+				log.Printf("Error checking password: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				validationErrs.AddError("currentpassword", "Password is incorrect")
+			}
+		}
+		if validationErrs.HasErrors() {
+			h.renderTwoFactor(w, r, tmpl, "codes", validationErrs)
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		var enabled bool
+		query := `SELECT totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`
+		if err := tx.QueryRowContext(r.Context(), query, userID).Scan(&enabled); err != nil {
+			log.Printf("Error querying user: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if !enabled {
+			redirect(w, r, "/settings/account/two-factor")
+			return
+		}
+		codes, err := generateRecoveryCodes(r.Context(), tx, userID)
+		if err != nil {
+			log.Printf("Error generating recovery codes: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		renderRecoveryCodes(w, tmpl, codes)
+	}
+}
+
+// DisableTwoFactor turns off two-factor authentication for the signed in
+// user, after checking their password, unless it is required of them and
+// they have no passkey to use instead.
+func (h *Handler) DisableTwoFactor() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("two_factor")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		var form validator.ConfirmPasswordForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		if !validationErrs.HasErrors() {
+			ok, err := h.checkPassword(r.Context(), userID, form.CurrentPassword)
+			if err != nil {
+				log.Printf("Error checking password: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !ok {
+				validationErrs.AddError("currentpassword", "Password is incorrect")
+			}
+		}
+		if validationErrs.HasErrors() {
+			h.renderTwoFactor(w, r, tmpl, "disable", validationErrs)
+			return
+		}
+
+		tf, err := h.findTwoFactor(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying two-factor authentication: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if tf.Required && tf.Passkeys == 0 {
+			flash.SetError(w, "Two-factor authentication is required of your account and cannot be turned off.")
+			redirect(w, r, "/settings/account/two-factor")
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0, updated_at = NOW() WHERE id = $1`
+		if _, err := tx.ExecContext(r.Context(), query, userID); err != nil {
+			log.Printf("Error disabling two-factor authentication: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			log.Printf("Error deleting recovery codes: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Two-factor authentication is off.")
+		redirect(w, r, "/settings/account")
+	}
+}
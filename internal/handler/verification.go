@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/middleware"
+)
+
+const (
+	// emailVerificationTTL is how long an email verification link can be
+	// used.
+	emailVerificationTTL = 24 * time.Hour
+	// emailVerificationInterval is how long to wait before sending another
+	// verification link to the same user.
+	emailVerificationInterval = time.Minute
+)
+
+// requestEmailVerification sends a link verifying the email address of a
+// user, replacing any link sent earlier. Nothing is sent, and false returned,
+// when the address is already verified or a link was sent less than
+// emailVerificationInterval ago.
+func (h *Handler) requestEmailVerification(r *http.Request, userID string) (bool, error) {
+	ctx := r.Context()
+
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Locking the user keeps concurrent requests from both passing the
+	// checks below
+	var username, email string
+	var verified bool
+	query := `SELECT username, email, email_verified_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`
+	if err := tx.QueryRowContext(ctx, query, userID).Scan(&username, &email, &verified); err != nil {
+		return false, err
+	}
+	if verified {
+		return false, nil
+	}
+	var recent bool
+	query = `SELECT EXISTS (SELECT 1 FROM email_verifications WHERE user_id = $1 AND created_at > $2)`
+	if err := tx.QueryRowContext(ctx, query, userID, time.Now().Add(-emailVerificationInterval)).Scan(&recent); err != nil {
+		return false, err
+	}
+	if recent {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE user_id = $1`, userID); err != nil {
+		return false, err
+	}
+	token := rand.Text()
+	query = `INSERT INTO email_verifications (user_id, email, token_hash, expires_at, created_at, updated_at)
+	         VALUES ($1, $2, $3, $4, NOW(), NOW())`
+	if _, err := tx.ExecContext(ctx, query, userID, email, hashToken(token), time.Now().Add(emailVerificationTTL)); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	h.sendEmail(email, "email_verification", map[string]any{
+		"Username": username,
+		"Link":     h.config.URL("/verify-email?token=" + url.QueryEscape(token)),
+	})
+	return true, nil
+}
+
+// VerifyEmail asks the signed in user to verify their email address, or
+// verifies it when following the link sent to it. The link only works for
+// the account it was sent for, and only while the account still uses the
+// address.
+func (h *Handler) VerifyEmail() http.HandlerFunc {
+	tmpl := h.templates.MustRender("verify_email")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		if token := r.URL.Query().Get("token"); token != "" {
+			verified, err := h.verifyEmail(r, userID, token)
+			if err != nil {
+				log.Printf("Error verifying email: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !verified {
+				flash.SetError(w, "This link has expired or was already used.")
+				http.Redirect(w, r, "/verify-email", http.StatusSeeOther)
+				return
+			}
+			flash.SetSuccess(w, "Your email address is verified.")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		account, err := h.findAccount(r.Context(), userID)
+		if err != nil {
+			log.Printf("Error querying account: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if account.EmailVerified {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		// The policy only applies to the cloud
+		policy := "off"
+		if h.config.IsCloud() {
+			policy = h.config.Project.EmailVerification
+		}
+		data := map[string]any{
+			"Account": account,
+			"Policy":  policy,
+			"Flash":   h.GetFlashMessage(w, r),
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Printf("Error executing template: %v", err)
+		}
+	}
+}
+
+// verifyEmail uses up a verification token of a user, and reports whether it
+// verified their email address.
+func (h *Handler) verifyEmail(r *http.Request, userID, token string) (bool, error) {
+	tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var email string
+	query := `DELETE FROM email_verifications WHERE token_hash = $1 AND user_id = $2 AND expires_at > NOW() RETURNING email`
+	err = tx.QueryRowContext(r.Context(), query, hashToken(token), userID).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	query = `UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW()
+	         WHERE id = $1 AND lower(email) = lower($2)`
+	res, err := tx.ExecContext(r.Context(), query, userID, email)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ResendVerificationEmail sends the signed in user a new link verifying their
+// email address.
+func (h *Handler) ResendVerificationEmail() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, _ := middleware.GetUserID(r)
+
+		sent, err := h.requestEmailVerification(r, userID)
+		if err != nil {
+			log.Printf("Error requesting email verification: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if sent {
+			flash.SetSuccess(w, "We sent you a new link. It is valid for 24 hours.")
+		} else {
+			flash.SetWarning(w, "A link was sent less than a minute ago. Check your inbox, or try again in a moment.")
+		}
+		redirect(w, r, "/verify-email")
+	}
+}
@@ -0,0 +1,533 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/wrytehq/wryte/internal/flash"
+	"github.com/wrytehq/wryte/internal/middleware"
+	"github.com/wrytehq/wryte/internal/validator"
+)
+
+// WorkspaceRole is the role of a member within a workspace. Every role
+// includes the abilities of the roles below it.
+type WorkspaceRole int
+
+const (
+	WorkspaceNone WorkspaceRole = iota
+	// WorkspaceMember may create documents and edit every document of the
+	// workspace.
+	WorkspaceMember
+	// WorkspaceAdmin may also rename the workspace, invite people and manage
+	// members.
+	WorkspaceAdmin
+	// WorkspaceOwner may also appoint owners and delete the workspace.
+	WorkspaceOwner
+)
+
+var workspaceRoleNames = map[WorkspaceRole]string{
+	WorkspaceMember: "member",
+	WorkspaceAdmin:  "admin",
+	WorkspaceOwner:  "owner",
+}
+
+func (r WorkspaceRole) String() string {
+	if name, ok := workspaceRoleNames[r]; ok {
+		return name
+	}
+	return "none"
+}
+
+func parseWorkspaceRole(name string) (WorkspaceRole, bool) {
+	for role, n := range workspaceRoleNames {
+		if n == name {
+			return role, true
+		}
+	}
+	return WorkspaceNone, false
+}
+
+func (r WorkspaceRole) CanManage() bool { return r >= WorkspaceAdmin }
+func (r WorkspaceRole) IsOwner() bool   { return r >= WorkspaceOwner }
+
+type Workspace struct {
+	ID       string
+	Name     string
+	UserID   string
+	IsPublic bool
+	// RequireTwoFactor is whether members must use two-factor
+	// authentication.
+	RequireTwoFactor bool
+	// Role is the role of the signed in user, when known.
+	Role WorkspaceRole
+}
+
+// WorkspaceSwitcher is the data of the workspace switcher shown on every
+// signed in page.
+type WorkspaceSwitcher struct {
+	Workspaces []Workspace
+	Current    *Workspace
+}
+
+// listWorkspaces returns the workspaces the user belongs to, in the order they
+// joined them.
+func (h *Handler) listWorkspaces(ctx context.Context, userID string) ([]Workspace, error) {
+	query := `SELECT w.id, w.name, w.user_id, w.is_public, m.role
+		FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = $1
+		ORDER BY m.created_at, w.name`
+	rows, err := h.db.GetDB().QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workspaces []Workspace
+	for rows.Next() {
+		var ws Workspace
+		var role string
+		if err := rows.Scan(&ws.ID, &ws.Name, &ws.UserID, &ws.IsPublic, &role); err != nil {
+			return nil, err
+		}
+		ws.Role, _ = parseWorkspaceRole(role)
+		workspaces = append(workspaces, ws)
+	}
+	return workspaces, rows.Err()
+}
+
+// workspaceSwitcher lists the workspaces of the user for the switcher, marking
+// currentID as the current one when the user belongs to it.
+func (h *Handler) workspaceSwitcher(ctx context.Context, userID, currentID string) (*WorkspaceSwitcher, error) {
+	workspaces, err := h.listWorkspaces(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	switcher := &WorkspaceSwitcher{Workspaces: workspaces}
+	for i := range workspaces {
+		if workspaces[i].ID == currentID {
+			switcher.Current = &workspaces[i]
+			break
+		}
+	}
+	return switcher, nil
+}
+
+var errWorkspaceNotFound = errors.New("workspace not found")
+
+// findWorkspace loads a workspace by ID. It returns errWorkspaceNotFound when
+// the ID is malformed or no such workspace exists.
+func (h *Handler) findWorkspace(ctx context.Context, workspaceID string) (*Workspace, error) {
+	if _, err := uuid.Parse(workspaceID); err != nil {
+		return nil, errWorkspaceNotFound
+	}
+
+	var ws Workspace
+	query := `SELECT id, name, user_id, is_public, require_two_factor FROM workspaces WHERE id = $1`
+	err := h.db.GetDB().QueryRowContext(ctx, query, workspaceID).Scan(&ws.ID, &ws.Name, &ws.UserID, &ws.IsPublic, &ws.RequireTwoFactor)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errWorkspaceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ws, nil
+}
+
+// createWorkspace creates a workspace owned by userID and returns its ID.
+func createWorkspace(ctx context.Context, tx *sql.Tx, name, userID string) (string, error) {
+	var id string
+	query := `INSERT INTO workspaces (name, user_id, created_at, updated_at)
+	          VALUES ($1, $2, NOW(), NOW())
+	          RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, name, userID).Scan(&id); err != nil {
+		return "", err
+	}
+
+	query = `INSERT INTO workspace_members (workspace_id, user_id, role, created_at, updated_at)
+	         VALUES ($1, $2, $3, NOW(), NOW())`
+	_, err := tx.ExecContext(ctx, query, id, userID, WorkspaceOwner.String())
+	return id, err
+}
+
+// defaultWorkspaceID returns the first workspace the user joined, creating a
+// personal workspace when the user belongs to none.
+func (h *Handler) defaultWorkspaceID(ctx context.Context, userID string) (string, error) {
+	var id string
+	query := `SELECT workspace_id FROM workspace_members WHERE user_id = $1 ORDER BY created_at LIMIT 1`
+	err := h.db.GetDB().QueryRowContext(ctx, query, userID).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	tx, err := h.db.GetDB().BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	if id, err = createWorkspace(ctx, tx, "Personal", userID); err != nil {
+		return "", err
+	}
+	return id, tx.Commit()
+}
+
+// workspaceRole returns the role of the user in the workspace, WorkspaceNone
+// when they are not a member.
+func (h *Handler) workspaceRole(ctx context.Context, workspaceID, userID string) (WorkspaceRole, error) {
+	var name string
+	query := `SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2`
+	err := h.db.GetDB().QueryRowContext(ctx, query, workspaceID, userID).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WorkspaceNone, nil
+	}
+	if err != nil {
+		return WorkspaceNone, err
+	}
+	role, _ := parseWorkspaceRole(name)
+	return role, nil
+}
+
+// isWorkspaceMember reports whether the user belongs to the workspace.
+func (h *Handler) isWorkspaceMember(ctx context.Context, workspaceID, userID string) (bool, error) {
+	var member bool
+	query := `SELECT $1::uuid IN (` + memberWorkspaces("$2") + `)`
+	err := h.db.GetDB().QueryRowContext(ctx, query, workspaceID, userID).Scan(&member)
+	return member, err
+}
+
+// authorizeWorkspace loads the workspace named in the request path and checks
+// that the signed in user holds at least the needed role in it. It writes the
+// error response and returns a nil workspace when the request cannot proceed.
+func (h *Handler) authorizeWorkspace(w http.ResponseWriter, r *http.Request, need WorkspaceRole) (*Workspace, string) {
+	userID, ok := middleware.GetUserID(r)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, ""
+	}
+
+	workspace, err := h.findWorkspace(r.Context(), r.PathValue("workspaceId"))
+	if err != nil {
+		if errors.Is(err, errWorkspaceNotFound) {
+			http.Error(w, "Workspace not found", http.StatusNotFound)
+			return nil, ""
+		}
+		log.Printf("Error querying workspace: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, ""
+	}
+
+	workspace.Role, err = h.workspaceRole(r.Context(), workspace.ID, userID)
+	if err != nil {
+		log.Printf("Error querying workspace role: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return nil, ""
+	}
+	if workspace.Role == WorkspaceNone {
+		http.Error(w, "Forbidden - You don't have access to this workspace", http.StatusForbidden)
+		return nil, ""
+	}
+	if workspace.Role < need {
+		http.Error(w, "Forbidden - You don't have permission to manage this workspace", http.StatusForbidden)
+		return nil, ""
+	}
+
+	return workspace, userID
+}
+
+// Workspaces lists the workspaces of the signed in user and offers to create a
+// new one.
+func (h *Handler) Workspaces() http.HandlerFunc {
+	tmpl := h.templates.MustRender("workspaces")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, "")
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Workspaces": switcher.Workspaces,
+			"Switcher":   switcher,
+			"Form":       &validator.WorkspaceForm{},
+			"Errors":     &validator.ValidationErrors{},
+			"Flash":      h.GetFlashMessage(w, r),
+		}
+
+		err = tmpl.ExecuteTemplate(w, "layout.html", data)
+		if err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// CreateWorkspace creates a workspace owned by the signed in user and opens
+// it.
+func (h *Handler) CreateWorkspace() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("workspaces")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := middleware.GetUserID(r)
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		var form validator.WorkspaceForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		form.Name = strings.TrimSpace(form.Name)
+		if form.Name == "" && !validationErrs.Has("name") {
+			validationErrs.AddError("name", "Name is required")
+		}
+		if validationErrs.HasErrors() {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			data := map[string]any{
+				"Form":   &form,
+				"Errors": validationErrs,
+			}
+			if err := tmpl.ExecuteTemplate(w, "workspace_create_form", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		id, err := createWorkspace(r.Context(), tx, form.Name, userID)
+		if err != nil {
+			log.Printf("Error creating workspace: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Workspace created.")
+		redirect(w, r, "/?workspace="+id)
+	}
+}
+
+// WorkspaceSettings shows the name, members and pending invitations of a
+// workspace, and the single sign-on mappings when it is set up. Only admins
+// may change them.
+func (h *Handler) WorkspaceSettings() http.HandlerFunc {
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceMember)
+		if workspace == nil {
+			return
+		}
+
+		switcher, err := h.workspaceSwitcher(r.Context(), userID, workspace.ID)
+		if err != nil {
+			log.Printf("Error listing workspaces: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		members, err := h.membersData(r.Context(), workspace, userID)
+		if err != nil {
+			log.Printf("Error listing workspace members: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Workspace": workspace,
+			"Switcher":  switcher,
+			"Members":   members,
+			"Form":      &validator.WorkspaceForm{Name: workspace.Name},
+			"Errors":    &validator.ValidationErrors{},
+			"Flash":     h.GetFlashMessage(w, r),
+		}
+		if h.config.Auth.MapsWorkspaces() && workspace.Role.CanManage() {
+			sso, err := h.ssoMappingsData(r.Context(), workspace, &validator.SSOMappingForm{Kind: "domain", Role: WorkspaceMember.String()})
+			if err != nil {
+				log.Printf("Error listing single sign-on mappings: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			data["SSO"] = sso
+		}
+
+		err = tmpl.ExecuteTemplate(w, "layout.html", data)
+		if err != nil {
+			log.Printf("Error executing template: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// RenameWorkspace changes the name of a workspace.
+func (h *Handler) RenameWorkspace() http.HandlerFunc {
+	v := validator.New()
+	tmpl := h.templates.MustRender("workspace")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		var form validator.WorkspaceForm
+		validationErrs, err := v.DecodeAndValidate(r, &form)
+		if err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+		form.Name = strings.TrimSpace(form.Name)
+		if form.Name == "" && !validationErrs.Has("name") {
+			validationErrs.AddError("name", "Name is required")
+		}
+		if validationErrs.HasErrors() {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			data := map[string]any{
+				"Workspace": workspace,
+				"Form":      &form,
+				"Errors":    validationErrs,
+			}
+			if err := tmpl.ExecuteTemplate(w, "workspace_rename_form", data); err != nil {
+				log.Printf("Error rendering template: %v", err)
+			}
+			return
+		}
+
+		query := `UPDATE workspaces SET name = $1, updated_at = NOW() WHERE id = $2`
+		if _, err := h.db.GetDB().ExecContext(r.Context(), query, form.Name, workspace.ID); err != nil {
+			log.Printf("Error renaming workspace: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		flash.SetSuccess(w, "Workspace renamed.")
+		redirect(w, r, "/workspaces/"+workspace.ID)
+	}
+}
+
+// RequireTwoFactor sets whether members of a workspace must use two-factor
+// authentication. Members without it are asked to set it up the next time
+// they open a page. Admins can only require it once they use it themselves,
+// so they cannot lock themselves out.
+func (h *Handler) RequireTwoFactor() http.HandlerFunc {
+	v := validator.New()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, userID := h.authorizeWorkspace(w, r, WorkspaceAdmin)
+		if workspace == nil {
+			return
+		}
+
+		var form validator.WorkspaceTwoFactorForm
+		if _, err := v.DecodeAndValidate(r, &form); err != nil {
+			log.Printf("Error decoding/validating form: %v", err)
+			http.Error(w, "Error processing form", http.StatusBadRequest)
+			return
+		}
+
+		if form.Required {
+			tf, err := h.findTwoFactor(r.Context(), userID)
+			if err != nil {
+				log.Printf("Error querying two-factor authentication: %v", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !tf.Enabled {
+				flash.SetError(w, "Set up two-factor authentication for your own account first.")
+				redirect(w, r, "/workspaces/"+workspace.ID)
+				return
+			}
+		}
+
+		query := `UPDATE workspaces SET require_two_factor = $1, updated_at = NOW() WHERE id = $2`
+		if _, err := h.db.GetDB().ExecContext(r.Context(), query, form.Required, workspace.ID); err != nil {
+			log.Printf("Error updating workspace: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		if form.Required {
+			flash.SetSuccess(w, "Members of "+workspace.Name+" now need two-factor authentication.")
+		} else {
+			flash.SetSuccess(w, "Two-factor authentication is no longer required in "+workspace.Name+".")
+		}
+		redirect(w, r, "/workspaces/"+workspace.ID)
+	}
+}
+
+// DeleteWorkspace removes a workspace together with all of its documents.
+func (h *Handler) DeleteWorkspace() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		workspace, _ := h.authorizeWorkspace(w, r, WorkspaceOwner)
+		if workspace == nil {
+			return
+		}
+
+		tx, err := h.db.GetDB().BeginTx(r.Context(), nil)
+		if err != nil {
+			log.Printf("Error starting transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer tx.Rollback()
+
+		// Documents do not cascade with their workspace, remove them first
+		keys, _, err := deleteDocuments(r.Context(), tx, `workspace_id = $1`, workspace.ID)
+		if err != nil {
+			log.Printf("Error deleting workspace documents: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if _, err := tx.ExecContext(r.Context(), `DELETE FROM workspaces WHERE id = $1`, workspace.ID); err != nil {
+			log.Printf("Error deleting workspace: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			log.Printf("Error committing transaction: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		removeBlobs(h.storage, keys)
+
+		flash.SetSuccess(w, "Workspace deleted.")
+		redirect(w, r, "/")
+	}
+}
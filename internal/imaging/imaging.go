@@ -0,0 +1,143 @@
+// Package imaging prepares uploaded images for the web: it checks what they
+// really are, strips the metadata they carry, turns them upright and scales
+// them down to the variants pages load instead of the original.
+//
+// Everything is done in pure Go. PNG, JPEG, GIF and WebP images are accepted;
+// WebP cannot be encoded by the standard library, so WebP images that have to
+// be re-encoded are turned into PNG or JPEG.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+
+	// Register the decoders of the accepted formats
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// MaxPixels bounds the size of images once decoded, so that a small file
+// cannot claim gigabytes of memory.
+const MaxPixels = 50_000_000
+
+var (
+	// ErrUnsupported is returned for files that are not images of an
+	// accepted format.
+	ErrUnsupported = errors.New("imaging: unsupported image format")
+	// ErrTooLarge is returned for images with more than MaxPixels pixels.
+	ErrTooLarge = errors.New("imaging: image dimensions are too large")
+)
+
+// contentTypes lists the accepted formats.
+var contentTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Image is an image ready to be stored.
+type Image struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+}
+
+// Detect returns the content type of data when it is an image of an accepted
+// format.
+func Detect(data []byte) (string, error) {
+	contentType := mimetype.Detect(data).String()
+	if !contentTypes[contentType] {
+		return "", ErrUnsupported
+	}
+	return contentType, nil
+}
+
+// Supported reports whether images of contentType can be processed.
+func Supported(contentType string) bool {
+	return contentTypes[contentType]
+}
+
+// config reads the dimensions of an image without decoding it and refuses
+// images that would not fit in memory.
+func config(data []byte) (image.Config, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return cfg, fmt.Errorf("imaging: %w", err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return cfg, ErrUnsupported
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return cfg, ErrTooLarge
+	}
+	return cfg, nil
+}
+
+// Process checks that data is an image of an accepted format and prepares it
+// for storage. Metadata such as EXIF, which may hold the location a photo was
+// taken at, is removed, and images meant to be shown rotated or mirrored are
+// turned so that they display correctly without it.
+func Process(data []byte) (*Image, error) {
+	contentType, err := Detect(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config(data)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{ContentType: contentType, Width: cfg.Width, Height: cfg.Height}
+
+	if o := orientation(contentType, data); o > 1 {
+		src, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("imaging: %w", err)
+		}
+		oriented := orient(src, o)
+		img.Data, img.ContentType, err = encode(oriented, contentType)
+		if err != nil {
+			return nil, err
+		}
+		img.Width, img.Height = oriented.Bounds().Dx(), oriented.Bounds().Dy()
+		return img, nil
+	}
+
+	switch contentType {
+	case "image/jpeg":
+		img.Data, err = stripJPEG(data)
+	case "image/png":
+		img.Data, err = stripPNG(data)
+	case "image/webp":
+		img.Data, err = stripWebP(data)
+	case "image/gif":
+		img.Data, err = stripGIF(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("imaging: %w", err)
+	}
+	return img, nil
+}
+
+// stripGIF re-encodes a GIF, which keeps its frames, timing and loop count and
+// drops comments and application data.
+func stripGIF(data []byte) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,166 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errMalformed = errors.New("malformed image")
+
+// jpegSegments calls fn with the marker and payload of every segment of a
+// JPEG image up to the start of the image data, until fn returns false. It
+// returns the offset of the start of scan marker.
+func jpegSegments(data []byte, fn func(marker byte, segment []byte) bool) (int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errMalformed
+	}
+	i := 2
+	for {
+		// Markers may be preceded by any number of fill bytes
+		for i < len(data) && data[i] == 0xFF && i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+4 > len(data) || data[i] != 0xFF {
+			return 0, errMalformed
+		}
+		marker := data[i+1]
+		if marker == 0xDA {
+			return i, nil
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 0, errMalformed
+		}
+		if !fn(marker, data[i+4:i+2+length]) {
+			return i, nil
+		}
+		i += 2 + length
+	}
+}
+
+// stripJPEG removes the EXIF, XMP and IPTC segments and comments of a JPEG
+// image without decoding it. Color profiles and the segments decoders rely on
+// are kept.
+func stripJPEG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, 0xFF, 0xD8)
+	start, err := jpegSegments(data, func(marker byte, segment []byte) bool {
+		switch marker {
+		case 0xE1, 0xEC, 0xED, 0xFE: // APP1 EXIF and XMP, APP12, APP13 IPTC, COM
+		default:
+			out = append(out, 0xFF, marker)
+			out = binary.BigEndian.AppendUint16(out, uint16(len(segment)+2))
+			out = append(out, segment...)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(out, data[start:]...), nil
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngChunks calls fn with the type and data of every chunk of a PNG image,
+// until fn returns false.
+func pngChunks(data []byte, fn func(kind string, chunk []byte) bool) error {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return errMalformed
+	}
+	for i := len(pngSignature); i < len(data); {
+		if i+12 > len(data) {
+			return errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return errMalformed
+		}
+		if !fn(string(data[i+4:i+8]), data[i+8:i+8+length]) {
+			return nil
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// stripPNG removes the text, EXIF and time chunks of a PNG image without
+// decoding it.
+func stripPNG(data []byte) ([]byte, error) {
+	out := make([]byte, 0, len(data))
+	out = append(out, pngSignature...)
+	i := len(pngSignature)
+	err := pngChunks(data, func(kind string, chunk []byte) bool {
+		size := 12 + len(chunk)
+		switch kind {
+		case "tEXt", "zTXt", "iTXt", "eXIf", "tIME":
+		default:
+			out = append(out, data[i:i+size]...)
+		}
+		i += size
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// webpChunks calls fn with the FourCC and payload of every chunk of a WebP
+// image, until fn returns false.
+func webpChunks(data []byte, fn func(fourCC string, chunk []byte) bool) error {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return errMalformed
+	}
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return errMalformed
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if size < 0 || i+8+size > len(data) {
+			return errMalformed
+		}
+		if !fn(string(data[i:i+4]), data[i+8:i+8+size]) {
+			return nil
+		}
+		// Chunks are padded to an even size
+		i += 8 + size + size&1
+	}
+	return nil
+}
+
+// VP8X flags announcing metadata chunks.
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// stripWebP removes the EXIF and XMP chunks of a WebP image without decoding
+// it.
+func stripWebP(data []byte) ([]byte, error) {
+	out := make([]byte, 12, len(data))
+	copy(out, data[:12])
+	err := webpChunks(data, func(fourCC string, chunk []byte) bool {
+		switch fourCC {
+		case "EXIF", "XMP ":
+			return true
+		}
+		start := len(out)
+		out = append(out, fourCC...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(chunk)))
+		out = append(out, chunk...)
+		if len(chunk)&1 == 1 {
+			out = append(out, 0)
+		}
+		if fourCC == "VP8X" && len(chunk) > 0 {
+			out[start+8] &^= webpFlagEXIF | webpFlagXMP
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,145 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+)
+
+// orientationTag is the EXIF tag telling how an image is meant to be turned
+// for display, from 1, upright, to 8.
+const orientationTag = 0x0112
+
+// orientation returns the EXIF orientation of an image, 1 when it has none.
+func orientation(contentType string, data []byte) int {
+	var exif []byte
+	switch contentType {
+	case "image/jpeg":
+		exif = jpegEXIF(data)
+	case "image/png":
+		exif = pngEXIF(data)
+	case "image/webp":
+		exif = webpEXIF(data)
+	}
+	o := tiffOrientation(exif)
+	if o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+// tiffOrientation reads the orientation tag from the first directory of EXIF
+// data, which is laid out as a TIFF file.
+func tiffOrientation(exif []byte) int {
+	if len(exif) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(exif[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(exif[4:8]))
+	if offset < 8 || offset+2 > len(exif) {
+		return 0
+	}
+	count := int(order.Uint16(exif[offset:]))
+	for i := 0; i < count; i++ {
+		entry := offset + 2 + 12*i
+		if entry+12 > len(exif) {
+			return 0
+		}
+		// The value is a SHORT stored in the first bytes of the value field
+		if order.Uint16(exif[entry:]) == orientationTag && order.Uint16(exif[entry+2:]) == 3 {
+			return int(order.Uint16(exif[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// jpegEXIF returns the EXIF data of a JPEG image, found in an APP1 segment
+// before the image data.
+func jpegEXIF(data []byte) []byte {
+	var exif []byte
+	_, _ = jpegSegments(data, func(marker byte, segment []byte) bool {
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			exif = segment[6:]
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// pngEXIF returns the content of the eXIf chunk of a PNG image.
+func pngEXIF(data []byte) []byte {
+	var exif []byte
+	_ = pngChunks(data, func(kind string, chunk []byte) bool {
+		if kind == "eXIf" {
+			exif = chunk
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// webpEXIF returns the content of the EXIF chunk of a WebP image.
+func webpEXIF(data []byte) []byte {
+	var exif []byte
+	_ = webpChunks(data, func(fourCC string, chunk []byte) bool {
+		if fourCC == "EXIF" {
+			exif = bytes.TrimPrefix(chunk, []byte("Exif\x00\x00"))
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// orient turns an image the way its EXIF orientation describes, so that it
+// displays upright once the orientation is dropped.
+func orient(src image.Image, o int) image.Image {
+	b := src.Bounds()
+	in := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(in, in.Bounds(), src, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch o {
+			case 2: // mirrored
+				sx, sy = w-1-x, y
+			case 3: // upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: // turned left and mirrored
+				sx, sy = y, x
+			case 6: // turned left
+				sx, sy = y, h-1-x
+			case 7: // turned right and mirrored
+				sx, sy = w-1-y, h-1-x
+			case 8: // turned right
+				sx, sy = w-1-y, x
+			default:
+				sx, sy = x, y
+			}
+			copy(out.Pix[out.PixOffset(x, y):out.PixOffset(x, y)+4], in.Pix[in.PixOffset(sx, sy):in.PixOffset(sx, sy)+4])
+		}
+	}
+	return out
+}
@@ -0,0 +1,137 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strconv"
+
+	"golang.org/x/image/draw"
+)
+
+// jpegQuality is the quality of the JPEG images this package encodes.
+const jpegQuality = 85
+
+// ErrNoVariant is returned when an image is already smaller than a variant,
+// which should then be served by the original.
+var ErrNoVariant = errors.New("imaging: image is smaller than the variant")
+
+// Variant is a scaled down version of an image.
+type Variant struct {
+	// Name identifies the variant in URLs and storage keys.
+	Name string
+	// Width and Height bound the size of the variant. A zero Height keeps
+	// the aspect ratio of the image.
+	Width  int
+	Height int
+}
+
+// Thumbnail is a small square preview, cropped from the middle of the image.
+var Thumbnail = Variant{Name: "thumb", Width: 200, Height: 200}
+
+// Widths lists the widths of the responsive variants pages choose from with
+// srcset, from the smallest.
+var Widths = []int{480, 960, 1920}
+
+// Variants lists every variant of an image.
+func Variants() []Variant {
+	variants := []Variant{Thumbnail}
+	for _, w := range Widths {
+		variants = append(variants, Variant{Name: strconv.Itoa(w), Width: w})
+	}
+	return variants
+}
+
+// LookupVariant returns the variant called name.
+func LookupVariant(name string) (Variant, bool) {
+	for _, v := range Variants() {
+		if v.Name == name {
+			return v, true
+		}
+	}
+	return Variant{}, false
+}
+
+// Resize renders the variant v of an image. Animated GIFs are reduced to
+// their first frame. Images are never scaled up: ErrNoVariant is returned
+// when the image is already small enough.
+func Resize(data []byte, v Variant) (*Image, error) {
+	contentType, err := Detect(data)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Crop a thumbnail to its aspect ratio first, then scale the result
+	crop := image.Rect(0, 0, cfg.Width, cfg.Height)
+	width, height := v.Width, v.Height
+	if height == 0 {
+		if cfg.Width <= width {
+			return nil, ErrNoVariant
+		}
+		height = max(1, cfg.Height*width/cfg.Width)
+	} else {
+		if cfg.Width*height > cfg.Height*width {
+			w := cfg.Height * width / height
+			crop = image.Rect((cfg.Width-w)/2, 0, (cfg.Width-w)/2+w, cfg.Height)
+		} else {
+			h := cfg.Width * height / width
+			crop = image.Rect(0, (cfg.Height-h)/2, cfg.Width, (cfg.Height-h)/2+h)
+		}
+		if crop.Dx() < width {
+			width, height = crop.Dx(), crop.Dy()
+		}
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("imaging: %w", err)
+	}
+	crop = crop.Add(src.Bounds().Min)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+
+	img := &Image{Width: width, Height: height}
+	img.Data, img.ContentType, err = encode(dst, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// encode writes an image in the format it came in when possible. Images that
+// came in as GIF or WebP become JPEG when they are opaque, PNG otherwise.
+func encode(img image.Image, contentType string) ([]byte, string, error) {
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		contentType = "image/png"
+		if opaque(img) {
+			contentType = "image/jpeg"
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("imaging: %w", err)
+	}
+	return buf.Bytes(), contentType, nil
+}
+
+func opaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
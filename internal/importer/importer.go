@@ -0,0 +1,331 @@
+// Package importer reads zip archives of Markdown files, Obsidian vaults and
+// Notion exports into a tree of pages that can be stored as documents. Folders
+// become pages, links between files point at the documents they turn into and
+// images are embedded into the pages that show them.
+package importer
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Limits protecting the server from oversized or malicious archives.
+const (
+	maxFiles     = 5000
+	maxPages     = 1000
+	maxFileSize  = 10 << 20
+	maxTotalSize = 256 << 20
+	maxTitle     = 255
+)
+
+// Status is the outcome of importing a file.
+type Status string
+
+const (
+	Imported Status = "imported"
+	Skipped  Status = "skipped"
+	Failed   Status = "failed"
+)
+
+// Entry reports what happened to one file of an archive.
+type Entry struct {
+	File    string
+	Status  Status
+	Message string
+	// Page is the page the file was imported into, if any.
+	Page *Page
+}
+
+// Page is a document to create. IDs are generated up front so that links
+// between pages can be rewritten before anything is stored.
+type Page struct {
+	ID       string
+	Title    string
+	Content  string
+	Children []*Page
+
+	file string // path of the note in the archive, empty for folders
+}
+
+// Result is the outcome of reading an archive: the top level pages in the
+// order they should be created and a report with an entry per file.
+type Result struct {
+	Pages  []*Page
+	Report []*Entry
+}
+
+// Count is the number of pages in the result, including nested ones.
+func (r *Result) Count() int {
+	var count func(pages []*Page) int
+	count = func(pages []*Page) int {
+		n := len(pages)
+		for _, p := range pages {
+			n += count(p.Children)
+		}
+		return n
+	}
+	return count(r.Pages)
+}
+
+// archive holds the state of reading a single zip file.
+type archive struct {
+	notes   map[string]*Page // by path of the note file
+	keys    map[string]*Page // by path of the note file without extension
+	names   map[string]*Page // by lowercased file name without extension
+	assets  map[string]*zip.File
+	folders map[string]*Page
+	placed  map[*Page]bool
+	entries map[string]*Entry
+	embeds  map[string]string // data URIs of images by path
+	used    map[string]bool   // assets referenced by a page
+	read    int64
+	roots   []*Page
+}
+
+// Read imports the notes of an archive. Files that cannot be imported are
+// reported rather than failing the whole import; only archives exceeding the
+// limits are rejected.
+func Read(zr *zip.Reader) (*Result, error) {
+	if len(zr.File) > maxFiles {
+		return nil, fmt.Errorf("the archive contains more than %d files", maxFiles)
+	}
+
+	a := &archive{
+		notes:   make(map[string]*Page),
+		keys:    make(map[string]*Page),
+		names:   make(map[string]*Page),
+		assets:  make(map[string]*zip.File),
+		folders: make(map[string]*Page),
+		placed:  make(map[*Page]bool),
+		entries: make(map[string]*Entry),
+		embeds:  make(map[string]string),
+		used:    make(map[string]bool),
+	}
+
+	var notes []*zip.File
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		name := cleanName(f.Name)
+		switch {
+		case name == "":
+			a.report(f.Name, Skipped, "Invalid file name")
+		case hidden(name):
+			a.report(name, Skipped, "Hidden or system file")
+		case f.UncompressedSize64 > maxFileSize:
+			a.report(name, Failed, fmt.Sprintf("File is larger than %d MB", maxFileSize>>20))
+		case isNote(name):
+			notes = append(notes, f)
+		case strings.EqualFold(path.Ext(name), ".zip"):
+			a.report(name, Skipped, "Nested archives are not imported, upload them separately")
+		default:
+			a.assets[name] = f
+		}
+	}
+	if len(notes) > maxPages {
+		return nil, fmt.Errorf("the archive contains more than %d pages", maxPages)
+	}
+
+	sort.Slice(notes, func(i, j int) bool { return cleanName(notes[i].Name) < cleanName(notes[j].Name) })
+	for _, f := range notes {
+		name := cleanName(f.Name)
+		page, err := a.readNote(f, name)
+		if err != nil {
+			a.report(name, Failed, err.Error())
+			continue
+		}
+		a.notes[name] = page
+		a.keys[strings.TrimSuffix(name, path.Ext(name))] = page
+		base := strings.ToLower(strings.TrimSuffix(path.Base(name), path.Ext(name)))
+		if _, ok := a.names[base]; !ok {
+			a.names[base] = page
+		}
+	}
+
+	// Place notes in the tree, creating pages for the folders holding them
+	for _, f := range notes {
+		name := cleanName(f.Name)
+		page, ok := a.notes[name]
+		if !ok || a.placed[page] {
+			continue
+		}
+		a.place(page, strings.TrimSuffix(name, path.Ext(name)))
+	}
+
+	for _, f := range notes {
+		name := cleanName(f.Name)
+		if page, ok := a.notes[name]; ok {
+			warnings := a.rewriteLinks(page)
+			a.entries[name] = &Entry{File: name, Status: Imported, Message: warnings, Page: page}
+		}
+	}
+
+	for name := range a.assets {
+		if _, ok := a.entries[name]; ok {
+			continue
+		}
+		if a.used[name] {
+			a.report(name, Skipped, "Only images can be embedded, other attachments are not imported")
+		} else {
+			a.report(name, Skipped, "Not referenced by any page")
+		}
+	}
+
+	sortPages(a.roots)
+	result := &Result{Pages: a.roots}
+	for _, entry := range a.entries {
+		result.Report = append(result.Report, entry)
+	}
+	sort.Slice(result.Report, func(i, j int) bool { return result.Report[i].File < result.Report[j].File })
+	return result, nil
+}
+
+func (a *archive) report(file string, status Status, message string) {
+	a.entries[file] = &Entry{File: file, Status: status, Message: message}
+}
+
+// readFile reads a file of the archive within the size limits.
+func (a *archive) readFile(f *zip.File) ([]byte, error) {
+	if a.read+int64(f.UncompressedSize64) > maxTotalSize {
+		return nil, fmt.Errorf("the archive expands to more than %d MB", maxTotalSize>>20)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	// The size in the header may lie, so the read is bounded as well
+	data, err := io.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("file is larger than %d MB", maxFileSize>>20)
+	}
+	a.read += int64(len(data))
+	return data, nil
+}
+
+func (a *archive) readNote(f *zip.File, name string) (*Page, error) {
+	data, err := a.readFile(f)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("file is not valid UTF-8 text")
+	}
+
+	page := &Page{
+		ID:    uuid.NewString(),
+		Title: titleFromName(path.Base(name)),
+		file:  name,
+	}
+	if isHTML(name) {
+		title, content, err := convertHTML(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("could not convert HTML: %v", err)
+		}
+		if title != "" {
+			page.Title = title
+		}
+		page.Content = content
+	} else {
+		page.Content = stripFrontMatter(string(data))
+	}
+	page.Title = truncate(page.Title, maxTitle)
+	page.Content = stripTitleHeading(page.Content, page.Title)
+	return page, nil
+}
+
+// place attaches page below the page of the folder containing key, the path
+// of its note without extension.
+func (a *archive) place(page *Page, key string) {
+	a.placed[page] = true
+	if parent := a.folder(path.Dir(key)); parent != nil {
+		parent.Children = append(parent.Children, page)
+	} else {
+		a.roots = append(a.roots, page)
+	}
+}
+
+// folder returns the page of a folder. A note named like the folder next to
+// it, as Notion exports and Obsidian folder notes have, becomes that page;
+// otherwise an empty page titled after the folder is created.
+func (a *archive) folder(dir string) *Page {
+	if dir == "." || dir == "/" {
+		return nil
+	}
+	if page, ok := a.folders[dir]; ok {
+		return page
+	}
+	page, ok := a.keys[dir]
+	if !ok {
+		page = &Page{ID: uuid.NewString(), Title: truncate(titleFromName(path.Base(dir)), maxTitle)}
+	}
+	a.folders[dir] = page
+	if !a.placed[page] {
+		a.place(page, dir)
+	}
+	return page
+}
+
+// sortPages orders siblings by title, as the document tree does.
+func sortPages(pages []*Page) {
+	sort.SliceStable(pages, func(i, j int) bool {
+		return strings.ToLower(pages[i].Title) < strings.ToLower(pages[j].Title)
+	})
+	for _, p := range pages {
+		sortPages(p.Children)
+	}
+}
+
+// cleanName normalizes the path of a file in the archive, returning an empty
+// string for paths escaping it.
+func cleanName(name string) string {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimPrefix(name, "/")
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return ""
+	}
+	return name
+}
+
+// hidden reports whether a file belongs to a hidden folder, such as the
+// settings of an Obsidian vault, or is metadata added by the archiver.
+func hidden(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || part == "__MACOSX" {
+			return true
+		}
+	}
+	return false
+}
+
+func isNote(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".md", ".markdown", ".html", ".htm":
+		return true
+	}
+	return false
+}
+
+func isHTML(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	return ext == ".html" || ext == ".htm"
+}
+
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
@@ -0,0 +1,190 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// pixel is a 1x1 PNG image.
+var pixel, _ = base64.StdEncoding.DecodeString("iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg==")
+
+func readArchive(t *testing.T, files map[string]string) *Result {
+	t.Helper()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := Read(zr)
+	if err != nil {
+		t.Fatalf("Read() = %v", err)
+	}
+	return result
+}
+
+func findPage(t *testing.T, pages []*Page, path ...string) *Page {
+	t.Helper()
+	for _, p := range pages {
+		if p.Title != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return p
+		}
+		return findPage(t, p.Children, path[1:]...)
+	}
+	t.Fatalf("no page %q", path[0])
+	return nil
+}
+
+func findEntry(t *testing.T, result *Result, file string) *Entry {
+	t.Helper()
+	for _, e := range result.Report {
+		if e.File == file {
+			return e
+		}
+	}
+	t.Fatalf("no report entry for %s", file)
+	return nil
+}
+
+func TestReadObsidianVault(t *testing.T) {
+	result := readArchive(t, map[string]string{
+		"vault/.obsidian/app.json": "{}",
+		"vault/Home.md": "---\ntags: [a]\n---\n" +
+			"See [[Other Note|the other]], [[Missing]], ![[pic.png]] and [relative](Folder/Other%20Note.md).\n\n" +
+			"```\n[[Other Note]] in code\n```\n",
+		"vault/Folder/Other Note.md": "# Other Note\n\nBack to [[Home#Intro]] and [out](https://example.org).",
+		"vault/pic.png":              string(pixel),
+		"vault/unused.png":           string(pixel),
+		"vault/doc.pdf":              "%PDF-1.4",
+	})
+
+	if got := result.Count(); got != 4 {
+		t.Errorf("Count() = %d, want 4", got)
+	}
+
+	home := findPage(t, result.Pages, "vault", "Home")
+	other := findPage(t, result.Pages, "vault", "Folder", "Other Note")
+
+	for _, want := range []string{
+		"[the other](/documents/" + other.ID + ")",
+		"[relative](/documents/" + other.ID + ")",
+		"Missing,",
+		"![pic.png](data:image/png;base64,",
+		"```\n[[Other Note]] in code\n```",
+	} {
+		if !strings.Contains(home.Content, want) {
+			t.Errorf("Home content %q is missing %q", home.Content, want)
+		}
+	}
+	if strings.Contains(home.Content, "tags:") {
+		t.Errorf("front matter was kept: %q", home.Content)
+	}
+
+	for _, want := range []string{"[Home > Intro](/documents/" + home.ID + ")", "[out](https://example.org)"} {
+		if !strings.Contains(other.Content, want) {
+			t.Errorf("Other Note content %q is missing %q", other.Content, want)
+		}
+	}
+	if strings.HasPrefix(other.Content, "# Other Note") {
+		t.Errorf("title heading was kept: %q", other.Content)
+	}
+
+	statuses := map[string]Status{
+		"vault/Home.md":            Imported,
+		"vault/pic.png":            Imported,
+		"vault/unused.png":         Skipped,
+		"vault/doc.pdf":            Skipped,
+		"vault/.obsidian/app.json": Skipped,
+	}
+	for file, want := range statuses {
+		if got := findEntry(t, result, file).Status; got != want {
+			t.Errorf("status of %s = %s, want %s", file, got, want)
+		}
+	}
+}
+
+func TestReadNotionExport(t *testing.T) {
+	result := readArchive(t, map[string]string{
+		"Export/Page 0123456789abcdef0123456789abcdef.html": `<html><head><title>My Page</title></head><body>` +
+			`<header><h1 class="page-title">My Page</h1></header><div class="page-body">` +
+			`<p>Hello <strong>world</strong> <a href="Page%200123456789abcdef0123456789abcdef/Sub%20fedcba9876543210fedcba9876543210.html">Sub</a></p>` +
+			`<table><tr><th>a</th></tr><tr><td>1</td></tr></table></div></body></html>`,
+		"Export/Page 0123456789abcdef0123456789abcdef/Sub fedcba9876543210fedcba9876543210.html": `<html><head><title>Sub</title></head>` +
+			`<body><div class="page-body"><ul><li>x</li></ul></div></body></html>`,
+	})
+
+	page := findPage(t, result.Pages, "Export", "My Page")
+	sub := findPage(t, result.Pages, "Export", "My Page", "Sub")
+
+	for _, want := range []string{"Hello **world**", "[Sub](/documents/" + sub.ID + ")", "| a |"} {
+		if !strings.Contains(page.Content, want) {
+			t.Errorf("page content %q is missing %q", page.Content, want)
+		}
+	}
+	if strings.Contains(page.Content, "My Page") {
+		t.Errorf("page title was kept in the content: %q", page.Content)
+	}
+	if got := strings.TrimSpace(sub.Content); got != "- x" {
+		t.Errorf("subpage content = %q, want %q", got, "- x")
+	}
+}
+
+func TestReadSkipsUnsafeFiles(t *testing.T) {
+	result := readArchive(t, map[string]string{
+		"../evil.md":  "x",
+		"nested.zip":  "PK",
+		"__MACOSX/._": "x",
+		"note.md":     "kept",
+	})
+
+	if got := result.Count(); got != 1 {
+		t.Errorf("Count() = %d, want 1", got)
+	}
+	for _, file := range []string{"../evil.md", "nested.zip", "__MACOSX/._"} {
+		if got := findEntry(t, result, file).Status; got != Skipped {
+			t.Errorf("status of %s = %s, want %s", file, got, Skipped)
+		}
+	}
+}
+
+func TestReadRejectsTooManyFiles(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := range maxFiles + 1 {
+		if _, err := zw.Create(fmt.Sprintf("%d.md", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(zr); err == nil {
+		t.Error("Read() accepted an archive over the file limit")
+	}
+}
@@ -0,0 +1,248 @@
+package importer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/wrytehq/wryte/internal/markdown"
+	"github.com/yuin/goldmark/ast"
+)
+
+// wikiLink matches Obsidian links and embeds: [[Note]], [[Note#Heading]],
+// [[Note|Label]] and ![[image.png]].
+var wikiLink = regexp.MustCompile(`(!?)\[\[([^\[\]|#^]*)([#^][^\[\]|]*)?(?:\|([^\[\]]*))?\]\]`)
+
+// embeddable are the image types pages may show inline.
+var embeddable = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// rewriteLinks points the links of a page at the pages and images they
+// reference in the archive. It returns a description of the links that could
+// not be rewritten, or an empty string.
+func (a *archive) rewriteLinks(page *Page) string {
+	dir := path.Dir(page.file)
+	var unresolved, attachments int
+
+	content := outsideCode(page.Content, func(text string) string {
+		return wikiLink.ReplaceAllStringFunc(text, func(match string) string {
+			m := wikiLink.FindStringSubmatch(match)
+			target, heading, label := strings.TrimSpace(m[2]), m[3], strings.TrimSpace(m[4])
+			if label == "" {
+				label = target
+				if heading != "" {
+					label = strings.TrimSpace(target + " > " + strings.TrimLeft(heading, "#^"))
+				}
+			}
+			if target == "" {
+				// A link to a heading of the same page
+				return label
+			}
+
+			if note := a.findNote(target); note != nil {
+				return "[" + escapeLabel(label) + "](/documents/" + note.ID + ")"
+			}
+			if name, ok := a.findAsset(target); ok {
+				if uri := a.embed(name); uri != "" {
+					return "![" + escapeLabel(path.Base(target)) + "](" + uri + ")"
+				}
+				attachments++
+				return label
+			}
+			unresolved++
+			return label
+		})
+	})
+
+	// Markdown links are found through the parser, so that code and text that
+	// merely looks like a link are left alone
+	seen := make(map[string]bool)
+	_ = ast.Walk(markdown.Parse([]byte(content)), func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		var dest string
+		switch n := n.(type) {
+		case *ast.Link:
+			dest = string(n.Destination)
+		case *ast.Image:
+			dest = string(n.Destination)
+		default:
+			return ast.WalkContinue, nil
+		}
+		if dest == "" || seen[dest] || external(dest) {
+			return ast.WalkContinue, nil
+		}
+		seen[dest] = true
+
+		name := a.resolve(dir, dest)
+		if note, ok := a.notes[name]; ok {
+			content = replaceDestination(content, dest, "/documents/"+note.ID)
+		} else if _, ok := a.assets[name]; ok {
+			if uri := a.embed(name); uri != "" {
+				content = replaceDestination(content, dest, uri)
+			} else {
+				attachments++
+			}
+		} else {
+			unresolved++
+		}
+		return ast.WalkContinue, nil
+	})
+	page.Content = content
+
+	var warnings []string
+	if unresolved > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s to files missing from the archive", plural(unresolved, "link")))
+	}
+	if attachments > 0 {
+		warnings = append(warnings, fmt.Sprintf("%s not imported", plural(attachments, "attachment")))
+	}
+	return strings.Join(warnings, ", ")
+}
+
+// findNote looks a note up the way Obsidian resolves links: by path when the
+// target contains one, otherwise by name anywhere in the vault.
+func (a *archive) findNote(target string) *Page {
+	if isNote(target) {
+		target = strings.TrimSuffix(target, path.Ext(target))
+	}
+	if strings.Contains(target, "/") {
+		if page, ok := a.keys[cleanName(target)]; ok {
+			return page
+		}
+		for key, page := range a.keys {
+			if strings.HasSuffix(key, "/"+target) {
+				return page
+			}
+		}
+		return nil
+	}
+	return a.names[strings.ToLower(target)]
+}
+
+// findAsset looks a file other than a note up by path or by name.
+func (a *archive) findAsset(target string) (string, bool) {
+	if name := cleanName(target); name != "" {
+		if _, ok := a.assets[name]; ok {
+			return name, true
+		}
+	}
+	var found string
+	for name := range a.assets {
+		if strings.EqualFold(path.Base(name), path.Base(target)) && (found == "" || len(name) < len(found)) {
+			found = name
+		}
+	}
+	return found, found != ""
+}
+
+// resolve turns a relative link of a note in dir into the path of the file
+// it points at.
+func (a *archive) resolve(dir, dest string) string {
+	dest, _, _ = strings.Cut(dest, "#")
+	dest, _, _ = strings.Cut(dest, "?")
+	if unescaped, err := url.PathUnescape(dest); err == nil {
+		dest = unescaped
+	}
+	if name := cleanName(path.Join(dir, dest)); name != "" {
+		if _, ok := a.notes[name]; ok {
+			return name
+		}
+		if _, ok := a.assets[name]; ok {
+			return name
+		}
+	}
+	// Obsidian may also link relative to the root of the vault
+	return cleanName(dest)
+}
+
+// embed returns a data URI of an image of the archive, or an empty string
+// for files that cannot be shown inline.
+func (a *archive) embed(name string) string {
+	if uri, ok := a.embeds[name]; ok {
+		return uri
+	}
+	a.used[name] = true
+
+	data, err := a.readFile(a.assets[name])
+	if err != nil {
+		a.report(name, Failed, err.Error())
+		a.embeds[name] = ""
+		return ""
+	}
+	mediaType := http.DetectContentType(data)
+	if !embeddable[mediaType] {
+		a.embeds[name] = ""
+		return ""
+	}
+
+	uri := "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	a.embeds[name] = uri
+	a.report(name, Imported, "Embedded as an image")
+	return uri
+}
+
+// outsideCode applies replace to the parts of content outside of fenced code
+// blocks.
+func outsideCode(content string, replace func(string) string) string {
+	var b, text strings.Builder
+	var fence string
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case fence == "" && (strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~")):
+			fence = trimmed[:3]
+			b.WriteString(replace(text.String()))
+			text.Reset()
+			b.WriteString(line)
+		case fence != "":
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				fence = ""
+			}
+			b.WriteString(line)
+		default:
+			text.WriteString(line)
+		}
+	}
+	b.WriteString(replace(text.String()))
+	return b.String()
+}
+
+// replaceDestination swaps the destination of Markdown links and images,
+// written plainly or between angle brackets.
+func replaceDestination(content, dest, replacement string) string {
+	content = strings.ReplaceAll(content, "](<"+dest+">", "]("+replacement)
+	content = strings.ReplaceAll(content, "]("+dest+")", "]("+replacement+")")
+	content = strings.ReplaceAll(content, "]("+dest+" ", "]("+replacement+" ")
+	return content
+}
+
+// external reports whether a link leaves the archive: it has a scheme, is
+// absolute or points into the same page.
+func external(dest string) bool {
+	if strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "#") {
+		return true
+	}
+	u, err := url.Parse(dest)
+	return err == nil && u.Scheme != ""
+}
+
+func escapeLabel(label string) string {
+	return strings.NewReplacer(`[`, `\[`, `]`, `\]`).Replace(label)
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
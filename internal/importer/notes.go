@@ -0,0 +1,146 @@
+package importer
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"strings"
+
+	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/base"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/commonmark"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/strikethrough"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/table"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// notionID matches the identifier Notion appends to the names of exported
+// files and folders.
+var notionID = regexp.MustCompile(`\s+[0-9a-f]{32}$`)
+
+var htmlConverter = htmltomarkdown.NewConverter(
+	htmltomarkdown.WithPlugins(
+		base.NewBasePlugin(),
+		commonmark.NewCommonmarkPlugin(),
+		table.NewTablePlugin(),
+		strikethrough.NewStrikethroughPlugin(),
+	),
+)
+
+// titleFromName turns a file or folder name into a page title.
+func titleFromName(name string) string {
+	if isNote(name) {
+		name = strings.TrimSuffix(name, path.Ext(name))
+	}
+	name = strings.TrimSpace(notionID.ReplaceAllString(name, ""))
+	if name == "" {
+		return "Untitled"
+	}
+	return name
+}
+
+// stripFrontMatter removes the YAML metadata block Obsidian notes may start
+// with, which would otherwise render as text.
+func stripFrontMatter(content string) string {
+	content = strings.TrimPrefix(content, "\ufeff")
+	if !strings.HasPrefix(content, "---\n") && !strings.HasPrefix(content, "---\r\n") {
+		return content
+	}
+	rest := content[strings.Index(content, "\n")+1:]
+	for offset := 0; offset < len(rest); {
+		end := strings.IndexByte(rest[offset:], '\n')
+		line := rest[offset:]
+		if end >= 0 {
+			line = rest[offset : offset+end]
+		}
+		if strings.TrimRight(line, "\r") == "---" {
+			if end < 0 {
+				return ""
+			}
+			return strings.TrimLeft(rest[offset+end+1:], "\r\n")
+		}
+		if end < 0 {
+			break
+		}
+		offset += end + 1
+	}
+	return content
+}
+
+// stripTitleHeading removes a first level heading repeating the title at the
+// start of the content, as Notion writes it into every page.
+func stripTitleHeading(content, title string) string {
+	trimmed := strings.TrimLeft(content, "\r\n")
+	line, rest, _ := strings.Cut(trimmed, "\n")
+	if strings.TrimSpace(strings.TrimPrefix(line, "# ")) == title && strings.HasPrefix(line, "# ") {
+		return strings.TrimLeft(rest, "\r\n")
+	}
+	return content
+}
+
+// convertHTML converts an HTML page to Markdown. Only the body of Notion
+// pages is kept, leaving out the title and properties shown above it.
+func convertHTML(source string) (title, content string, err error) {
+	doc, err := html.Parse(strings.NewReader(source))
+	if err != nil {
+		return "", "", err
+	}
+	if n := findElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Title }); n != nil {
+		title = strings.TrimSpace(textContent(n))
+	}
+
+	body := findElement(doc, func(n *html.Node) bool { return hasClass(n, "page-body") })
+	if body == nil {
+		body = findElement(doc, func(n *html.Node) bool { return n.DataAtom == atom.Body })
+	}
+	if body == nil {
+		body = doc
+	}
+
+	markdown, err := htmlConverter.ConvertNode(body)
+	if err != nil {
+		return "", "", err
+	}
+	return title, string(bytes.TrimSpace(markdown)) + "\n", nil
+}
+
+func findElement(n *html.Node, match func(*html.Node) bool) *html.Node {
+	if n.Type == html.ElementNode && match(n) {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == "class" {
+			for _, c := range strings.Fields(attr.Val) {
+				if c == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func textContent(n *html.Node) string {
+	var b strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
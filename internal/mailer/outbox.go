@@ -0,0 +1,177 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for messages that are not in the outbox.
+var ErrNotFound = errors.New("mailer: message not found")
+
+// Outbox keeps messages as .eml files in a directory instead of sending them,
+// so that emails can be read during development without a mail server.
+type Outbox struct {
+	dir  string
+	from *mail.Address
+}
+
+// NewOutbox returns a mailer writing messages from the address from to dir,
+// which is created if needed.
+func NewOutbox(dir string, from *mail.Address) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating outbox: %w", err)
+	}
+	return &Outbox{dir: dir, from: from}, nil
+}
+
+func (o *Outbox) Send(ctx context.Context, msg *Message) error {
+	data, err := compose(o.from, msg)
+	if err != nil {
+		return err
+	}
+
+	// Names sort in the order messages were sent
+	name := time.Now().UTC().Format("20060102T150405.000000000") + "-" + strings.ToLower(rand.Text()[:8]) + ".eml"
+	tmp, err := os.CreateTemp(o.dir, ".message-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(o.dir, name))
+}
+
+// StoredMessage is a message kept in the outbox.
+type StoredMessage struct {
+	// Name identifies the message in the outbox.
+	Name string
+	From string
+	Date time.Time
+	Message
+}
+
+// List returns the messages in the outbox, the most recent first. Only their
+// headers are read.
+func (o *Outbox) List() ([]StoredMessage, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var messages []StoredMessage
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".eml") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(o.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, err := mail.ReadMessage(f)
+		if err == nil {
+			messages = append(messages, storedMessage(entry.Name(), m.Header))
+		}
+		f.Close()
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+// Read returns a message of the outbox with its bodies, along with the raw
+// message.
+func (o *Outbox) Read(name string) (*StoredMessage, []byte, error) {
+	if !strings.HasSuffix(name, ".eml") || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, nil, ErrNotFound
+	}
+	data, err := os.ReadFile(filepath.Join(o.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	msg := storedMessage(name, m.Header)
+	if err := readBody(&msg.Message, m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body); err != nil {
+		return nil, nil, err
+	}
+	return &msg, data, nil
+}
+
+func storedMessage(name string, h mail.Header) StoredMessage {
+	var dec mime.WordDecoder
+	subject, err := dec.DecodeHeader(h.Get("Subject"))
+	if err != nil {
+		subject = h.Get("Subject")
+	}
+	date, _ := h.Date()
+	return StoredMessage{
+		Name:    name,
+		From:    h.Get("From"),
+		Date:    date,
+		Message: Message{To: h.Get("To"), Subject: subject},
+	}
+}
+
+// readBody decodes the text and HTML bodies of a message written by compose.
+func readBody(msg *Message, contentType, encoding string, body io.Reader) error {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			// NextPart decodes quoted-printable parts itself
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if err := readBody(msg, part.Header.Get("Content-Type"), "", part); err != nil {
+				return err
+			}
+		}
+	}
+
+	if strings.EqualFold(encoding, "quoted-printable") {
+		body = quotedprintable.NewReader(body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	switch mediaType {
+	case "text/plain":
+		msg.Text = string(data)
+	case "text/html":
+		msg.HTML = string(data)
+	}
+	return nil
+}
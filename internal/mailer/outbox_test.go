@@ -0,0 +1,72 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestOutbox(t *testing.T) {
+	from, _ := mail.ParseAddress("Wryte <wryte@example.com>")
+	o, err := NewOutbox(t.TempDir(), from)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("é", 100)
+	messages := []*Message{
+		{To: "bob@example.com", Subject: "Réinitialiser", Text: "Hello\nhttps://example.com/reset-password?token=A=B\n"},
+		{To: "Al <al@example.com>", Subject: "Invitation", Text: "text " + long + "\n", HTML: `<p style="color: red">` + long + "</p>"},
+	}
+	for _, msg := range messages {
+		if err := o.Send(context.Background(), msg); err != nil {
+			t.Fatalf("Send() = %v", err)
+		}
+	}
+
+	list, err := o.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Subject != "Invitation" || list[1].Subject != "Réinitialiser" {
+		t.Fatalf("List() = %+v, want the two messages, the most recent first", list)
+	}
+	if list[0].To != `"Al" <al@example.com>` || list[0].From != `"Wryte" <wryte@example.com>` {
+		t.Errorf("List()[0] is to %q from %q", list[0].To, list[0].From)
+	}
+
+	for i, stored := range list {
+		want := messages[len(messages)-1-i]
+		msg, raw, err := o.Read(stored.Name)
+		if err != nil {
+			t.Fatalf("Read(%q) = %v", stored.Name, err)
+		}
+		// Bodies are sent with CRLF line endings
+		if got := strings.ReplaceAll(msg.Text, "\r\n", "\n"); got != want.Text {
+			t.Errorf("Text = %q, want %q", got, want.Text)
+		}
+		if msg.HTML != want.HTML {
+			t.Errorf("HTML = %q, want %q", msg.HTML, want.HTML)
+		}
+		for _, line := range strings.Split(string(raw), "\r\n") {
+			if len(line) > 998 {
+				t.Errorf("raw message has a line of %d characters", len(line))
+			}
+		}
+	}
+
+	for _, name := range []string{"../x.eml", ".message-1", "missing.eml"} {
+		if _, _, err := o.Read(name); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Read(%q) = %v, want ErrNotFound", name, err)
+		}
+	}
+}
+
+func TestComposeRejectsRecipient(t *testing.T) {
+	from, _ := mail.ParseAddress("wryte@example.com")
+	if _, err := compose(from, &Message{To: "not an address\r\nBcc: x@example.com"}); err == nil {
+		t.Error("compose() accepted an invalid recipient")
+	}
+}
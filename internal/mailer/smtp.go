@@ -0,0 +1,109 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+
+	"github.com/wrytehq/wryte/internal/config"
+)
+
+// SMTP sends messages through an SMTP server. Depending on its Security
+// setting, the connection is upgraded with STARTTLS, which is then required,
+// encrypted from the start, or left in clear text. Credentials are never
+// sent in clear text, except to a server on the same host.
+type SMTP struct {
+	cfg  config.MailConfig
+	from *mail.Address
+}
+
+// NewSMTP returns a mailer sending messages from the address from through the
+// server described by cfg.
+func NewSMTP(cfg config.MailConfig, from *mail.Address) *SMTP {
+	return &SMTP{cfg: cfg, from: from}
+}
+
+func (m *SMTP) Send(ctx context.Context, msg *Message) error {
+	data, err := compose(m.from, msg)
+	if err != nil {
+		return err
+	}
+	to, _ := mail.ParseAddress(msg.To)
+
+	c, err := m.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("mailer: connecting to %s: %w", m.cfg.Host, err)
+	}
+	defer c.Close()
+
+	if m.cfg.Security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return errors.New("mailer: the SMTP server does not support STARTTLS")
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return fmt.Errorf("mailer: STARTTLS: %w", err)
+		}
+	}
+	if m.cfg.Username != "" {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("mailer: the SMTP server does not support authentication")
+		}
+		if err := c.Auth(smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)); err != nil {
+			return fmt.Errorf("mailer: authenticating: %w", err)
+		}
+	}
+
+	if err := c.Mail(m.from.Address); err != nil {
+		return fmt.Errorf("mailer: MAIL FROM: %w", err)
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("mailer: RCPT TO: %w", err)
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("mailer: DATA: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("mailer: writing message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("mailer: writing message: %w", err)
+	}
+	return c.Quit()
+}
+
+// dial connects to the SMTP server, giving up when ctx is done.
+func (m *SMTP) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+
+	var conn net.Conn
+	var err error
+	if m.cfg.Security == "tls" {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: m.cfg.Host}}
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The SMTP client has no notion of contexts, bound the whole exchange
+	// by the deadline instead
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
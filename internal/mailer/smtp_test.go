@@ -0,0 +1,137 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/wrytehq/wryte/internal/config"
+)
+
+// fakeSMTP accepts one connection on a local port and records the commands
+// and the message it receives. The extensions are advertised in reply to
+// EHLO.
+func fakeSMTP(t *testing.T, extensions ...string) (port int, session <-chan []string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	done := make(chan []string, 1)
+	go func() {
+		var lines []string
+		defer func() { done <- lines }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		tc := textproto.NewConn(conn)
+		tc.PrintfLine("220 localhost ready")
+		for {
+			line, err := tc.ReadLine()
+			if err != nil {
+				return
+			}
+			lines = append(lines, line)
+			verb, _, _ := strings.Cut(strings.ToUpper(line), " ")
+			switch verb {
+			case "EHLO":
+				reply := append([]string{"localhost"}, extensions...)
+				for i, ext := range reply {
+					sep := "-"
+					if i == len(reply)-1 {
+						sep = " "
+					}
+					tc.PrintfLine("250%s%s", sep, ext)
+				}
+			case "AUTH":
+				tc.PrintfLine("235 authenticated")
+			case "DATA":
+				tc.PrintfLine("354 go ahead")
+				data, err := tc.ReadDotLines()
+				if err != nil {
+					return
+				}
+				lines = append(lines, data...)
+				tc.PrintfLine("250 queued")
+			case "QUIT":
+				tc.PrintfLine("221 bye")
+				return
+			default:
+				tc.PrintfLine("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, done
+}
+
+func TestSMTP(t *testing.T) {
+	port, session := fakeSMTP(t, "AUTH PLAIN")
+	from, _ := mail.ParseAddress("Wryte <wryte@example.com>")
+	m := NewSMTP(config.MailConfig{
+		Host:     "localhost",
+		Port:     port,
+		Username: "user",
+		Password: "pass",
+		Security: "none",
+	}, from)
+
+	err := m.Send(context.Background(), &Message{To: "Bob <bob@example.com>", Subject: "Hi", Text: "Hello\n.\nBye"})
+	if err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	lines := <-session
+	got := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"AUTH PLAIN",
+		"MAIL FROM:<wryte@example.com>",
+		"RCPT TO:<bob@example.com>",
+		"Subject: Hi",
+		"Hello\n.\nBye",
+		"QUIT",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("session is missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestSMTPRequiresSTARTTLS(t *testing.T) {
+	port, session := fakeSMTP(t)
+	from, _ := mail.ParseAddress("wryte@example.com")
+	m := NewSMTP(config.MailConfig{Host: "localhost", Port: port, Security: "starttls"}, from)
+
+	err := m.Send(context.Background(), &Message{To: "bob@example.com", Subject: "Hi", Text: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("Send() = %v, want it to refuse the server", err)
+	}
+	if lines := <-session; strings.Contains(strings.Join(lines, "\n"), "MAIL FROM") {
+		t.Errorf("message was sent in clear text: %q", lines)
+	}
+}
+
+func TestSMTPDialError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	from, _ := mail.ParseAddress("wryte@example.com")
+	m := NewSMTP(config.MailConfig{Host: "127.0.0.1", Port: port, Security: "none"}, from)
+	err = m.Send(context.Background(), &Message{To: "bob@example.com", Subject: "Hi", Text: "Hello"})
+	if err == nil || !strings.Contains(err.Error(), "connecting to 127.0.0.1") {
+		t.Errorf("Send() = %v, want a connection error", err)
+	}
+}
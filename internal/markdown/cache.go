@@ -0,0 +1,57 @@
+package markdown
+
+import (
+	"container/list"
+	"html/template"
+	"sync"
+)
+
+// lru is a fixed size cache of rendered HTML, keyed by the hash of the
+// source. The least recently used entry is evicted when it is full.
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[[32]byte]*list.Element
+}
+
+type lruEntry struct {
+	key  [32]byte
+	html template.HTML
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[[32]byte]*list.Element, size),
+	}
+}
+
+func (c *lru) get(key [32]byte) (template.HTML, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry).html, true
+}
+
+func (c *lru) put(key [32]byte, html template.HTML) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, html: html})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
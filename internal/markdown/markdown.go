@@ -0,0 +1,216 @@
+// Package markdown renders document content, written in CommonMark with the
+// GitHub flavored tables, task lists and strikethrough plus footnotes, to HTML
+// that is safe to embed in a page. Raw HTML in the source is left out.
+package markdown
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"html/template"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/wrytehq/wryte/internal/imaging"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// idPrefix starts every id attribute of rendered content, so that headings and
+// footnotes cannot clash with the ids of the page around them.
+const idPrefix = "md-"
+
+// cacheSize is the number of rendered documents kept in memory.
+const cacheSize = 1024
+
+var (
+	converter = newConverter(Options{IDPrefix: idPrefix, ResponsiveImages: true})
+
+	policy = newPolicy()
+
+	cache = newLRU(cacheSize)
+)
+
+// Options changes how content is rendered for use outside of a page, such as
+// in an exported file.
+type Options struct {
+	// IDPrefix replaces the prefix of id attributes, which keeps the ids of
+	// several documents rendered into one file apart.
+	IDPrefix string
+	// XHTML closes void elements, as XML based formats require.
+	XHTML bool
+	// Image returns the source to use for an image instead of its original
+	// destination, or an empty string to keep it.
+	Image func(dest string) string
+	// ResponsiveImages lets browsers load a scaled down variant of images
+	// attached to documents, and only when they are about to be seen.
+	ResponsiveImages bool
+}
+
+// attachedImage matches the address of a file attached to a document, as
+// served to members or on a published page.
+var attachedImage = regexp.MustCompile(`^(/p/[^/]+/[0-9a-f-]{36}|/documents/[0-9a-f-]{36})/attachments/[0-9a-f-]{36}$`)
+
+// imageSizes tells browsers how wide images are displayed, content being at
+// most 960 pixels wide on large screens.
+const imageSizes = "(min-width: 1024px) 960px, 100vw"
+
+func newConverter(opts Options) goldmark.Markdown {
+	transformers := []util.PrioritizedValue{util.Prioritized(headingAnchors{prefix: opts.IDPrefix}, 100)}
+	if opts.Image != nil {
+		transformers = append(transformers, util.Prioritized(imageSources(opts.Image), 200))
+	}
+	if opts.ResponsiveImages {
+		transformers = append(transformers, util.Prioritized(responsiveImages{}, 300))
+	}
+	var rendererOpts []renderer.Option
+	if opts.XHTML {
+		rendererOpts = append(rendererOpts, html.WithXHTML())
+	}
+
+	return goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.NewFootnote(extension.WithFootnoteIDPrefix(opts.IDPrefix)),
+		),
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+			parser.WithASTTransformers(transformers...),
+		),
+		goldmark.WithRendererOptions(rendererOpts...),
+	)
+}
+
+// newPolicy allows the markup of user generated content along with what the
+// renderer produces for heading anchors, task lists and footnotes. It guards
+// against unsafe links and markup the renderer might still let through.
+func newPolicy() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^(heading-anchor|footnote-ref|footnote-backref|footnotes)$`)).Globally()
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(noteref|endnotes|backlink)$`)).Globally()
+	p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	p.AllowAttrs("checked", "disabled").OnElements("input")
+	p.AllowAttrs("style").Matching(regexp.MustCompile(`^text-align:\s*(left|center|right)$`)).OnElements("th", "td")
+	p.AllowAttrs("srcset").Matching(regexp.MustCompile(`^(/[\w/.?=-]+ \d+w)(, /[\w/.?=-]+ \d+w)*$`)).OnElements("img")
+	p.AllowAttrs("sizes").Matching(regexp.MustCompile(`^[\w\s(),:-]+$`)).OnElements("img")
+	p.AllowAttrs("loading").Matching(regexp.MustCompile(`^lazy$`)).OnElements("img")
+	p.AllowDataURIImages()
+	return p
+}
+
+// headingAnchors prefixes the generated heading ids and links every heading to
+// itself, so a section can be linked to.
+type headingAnchors struct {
+	prefix string
+}
+
+func (t headingAnchors) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		id, ok := heading.AttributeString("id")
+		if !ok {
+			return ast.WalkSkipChildren, nil
+		}
+		anchor := append([]byte(t.prefix), id.([]byte)...)
+		heading.SetAttributeString("id", anchor)
+
+		link := ast.NewLink()
+		link.Destination = append([]byte("#"), anchor...)
+		link.SetAttributeString("class", []byte("heading-anchor"))
+		link.AppendChild(link, ast.NewString([]byte("#")))
+		heading.AppendChild(heading, link)
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// imageSources replaces the destination of images.
+type imageSources func(dest string) string
+
+func (t imageSources) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if image, ok := n.(*ast.Image); ok && entering {
+			if src := t(string(image.Destination)); src != "" {
+				image.Destination = []byte(src)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// responsiveImages offers the scaled down variants of attached images with
+// srcset and defers loading them.
+type responsiveImages struct{}
+
+func (t responsiveImages) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	_ = ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		image, ok := n.(*ast.Image)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		image.SetAttributeString("loading", []byte("lazy"))
+		if dest := string(image.Destination); attachedImage.MatchString(dest) {
+			var srcset []string
+			for _, w := range imaging.Widths {
+				srcset = append(srcset, fmt.Sprintf("%s?variant=%d %dw", dest, w, w))
+			}
+			image.SetAttributeString("srcset", []byte(strings.Join(srcset, ", ")))
+			image.SetAttributeString("sizes", []byte(imageSizes))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// HeadingAnchor reports whether n is the link added to a heading to point at
+// itself, which only makes sense in a page.
+func HeadingAnchor(n ast.Node) bool {
+	class, ok := n.AttributeString("class")
+	if !ok {
+		return false
+	}
+	b, ok := class.([]byte)
+	return ok && string(b) == "heading-anchor"
+}
+
+// Parse parses Markdown source the way Render does, for callers producing
+// other formats than HTML.
+func Parse(source []byte) ast.Node {
+	return converter.Parser().Parse(text.NewReader(source))
+}
+
+// Export is Render for content leaving the application, rendered with opts
+// and not cached.
+func Export(source string, opts Options) template.HTML {
+	var buf bytes.Buffer
+	if err := newConverter(opts).Convert([]byte(source), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
+
+// Render converts Markdown source to sanitized HTML. Results are cached by the
+// hash of the source, so rendering a document again costs a lookup until its
+// content changes.
+func Render(source string) template.HTML {
+	key := sha256.Sum256([]byte(source))
+	if out, ok := cache.get(key); ok {
+		return out
+	}
+
+	var buf bytes.Buffer
+	if err := converter.Convert([]byte(source), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(source))
+	}
+	out := template.HTML(policy.SanitizeBytes(buf.Bytes()))
+	cache.put(key, out)
+	return out
+}
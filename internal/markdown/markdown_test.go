@@ -0,0 +1,108 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		banned []string
+	}{
+		{"script", "<script>alert(1)</script>\n\nok", []string{"<script", "alert"}},
+		{"event handler", `<div onclick="steal()" class="fixed inset-0">raw</div>`, []string{"onclick", "steal", "fixed"}},
+		{"image handler", "<img src=x onerror=alert(1)>", []string{"onerror", "alert"}},
+		{"javascript link", "[bad](javascript:alert(1))", []string{"javascript:", "href"}},
+		{"inline style", `<p style="position:fixed">x</p>`, []string{"style", "position"}},
+		{"iframe", `<iframe src="https://example.org"></iframe>`, []string{"<iframe"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := string(Render(tt.source))
+			for _, b := range tt.banned {
+				if strings.Contains(out, b) {
+					t.Errorf("Render(%q) = %q, contains %q", tt.source, out, b)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderMarkup(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   []string
+	}{
+		{
+			name:   "heading anchor",
+			source: "# Hello World",
+			want: []string{
+				`<h1 id="md-hello-world">`,
+				`<a href="#md-hello-world" class="heading-anchor" rel="nofollow">#</a>`,
+			},
+		},
+		{
+			name:   "task list",
+			source: "- [x] done\n- [ ] todo",
+			want: []string{
+				`<input checked="" disabled="" type="checkbox"> done`,
+				`<input disabled="" type="checkbox"> todo`,
+			},
+		},
+		{
+			name:   "table alignment",
+			source: "| a | b |\n|:-:|--:|\n| 1 | 2 |",
+			want:   []string{`<th style="text-align:center">a</th>`, `<td style="text-align:right">2</td>`},
+		},
+		{
+			name:   "footnote",
+			source: "Text[^1]\n\n[^1]: Note.",
+			want: []string{
+				`<sup id="md-fnref:1"><a href="#md-fn:1" class="footnote-ref" role="doc-noteref"`,
+				`<div class="footnotes" role="doc-endnotes">`,
+				`<li id="md-fn:1">`,
+			},
+		},
+		{
+			name:   "strikethrough and autolink",
+			source: "~~gone~~ https://example.org",
+			want:   []string{"<del>gone</del>", `<a href="https://example.org" rel="nofollow">`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := string(Render(tt.source))
+			for _, w := range tt.want {
+				if !strings.Contains(out, w) {
+					t.Errorf("Render(%q) = %q, missing %q", tt.source, out, w)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderCache(t *testing.T) {
+	source := "# Cached"
+	first := Render(source)
+	if got := Render(source); got != first {
+		t.Errorf("second Render = %q, want %q", got, first)
+	}
+
+	c := newLRU(2)
+	a, b, d := [32]byte{1}, [32]byte{2}, [32]byte{3}
+	c.put(a, "a")
+	c.put(b, "b")
+	c.get(a)
+	c.put(d, "d")
+	if _, ok := c.get(b); ok {
+		t.Error("least recently used entry was kept")
+	}
+	if got, ok := c.get(a); !ok || got != "a" {
+		t.Errorf("get(a) = %q, %t, want it kept", got, ok)
+	}
+}
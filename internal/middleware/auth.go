@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/wrytehq/wryte/internal/database"
@@ -52,7 +53,12 @@ func Authenticated(db database.Service) func(http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			session, err := GetSession(r, db)
 			if err != nil {
-				http.Redirect(w, r, "/login", http.StatusSeeOther)
+				// Pages come back to where they were after signing in
+				target := "/login"
+				if r.Method == http.MethodGet && r.URL.Path != "/" {
+					target += "?next=" + url.QueryEscape(r.URL.RequestURI())
+				}
+				http.Redirect(w, r, target, http.StatusSeeOther)
 				return
 			}
 
@@ -76,6 +82,142 @@ func Guest(db database.Service) func(http.Handler) http.Handler {
 	}
 }
 
+// unverifiedRoutes are the routes that accounts whose email address is not
+// verified can still use when the policy blocks them: enough to verify it, or
+// to correct it first.
+var unverifiedRoutes = []string{
+	"GET /verify-email",
+	"POST /verify-email",
+	"GET /logout",
+	"GET /settings/account",
+	"POST /settings/account/profile",
+	"POST /settings/account/email",
+	"GET /settings/account/email/confirm",
+	"POST /settings/account/password",
+}
+
+// limitedRoutes are the routes that accounts whose email address is not
+// verified cannot use when the policy limits them, those reaching other
+// people.
+var limitedRoutes = []string{
+	"POST /workspaces/{workspaceId}/invitations",
+	"POST /documents/{documentId}/permissions",
+	"POST /documents/{documentId}/public-link",
+	"POST /workspaces/{workspaceId}/public-link",
+}
+
+// EmailVerified sends signed in users whose email address is not verified to
+// /verify-email, depending on policy: "block" lets them use nothing else,
+// "limit" only keeps them from the routes reaching other people and "off"
+// lets them through. It must run after Authenticated.
+func EmailVerified(db database.Service, policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if policy == "off" {
+			return next
+		}
+
+		// The patterns are matched the same way as the routes themselves
+		routes := http.NewServeMux()
+		patterns := limitedRoutes
+		if policy == "block" {
+			patterns = unverifiedRoutes
+		}
+		for _, pattern := range patterns {
+			routes.Handle(pattern, next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := routes.Handler(r)
+			restricted := pattern == ""
+			if policy == "limit" {
+				restricted = pattern != ""
+			}
+			if !restricted {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, _ := GetUserID(r)
+			var verified bool
+			query := `SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1`
+			if err := db.GetDB().QueryRowContext(r.Context(), query, userID).Scan(&verified); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if verified {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Redirect", "/verify-email")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			http.Redirect(w, r, "/verify-email", http.StatusSeeOther)
+		})
+	}
+}
+
+// twoFactorSetupRoutes are the routes that users who have to set up
+// two-factor authentication, with an authenticator app or a passkey, can
+// still use until they do. They include the email verification routes,
+// which EmailVerified may send them to first.
+var twoFactorSetupRoutes = []string{
+	"GET /settings/account/two-factor",
+	"POST /settings/account/two-factor",
+	"GET /settings/account/passkeys",
+	"POST /settings/account/passkeys/options",
+	"POST /settings/account/passkeys",
+	"GET /verify-email",
+	"POST /verify-email",
+	"GET /logout",
+}
+
+// TwoFactorRequired sends signed in users who have to use two-factor
+// authentication, because the instance requires it or a workspace they belong
+// to does, to set it up before they can use anything else. It must run after
+// Authenticated.
+func TwoFactorRequired(db database.Service, instance bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		routes := http.NewServeMux()
+		for _, pattern := range twoFactorSetupRoutes {
+			routes.Handle(pattern, next)
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, pattern := routes.Handler(r); pattern != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			userID, _ := GetUserID(r)
+			var missing bool
+			query := `SELECT u.totp_enabled_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM passkeys p WHERE p.user_id = u.id)
+				AND ($2 OR EXISTS (
+				SELECT 1 FROM workspace_members m JOIN workspaces w ON w.id = m.workspace_id
+				WHERE m.user_id = u.id AND w.require_two_factor))
+				FROM users u WHERE u.id = $1`
+			if err := db.GetDB().QueryRowContext(r.Context(), query, userID, instance).Scan(&missing); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			if !missing {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if r.Header.Get("HX-Request") == "true" {
+				w.Header().Set("HX-Redirect", "/settings/account/two-factor")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			http.Redirect(w, r, "/settings/account/two-factor", http.StatusSeeOther)
+		})
+	}
+}
+
 func GetUserID(r *http.Request) (string, bool) {
 	userID, ok := r.Context().Value(UserIDKey).(string)
 	return userID, ok
@@ -39,13 +39,71 @@ func (s *Server) Routes(h *handler.Handler) http.Handler {
 	{
 		loginMux := http.NewServeMux()
 		loginMux.HandleFunc("GET /", h.LoginPage())
-		loginMux.HandleFunc("POST /", h.LoginForm())
+		if !s.config.Auth.SSOOnly {
+			loginMux.HandleFunc("POST /", h.LoginForm())
+		}
 
 		mux.Handle("/login", h.Guest(loginMux))
 	}
 
-	// Guest routes - register (only for cloud)
-	if !s.config.IsSelfHosted() && s.config.IsCloud() {
+	// Guest routes - second step of signing in with two-factor authentication
+	{
+		twoFactorMux := http.NewServeMux()
+		twoFactorMux.HandleFunc("GET /login/two-factor", h.TwoFactorPage())
+		twoFactorMux.HandleFunc("POST /login/two-factor", h.TwoFactorForm())
+		twoFactorMux.HandleFunc("POST /login/two-factor/passkey/options", h.TwoFactorPasskeyOptions())
+		twoFactorMux.HandleFunc("POST /login/two-factor/passkey", h.TwoFactorPasskey())
+
+		mux.Handle("/login/two-factor", h.Guest(twoFactorMux))
+		mux.Handle("/login/two-factor/", h.Guest(twoFactorMux))
+	}
+
+	// Guest routes - single sign-on with an OpenID Connect identity provider
+	if s.config.Auth.OIDC.Enabled() {
+		ssoMux := http.NewServeMux()
+		ssoMux.HandleFunc("GET /login/sso", h.SSOLogin())
+		ssoMux.HandleFunc("GET /login/sso/callback", h.SSOCallback())
+
+		mux.Handle("/login/sso", h.Guest(ssoMux))
+		mux.Handle("/login/sso/", h.Guest(ssoMux))
+	}
+
+	// Guest routes - signing in with a passkey instead of a password, unless
+	// single sign-on is the only way in
+	if !s.config.Auth.SSOOnly {
+		passkeyMux := http.NewServeMux()
+		passkeyMux.HandleFunc("POST /login/passkey/options", h.PasskeyLoginOptions())
+		passkeyMux.HandleFunc("POST /login/passkey", h.PasskeyLogin())
+
+		mux.Handle("/login/passkey", h.Guest(passkeyMux))
+		mux.Handle("/login/passkey/", h.Guest(passkeyMux))
+	}
+
+	// Guest routes - password reset, for passwords kept in the database
+	if !s.config.Auth.SSOOnly && s.config.Auth.ManagesPasswords() {
+		passwordMux := http.NewServeMux()
+		passwordMux.HandleFunc("GET /forgot-password", h.ForgotPasswordPage())
+		passwordMux.HandleFunc("POST /forgot-password", h.ForgotPasswordForm())
+		passwordMux.HandleFunc("GET /reset-password", h.ResetPasswordPage())
+		passwordMux.HandleFunc("POST /reset-password", h.ResetPasswordForm())
+
+		mux.Handle("/forgot-password", h.Guest(passwordMux))
+		mux.Handle("/reset-password", h.Guest(passwordMux))
+	}
+
+	// Guest routes - connecting an identity of a directory or an identity
+	// provider to an existing account, from the link sent to its owner
+	if s.config.Auth.Provider == "ldap" || s.config.Auth.OIDC.Enabled() {
+		linkMux := http.NewServeMux()
+		linkMux.HandleFunc("GET /link-account", h.LinkAccountPage())
+		linkMux.HandleFunc("POST /link-account", h.LinkAccountForm())
+
+		mux.Handle("/link-account", h.Guest(linkMux))
+	}
+
+	// Guest routes - register (only for cloud, accounts are created on the
+	// first sign in with single sign-on only or a directory server)
+	if !s.config.IsSelfHosted() && s.config.IsCloud() && !s.config.Auth.SSOOnly && s.config.Auth.ManagesPasswords() {
 		cloudMux := http.NewServeMux()
 		cloudMux.HandleFunc("GET /", h.RegisterPage())
 		cloudMux.HandleFunc("POST /", h.RegisterForm())
@@ -53,14 +111,105 @@ func (s *Server) Routes(h *handler.Handler) http.Handler {
 		mux.Handle("/register", h.Guest(cloudMux))
 	}
 
+	// Development mailbox - emails kept by the outbox instead of being sent
+	if s.config.IsDevelopment() && s.config.Mail.Backend == "outbox" {
+		mux.HandleFunc("GET /dev/mailbox", h.DevMailbox())
+		mux.HandleFunc("GET /dev/mailbox/{name}", h.DevMailboxMessage())
+	}
+
+	// Public share links - read-only, no auth required
+	mux.HandleFunc("GET /p/{slug}", h.PublicPage())
+	mux.HandleFunc("POST /p/{slug}", h.UnlockPublicLink())
+	mux.HandleFunc("GET /p/{slug}/{documentId}", h.PublicPage())
+	mux.HandleFunc("GET /p/{slug}/{documentId}/attachments/{attachmentId}", h.PublicAttachment())
+
 	// Authenticated routes
 	{
 		authenticatedMux := http.NewServeMux()
 		authenticatedMux.HandleFunc("GET /{$}", h.Home())
 		authenticatedMux.HandleFunc("GET /logout", h.Logout())
+		authenticatedMux.HandleFunc("GET /verify-email", h.VerifyEmail())
+		authenticatedMux.HandleFunc("POST /verify-email", h.ResendVerificationEmail())
+		authenticatedMux.HandleFunc("GET /settings/account", h.AccountSettings())
+		authenticatedMux.HandleFunc("POST /settings/account/profile", h.UpdateProfile())
+		authenticatedMux.HandleFunc("POST /settings/account/email", h.ChangeEmail())
+		authenticatedMux.HandleFunc("GET /settings/account/email/confirm", h.ConfirmEmail())
+		if s.config.Auth.ManagesPasswords() {
+			authenticatedMux.HandleFunc("POST /settings/account/password", h.ChangePassword())
+		}
+		authenticatedMux.HandleFunc("GET /settings/account/two-factor", h.TwoFactorSettings())
+		authenticatedMux.HandleFunc("POST /settings/account/two-factor", h.EnableTwoFactor())
+		authenticatedMux.HandleFunc("POST /settings/account/two-factor/recovery-codes", h.RegenerateRecoveryCodes())
+		authenticatedMux.HandleFunc("POST /settings/account/two-factor/disable", h.DisableTwoFactor())
+		authenticatedMux.HandleFunc("GET /settings/account/passkeys", h.PasskeySettings())
+		authenticatedMux.HandleFunc("POST /settings/account/passkeys/options", h.PasskeyRegistrationOptions())
+		authenticatedMux.HandleFunc("POST /settings/account/passkeys", h.RegisterPasskey())
+		authenticatedMux.HandleFunc("DELETE /settings/account/passkeys/{passkeyId}", h.DeletePasskey())
+		authenticatedMux.HandleFunc("POST /documents", h.CreateDocument())
 		authenticatedMux.HandleFunc("GET /documents/{documentId}", h.ViewDocument())
-
-		mux.Handle("/", h.Authenticated(authenticatedMux))
+		authenticatedMux.HandleFunc("PUT /documents/{documentId}", h.UpdateDocument())
+		authenticatedMux.HandleFunc("PATCH /documents/{documentId}", h.UpdateDocument())
+		authenticatedMux.HandleFunc("DELETE /documents/{documentId}", h.DeleteDocument())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/children", h.DocumentChildren())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/move", h.MoveDocument())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/collab", h.CollabStream())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/collab", h.CollabOps())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/cursor", h.CollabCursor())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/permissions", h.DocumentPermissions())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/permissions", h.ShareDocument())
+		authenticatedMux.HandleFunc("DELETE /documents/{documentId}/permissions/{permissionId}", h.RevokePermission())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/public-link", h.SaveDocumentPublicLink())
+		authenticatedMux.HandleFunc("DELETE /documents/{documentId}/public-link", h.DeleteDocumentPublicLink())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/archive", h.ArchiveDocument())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/unarchive", h.UnarchiveDocument())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/restore", h.RestoreDocument())
+		authenticatedMux.HandleFunc("DELETE /documents/{documentId}/trash", h.PurgeDocument())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/history", h.DocumentHistory())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/export", h.ExportDocument())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/attachments", h.DocumentAttachments())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/attachments", h.UploadAttachment())
+		authenticatedMux.HandleFunc("GET /documents/{documentId}/attachments/{attachmentId}", h.DownloadAttachment())
+		authenticatedMux.HandleFunc("DELETE /documents/{documentId}/attachments/{attachmentId}", h.DeleteAttachment())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/images", h.UploadImage())
+		authenticatedMux.HandleFunc("POST /documents/{documentId}/revisions/{revisionId}/restore", h.RestoreRevision())
+
+		authenticatedMux.HandleFunc("GET /search", h.Search())
+
+		authenticatedMux.HandleFunc("GET /workspaces", h.Workspaces())
+		authenticatedMux.HandleFunc("POST /workspaces", h.CreateWorkspace())
+		authenticatedMux.HandleFunc("GET /workspaces/{workspaceId}", h.WorkspaceSettings())
+		authenticatedMux.HandleFunc("PUT /workspaces/{workspaceId}", h.RenameWorkspace())
+		authenticatedMux.HandleFunc("DELETE /workspaces/{workspaceId}", h.DeleteWorkspace())
+		authenticatedMux.HandleFunc("PUT /workspaces/{workspaceId}/two-factor", h.RequireTwoFactor())
+		authenticatedMux.HandleFunc("GET /workspaces/{workspaceId}/backup", h.BackupWorkspace())
+		authenticatedMux.HandleFunc("GET /workspaces/{workspaceId}/import", h.Import())
+		authenticatedMux.HandleFunc("POST /workspaces/{workspaceId}/import", h.ImportDocuments())
+		authenticatedMux.HandleFunc("GET /workspaces/{workspaceId}/archive", h.Archive())
+		authenticatedMux.HandleFunc("POST /workspaces/{workspaceId}/archive", h.BulkArchive())
+		authenticatedMux.HandleFunc("GET /workspaces/{workspaceId}/trash", h.Trash())
+		authenticatedMux.HandleFunc("DELETE /workspaces/{workspaceId}/trash", h.EmptyTrash())
+		authenticatedMux.HandleFunc("PATCH /workspaces/{workspaceId}/members/{memberId}", h.UpdateMember())
+		authenticatedMux.HandleFunc("DELETE /workspaces/{workspaceId}/members/{memberId}", h.RemoveMember())
+		authenticatedMux.HandleFunc("POST /workspaces/{workspaceId}/invitations", h.InviteMember())
+		authenticatedMux.HandleFunc("DELETE /workspaces/{workspaceId}/invitations/{invitationId}", h.RevokeInvitation())
+		authenticatedMux.HandleFunc("GET /workspaces/{workspaceId}/public-link", h.WorkspacePublicLink())
+		authenticatedMux.HandleFunc("POST /workspaces/{workspaceId}/public-link", h.SaveWorkspacePublicLink())
+		authenticatedMux.HandleFunc("DELETE /workspaces/{workspaceId}/public-link", h.DeleteWorkspacePublicLink())
+		if s.config.Auth.MapsWorkspaces() {
+			authenticatedMux.HandleFunc("POST /workspaces/{workspaceId}/sso-mappings", h.AddSSOMapping())
+			authenticatedMux.HandleFunc("POST /workspaces/{workspaceId}/sso-mappings/{mappingId}/verify", h.VerifySSOMapping())
+			authenticatedMux.HandleFunc("DELETE /workspaces/{workspaceId}/sso-mappings/{mappingId}", h.DeleteSSOMapping())
+		}
+
+		authenticatedMux.HandleFunc("GET /invitations/{token}", h.InvitationPage())
+		authenticatedMux.HandleFunc("POST /invitations/{token}", h.AcceptInvitation())
+
+		// Unverified email addresses are only held against cloud accounts
+		if s.config.IsCloud() {
+			mux.Handle("/", h.Authenticated(h.EmailVerified(h.TwoFactorRequired(authenticatedMux))))
+		} else {
+			mux.Handle("/", h.Authenticated(h.TwoFactorRequired(authenticatedMux)))
+		}
 	}
 
 	// Wrap everything with SelfHosted middleware if self-hosted
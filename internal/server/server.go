@@ -1,22 +1,29 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 
+	"github.com/wrytehq/wryte/internal/auth"
 	"github.com/wrytehq/wryte/internal/config"
 	"github.com/wrytehq/wryte/internal/database"
 	"github.com/wrytehq/wryte/internal/handler"
+	"github.com/wrytehq/wryte/internal/mailer"
+	"github.com/wrytehq/wryte/internal/storage"
 	"github.com/wrytehq/wryte/internal/templates"
 )
 
 type Server struct {
 	config *config.Config
 
-	db database.Service
+	db      database.Service
+	handler *handler.Handler
+	http    *http.Server
 }
 
-func New() *http.Server {
+func New() *Server {
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
@@ -37,18 +44,55 @@ func New() *http.Server {
 		log.Fatalf("Failed to run database migrations: %v", err)
 	}
 
-	h := handler.New(tmpl, db, cfg)
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		log.Fatalf("Failed to initialize mailer: %v", err)
+	}
+
+	provider, err := auth.New(cfg.Auth, db.GetDB())
+	if err != nil {
+		log.Fatalf("Failed to initialize auth provider: %v", err)
+	}
+
+	h := handler.New(tmpl, db, cfg, store, mail, provider)
 
 	newServer := &Server{
-		config: cfg,
-		db:     db,
+		config:  cfg,
+		db:      db,
+		handler: h,
 	}
 
-	s := &http.Server{
+	newServer.http = &http.Server{
 		Addr:    cfg.Addr(),
 		Handler: newServer.Routes(h),
 	}
 
-	return s
+	return newServer
+}
+
+func (s *Server) ListenAndServe() error {
+	return s.http.ListenAndServe()
+}
+
+// Shutdown drains long-lived connections first, since http.Server.Shutdown
+// only waits for them, then stops the HTTP server and closes the database.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var errs []error
+
+	if err := s.handler.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.http.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := s.db.Close(); err != nil {
+		errs = append(errs, err)
+	}
 
+	return errors.Join(errs...)
 }
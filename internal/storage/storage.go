@@ -0,0 +1,53 @@
+// Package storage keeps uploaded files outside of the database, on the local
+// filesystem or in an S3-compatible object store.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wrytehq/wryte/internal/config"
+)
+
+// ErrNotFound is returned when no object is stored under a key.
+var ErrNotFound = errors.New("storage: object not found")
+
+// Backend stores objects under slash separated keys.
+type Backend interface {
+	// Put stores size bytes read from r under key, replacing any object
+	// already stored there.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens the object stored under key. The caller closes it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object stored under key. Deleting a missing object
+	// is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the backend selected by the configuration.
+func New(cfg config.StorageConfig) (Backend, error) {
+	switch cfg.Backend {
+	case "local":
+		return NewLocal(cfg.Path)
+	case "s3":
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+}
+
+// validKey rejects keys that could escape the storage root or would be
+// ambiguous as object names.
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." || strings.ContainsAny(part, `\`) {
+			return fmt.Errorf("storage: invalid key %q", key)
+		}
+	}
+	return nil
+}
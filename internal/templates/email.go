@@ -0,0 +1,88 @@
+package templates
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+
+	"github.com/wrytehq/wryte/web"
+)
+
+// Email is a rendered email, with an HTML body and its plain text
+// alternative.
+type Email struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// emailTemplate holds both versions of an email. The text version defines
+// the subject in a "subject" template.
+type emailTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// loadEmails parses every email in templates/email: name.html, rendered in
+// the email layout, and name.txt.
+func (m *Manager) loadEmails() error {
+	pages, err := fs.Glob(web.Files, "templates/email/*.html")
+	if err != nil {
+		return fmt.Errorf("error finding email files: %w", err)
+	}
+
+	for _, page := range pages {
+		if page == "templates/email/layout.html" {
+			continue
+		}
+		name := strings.TrimSuffix(path.Base(page), ".html")
+
+		html, err := htmltemplate.New("layout.html").Funcs(templateFuncs()).
+			ParseFS(web.Files, "templates/email/layout.html", page)
+		if err != nil {
+			return fmt.Errorf("error parsing email %s: %w", page, err)
+		}
+
+		textPage := "templates/email/" + name + ".txt"
+		text, err := texttemplate.New(name+".txt").ParseFS(web.Files, textPage)
+		if err != nil {
+			return fmt.Errorf("error parsing email %s: %w", textPage, err)
+		}
+		if text.Lookup("subject") == nil {
+			return fmt.Errorf("email %s does not define a subject", textPage)
+		}
+
+		m.emails[name] = &emailTemplate{html: html, text: text}
+	}
+
+	return nil
+}
+
+// RenderEmail renders the email called name with data.
+func (m *Manager) RenderEmail(name string, data any) (*Email, error) {
+	tmpl, ok := m.emails[name]
+	if !ok {
+		return nil, fmt.Errorf("email %s not found", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := tmpl.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := tmpl.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	return &Email{
+		Subject: strings.Join(strings.Fields(subject.String()), " "),
+		HTML:    html.String(),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+	}, nil
+}
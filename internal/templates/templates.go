@@ -8,11 +8,13 @@ import (
 	"io/fs"
 	"strings"
 
+	"github.com/wrytehq/wryte/internal/markdown"
 	"github.com/wrytehq/wryte/web"
 )
 
 type Manager struct {
 	templates map[string]*template.Template
+	emails    map[string]*emailTemplate
 }
 
 func templateFuncs() template.FuncMap {
@@ -45,6 +47,8 @@ func templateFuncs() template.FuncMap {
 			}
 			return result[field], nil
 		},
+		// markdown renders Markdown content to sanitized HTML
+		"markdown": markdown.Render,
 		// dict creates a map from key-value pairs for passing to templates
 		"dict": func(values ...interface{}) (map[string]interface{}, error) {
 			if len(values)%2 != 0 {
@@ -66,12 +70,17 @@ func templateFuncs() template.FuncMap {
 func New() (*Manager, error) {
 	m := &Manager{
 		templates: make(map[string]*template.Template),
+		emails:    make(map[string]*emailTemplate),
 	}
 
 	if err := m.loadTemplates(); err != nil {
 		return nil, fmt.Errorf("error loading templates: %w", err)
 	}
 
+	if err := m.loadEmails(); err != nil {
+		return nil, fmt.Errorf("error loading email templates: %w", err)
+	}
+
 	return m, nil
 }
 
@@ -107,8 +116,9 @@ func (m *Manager) loadTemplates() error {
 			continue // Skip layout.html, it's the base template
 		}
 
-		// Skip component files as they are parsed separately
-		if strings.HasPrefix(page, "templates/components/") {
+		// Skip component files as they are parsed separately, and emails
+		// which have a layout of their own
+		if strings.HasPrefix(page, "templates/components/") || strings.HasPrefix(page, "templates/email/") {
 			continue
 		}
 
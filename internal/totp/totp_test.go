@@ -0,0 +1,100 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the test vectors of RFC 6238 Appendix B,
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// rfcVectors are the SHA-1 test vectors of RFC 6238 Appendix B, cut to the
+// last six digits, which is what dynamic truncation gives for six digit codes.
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestHOTP(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, v := range rfcVectors {
+		step := Step(time.Unix(v.unix, 0))
+		if got := hotp(key, step); got != v.code {
+			t.Errorf("hotp(step %d) = %s, want %s", step, got, v.code)
+		}
+	}
+}
+
+func TestCode(t *testing.T) {
+	for _, v := range rfcVectors {
+		got, err := Code(rfcSecret, Step(time.Unix(v.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code() = %v", err)
+		}
+		if got != v.code {
+			t.Errorf("Code() at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code() accepted an invalid secret")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+	code := func(step int64) string {
+		c, err := Code(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name  string
+		code  string
+		after int64
+		step  int64
+		ok    bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"spaces", code(current)[:3] + " " + code(current)[3:], 0, current, true},
+		{"previous step", code(current - 1), 0, current - 1, true},
+		{"next step", code(current + 1), 0, current + 1, true},
+		{"outside skew before", code(current - Skew - 1), 0, 0, false},
+		{"outside skew after", code(current + Skew + 1), 0, 0, false},
+		{"replayed step", code(current), current, 0, false},
+		{"step before the last used", code(current - 1), current - 1, 0, false},
+		{"step after the last used", code(current), current - 1, current, true},
+		{"wrong code", "000000", 0, 0, false},
+		{"short code", code(current)[:5], 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(rfcSecret, tt.code, now, tt.after)
+			if ok != tt.ok || step != tt.step {
+				t.Errorf("Validate(%q, after %d) = %d, %v, want %d, %v", tt.code, tt.after, step, ok, tt.step, tt.ok)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret := GenerateSecret()
+	if _, err := Code(secret, 1); err != nil {
+		t.Fatalf("Code() of a generated secret = %v", err)
+	}
+	if GenerateSecret() == secret {
+		t.Error("GenerateSecret() returned the same secret twice")
+	}
+}
@@ -10,6 +10,15 @@ type SetupForm struct {
 type LoginForm struct {
 	Email    string `form:"email" validate:"required,email"`
 	Password string `form:"password" validate:"required"`
+	Next     string `form:"next" validate:"max=2048"`
+}
+
+// DirectoryLoginForm is the LoginForm of a directory server, whose users sign
+// in with an email address or a name.
+type DirectoryLoginForm struct {
+	Email    string `form:"email" validate:"required,max=255"`
+	Password string `form:"password" validate:"required"`
+	Next     string `form:"next" validate:"max=2048"`
 }
 
 type ForgotPasswordForm struct {
@@ -22,8 +31,47 @@ type ResetPasswordForm struct {
 	Token           string `form:"token" validate:"required"`
 }
 
+// LinkAccountForm confirms connecting an identity to an existing account.
+type LinkAccountForm struct {
+	Token string `form:"token" validate:"required"`
+}
+
+// ChangePasswordForm sets a new password after checking the current one, and
+// signs out every other session when SignOutOthers is set.
 type ChangePasswordForm struct {
 	CurrentPassword string `form:"currentPassword" validate:"required"`
 	NewPassword     string `form:"newPassword" validate:"required,min=8,max=72"`
 	ConfirmPassword string `form:"confirmPassword" validate:"required,eqfield=NewPassword"`
+	SignOutOthers   bool   `form:"signOutOthers"`
+}
+
+// ProfileForm changes the name a user goes by.
+type ProfileForm struct {
+	Username string `form:"username" validate:"required,min=2,max=100"`
+}
+
+// ChangeEmailForm asks to move an account to another email address, which
+// takes effect once the new address is confirmed.
+type ChangeEmailForm struct {
+	Email           string `form:"email" validate:"required,email,max=255"`
+	CurrentPassword string `form:"currentPassword" validate:"required"`
+}
+
+// TwoFactorForm carries a code from an authenticator app, or a recovery code
+// when signing in.
+type TwoFactorForm struct {
+	Code string `form:"code" validate:"required,max=32"`
+}
+
+// ConfirmPasswordForm asks for the current password before a sensitive
+// change, such as turning off two-factor authentication.
+type ConfirmPasswordForm struct {
+	CurrentPassword string `form:"currentPassword" validate:"required"`
+}
+
+// PasskeyForm names a passkey about to be registered, after checking the
+// current password.
+type PasskeyForm struct {
+	Name            string `form:"name" validate:"required,max=100"`
+	CurrentPassword string `form:"currentPassword" validate:"required"`
 }
@@ -0,0 +1,51 @@
+package validator
+
+type CreateDocumentForm struct {
+	Title       string `form:"title" validate:"max=255"`
+	ParentID    string `form:"parentId" validate:"omitempty,uuid"`
+	WorkspaceID string `form:"workspaceId" validate:"omitempty,uuid"`
+}
+
+type UpdateDocumentForm struct {
+	Title   string `form:"title" validate:"max=255"`
+	Content string `form:"content"`
+	Version int    `form:"version" validate:"required,min=1"`
+}
+
+// ShareDocumentForm grants a role on a document to a user, found by username
+// or email, or to the members of a workspace.
+type ShareDocumentForm struct {
+	Username    string `form:"username" validate:"max=255"`
+	WorkspaceID string `form:"workspaceId" validate:"omitempty,uuid"`
+	Role        string `form:"role" validate:"required,oneof=viewer commenter editor owner"`
+}
+
+// PublicLinkForm configures the public link of a document or workspace. An
+// empty password keeps the current one unless RemovePassword is set, and an
+// empty expiry date keeps the link valid indefinitely.
+type PublicLinkForm struct {
+	Password       string `form:"password" validate:"max=72"`
+	RemovePassword bool   `form:"removePassword"`
+	ExpiresAt      string `form:"expiresAt" validate:"omitempty,datetime=2006-01-02"`
+	AllowIndexing  bool   `form:"allowIndexing"`
+}
+
+// BulkArchiveForm applies an action to documents selected in the archive of a
+// workspace, either taking them out of the archive or moving them to the trash.
+type BulkArchiveForm struct {
+	DocumentIDs []string `form:"documentId" validate:"required,max=100,dive,uuid"`
+	Action      string   `form:"action" validate:"required,oneof=unarchive trash"`
+}
+
+// ExportForm selects the file format of a document export and whether the
+// subpages of the document are included.
+type ExportForm struct {
+	Format      string `form:"format" validate:"required,oneof=md html pdf epub"`
+	Descendants bool   `form:"descendants"`
+}
+
+// ImportForm imports an uploaded archive into a workspace, below an existing
+// document or at the top level when ParentID is empty.
+type ImportForm struct {
+	ParentID string `form:"parentId" validate:"omitempty,uuid"`
+}
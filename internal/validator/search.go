@@ -0,0 +1,13 @@
+package validator
+
+// SearchForm holds a full-text query and the filters narrowing it down. Dates
+// bound the last update of a document and Archived is one of exclude, include
+// or only, excluding archived documents when empty.
+type SearchForm struct {
+	Query       string `form:"q" validate:"max=200"`
+	WorkspaceID string `form:"workspace" validate:"omitempty,uuid"`
+	Author      string `form:"author" validate:"max=100"`
+	From        string `form:"from" validate:"omitempty,datetime=2006-01-02"`
+	To          string `form:"to" validate:"omitempty,datetime=2006-01-02"`
+	Archived    string `form:"archived" validate:"omitempty,oneof=exclude include only"`
+}
@@ -52,6 +52,23 @@ func (v *Validator) DecodeAndValidate(r *http.Request, dst interface{}) (*Valida
 	return &ValidationErrors{errors: make(map[string]string)}, nil
 }
 
+// DecodeQueryAndValidate is DecodeAndValidate for forms submitted with GET,
+// whose values travel in the query string.
+func (v *Validator) DecodeQueryAndValidate(r *http.Request, dst interface{}) (*ValidationErrors, error) {
+	if err := v.decoder.Decode(dst, r.URL.Query()); err != nil {
+		return nil, fmt.Errorf("error decoding query: %w", err)
+	}
+
+	if err := v.validate.Struct(dst); err != nil {
+		if validationErrors, ok := err.(validator.ValidationErrors); ok {
+			return v.formatErrors(validationErrors), nil
+		}
+		return nil, fmt.Errorf("unexpected validation error: %w", err)
+	}
+
+	return &ValidationErrors{errors: make(map[string]string)}, nil
+}
+
 func (v *Validator) Validate(s interface{}) *ValidationErrors {
 	err := v.validate.Struct(s)
 	if err != nil {
@@ -103,6 +120,12 @@ func (v *Validator) getErrorMessage(err validator.FieldError) string {
 		return "Must be a valid URL"
 	case "uri":
 		return "Must be a valid URI"
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, err.Param())
+	case "datetime":
+		return fmt.Sprintf("%s must be a valid date", field)
+	case "uuid":
+		return fmt.Sprintf("%s must be a valid identifier", field)
 	default:
 		return fmt.Sprintf("%s is invalid", field)
 	}
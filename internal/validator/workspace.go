@@ -0,0 +1,29 @@
+package validator
+
+type WorkspaceForm struct {
+	Name string `form:"name" validate:"max=255"`
+}
+
+// InviteMemberForm invites someone to a workspace by email.
+type InviteMemberForm struct {
+	Email string `form:"email" validate:"required,email,max=255"`
+	Role  string `form:"role" validate:"required,oneof=member admin owner"`
+}
+
+type UpdateMemberForm struct {
+	Role string `form:"role" validate:"required,oneof=member admin owner"`
+}
+
+// WorkspaceTwoFactorForm sets whether members of a workspace must use
+// two-factor authentication.
+type WorkspaceTwoFactorForm struct {
+	Required bool `form:"required"`
+}
+
+// SSOMappingForm has users signing in with single sign-on join a workspace
+// when the domain of their email address, or one of their groups, is Value.
+type SSOMappingForm struct {
+	Kind  string `form:"kind" validate:"required,oneof=domain group"`
+	Value string `form:"value" validate:"required,max=255"`
+	Role  string `form:"role" validate:"required,oneof=member admin"`
+}
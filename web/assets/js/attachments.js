@@ -0,0 +1,101 @@
+// File attachments for the editor.
+//
+// Files chosen with the attach button, pasted or dropped onto the editor are
+// uploaded to the document, and a Markdown reference to each one is inserted
+// at the caret. The textarea is changed the way typing would change it, so
+// the collaborative editing client picks the insertion up as a local edit.
+// Images go through the image endpoint, which strips their metadata and
+// makes them available in smaller sizes.
+window.Attachments = (function () {
+  const imageTypes = ['image/png', 'image/jpeg', 'image/gif', 'image/webp'];
+
+  function upload(documentID, file) {
+    const body = new FormData();
+    body.append('file', file);
+
+    const endpoint = imageTypes.includes(file.type) ? 'images' : 'attachments';
+    return fetch('/documents/' + documentID + '/' + endpoint, {
+      method: 'POST',
+      body: body,
+    }).then(function (response) {
+      if (response.ok) return response.json();
+      return response.text().then(function (text) {
+        throw new Error(text.trim() || 'status ' + response.status);
+      });
+    });
+  }
+
+  function insert(textarea, text) {
+    const start = textarea.selectionStart;
+    const end = textarea.selectionEnd;
+    const before = textarea.value.slice(0, start);
+
+    // Keep references on lines of their own
+    let snippet = text;
+    if (before !== '' && !before.endsWith('\n')) snippet = '\n' + snippet;
+    snippet += '\n';
+
+    textarea.setRangeText(snippet, start, end, 'end');
+    textarea.dispatchEvent(new Event('input', { bubbles: true }));
+  }
+
+  function attach(textarea, options) {
+    const documentID = textarea.dataset.documentId;
+    const onStatus = options.onStatus || function () {};
+    let pending = 0;
+
+    function uploadAll(files) {
+      if (textarea.readOnly) {
+        onStatus('Wait for the editor to connect before attaching files');
+        return;
+      }
+
+      Array.from(files).forEach(function (file) {
+        pending++;
+        onStatus('Uploading ' + file.name + '...');
+
+        upload(documentID, file)
+          .then(function (attachment) {
+            insert(textarea, attachment.markdown);
+            document.body.dispatchEvent(new Event('attachments-changed'));
+            pending--;
+            if (pending === 0) onStatus('');
+          })
+          .catch(function (error) {
+            pending--;
+            onStatus('Could not upload ' + file.name + ': ' + error.message);
+          });
+      });
+    }
+
+    if (options.input) {
+      options.input.addEventListener('change', function () {
+        uploadAll(options.input.files);
+        options.input.value = '';
+      });
+    }
+
+    textarea.addEventListener('paste', function (event) {
+      const files = event.clipboardData && event.clipboardData.files;
+      if (!files || files.length === 0) return;
+      event.preventDefault();
+      uploadAll(files);
+    });
+
+    textarea.addEventListener('dragover', function (event) {
+      if (event.dataTransfer && Array.from(event.dataTransfer.types).includes('Files')) {
+        event.preventDefault();
+      }
+    });
+
+    textarea.addEventListener('drop', function (event) {
+      const files = event.dataTransfer && event.dataTransfer.files;
+      if (!files || files.length === 0) return;
+      event.preventDefault();
+      textarea.focus();
+      uploadAll(files);
+    });
+  }
+
+  return { attach: attach };
+})();
@@ -0,0 +1,493 @@
+// Collaborative editing client.
+//
+// Keeps a replica of the document content in sync with the server. The replica
+// is a Replicated Growable Array and mirrors internal/crdt: every character
+// has a unique id, inserts name the element they follow and deletes leave
+// tombstones. Local edits are turned into operations and posted to the server,
+// remote operations arrive as server-sent events.
+//
+// The same stream lists the participants of the document. Editors report their
+// caret anchored to replica elements so other editors can draw it in place.
+window.Collab = (function () {
+  function idKey(id) {
+    return id.c + '@' + id.s;
+  }
+
+  // sortsFirst mirrors crdt.ID.after: higher counters win, ties by site
+  function sortsFirst(a, b) {
+    if (a.c !== b.c) return a.c > b.c;
+    return a.s > b.s;
+  }
+
+  function Replica() {
+    this.elements = [];
+    this.index = new Map();
+    this.clock = 0;
+    this.pending = [];
+  }
+
+  Replica.prototype.load = function (state) {
+    this.elements = state.elements.map(function (e) {
+      return { id: e.id, v: e.v, d: !!e.d };
+    });
+    this.clock = state.clock;
+    this.pending = [];
+    this.reindex(0);
+  };
+
+  Replica.prototype.reindex = function (from) {
+    for (let i = from; i < this.elements.length; i++) {
+      this.index.set(idKey(this.elements[i].id), i);
+    }
+  };
+
+  Replica.prototype.text = function () {
+    let text = '';
+    for (const e of this.elements) {
+      if (!e.d) text += e.v;
+    }
+    return text;
+  };
+
+  Replica.prototype.visible = function () {
+    return this.elements.filter(function (e) {
+      return !e.d;
+    });
+  };
+
+  // position returns the number of visible characters up to and including the
+  // element with the given id, which is where a caret anchored to it belongs
+  Replica.prototype.position = function (id) {
+    if (!id) return 0;
+    const end = this.index.get(idKey(id));
+    if (end === undefined) return 0;
+    let count = 0;
+    for (let i = 0; i <= end; i++) {
+      if (!this.elements[i].d) count++;
+    }
+    return count;
+  };
+
+  // applyOne returns 'applied', 'noop' or 'missing'
+  Replica.prototype.applyOne = function (op) {
+    if (op.id.c > this.clock) this.clock = op.id.c;
+
+    if (op.t === 'ins') {
+      if (this.index.has(idKey(op.id))) return 'noop';
+
+      let pos = 0;
+      if (op.a) {
+        const i = this.index.get(idKey(op.a));
+        if (i === undefined) return 'missing';
+        pos = i + 1;
+      }
+      while (pos < this.elements.length && sortsFirst(this.elements[pos].id, op.id)) {
+        pos++;
+      }
+      this.elements.splice(pos, 0, { id: op.id, v: op.v, d: false });
+      this.reindex(pos);
+      return 'applied';
+    }
+
+    const i = this.index.get(idKey(op.id));
+    if (i === undefined) return 'missing';
+    if (this.elements[i].d) return 'noop';
+    this.elements[i].d = true;
+    return 'applied';
+  };
+
+  Replica.prototype.apply = function (op) {
+    const result = this.applyOne(op);
+    if (result === 'missing') {
+      this.pending.push(op);
+      return;
+    }
+    if (result !== 'applied') return;
+
+    let progress = true;
+    while (progress && this.pending.length > 0) {
+      progress = false;
+      const remaining = [];
+      for (const p of this.pending) {
+        if (this.applyOne(p) === 'missing') {
+          remaining.push(p);
+        } else {
+          progress = true;
+        }
+      }
+      this.pending = remaining;
+    }
+  };
+
+  function newSite() {
+    return Date.now().toString(36) + Math.random().toString(36).slice(2, 10);
+  }
+
+  // Textareas count UTF-16 code units while the replica counts code points
+  function toPoints(text, units) {
+    return Array.from(text.slice(0, units)).length;
+  }
+
+  function toUnits(chars, points) {
+    return chars.slice(0, points).join('').length;
+  }
+
+  const head = { c: 0, s: '' };
+  const colors = ['#e11d48', '#2563eb', '#16a34a', '#d97706', '#7c3aed', '#0891b2', '#db2777', '#65a30d'];
+
+  function colorOf(userId) {
+    let hash = 0;
+    for (let i = 0; i < userId.length; i++) {
+      hash = (hash * 31 + userId.charCodeAt(i)) | 0;
+    }
+    return colors[Math.abs(hash) % colors.length];
+  }
+
+  function streamURL(documentID, session, mode) {
+    return '/documents/' + documentID + '/collab?session=' + session + '&mode=' + mode;
+  }
+
+  // renderParticipants lists everyone but the current session as initials
+  function renderParticipants(container, participants, session) {
+    if (!container) return;
+    container.replaceChildren();
+    for (const p of participants) {
+      if (p.session === session) continue;
+      const avatar = document.createElement('div');
+      avatar.className = 'w-8 h-8 rounded-full text-white text-xs font-semibold flex items-center justify-center ring-2 ring-base-100';
+      avatar.style.background = colorOf(p.userId);
+      avatar.textContent = p.username.slice(0, 2).toUpperCase();
+      avatar.title = p.username + (p.mode === 'edit' ? ' (editing)' : ' (viewing)');
+      container.appendChild(avatar);
+    }
+  }
+
+  // Carets draws the cursors of other editors over a textarea. A mirror of the
+  // textarea with transparent text holds a marker at every cursor position, so
+  // the browser lays them out exactly like the text.
+  function Carets(textarea) {
+    this.textarea = textarea;
+    this.mirror = document.createElement('div');
+    this.mirror.setAttribute('aria-hidden', 'true');
+    this.mirror.style.position = 'absolute';
+    this.mirror.style.pointerEvents = 'none';
+    this.mirror.style.overflow = 'hidden';
+    this.mirror.style.color = 'transparent';
+    this.mirror.style.whiteSpace = 'pre-wrap';
+    this.mirror.style.overflowWrap = 'break-word';
+
+    const wrapper = document.createElement('div');
+    wrapper.style.position = 'relative';
+    textarea.parentNode.insertBefore(wrapper, textarea);
+    wrapper.appendChild(textarea);
+    wrapper.appendChild(this.mirror);
+
+    this.inner = document.createElement('div');
+    this.mirror.appendChild(this.inner);
+
+    const self = this;
+    textarea.addEventListener('scroll', function () {
+      self.inner.style.transform = 'translateY(' + -textarea.scrollTop + 'px)';
+    });
+  }
+
+  Carets.prototype.layout = function () {
+    const style = window.getComputedStyle(this.textarea);
+    const names = [
+      'fontFamily', 'fontSize', 'fontWeight', 'fontStyle', 'letterSpacing', 'lineHeight', 'tabSize', 'boxSizing',
+      'paddingTop', 'paddingRight', 'paddingBottom', 'paddingLeft',
+      'borderTopWidth', 'borderRightWidth', 'borderBottomWidth', 'borderLeftWidth', 'borderStyle',
+    ];
+    for (const name of names) {
+      this.mirror.style[name] = style[name];
+    }
+    this.mirror.style.borderColor = 'transparent';
+    this.mirror.style.top = this.textarea.offsetTop + 'px';
+    this.mirror.style.left = this.textarea.offsetLeft + 'px';
+    this.mirror.style.width = this.textarea.offsetWidth + 'px';
+    this.mirror.style.height = this.textarea.offsetHeight + 'px';
+  };
+
+  Carets.prototype.render = function (replica, participants, session) {
+    this.layout();
+
+    const chars = Array.from(replica.text());
+    const marks = [];
+    for (const p of participants) {
+      if (p.session === session || !p.cursor) continue;
+      const a = replica.position(p.cursor.anchor);
+      const b = replica.position(p.cursor.head);
+      marks.push({ p: p, start: Math.min(a, b), end: Math.max(a, b), caret: b });
+    }
+
+    // Split the text at every cursor boundary and wrap selected stretches
+    const cuts = new Set([0, chars.length]);
+    for (const m of marks) {
+      cuts.add(m.start);
+      cuts.add(m.end);
+    }
+    const points = Array.from(cuts).sort(function (x, y) {
+      return x - y;
+    });
+
+    this.inner.replaceChildren();
+    for (let i = 0; i < points.length; i++) {
+      const at = points[i];
+      for (const m of marks) {
+        if (m.caret !== at) continue;
+        const caret = document.createElement('span');
+        caret.style.position = 'relative';
+        caret.style.borderLeft = '2px solid ' + colorOf(m.p.userId);
+        caret.style.marginLeft = '-1px';
+        caret.style.marginRight = '-1px';
+        const label = document.createElement('span');
+        label.textContent = m.p.username;
+        label.style.cssText =
+          'position:absolute;bottom:100%;left:-2px;font-size:10px;line-height:1.2;padding:0 3px;' +
+          'border-radius:3px;white-space:nowrap;color:#fff;background:' + colorOf(m.p.userId);
+        caret.appendChild(label);
+        this.inner.appendChild(caret);
+      }
+      if (i === points.length - 1) break;
+
+      const segment = document.createElement('span');
+      segment.textContent = chars.slice(at, points[i + 1]).join('');
+      for (const m of marks) {
+        if (m.start <= at && points[i + 1] <= m.end) {
+          segment.style.background = colorOf(m.p.userId) + '33';
+        }
+      }
+      this.inner.appendChild(segment);
+    }
+    // A trailing newline only takes room when followed by something
+    this.inner.appendChild(document.createTextNode('\u200b'));
+  };
+
+  function attach(textarea, options) {
+    const documentID = textarea.dataset.documentId;
+    const url = '/documents/' + documentID + '/collab';
+    const site = newSite();
+    const replica = new Replica();
+    const carets = new Carets(textarea);
+    const onStatus = options.onStatus || function () {};
+    const onSaved = options.onSaved || function () {};
+
+    let participants = [];
+    let cursorTimer = null;
+    let lastCursor = '';
+
+    let ready = false;
+    let queue = [];
+    let inflight = [];
+    let sending = false;
+
+    textarea.readOnly = true;
+
+    function send() {
+      if (sending || queue.length === 0) return;
+      sending = true;
+      inflight = queue;
+      queue = [];
+
+      fetch(url, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ session: site, ops: inflight }),
+      })
+        .then(function (response) {
+          // A conflict means the server will never accept these operations,
+          // sending them again would not help
+          if (!response.ok && response.status !== 409) throw new Error('status ' + response.status);
+          inflight = [];
+          sending = false;
+          send();
+        })
+        .catch(function () {
+          // Operations are idempotent, resend them once the server is back
+          onStatus('offline');
+          queue = inflight.concat(queue);
+          inflight = [];
+          sending = false;
+          setTimeout(send, 1000);
+        });
+    }
+
+    function onInput() {
+      if (!ready) return;
+
+      const before = Array.from(replica.text());
+      const after = Array.from(textarea.value);
+
+      let prefix = 0;
+      while (prefix < before.length && prefix < after.length && before[prefix] === after[prefix]) {
+        prefix++;
+      }
+      let suffix = 0;
+      while (
+        suffix < before.length - prefix &&
+        suffix < after.length - prefix &&
+        before[before.length - 1 - suffix] === after[after.length - 1 - suffix]
+      ) {
+        suffix++;
+      }
+
+      const visible = replica.visible();
+      const ops = [];
+
+      for (let i = prefix; i < before.length - suffix; i++) {
+        ops.push({ t: 'del', id: visible[i].id });
+      }
+
+      let prev = prefix > 0 ? visible[prefix - 1].id : null;
+      for (let i = prefix; i < after.length - suffix; i++) {
+        const id = { c: replica.clock + 1, s: site };
+        const op = { t: 'ins', id: id, v: after[i] };
+        if (prev) op.a = prev;
+        replica.apply(op);
+        ops.push(op);
+        prev = id;
+      }
+      for (const op of ops) {
+        if (op.t === 'del') replica.apply(op);
+      }
+
+      if (ops.length > 0) {
+        onStatus('saving');
+        queue = queue.concat(ops);
+        send();
+      }
+      carets.render(replica, participants, site);
+      reportCursor();
+    }
+
+    function anchorAt(units) {
+      const points = toPoints(textarea.value, units);
+      const visible = replica.visible();
+      return points > 0 && visible[points - 1] ? visible[points - 1].id : head;
+    }
+
+    // reportCursor sends the caret to the server, at most every 150ms
+    function reportCursor() {
+      if (!ready || cursorTimer) return;
+      cursorTimer = setTimeout(function () {
+        cursorTimer = null;
+        const backward = textarea.selectionDirection === 'backward';
+        const start = anchorAt(textarea.selectionStart);
+        const end = anchorAt(textarea.selectionEnd);
+        const cursor = backward ? { anchor: end, head: start } : { anchor: start, head: end };
+        const body = JSON.stringify({ session: site, cursor: cursor });
+        if (body === lastCursor) return;
+        lastCursor = body;
+        fetch('/documents/' + documentID + '/cursor', {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: body,
+        }).catch(function () {
+          lastCursor = '';
+        });
+      }, 150);
+    }
+
+    // render replaces the textarea content while keeping the caret attached to
+    // the characters it was next to
+    function render() {
+      const value = textarea.value;
+      const visible = replica.visible();
+      const anchors = [textarea.selectionStart, textarea.selectionEnd].map(function (units) {
+        const points = toPoints(value, units);
+        return points > 0 && visible[points - 1] ? visible[points - 1].id : null;
+      });
+      return function () {
+        const chars = Array.from(replica.text());
+        textarea.value = chars.join('');
+        if (document.activeElement === textarea) {
+          textarea.setSelectionRange(
+            toUnits(chars, replica.position(anchors[0])),
+            toUnits(chars, replica.position(anchors[1]))
+          );
+        }
+      };
+    }
+
+    function load(state) {
+      replica.load(state);
+      // Local operations the server has not confirmed yet must survive a
+      // reconnect; they come back as regular operations later on
+      for (const op of inflight.concat(queue)) {
+        replica.apply(op);
+      }
+      textarea.value = replica.text();
+      ready = true;
+      textarea.readOnly = false;
+      carets.render(replica, participants, site);
+      // The stream is new, the server has no cursor for it yet
+      lastCursor = '';
+      reportCursor();
+    }
+
+    const source = new EventSource(streamURL(documentID, site, 'edit'));
+
+    source.addEventListener('state', function (event) {
+      load(JSON.parse(event.data));
+      onStatus('connected');
+    });
+
+    source.addEventListener('reset', function (event) {
+      // The server reloaded its replica, local edits still apply on top
+      load(JSON.parse(event.data));
+      onStatus('connected');
+    });
+
+    source.addEventListener('ops', function (event) {
+      const finish = render();
+      for (const op of JSON.parse(event.data)) {
+        replica.apply(op);
+      }
+      finish();
+      carets.render(replica, participants, site);
+    });
+
+    source.addEventListener('presence', function (event) {
+      participants = JSON.parse(event.data);
+      renderParticipants(options.participants, participants, site);
+      carets.render(replica, participants, site);
+    });
+
+    source.addEventListener('saved', function (event) {
+      onSaved(JSON.parse(event.data));
+      if (queue.length === 0 && inflight.length === 0) onStatus('saved');
+    });
+
+    source.addEventListener('error', function () {
+      onStatus('offline');
+    });
+
+    textarea.addEventListener('input', onInput);
+    document.addEventListener('selectionchange', function () {
+      if (document.activeElement === textarea) reportCursor();
+    });
+    window.addEventListener('resize', function () {
+      carets.render(replica, participants, site);
+    });
+
+    return {
+      source: source,
+      replica: replica,
+    };
+  }
+
+  // watch lists the participants of a document that is only being viewed
+  function watch(documentID, options) {
+    const session = newSite();
+    const source = new EventSource(streamURL(documentID, session, 'view'));
+    source.addEventListener('presence', function (event) {
+      renderParticipants(options.participants, JSON.parse(event.data), session);
+    });
+    return { source: source };
+  }
+
+  return {
+    attach: attach,
+    watch: watch,
+  };
+})();
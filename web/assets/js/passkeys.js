@@ -0,0 +1,124 @@
+// Passkeys, with the WebAuthn API of the browser.
+//
+// The server answers with the options of a ceremony as JSON, where binary
+// values are base64url encoded. They are decoded for the browser, which
+// creates or uses a passkey, and its answer is encoded the same way for the
+// server to check. Software authenticators, such as the virtual ones of the
+// browser developer tools, work the same as devices.
+window.Passkeys = (function () {
+  function decode(value) {
+    const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+    const binary = atob(base64 + '='.repeat((4 - (base64.length % 4)) % 4));
+    return Uint8Array.from(binary, function (c) { return c.charCodeAt(0); }).buffer;
+  }
+
+  function encode(buffer) {
+    if (!buffer) return undefined;
+    const binary = String.fromCharCode.apply(null, new Uint8Array(buffer));
+    return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+  }
+
+  function decodeDescriptors(descriptors) {
+    return (descriptors || []).map(function (d) {
+      return Object.assign({}, d, { id: decode(d.id) });
+    });
+  }
+
+  // send posts body and resolves with the JSON answer. Answers carrying a
+  // redirect are followed, whether the request succeeded or not.
+  function send(url, body) {
+    const options = { method: 'POST', body: body };
+    if (body !== undefined && !(body instanceof FormData)) {
+      options.headers = { 'Content-Type': 'application/json' };
+      options.body = JSON.stringify(body);
+    }
+    return fetch(url, options).then(function (response) {
+      const type = response.headers.get('Content-Type') || '';
+      const read = type.includes('application/json')
+        ? response.json()
+        : response.text().then(function (text) { return { error: text.trim() }; });
+      return read.then(function (data) {
+        if (data.redirect) {
+          window.location.href = data.redirect;
+          return new Promise(function () {});
+        }
+        if (!response.ok) {
+          const messages = data.errors ? Object.values(data.errors) : [data.error];
+          throw new Error(messages.join(' ') || 'status ' + response.status);
+        }
+        return data;
+      });
+    });
+  }
+
+  // explain turns the errors of the WebAuthn API into something to show.
+  function explain(err) {
+    if (err.name === 'NotAllowedError') return new Error('No passkey was used.');
+    if (err.name === 'InvalidStateError') return new Error('This passkey is already registered.');
+    return err;
+  }
+
+  function supported() {
+    return typeof window.PublicKeyCredential === 'function';
+  }
+
+  // register adds a passkey named in form, which also holds the current
+  // password.
+  function register(form) {
+    return send('/settings/account/passkeys/options', new FormData(form)).then(function (options) {
+      const publicKey = Object.assign({}, options.publicKey, {
+        challenge: decode(options.publicKey.challenge),
+        user: Object.assign({}, options.publicKey.user, { id: decode(options.publicKey.user.id) }),
+        excludeCredentials: decodeDescriptors(options.publicKey.excludeCredentials),
+      });
+      return navigator.credentials.create({ publicKey: publicKey }).catch(function (err) {
+        throw explain(err);
+      });
+    }).then(function (credential) {
+      const response = credential.response;
+      return send('/settings/account/passkeys', {
+        id: credential.id,
+        rawId: encode(credential.rawId),
+        type: credential.type,
+        authenticatorAttachment: credential.authenticatorAttachment || undefined,
+        clientExtensionResults: credential.getClientExtensionResults(),
+        response: {
+          clientDataJSON: encode(response.clientDataJSON),
+          attestationObject: encode(response.attestationObject),
+          transports: response.getTransports ? response.getTransports() : [],
+        },
+      });
+    });
+  }
+
+  // signIn asks optionsURL for a challenge, has the browser sign it with a
+  // passkey and sends the signature to finishURL, which starts the session.
+  function signIn(optionsURL, finishURL) {
+    return send(optionsURL).then(function (options) {
+      const publicKey = Object.assign({}, options.publicKey, {
+        challenge: decode(options.publicKey.challenge),
+        allowCredentials: decodeDescriptors(options.publicKey.allowCredentials),
+      });
+      return navigator.credentials.get({ publicKey: publicKey }).catch(function (err) {
+        throw explain(err);
+      });
+    }).then(function (credential) {
+      const response = credential.response;
+      return send(finishURL, {
+        id: credential.id,
+        rawId: encode(credential.rawId),
+        type: credential.type,
+        authenticatorAttachment: credential.authenticatorAttachment || undefined,
+        clientExtensionResults: credential.getClientExtensionResults(),
+        response: {
+          clientDataJSON: encode(response.clientDataJSON),
+          authenticatorData: encode(response.authenticatorData),
+          signature: encode(response.signature),
+          userHandle: encode(response.userHandle),
+        },
+      });
+    });
+  }
+
+  return { supported: supported, register: register, signIn: signIn };
+})();